@@ -1,69 +1,20 @@
-//go:build tinygo
-
 package main
 
 import (
 	"log/slog"
-	"machine"
 	"time"
 )
 
 // Package-level logger for bindicator (set from main)
 var bindicatorLogger *slog.Logger
 
-// GPIO pin assignments for external LEDs
-const (
-	pinGreenLED = machine.GP2
-	pinBlackLED = machine.GP3
-	pinBrownLED = machine.GP4
-)
-
-// BinType represents the type of bin collection
-type BinType uint8
-
-const (
-	BinUnknown BinType = iota
-	BinGreen
-	BinBlack
-	BinBrown
-)
-
-// String returns the bin type name
-func (b BinType) String() string {
-	switch b {
-	case BinGreen:
-		return "green"
-	case BinBlack:
-		return "black"
-	case BinBrown:
-		return "brown"
-	default:
-		return "unknown"
-	}
-}
-
-// BinJob represents a scheduled bin collection
-type BinJob struct {
-	Year  uint16
-	Month uint8
-	Day   uint8
-	Bin   BinType
-}
-
-// Pre-allocated storage for bin jobs (avoids heap allocation)
-const maxJobs = 15
+// LED state storage, indexed by premises and BinType (persists across API
+// errors)
+var ledState [maxPremises][maxBins + 1]bool
 
-var (
-	jobStorage [maxJobs]BinJob
-	jobCount   int
-)
-
-// LED state storage (persists across API errors)
-var ledState struct {
-	green bool
-	black bool
-	brown bool
-}
+// leds holds the output of each premises' LED for each registered bin,
+// indexed by premises and BinType (nil until initLEDs)
+var leds [maxPremises][maxBins + 1]Indicator
 
 // bindicatorPaused stops LED updates during OTA
 var bindicatorPaused bool
@@ -78,144 +29,81 @@ func IsBindicatorPaused() bool {
 	return bindicatorPaused
 }
 
-// initLEDs configures the GPIO pins for LED output
-func initLEDs() {
-	pinGreenLED.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	pinBlackLED.Configure(machine.PinConfig{Mode: machine.PinOutput})
-	pinBrownLED.Configure(machine.PinConfig{Mode: machine.PinOutput})
-
-	// Initialize all LEDs off
-	pinGreenLED.Low()
-	pinBlackLED.Low()
-	pinBrownLED.Low()
+// initLEDs opens the output of each premises' bin LEDs on its pin (see
+// premisesPin) and turns it off
+func initLEDs(open func(pin uint8) Indicator) {
+	for p := 0; p < numPremises(); p++ {
+		for bt := BinType(1); int(bt) <= numBins(); bt++ {
+			led := open(premisesPin(p, bt))
+			led.Set(false)
+			leds[p][bt] = led
+			ledState[p][bt] = false
+		}
+	}
 }
 
-// setLED sets the state of a specific bin LED
-func setLED(binType BinType, on bool) {
-	var changed bool
-	var name string
-
-	switch binType {
-	case BinGreen:
-		changed = ledState.green != on
-		name = "GREEN"
-		if on {
-			pinGreenLED.High()
-		} else {
-			pinGreenLED.Low()
-		}
-		ledState.green = on
-	case BinBlack:
-		changed = ledState.black != on
-		name = "BLACK"
-		if on {
-			pinBlackLED.High()
-		} else {
-			pinBlackLED.Low()
-		}
-		ledState.black = on
-	case BinBrown:
-		changed = ledState.brown != on
-		name = "BROWN"
-		if on {
-			pinBrownLED.High()
-		} else {
-			pinBrownLED.Low()
-		}
-		ledState.brown = on
+// setLED sets the state of a bin LED at premises p
+func setLED(p int, binType BinType, on bool) {
+	if p < 0 || p >= numPremises() || binType == BinUnknown || int(binType) > numBins() {
+		return
 	}
+	changed := ledState[p][binType] != on
+	if led := leds[p][binType]; led != nil {
+		led.Set(on)
+	}
+	ledState[p][binType] = on
 
 	if changed && bindicatorLogger != nil {
-		bindicatorLogger.Info("led:changed", slog.String("bin", name), slog.Bool("on", on))
+		bindicatorLogger.Info("led:changed",
+			slog.String("premises", premisesName(p)),
+			slog.String("bin", binType.Label()),
+			slog.Bool("on", on),
+		)
 	}
 }
 
 // updateLEDsFromSchedule checks the schedule and updates LED states.
-// LED ON: 12 hours before collection (noon day before)
-// LED OFF: 12 hours into collection day (noon on collection day)
+// Each bin's LED is lit during its configured window (see binStates),
+// by default from noon local time the day before collection until noon
+// on collection day. Every premises has its own LED group driven by its
+// own jobs. Schedule exceptions are applied to jobs first.
 func updateLEDsFromSchedule(jobs []BinJob, now time.Time) {
 	// Skip LED updates during OTA
 	if bindicatorPaused {
 		return
 	}
-
-	greenOn := false
-	blackOn := false
-	brownOn := false
+	jobs = applyExceptions(jobs)
 
 	if bindicatorLogger != nil {
 		bindicatorLogger.Debug("schedule:checking",
 			slog.Int("jobs", len(jobs)),
-			slog.String("now", now.Format("2006-01-02 15:04")),
+			slog.String("now", localTime(now).Format("2006-01-02 15:04 MST")),
 		)
 	}
 
-	for i := 0; i < len(jobs); i++ {
-		job := &jobs[i]
-		// Create collection date at midnight UTC
-		collectionDate := time.Date(
-			int(job.Year), time.Month(job.Month), int(job.Day),
-			0, 0, 0, 0, time.UTC,
-		)
-
-		// Window start: noon the day before (12 hours before midnight of collection day)
-		windowStart := collectionDate.Add(-12 * time.Hour)
-		// Window end: noon on collection day (12 hours after midnight)
-		windowEnd := collectionDate.Add(12 * time.Hour)
-
-		// Check if current time is within the window
-		inWindow := now.After(windowStart) && now.Before(windowEnd)
-
-		if bindicatorLogger != nil {
-			bindicatorLogger.Debug("schedule:job",
-				slog.String("date", collectionDate.Format("2006-01-02")),
-				slog.String("bin", job.Bin.String()),
-			)
-		}
-
-		if inWindow {
-			switch job.Bin {
-			case BinGreen:
-				greenOn = true
-			case BinBlack:
-				blackOn = true
-			case BinBrown:
-				brownOn = true
-			}
-		}
-	}
-
 	// Log next upcoming collection
 	if bindicatorLogger != nil {
-		for i := 0; i < len(jobs); i++ {
+		if i := nextCollection(jobs, now); i >= 0 {
 			job := &jobs[i]
-			collectionDate := time.Date(
-				int(job.Year), time.Month(job.Month), int(job.Day),
-				0, 0, 0, 0, time.UTC,
+			on, off := collectionWindow(job)
+			var date [10]byte
+			writeDate(date[:], job.Year, job.Month, job.Day)
+			bindicatorLogger.Info("schedule:next",
+				slog.String("date", string(date[:])),
+				slog.String("bin", job.Bin.String()),
+				slog.String("premises", job.PremisesID()),
+				slog.String("on", on.Format("2006-01-02 15:04")),
+				slog.String("off", off.Format("2006-01-02 15:04")),
 			)
-			// Find first collection that hasn't passed yet (noon on collection day)
-			if now.Before(collectionDate.Add(12 * time.Hour)) {
-				bindicatorLogger.Info("schedule:next",
-					slog.String("date", collectionDate.Format("2006-01-02")),
-					slog.String("bin", job.Bin.String()),
-				)
-				break
-			}
 		}
 	}
 
 	// Update LED states
-	setLED(BinGreen, greenOn)
-	setLED(BinBlack, blackOn)
-	setLED(BinBrown, brownOn)
-}
-
-// getJobs returns the current job storage slice
-func getJobs() []BinJob {
-	return jobStorage[:jobCount]
-}
-
-// clearJobs resets the job count
-func clearJobs() {
-	jobCount = 0
+	var binOn [maxBins + 1]bool
+	for p := 0; p < numPremises(); p++ {
+		binStates(jobs, now, p, &binOn)
+		for bt := BinType(1); int(bt) <= numBins(); bt++ {
+			setLED(p, bt, binOn[bt])
+		}
+	}
 }
@@ -5,7 +5,7 @@ import (
 	"time"
 )
 
-func TestIsInCollectionWindow(t *testing.T) {
+func TestInCollectionWindow(t *testing.T) {
 	// Collection date: 2026-01-20 (Monday)
 	// Window: 2026-01-19 12:00 to 2026-01-20 12:00
 	job := BinJob{Year: 2026, Month: 1, Day: 20, Bin: BinBlack}
@@ -84,9 +84,9 @@ func TestIsInCollectionWindow(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := isInCollectionWindow(job, tc.time)
+			got := inCollectionWindow(&job, tc.time)
 			if got != tc.expected {
-				t.Errorf("isInCollectionWindow() at %v = %v, want %v",
+				t.Errorf("inCollectionWindow() at %v = %v, want %v",
 					tc.time.Format("2006-01-02 15:04"), got, tc.expected)
 			}
 		})
@@ -179,21 +179,22 @@ func TestUpdateLEDsFromSchedule(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			// Reset state
-			ledState.green = false
-			ledState.black = false
-			ledState.brown = false
+			mocks := useMockLEDs(t) // All off
 
 			updateLEDsFromSchedule(tc.jobs, tc.now)
 
-			if ledState.green != tc.expectGreen {
-				t.Errorf("green LED = %v, want %v", ledState.green, tc.expectGreen)
+			if ledState[0][BinGreen] != tc.expectGreen {
+				t.Errorf("green LED = %v, want %v", ledState[0][BinGreen], tc.expectGreen)
 			}
-			if ledState.black != tc.expectBlack {
-				t.Errorf("black LED = %v, want %v", ledState.black, tc.expectBlack)
+			if ledState[0][BinBlack] != tc.expectBlack {
+				t.Errorf("black LED = %v, want %v", ledState[0][BinBlack], tc.expectBlack)
 			}
-			if ledState.brown != tc.expectBrown {
-				t.Errorf("brown LED = %v, want %v", ledState.brown, tc.expectBrown)
+			if ledState[0][BinBrown] != tc.expectBrown {
+				t.Errorf("brown LED = %v, want %v", ledState[0][BinBrown], tc.expectBrown)
+			}
+			// The outputs follow the state
+			if mocks[2].on != tc.expectGreen || mocks[3].on != tc.expectBlack || mocks[4].on != tc.expectBrown {
+				t.Errorf("outputs = %v %v %v", mocks[2].on, mocks[3].on, mocks[4].on)
 			}
 		})
 	}
@@ -231,13 +232,38 @@ func TestWindowEdgeCases(t *testing.T) {
 			now:      time.Date(2028, 2, 29, 8, 0, 0, 0, time.UTC),
 			expected: true,
 		},
+		// Europe/London in BST (UTC+1): window is local noon to local noon
+		{
+			name:     "BST - 11:30 UTC day before is 12:30 local",
+			job:      BinJob{Year: 2026, Month: 7, Day: 2, Bin: BinBlack},
+			now:      time.Date(2026, 7, 1, 11, 30, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "BST - 11:30 UTC collection day is 12:30 local",
+			job:      BinJob{Year: 2026, Month: 7, Day: 2, Bin: BinBlack},
+			now:      time.Date(2026, 7, 2, 11, 30, 0, 0, time.UTC),
+			expected: false,
+		},
+		{
+			name:     "clocks go forward overnight - window opens at GMT noon",
+			job:      BinJob{Year: 2026, Month: 3, Day: 29, Bin: BinGreen},
+			now:      time.Date(2026, 3, 28, 12, 1, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:     "clocks go forward overnight - window closes at BST noon",
+			job:      BinJob{Year: 2026, Month: 3, Day: 29, Bin: BinGreen},
+			now:      time.Date(2026, 3, 29, 11, 1, 0, 0, time.UTC),
+			expected: false,
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got := isInCollectionWindow(tc.job, tc.now)
+			got := inCollectionWindow(&tc.job, tc.now)
 			if got != tc.expected {
-				t.Errorf("isInCollectionWindow() = %v, want %v", got, tc.expected)
+				t.Errorf("inCollectionWindow() = %v, want %v", got, tc.expected)
 			}
 		})
 	}
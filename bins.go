@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+
+	"openenterprise/bindicator/config"
+)
+
+// maxBins is the number of bin types the registry can hold
+const maxBins = config.MaxBins
+
+// BinType identifies a bin in the registry (1-based; 0 is unknown)
+type BinType uint8
+
+// BinUnknown is returned for schedule names that match no registered bin
+const BinUnknown BinType = 0
+
+// Bin types of the default registry (config.DefaultBins)
+const (
+	BinGreen BinType = iota + 1
+	BinBlack
+	BinBrown
+)
+
+// binDef holds the registry entry for one bin type
+type binDef struct {
+	name    string   // lower-case name for logs and telemetry
+	label   string   // upper-case name for console output
+	metric  string   // telemetry gauge name for the bin's LED
+	channel uint8    // GPIO pin driving the bin's LED
+	aliases []string // extra schedule names mapping to this bin
+	window  config.Window
+	recur   config.Recurrence // offline schedule rule (zero = none)
+}
+
+// Bin registry (indexed by BinType-1)
+var (
+	binDefs  [maxBins]binDef
+	binCount int
+)
+
+func init() {
+	// Start with the default registry so host tests and early boot have
+	// bins (premises LED pins default to the bin channels)
+	loadBinRegistry(config.DefaultBins)
+	loadPremises(config.DefaultPremises)
+}
+
+// loadBinRegistry replaces the bin registry with the given definitions.
+// Aliases from config/bin_aliases.text are part of the definitions (see
+// config.WithBinAliases).
+func loadBinRegistry(bins []config.Bin) error {
+	if len(bins) == 0 {
+		return errors.New("bins: empty registry")
+	}
+	if len(bins) > maxBins {
+		return errors.New("bins: too many bins")
+	}
+	for i := range bins {
+		name := toLowerASCII(bins[i].Name)
+		window := bins[i].Window
+		if window == (config.Window{}) {
+			window = config.DefaultWindow
+		}
+		binDefs[i] = binDef{
+			name:    name,
+			label:   toUpperASCII(name),
+			metric:  "led." + name,
+			channel: bins[i].Channel,
+			aliases: bins[i].Aliases,
+			window:  window,
+			recur:   bins[i].Recur,
+		}
+	}
+	binCount = len(bins)
+	return nil
+}
+
+// numBins returns the number of registered bins.
+// Valid bin types are 1..numBins().
+func numBins() int {
+	return binCount
+}
+
+// def returns the registry entry for the bin type, or nil if unknown
+func (b BinType) def() *binDef {
+	if b == BinUnknown || int(b) > binCount {
+		return nil
+	}
+	return &binDefs[b-1]
+}
+
+// String returns the bin type name
+func (b BinType) String() string {
+	if d := b.def(); d != nil {
+		return d.name
+	}
+	return "unknown"
+}
+
+// Label returns the upper-case bin type name for console output
+func (b BinType) Label() string {
+	if d := b.def(); d != nil {
+		return d.label
+	}
+	return "UNKNOWN"
+}
+
+// Channel returns the GPIO pin driving the bin's LED
+func (b BinType) Channel() uint8 {
+	if d := b.def(); d != nil {
+		return d.channel
+	}
+	return 0
+}
+
+// Window returns the bin's notification window
+func (b BinType) Window() config.Window {
+	if d := b.def(); d != nil {
+		return d.window
+	}
+	return config.DefaultWindow
+}
+
+// Recurrence returns the bin's offline schedule rule (zero if none)
+func (b BinType) Recurrence() config.Recurrence {
+	if d := b.def(); d != nil {
+		return d.recur
+	}
+	return config.Recurrence{}
+}
+
+// lookupBin finds a bin by exact name or alias (case-insensitive, no allocation).
+// Anything else is BinUnknown: partial matches are never accepted.
+func lookupBin(s []byte) BinType {
+	if len(s) == 0 {
+		return BinUnknown
+	}
+	for i := 0; i < binCount; i++ {
+		d := &binDefs[i]
+		if equalFoldASCII(s, d.name) {
+			return BinType(i + 1)
+		}
+		for _, alias := range d.aliases {
+			if equalFoldASCII(s, alias) {
+				return BinType(i + 1)
+			}
+		}
+	}
+	return BinUnknown
+}
+
+// lookupBinName finds a bin by its registry name only
+func lookupBinName(name string) BinType {
+	for i := 0; i < binCount; i++ {
+		if equalFoldASCII([]byte(name), binDefs[i].name) {
+			return BinType(i + 1)
+		}
+	}
+	return BinUnknown
+}
+
+// equalFoldASCII compares a byte slice and string ignoring ASCII case
+func equalFoldASCII(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := 0; i < len(b); i++ {
+		if lowerASCII(b[i]) != lowerASCII(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// lowerASCII lower-cases a single ASCII letter
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+// toLowerASCII returns s with ASCII letters lower-cased
+func toLowerASCII(s string) string {
+	b := []byte(s)
+	for i := range b {
+		b[i] = lowerASCII(b[i])
+	}
+	return string(b)
+}
+
+// toUpperASCII returns s with ASCII letters upper-cased
+func toUpperASCII(s string) string {
+	b := []byte(s)
+	for i := range b {
+		if b[i] >= 'a' && b[i] <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return string(b)
+}
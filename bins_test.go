@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"openenterprise/bindicator/config"
+)
+
+func TestLookupBin(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected BinType
+	}{
+		{"green", BinGreen},
+		{"BLACK", BinBlack},
+		{"Brown", BinBrown},
+		{"", BinUnknown},
+		{"blue", BinUnknown},
+		{"greenish", BinUnknown},
+	}
+
+	for _, tc := range tests {
+		got := lookupBin([]byte(tc.input))
+		if got != tc.expected {
+			t.Errorf("lookupBin(%q) = %v, want %v", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestLoadBinRegistry(t *testing.T) {
+	defer loadBinRegistry(config.DefaultBins)
+
+	err := loadBinRegistry([]config.Bin{
+		{Name: "Green", Channel: 2},
+		{Name: "black", Channel: 3},
+		{Name: "brown", Channel: 4},
+		{Name: "glass", Channel: 5, Aliases: []string{"BOTTLES"}},
+		{Name: "food", Channel: 6, Aliases: []string{"CADDY", "FOOD WASTE"}},
+	})
+	if err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+
+	if numBins() != 5 {
+		t.Fatalf("numBins() = %d, want 5", numBins())
+	}
+
+	tests := []struct {
+		input   string
+		name    string
+		label   string
+		channel uint8
+	}{
+		{"green", "green", "GREEN", 2},
+		{"GLASS", "glass", "GLASS", 5},
+		{"bottles", "glass", "GLASS", 5},
+		{"caddy", "food", "FOOD", 6},
+	}
+
+	for _, tc := range tests {
+		bt := lookupBin([]byte(tc.input))
+		if bt.String() != tc.name || bt.Label() != tc.label || bt.Channel() != tc.channel {
+			t.Errorf("lookupBin(%q) = %s/%s/%d, want %s/%s/%d", tc.input,
+				bt.String(), bt.Label(), bt.Channel(), tc.name, tc.label, tc.channel)
+		}
+	}
+
+	if got := BinType(6).String(); got != "unknown" {
+		t.Errorf("BinType(6).String() = %q, want unknown", got)
+	}
+}
+
+func TestLoadBinRegistryErrors(t *testing.T) {
+	defer loadBinRegistry(config.DefaultBins)
+
+	if err := loadBinRegistry(nil); err == nil {
+		t.Error("expected error for empty registry")
+	}
+
+	tooMany := make([]config.Bin, maxBins+1)
+	if err := loadBinRegistry(tooMany); err == nil {
+		t.Error("expected error for too many bins")
+	}
+}
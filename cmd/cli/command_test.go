@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSignCommand(t *testing.T) {
+	// Same vector as the device's command_test.go
+	data := signCommand("fleet-secret", "c-42", "led-black home", time.Unix(1768852800, 0))
+	var got commandEnvelope
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	want := commandEnvelope{
+		ID:  "c-42",
+		Cmd: "led-black home",
+		TS:  1768852800,
+		MAC: "a39855571330af4fe6a839e8e8f913403837206804e020fb2aaf4d84b268edf9",
+	}
+	if got != want {
+		t.Errorf("envelope = %+v, want %+v", got, want)
+	}
+}
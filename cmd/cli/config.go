@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"openenterprise/bindicator/config"
+
+	"golang.org/x/term"
+)
+
+// credentialFiles are the files the firmware embeds from credentials/
+var credentialFiles = []string{
+	"ssid.text",
+	"password.text",
+	"console_password.text",
+	"command_secret.text",
+	"mqtt_username.text",
+	"mqtt_password.text",
+}
+
+// configCheck runs the firmware's config parsing over the config/ and
+// credentials/ directories under dir and prints every problem. Returns the
+// number of problems found.
+func configCheck(dir string, out io.Writer) (int, error) {
+	var problems []string
+	files := make(map[string]string)
+	read := func(sub, name string) {
+		data, err := os.ReadFile(filepath.Join(dir, sub, name))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, sub+"/"+name+": missing (the firmware embeds it; create it empty for the default)")
+		case err != nil:
+			problems = append(problems, err.Error())
+		default:
+			files[name] = string(data)
+		}
+	}
+	for _, name := range config.Files() {
+		read("config", name)
+	}
+	for _, name := range credentialFiles {
+		read("credentials", name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "config")); err != nil {
+		return 0, fmt.Errorf("no config directory in %s", dir)
+	}
+
+	config.Load(files)
+	for _, err := range config.Validate() {
+		problems = append(problems, err.Error())
+	}
+	problems = append(problems, checkCredentials(files)...)
+
+	for _, p := range problems {
+		fmt.Fprintln(out, "  "+p)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(out, "Configuration OK")
+	} else {
+		fmt.Fprintf(out, "%d problem(s) found\n", len(problems))
+	}
+	return len(problems), nil
+}
+
+// checkCredentials reports credentials the firmware would accept but that
+// do not work as intended
+func checkCredentials(files map[string]string) []string {
+	var problems []string
+	console := files["console_password.text"]
+	switch {
+	case console == "":
+		problems = append(problems, "console_password.text: empty, so the console accepts an empty password")
+	case strings.TrimRight(console, "\r\n") != console:
+		problems = append(problems, "console_password.text: ends with a line break, which the console expects as part of the password")
+	}
+	if strings.TrimSpace(files["mqtt_username.text"]) == "" && strings.TrimSpace(files["mqtt_password.text"]) != "" {
+		problems = append(problems, "mqtt_password.text: set without a username, so it is not sent")
+	}
+	return problems
+}
+
+// prompter asks the questions of config init
+type prompter struct {
+	in     *bufio.Reader
+	out    io.Writer
+	hidden func() (string, error) // Reads a secret without echo (nil: a plain line)
+}
+
+// ask prints question and returns the answer, or def if it is blank
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, _ := p.in.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+// askYes asks a yes/no question
+func (p *prompter) askYes(question string, def bool) bool {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+	switch strings.ToLower(p.ask(question+" ("+d+")", "")) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+// askSecret asks for a secret without echo; a blank answer keeps current
+func (p *prompter) askSecret(question, current string) string {
+	if current != "" {
+		question += " (Enter keeps the current one)"
+	}
+	fmt.Fprintf(p.out, "%s: ", question)
+	var line string
+	if p.hidden != nil {
+		line, _ = p.hidden()
+		fmt.Fprintln(p.out)
+	} else {
+		line, _ = p.in.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+	}
+	if line == "" {
+		return current
+	}
+	return line
+}
+
+// initAnswers is what config init asks for
+type initAnswers struct {
+	ssids, passwords []string
+	consolePassword  string
+	brokers          []string
+	mqttUsername     string
+	mqttPassword     string
+	clientID         string
+	premisesID       string
+	timezone         string
+	network          string // device.json network object
+	collector        string
+	session          bool
+	homeAssistant    bool
+	commandSecret    string
+}
+
+// configInit interactively writes a complete starter configuration under
+// dir: config/device.json with every setting, the credentials, and empty
+// files for the other embedded .text files. Existing credentials are
+// offered as defaults.
+func configInit(dir string, p *prompter) error {
+	devicePath := filepath.Join(dir, "config", "device.json")
+	if data, err := os.ReadFile(devicePath); err == nil && strings.TrimSpace(string(data)) != "{}" && strings.TrimSpace(string(data)) != "" {
+		if !p.askYes(devicePath+" exists. Overwrite it?", false) {
+			return errors.New("nothing written")
+		}
+	}
+	current := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, "credentials", name))
+		return strings.TrimRight(string(data), "\r\n")
+	}
+
+	var a initAnswers
+	fmt.Fprintln(p.out, "WiFi")
+	a.ssids = splitList(p.ask("  Network names, comma-separated in priority order", strings.ReplaceAll(current("ssid.text"), "\n", ", ")))
+	oldPasswords := strings.Split(current("password.text"), "\n")
+	for i, ssid := range a.ssids {
+		old := ""
+		if i < len(oldPasswords) {
+			old = strings.TrimRight(oldPasswords[i], "\r")
+		}
+		a.passwords = append(a.passwords, p.askSecret("  Password for "+ssid, old))
+	}
+	a.consolePassword = p.askSecret("  Debug console password", current("console_password.text"))
+
+	fmt.Fprintln(p.out, "Network")
+	switch p.ask("  IP setup, dhcp or static", "dhcp") {
+	case "static":
+		addr := p.ask("  Address with prefix length", "192.168.1.50/24")
+		gateway := p.ask("  Gateway", "192.168.1.1")
+		dns := splitList(p.ask("  DNS servers, comma-separated", gateway))
+		a.network = `{"mode": "static", "address": ` + quote(addr) + `, "gateway": ` + quote(gateway) + `, "dns": ` + quoteList(dns) + `}`
+	default:
+		if req := p.ask("  Address to request from DHCP (blank for any)", ""); req != "" {
+			a.network = `{"mode": "dhcp", "request": ` + quote(req) + `}`
+		} else {
+			a.network = `{"mode": "dhcp"}`
+		}
+	}
+
+	fmt.Fprintln(p.out, "MQTT")
+	a.brokers = splitList(p.ask("  Brokers, comma-separated in order (host[:port])", "192.168.1.100:1883"))
+	a.mqttUsername = p.ask("  Username (blank for anonymous)", current("mqtt_username.text"))
+	if a.mqttUsername != "" {
+		a.mqttPassword = p.askSecret("  Password", current("mqtt_password.text"))
+	}
+	a.clientID = p.ask("  Client ID", "bindicator")
+	a.premisesID = p.ask("  Premises ID sent in schedule requests", "1")
+	a.session = p.askYes("  Keep a persistent session (for remote commands and Home Assistant switches)?", false)
+	a.homeAssistant = p.askYes("  Enable Home Assistant discovery?", false)
+	if a.session && p.askYes("  Enable signed remote commands (generates a shared secret)?", false) {
+		a.commandSecret = current("command_secret.text")
+		if a.commandSecret == "" {
+			var b [24]byte
+			if _, err := rand.Read(b[:]); err != nil {
+				return err
+			}
+			a.commandSecret = hex.EncodeToString(b[:])
+		}
+	}
+
+	fmt.Fprintln(p.out, "Time and telemetry")
+	a.timezone = p.ask("  Timezone", config.DefaultTimezone)
+	a.collector = p.ask("  Telemetry collector host:port (blank to disable telemetry)", "")
+
+	// Write everything
+	if err := os.MkdirAll(filepath.Join(dir, "config"), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "credentials"), 0o755); err != nil {
+		return err
+	}
+	write := func(sub, name, data string, perm os.FileMode) error {
+		path := filepath.Join(dir, sub, name)
+		if err := os.WriteFile(path, []byte(data), perm); err != nil {
+			return err
+		}
+		fmt.Fprintln(p.out, "Wrote "+path)
+		return nil
+	}
+	if err := write("config", "device.json", deviceJSON(a), 0o644); err != nil {
+		return err
+	}
+	// device.json covers these, but the firmware still embeds them
+	for _, name := range config.Files() {
+		path := filepath.Join(dir, "config", name)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if err := write("config", name, "", 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	credentials := map[string]string{
+		"ssid.text":             strings.Join(a.ssids, "\n"),
+		"password.text":         strings.Join(a.passwords, "\n"),
+		"console_password.text": a.consolePassword, // No line break: compared as is
+		"command_secret.text":   a.commandSecret,
+		"mqtt_username.text":    a.mqttUsername,
+		"mqtt_password.text":    a.mqttPassword,
+	}
+	for _, name := range credentialFiles {
+		if err := write("credentials", name, credentials[name], 0o600); err != nil {
+			return err
+		}
+	}
+	if a.commandSecret != "" {
+		fmt.Fprintln(p.out, "Sign commands with BINDICATOR_COMMAND_SECRET set to the contents of credentials/command_secret.text")
+	}
+	return nil
+}
+
+// deviceJSON renders the starter device.json
+func deviceJSON(a initAnswers) string {
+	keepalive := "off"
+	if a.session {
+		keepalive = "60s"
+	}
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+	line("// Bindicator device configuration, written by bindicator-cli config init.")
+	line("// Every key is optional; a key replaces the config/*.text file of the same")
+	line("// setting. Check changes with: bindicator-cli config check")
+	line("{")
+	line(`  // IP setup: "dhcp" (optionally "request": "<address>") or "static" with`)
+	line(`  // "address" ("<address>/<bits>"), "gateway" and up to 2 "dns" servers`)
+	line(`  "network": %s,`, a.network)
+	line(``)
+	line(`  "mqtt": {`)
+	line(`    // Tried in order; hostnames are resolved by DNS, the port defaults to 1883`)
+	line(`    "brokers": %s,`, quoteList(a.brokers))
+	line(`    "client_id": %s,`, quote(a.clientID))
+	line(`    // "off" fetches the schedule with a short session on every refresh; a`)
+	line(`    // keepalive such as "60s" keeps a persistent session with pushed schedules;`)
+	line(`    // remote commands and Home Assistant switches need it`)
+	line(`    "keepalive": %s,`, quote(keepalive))
+	line(`    "schedule_refresh_interval": %s,`, quote(config.DefaultScheduleRefreshInterval.String()))
+	line(`    // {client} is replaced with the client ID, {premises} with the premises ID`)
+	line(`    // replies that do not echo the request's nonce (CSV bridges) are accepted on a`)
+	line(`    // shared response topic only; "nonceless": true or false overrides that`)
+	line(`    "topics": {"request": %s, "response": %s, "schedule": %s},`, quote(config.DefaultRequestTopic), quote(config.DefaultResponseTopic), quote(config.DefaultScheduleTopic))
+	line(`    // switches adds a switch per LED (needs the persistent session)`)
+	line(`    "home_assistant": {"enabled": %t, "switches": false, "prefix": %s}`, a.homeAssistant, quote(config.DefaultHomeAssistantPrefix))
+	line(`  },`)
+	line(``)
+	line(`  "ntp": {"server": %s, "timezone": %s},`, quote(config.DefaultNTPServer), quote(a.timezone))
+	line(``)
+	line(`  // OTLP/HTTP collector, host:port`)
+	line(`  "telemetry": {"enabled": %t, "collector": %s},`, a.collector != "", quote(a.collector))
+	line(``)
+	line(`  "leds": {`)
+	line(`    // How often the LEDs are updated`)
+	line(`    "wake_interval": %s,`, quote(config.DefaultWakeInterval.String()))
+	line(`    // Further premises need "pins": the LED pin of each bin, in bins order`)
+	line(`    "premises": [{"name": "home", "id": %s}],`, quote(a.premisesID))
+	line(`    // "gpio" switches the LEDs; "pwm" dims them, with "brightness" (percent).`)
+	line(`    // active_low is for LEDs wired between the pin and 3V3`)
+	line(`    "output": {"mode": "gpio", "active_low": false}`)
+	line(`  },`)
+	line(``)
+	line(`  // channel is the GPIO pin of the bin's LED; aliases are extra schedule names.`)
+	line(`  // Optional: "on"/"off" ("[±Nd]HH:MM") or "lead"/"trail" durations set the`)
+	line(`  // window, "every" ("14d", "2w") with "anchor" (a known collection date)`)
+	line(`  // generates collections offline`)
+	line(`  "bins": [`)
+	for i, bin := range config.DefaultBins {
+		sep := ","
+		if i == len(config.DefaultBins)-1 {
+			sep = ""
+		}
+		line(`    {"name": %s, "channel": %d}%s`, quote(bin.Name), bin.Channel, sep)
+	}
+	line(`  ],`)
+	line(`  // Schedule names that map to a bin, e.g. "GARDEN WASTE": "brown"`)
+	line(`  "bin_aliases": {},`)
+	line(``)
+	line(`  "console": {"port": %d}`, config.DefaultConsolePort)
+	line("}")
+	return b.String()
+}
+
+// splitList splits a comma-separated answer
+func splitList(s string) []string {
+	var list []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
+// quote returns s as a JSON string
+func quote(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// quoteList returns list as a JSON array of strings
+func quoteList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = quote(s)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// runConfigTool handles bindicator-cli config check|init [dir]
+func runConfigTool(args []string) int {
+	dir := "."
+	if len(args) > 1 {
+		dir = args[1]
+	}
+	switch args[0] {
+	case "check":
+		n, err := configCheck(dir, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if n > 0 {
+			return 1
+		}
+	case "init":
+		p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			p.hidden = func() (string, error) {
+				b, err := term.ReadPassword(int(os.Stdin.Fd()))
+				return string(b), err
+			}
+		}
+		if err := configInit(dir, p); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Println()
+		if n, err := configCheck(dir, os.Stdout); err != nil || n > 0 {
+			return 1
+		}
+		fmt.Println("Next: make build")
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigInit(t *testing.T) {
+	dir := t.TempDir()
+	answers := strings.Join([]string{
+		"home, garage", "pw1", "pw2", "letmein", // WiFi and console
+		"static", "192.168.1.50/24", "", "", // Network
+		"mqtt.local:1884, 192.168.1.100", "bin", "mqttpw", "kitchen", "", "y", "n", "y", // MQTT
+		"", "", // Time and telemetry
+	}, "\n") + "\n"
+	p := &prompter{in: bufio.NewReader(strings.NewReader(answers)), out: io.Discard}
+	if err := configInit(dir, p); err != nil {
+		t.Fatalf("configInit() err = %v", err)
+	}
+
+	var out strings.Builder
+	if n, err := configCheck(dir, &out); n != 0 || err != nil {
+		t.Fatalf("configCheck() = %d, %v:\n%s", n, err, out.String())
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read("credentials/ssid.text"); got != "home\ngarage" {
+		t.Errorf("ssid.text = %q", got)
+	}
+	if got := read("credentials/password.text"); got != "pw1\npw2" {
+		t.Errorf("password.text = %q", got)
+	}
+	if got := read("credentials/console_password.text"); got != "letmein" {
+		t.Errorf("console_password.text = %q", got)
+	}
+	if got := read("credentials/command_secret.text"); len(got) != 48 {
+		t.Errorf("command_secret.text = %q, want a generated secret", got)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "credentials/mqtt_password.text")); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("mqtt_password.text mode = %v, %v", fi.Mode(), err)
+	}
+	device := read("config/device.json")
+	for _, want := range []string{"// IP setup", `"address": "192.168.1.50/24"`, `"client_id": "kitchen"`, `"keepalive": "60s"`} {
+		if !strings.Contains(device, want) {
+			t.Errorf("device.json missing %q:\n%s", want, device)
+		}
+	}
+
+	// A second run keeps the secrets when Enter is pressed, once allowed to
+	// overwrite device.json
+	answers = "y\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n\n"
+	p = &prompter{in: bufio.NewReader(strings.NewReader(answers)), out: io.Discard}
+	if err := configInit(dir, p); err != nil {
+		t.Fatalf("configInit() again err = %v", err)
+	}
+	if got := read("credentials/password.text"); got != "pw1\npw2" {
+		t.Errorf("password.text after rerun = %q", got)
+	}
+	if got := read("credentials/console_password.text"); got != "letmein" {
+		t.Errorf("console_password.text after rerun = %q", got)
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config/device.json":                `{"leds": {"wake_interval": "soon"}, "bins": [{"name": "green"}]}`,
+		"config/broker.text":                "",
+		"credentials/ssid.text":             "home",
+		"credentials/password.text":         "pw",
+		"credentials/console_password.text": "letmein\n",
+		"credentials/mqtt_username.text":    "",
+		"credentials/mqtt_password.text":    "mqttpw",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	n, err := configCheck(dir, &out)
+	if err != nil {
+		t.Fatalf("configCheck() err = %v", err)
+	}
+	for _, want := range []string{
+		"config/network.text: missing",
+		"credentials/command_secret.text: missing",
+		"device.json leds.wake_interval: invalid interval",
+		"device.json bins[0]: channel is required",
+		"console_password.text: ends with a line break",
+		"mqtt_password.text: set without a username",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+	if n == 0 || !strings.Contains(out.String(), "problem(s) found") {
+		t.Errorf("configCheck() = %d problems", n)
+	}
+
+	if _, err := configCheck(t.TempDir(), io.Discard); err == nil {
+		t.Error("configCheck() accepted a directory without config/")
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bufio"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -44,9 +47,15 @@ func main() {
 		}
 	}
 
-	// Check for command as second positional arg
+	// Check for command as second positional arg; the rest are its
+	// arguments (e.g. config set wake_interval 5m)
 	if *cmd == "" && flag.NArg() > 1 {
-		*cmd = flag.Arg(1)
+		*cmd = strings.Join(flag.Args()[1:], " ")
+	}
+
+	// config check/init work on the project tree and need no device
+	if *host == "config" && flag.NArg() > 1 && (flag.Arg(1) == "check" || flag.Arg(1) == "init") {
+		os.Exit(runConfigTool(flag.Args()[1:]))
 	}
 
 	// Resolve password early for OTA commands that need console access
@@ -90,6 +99,23 @@ func main() {
 		return
 	}
 
+	// sign-command doesn't need a host either; the envelope goes to the broker
+	if flag.NArg() > 0 && flag.Arg(0) == "sign-command" {
+		if flag.NArg() < 2 {
+			fmt.Println("Usage: bindicator-cli sign-command <command> [args...]")
+			os.Exit(1)
+		}
+		secret := os.Getenv("BINDICATOR_COMMAND_SECRET")
+		if secret == "" {
+			fmt.Fprintln(os.Stderr, "Error: BINDICATOR_COMMAND_SECRET is not set")
+			os.Exit(1)
+		}
+		id := fmt.Sprintf("cli-%x", time.Now().UnixNano())
+		envelope := signCommand(secret, id, strings.Join(flag.Args()[1:], " "), time.Now())
+		fmt.Println(string(envelope))
+		return
+	}
+
 	// ota-file doesn't need a host, just inspect the file
 	if *cmd == "ota-file" || (flag.NArg() > 0 && flag.Arg(0) == "ota-file") {
 		var fwPath string
@@ -141,8 +167,9 @@ func printUsage() {
 	fmt.Println()
 	fmt.Println("Console Commands:")
 	fmt.Println("  help, version, status, net, wifi, time, jobs, next, leds, ota")
-	fmt.Println("  refresh, sleep <dur>, ota-enable [dur]")
-	fmt.Println("  led-green, led-black, led-brown")
+	fmt.Println("  refresh, sleep <dur>, ota-enable [dur], schedule-errors")
+	fmt.Println("  led-<bin> (e.g. led-green, led-black, led-brown)")
+	fmt.Println("  config [list], config get <key>, config set <key> <value>, config reset [key]")
 	fmt.Println()
 	fmt.Println("OTA Commands:")
 	fmt.Println("  ota-info                   Query device OTA status")
@@ -150,12 +177,49 @@ func printUsage() {
 	fmt.Println("  ota-push <file.uf2>        Push firmware update (auto-enables OTA)")
 	fmt.Println("  ota-file <file.uf2>        Inspect UF2 file (no device needed)")
 	fmt.Println()
+	fmt.Println("MQTT Commands:")
+	fmt.Println("  sign-command <command>     Print a signed command envelope for")
+	fmt.Println("                             bindicator/<clientid>/cmd (secret from")
+	fmt.Println("                             BINDICATOR_COMMAND_SECRET)")
+	fmt.Println()
+	fmt.Println("Project Commands (no device needed):")
+	fmt.Println("  config check [dir]         Check config/ and credentials/ as the")
+	fmt.Println("                             firmware parses them")
+	fmt.Println("  config init [dir]          Write a commented starter configuration")
+	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  bindicator-cli 172.18.1.136                      # Interactive mode")
 	fmt.Println("  bindicator-cli 172.18.1.136 status               # Single command")
 	fmt.Println("  bindicator-cli -password secret 172.18.1.136 status")
 	fmt.Println("  BINDICATOR_PASSWORD=secret bindicator-cli 172.18.1.136 status")
+	fmt.Println("  bindicator-cli 172.18.1.136 config set wake_interval 5m")
 	fmt.Println("  bindicator-cli ota-file build.uf2                # Inspect file")
+	fmt.Println("  bindicator-cli config check .                    # Before make build")
+	fmt.Println("  bindicator-cli sign-command led-black | mosquitto_pub -t bindicator/kitchen/cmd -s")
+}
+
+// commandEnvelope is a remote command as sent on the command topic
+type commandEnvelope struct {
+	ID  string `json:"id"`
+	Cmd string `json:"cmd"`
+	TS  int64  `json:"ts"`
+	MAC string `json:"mac"`
+}
+
+// signCommand returns the JSON envelope for cmd, signed with the shared
+// secret: mac is the HMAC-SHA256 of "<id>\n<ts>\n<cmd>". The device only
+// accepts it within 5 minutes of now.
+func signCommand(secret, id, cmd string, now time.Time) []byte {
+	ts := now.Unix()
+	m := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(m, "%s\n%d\n%s", id, ts, cmd)
+	envelope, _ := json.Marshal(commandEnvelope{
+		ID:  id,
+		Cmd: cmd,
+		TS:  ts,
+		MAC: hex.EncodeToString(m.Sum(nil)),
+	})
+	return envelope
 }
 
 // runCommand executes a single command and prints the response
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Remote commands. With a command secret configured, a persistent session
+// subscribes to bindicator/<clientid>/cmd and accepts signed envelopes:
+//
+//	{"id":"c-42","cmd":"led-black home","ts":1768852800,"mac":"<64 hex digits>"}
+//
+// mac is the HMAC-SHA256 of "<id>\n<ts>\n<cmd>" under the shared secret.
+// The reply goes to bindicator/<clientid>/cmd/reply (not retained):
+//
+//	{"id":"c-42","ok":true,"output":"Black LED: ON"}
+const (
+	topicCommandSuffix  = "/cmd"
+	topicReplySuffix    = "/cmd/reply"
+	maxCommandIDLen     = 32
+	maxCommandLen       = 48
+	commandBufSize      = 256 // Largest envelope read
+	commandOutputSize   = 256
+	commandReplySize    = 384
+	commandMaxSkew      = 5 * time.Minute // Accepted clock difference
+	commandReplayWindow = 16              // Commands accepted within commandMaxSkew
+)
+
+// Command envelope errors
+var (
+	errCommandSyntax   = errors.New("command: invalid envelope")
+	errCommandAuth     = errors.New("command: bad signature")
+	errCommandStale    = errors.New("command: timestamp outside window")
+	errCommandReplay   = errors.New("command: already executed")
+	errCommandBusy     = errors.New("command: too many recent commands")
+	errCommandRetained = errors.New("command: retained message ignored")
+	errCommandTooLarge = errors.New("command: envelope too large")
+)
+
+// Command topics (built by setStatusTopics)
+var (
+	commandTopicBuf [maxStatusTopicLen]byte
+	commandTopicLen int
+	replyTopicBuf   [maxStatusTopicLen]byte
+	replyTopicLen   int
+)
+
+// setCommandTopics expands the command topics below topicDeviceTemplate
+// for a client ID
+func setCommandTopics(clientID string) {
+	commandTopicLen = expandTopic(commandTopicBuf[:], topicDeviceTemplate+topicCommandSuffix, clientID, "")
+	replyTopicLen = expandTopic(replyTopicBuf[:], topicDeviceTemplate+topicReplySuffix, clientID, "")
+}
+
+// commandTopic returns bindicator/<clientid>/cmd
+func commandTopic() []byte {
+	return commandTopicBuf[:commandTopicLen]
+}
+
+// commandReplyTopic returns bindicator/<clientid>/cmd/reply
+func commandReplyTopic() []byte {
+	return replyTopicBuf[:replyTopicLen]
+}
+
+// remoteCommand is a parsed command envelope
+type remoteCommand struct {
+	ID        [maxCommandIDLen]byte
+	IDLen     uint8
+	Cmd       [maxCommandLen]byte
+	CmdLen    uint8
+	Timestamp int64
+	MAC       [sha256.Size]byte
+}
+
+// id returns the correlation ID (empty if the envelope had none)
+func (c *remoteCommand) id() []byte {
+	return c.ID[:c.IDLen]
+}
+
+// cmd returns the console command line
+func (c *remoteCommand) cmd() []byte {
+	return c.Cmd[:c.CmdLen]
+}
+
+// parseRemoteCommand parses a command envelope into c. All four members
+// are required and unknown members are skipped. The ID is kept even when
+// the envelope is rejected later, so the error can still be answered.
+func parseRemoteCommand(data []byte, c *remoteCommand) error {
+	*c = remoteCommand{}
+	r := jsonReader{data: data}
+	r.skipValue(0)
+	r.skipSpace()
+	if r.err != nil || r.pos != len(data) || len(data) == 0 {
+		return errCommandSyntax
+	}
+
+	var hasCmd, hasTS, hasMAC bool
+	r = jsonReader{data: data}
+	if !r.expect('{') {
+		return errCommandSyntax
+	}
+	for i := 0; r.next('}', i); i++ {
+		key := r.readString()
+		r.expect(':')
+		switch {
+		case jsonKeyIs(key, "id"):
+			s := r.stringValue()
+			if len(s) > len(c.ID) {
+				return errCommandSyntax
+			}
+			c.IDLen = uint8(copyJSONString(c.ID[:], s))
+		case jsonKeyIs(key, "cmd"):
+			s := r.stringValue()
+			if len(s) == 0 || len(s) > len(c.Cmd) {
+				return errCommandSyntax
+			}
+			c.CmdLen = uint8(copyJSONString(c.Cmd[:], s))
+			hasCmd = true
+		case jsonKeyIs(key, "ts"):
+			c.Timestamp = r.intValue()
+			hasTS = c.Timestamp > 0
+		case jsonKeyIs(key, "mac"):
+			hasMAC = decodeHex(c.MAC[:], r.stringValue())
+		default:
+			r.skipValue(0)
+		}
+	}
+	if r.err != nil || c.IDLen == 0 || !hasCmd || !hasTS || !hasMAC {
+		return errCommandSyntax
+	}
+	return nil
+}
+
+// commandMAC returns the HMAC-SHA256 of "<id>\n<ts>\n<cmd>" under secret
+func commandMAC(secret, id, cmd []byte, ts int64) [sha256.Size]byte {
+	var tsBuf [20]byte
+	m := hmac.New(sha256.New, secret)
+	m.Write(id)
+	m.Write([]byte{'\n'})
+	m.Write(strconv.AppendInt(tsBuf[:0], ts, 10))
+	m.Write([]byte{'\n'})
+	m.Write(cmd)
+
+	var sum [sha256.Size]byte
+	m.Sum(sum[:0])
+	return sum
+}
+
+// commandGuard authenticates command envelopes and remembers every one it
+// accepted until its timestamp leaves the window, so a captured envelope
+// cannot be executed twice. Once commandReplayWindow commands are inside
+// the window, further ones are refused until the oldest expire.
+type commandGuard struct {
+	secret []byte
+	recent [commandReplayWindow]acceptedCommand
+}
+
+// acceptedCommand is the MAC and timestamp of an accepted envelope (a zero
+// timestamp marks a free slot)
+type acceptedCommand struct {
+	mac [sha256.Size]byte
+	ts  int64
+}
+
+// commands guards the command topic (disabled until setSecret)
+var commands commandGuard
+
+// setSecret sets the shared secret (see trimSecret)
+func (g *commandGuard) setSecret(s string) {
+	g.secret = []byte(trimSecret(s))
+}
+
+// trimSecret drops the trailing whitespace editors leave in secret files
+func trimSecret(s string) string {
+	for len(s) > 0 && (s[len(s)-1] == '\n' || s[len(s)-1] == '\r' || s[len(s)-1] == ' ' || s[len(s)-1] == '\t') {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// enabled reports whether a secret is configured
+func (g *commandGuard) enabled() bool {
+	return len(g.secret) > 0
+}
+
+// verify checks the signature, the timestamp against now and the replay
+// window, then records c as executed
+func (g *commandGuard) verify(c *remoteCommand, now time.Time) error {
+	if !g.enabled() {
+		return errCommandAuth
+	}
+	want := commandMAC(g.secret, c.id(), c.cmd(), c.Timestamp)
+	if !hmac.Equal(want[:], c.MAC[:]) {
+		return errCommandAuth
+	}
+	if !commandInWindow(c.Timestamp, now) {
+		return errCommandStale
+	}
+	free := -1
+	for i := range g.recent {
+		a := &g.recent[i]
+		if a.ts == 0 || !commandInWindow(a.ts, now) {
+			// Expired: a replay of it would be stale
+			if free < 0 {
+				free = i
+			}
+			continue
+		}
+		if a.mac == c.MAC {
+			return errCommandReplay
+		}
+	}
+	if free < 0 {
+		return errCommandBusy
+	}
+	g.recent[free] = acceptedCommand{mac: c.MAC, ts: c.Timestamp}
+	return nil
+}
+
+// commandInWindow reports whether timestamp ts is within commandMaxSkew of
+// now
+func commandInWindow(ts int64, now time.Time) bool {
+	skew := now.Sub(time.Unix(ts, 0))
+	return skew <= commandMaxSkew && skew >= -commandMaxSkew
+}
+
+// encodeCommandReply writes the reply JSON for a command into buf and
+// returns its length. output is the command's text; newlines are kept.
+func encodeCommandReply(buf, id []byte, ok bool, output []byte) (int, error) {
+	w := stateWriter{buf: buf}
+	w.raw(`{"id":"`)
+	w.escBytes(id)
+	w.raw(`","ok":`)
+	w.bool(ok)
+	w.raw(`,"output":"`)
+	w.escBytes(output)
+	w.raw(`"}`)
+	if w.overflow {
+		return 0, errStateTooLarge
+	}
+	return w.n, nil
+}
+
+// Write appends p, so console commands can write their output into a
+// command reply (see runRemoteCommand). Output that does not fit is
+// dropped and reported as errStateTooLarge.
+func (w *stateWriter) Write(p []byte) (int, error) {
+	w.bytes(p)
+	if w.overflow {
+		return 0, errStateTooLarge
+	}
+	return len(p), nil
+}
+
+// escBytes writes the body of a JSON string like esc, but escapes
+// newlines instead of dropping them (command output has several lines)
+func (w *stateWriter) escBytes(b []byte) {
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			w.byte('\\')
+			w.byte(c)
+		case c == '\n':
+			w.raw(`\n`)
+		case c >= ' ':
+			w.byte(c)
+		}
+	}
+}
+
+// decodeHex decodes exactly len(dst) bytes of hex digits from s
+func decodeHex(dst, s []byte) bool {
+	if len(s) != 2*len(dst) {
+		return false
+	}
+	for i := range dst {
+		hi, ok1 := hexDigit(s[2*i])
+		lo, ok2 := hexDigit(s[2*i+1])
+		if !ok1 || !ok2 {
+			return false
+		}
+		dst[i] = hi<<4 | lo
+	}
+	return true
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
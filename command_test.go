@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Test vector shared with cmd/cli (sign-command)
+const (
+	testCommandSecret = "fleet-secret"
+	testCommandMAC    = "a39855571330af4fe6a839e8e8f913403837206804e020fb2aaf4d84b268edf9"
+)
+
+func TestCommandTopics(t *testing.T) {
+	setStatusTopics("kitchen")
+	if string(commandTopic()) != "bindicator/kitchen/cmd" || string(commandReplyTopic()) != "bindicator/kitchen/cmd/reply" {
+		t.Errorf("topics = %q, %q", commandTopic(), commandReplyTopic())
+	}
+}
+
+func TestParseRemoteCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+		wantID  string
+		wantCmd string
+	}{
+		{"valid", `{"id":"c-42","cmd":"led-black home","ts":1768852800,"mac":"` + testCommandMAC + `"}`, nil, "c-42", "led-black home"},
+		{"any order and unknown members", `{"mac":"` + testCommandMAC + `","x":[1,{"y":2}],"ts":1768852800,"cmd":"refresh","id":"a"}`, nil, "a", "refresh"},
+		{"escaped id", `{"id":"a\"b","cmd":"refresh","ts":1,"mac":"` + testCommandMAC + `"}`, nil, `a"b`, "refresh"},
+		{"missing id", `{"cmd":"refresh","ts":1,"mac":"` + testCommandMAC + `"}`, errCommandSyntax, "", "refresh"},
+		{"missing mac keeps id", `{"id":"c-1","cmd":"refresh","ts":1}`, errCommandSyntax, "c-1", "refresh"},
+		{"short mac", `{"id":"c-1","cmd":"refresh","ts":1,"mac":"abcd"}`, errCommandSyntax, "c-1", "refresh"},
+		{"bad hex", `{"id":"c-1","cmd":"refresh","ts":1,"mac":"` + testCommandMAC[:62] + `zz"}`, errCommandSyntax, "c-1", "refresh"},
+		{"zero timestamp", `{"id":"c-1","cmd":"refresh","ts":0,"mac":"` + testCommandMAC + `"}`, errCommandSyntax, "c-1", "refresh"},
+		{"empty command", `{"id":"c-1","cmd":"","ts":1,"mac":"` + testCommandMAC + `"}`, errCommandSyntax, "c-1", ""},
+		{"id too long", `{"id":"0123456789012345678901234567890123","cmd":"refresh","ts":1,"mac":"` + testCommandMAC + `"}`, errCommandSyntax, "", ""},
+		{"truncated", `{"id":"c-1","cmd":"refresh"`, errCommandSyntax, "", ""},
+		{"not an object", `["refresh"]`, errCommandSyntax, "", ""},
+		{"empty", ``, errCommandSyntax, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c remoteCommand
+			err := parseRemoteCommand([]byte(tt.input), &c)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if string(c.id()) != tt.wantID || string(c.cmd()) != tt.wantCmd {
+				t.Errorf("id, cmd = %q, %q; want %q, %q", c.id(), c.cmd(), tt.wantID, tt.wantCmd)
+			}
+		})
+	}
+}
+
+func TestCommandGuard(t *testing.T) {
+	ts := time.Unix(1768852800, 0)
+	envelope := func(cmd string, sent time.Time, secret string) *remoteCommand {
+		c := &remoteCommand{Timestamp: sent.Unix()}
+		c.IDLen = uint8(copy(c.ID[:], "c-42"))
+		c.CmdLen = uint8(copy(c.Cmd[:], cmd))
+		c.MAC = commandMAC([]byte(secret), c.id(), c.cmd(), c.Timestamp)
+		return c
+	}
+
+	c := envelope("led-black home", ts, testCommandSecret)
+	var want remoteCommand
+	decodeHex(want.MAC[:], []byte(testCommandMAC))
+	if c.MAC != want.MAC {
+		t.Fatalf("commandMAC = %x, want %s", c.MAC, testCommandMAC)
+	}
+
+	var off commandGuard
+	if off.enabled() || off.verify(c, ts) != errCommandAuth {
+		t.Error("guard without a secret accepted a command")
+	}
+
+	var g commandGuard
+	g.setSecret(testCommandSecret + "\r\n")
+	tests := []struct {
+		name string
+		c    *remoteCommand
+		now  time.Time
+		want error
+	}{
+		{"valid", c, ts.Add(time.Minute), nil},
+		{"replayed", c, ts.Add(2 * time.Minute), errCommandReplay},
+		{"wrong secret", envelope("reboot", ts, "other"), ts, errCommandAuth},
+		{"too old", envelope("reboot", ts, testCommandSecret), ts.Add(commandMaxSkew + time.Second), errCommandStale},
+		{"from the future", envelope("reboot", ts, testCommandSecret), ts.Add(-commandMaxSkew - time.Second), errCommandStale},
+		{"edge of window", envelope("reboot", ts, testCommandSecret), ts.Add(commandMaxSkew), nil},
+	}
+	for _, tt := range tests {
+		if err := g.verify(tt.c, tt.now); err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// A tampered command no longer matches its MAC
+	tampered := *envelope("refresh", ts, testCommandSecret)
+	tampered.CmdLen = uint8(copy(tampered.Cmd[:], "reboot"))
+	if err := g.verify(&tampered, ts); err != errCommandAuth {
+		t.Errorf("tampered: err = %v, want %v", err, errCommandAuth)
+	}
+
+	// Every command accepted inside the window is remembered: once the
+	// window is full, more are refused rather than forgetting one
+	var full commandGuard
+	full.setSecret(testCommandSecret)
+	if err := full.verify(c, ts); err != nil {
+		t.Fatalf("first command: %v", err)
+	}
+	for i := 1; i < commandReplayWindow; i++ {
+		sent := ts.Add(time.Duration(i) * time.Second)
+		if err := full.verify(envelope("refresh", sent, testCommandSecret), ts); err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+	}
+	if err := full.verify(envelope("reboot", ts, testCommandSecret), ts); err != errCommandBusy {
+		t.Errorf("window full: err = %v, want %v", err, errCommandBusy)
+	}
+	if err := full.verify(c, ts.Add(commandMaxSkew)); err != errCommandReplay {
+		t.Errorf("replayed at the edge of the window: err = %v, want %v", err, errCommandReplay)
+	}
+
+	// Commands whose timestamps left the window free their slots
+	later := ts.Add(commandMaxSkew + time.Minute)
+	if err := full.verify(envelope("reboot", later, testCommandSecret), later); err != nil {
+		t.Errorf("after the window moved on: err = %v", err)
+	}
+}
+
+func TestEncodeCommandReply(t *testing.T) {
+	var buf [commandReplySize]byte
+	n, err := encodeCommandReply(buf[:], []byte(`c"1`), true, []byte("NTP sync complete\nOffset: 12ms\r"))
+	if err != nil {
+		t.Fatalf("encodeCommandReply: %v", err)
+	}
+	var got struct {
+		ID     string `json:"id"`
+		OK     bool   `json:"ok"`
+		Output string `json:"output"`
+	}
+	if err := json.Unmarshal(buf[:n], &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", buf[:n], err)
+	}
+	if got.ID != `c"1` || !got.OK || got.Output != "NTP sync complete\nOffset: 12ms" {
+		t.Errorf("reply = %+v", got)
+	}
+
+	if _, err := encodeCommandReply(buf[:10], []byte("c-1"), false, nil); err != errStateTooLarge {
+		t.Errorf("small buffer: err = %v, want %v", err, errStateTooLarge)
+	}
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	_ "embed"
+	"errors"
 	"net/netip"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,6 +16,14 @@ const (
 	DefaultScheduleRefreshInterval = 3 * time.Hour
 	DefaultNTPServer               = "time.cloudflare.com"
 	DefaultTelemetryEnabled        = true
+	DefaultTimezone                = "Europe/London"
+)
+
+// MQTT keepalive limits for the persistent session (the protocol carries
+// the keepalive as a 16-bit number of seconds)
+const (
+	MinMQTTKeepAlive = 5 * time.Second
+	MaxMQTTKeepAlive = 65535 * time.Second
 )
 
 // Environment-specific configuration (must be provided via embedded text files).
@@ -41,31 +51,404 @@ var (
 
 	//go:embed telemetry_enabled.text
 	telemetryEnabledOverride string
+
+	//go:embed timezone.text
+	timezoneOverride string
+
+	//go:embed bins.text
+	binsOverride string
+
+	//go:embed bin_aliases.text
+	binAliasesOverride string
+
+	//go:embed premises.text
+	premisesOverride string
+
+	//go:embed mqtt_keepalive.text
+	mqttKeepAliveOverride string
+
+	//go:embed homeassistant.text
+	homeAssistantOverride string
+
+	//go:embed topics.text
+	topicsOverride string
+
+	//go:embed network.text
+	networkOverride string
+
+	//go:embed led_output.text
+	ledOutputOverride string
 )
 
-// BrokerAddr returns the MQTT broker address from broker.text file.
-// Format: "host:port" e.g., "192.168.1.100:1883"
-func BrokerAddr() (netip.AddrPort, error) {
-	addr := strings.TrimSpace(brokerAddr)
-	return netip.ParseAddrPort(addr)
+// WiFi credentials: one network per line of ssid.text, with its
+// passphrase on the same line of password.text. The firmware passes them
+// in from the credentials package (see SetWiFiCredentials), so tools that
+// only import config, such as the CLI, never embed them.
+var (
+	wifiSSID     string
+	wifiPassword string
+)
+
+// SetWiFiCredentials sets the contents of credentials/ssid.text and
+// password.text. The firmware calls it at boot, before anything reads the
+// WiFi networks.
+func SetWiFiCredentials(ssid, password string) {
+	wifiSSID, wifiPassword = ssid, password
+}
+
+// WPA2 limits for the WiFi settings
+const (
+	MaxSSIDLen         = 32
+	MinWiFiPasswordLen = 8
+	MaxWiFiPasswordLen = 63
+)
+
+// MaxWiFiNetworks is the maximum number of networks tried.
+const MaxWiFiNetworks = 4
+
+// WiFiNetwork is a network the device can join.
+type WiFiNetwork struct {
+	SSID     string
+	Password string
+}
+
+// WiFiNetworks returns the networks to join, in the order they are tried.
+// The wifi_ssid and wifi_password settings replace the first network
+// (wifi_ssid alone moves a network listed in the credentials to the front);
+// the networks from credentials/ssid.text and password.text follow.
+func WiFiNetworks() ([]WiFiNetwork, error) {
+	list, err := parseWiFiNetworks(wifiSSID, wifiPassword)
+	if err != nil {
+		return nil, err
+	}
+	if !overrideSet[settingWiFiSSID] && !overrideSet[settingWiFiPassword] {
+		return list, nil
+	}
+
+	var first WiFiNetwork
+	if len(list) > 0 {
+		first = list[0]
+	}
+	if overrideSet[settingWiFiSSID] {
+		first = WiFiNetwork{SSID: overrideValue[settingWiFiSSID]}
+		for _, n := range list {
+			if n.SSID == first.SSID {
+				first.Password = n.Password
+			}
+		}
+	}
+	if overrideSet[settingWiFiPassword] {
+		first.Password = overrideValue[settingWiFiPassword]
+	}
+	if first.SSID == "" || first.Password == "" {
+		return nil, errors.New("wifi: wifi_ssid " + strconv.Quote(first.SSID) + " has no password")
+	}
+
+	networks := []WiFiNetwork{first}
+	for _, n := range list {
+		if n.SSID != first.SSID && len(networks) < MaxWiFiNetworks {
+			networks = append(networks, n)
+		}
+	}
+	return networks, nil
+}
+
+// parseWiFiNetworks pairs the lines of ssid.text and password.text. Blank
+// lines are skipped in both files.
+func parseWiFiNetworks(ssids, passwords string) ([]WiFiNetwork, error) {
+	var list []WiFiNetwork
+	for _, line := range strings.Split(ssids, "\n") {
+		if line = strings.TrimRight(line, "\r"); line == "" {
+			continue
+		}
+		if len(line) > MaxSSIDLen {
+			return nil, errors.New("wifi: SSID " + strconv.Quote(line) + " longer than " + strconv.Itoa(MaxSSIDLen) + " bytes")
+		}
+		if len(list) == MaxWiFiNetworks {
+			return nil, errors.New("wifi: more than " + strconv.Itoa(MaxWiFiNetworks) + " networks")
+		}
+		list = append(list, WiFiNetwork{SSID: line})
+	}
+	i := 0
+	for _, line := range strings.Split(passwords, "\n") {
+		if line = strings.TrimRight(line, "\r"); line == "" {
+			continue
+		}
+		if i == len(list) {
+			i++
+			break
+		}
+		list[i].Password = line
+		i++
+	}
+	if i != len(list) {
+		return nil, errors.New("wifi: ssid.text and password.text must have one line per network")
+	}
+	return list, nil
+}
+
+// MaxDNSServers is the maximum number of DNS servers of a static setup.
+const MaxDNSServers = 2
+
+// Network is the IPv4 setup of the WiFi interface.
+type Network struct {
+	Static  bool         // Fixed address; DHCP is not used
+	Addr    netip.Addr   // Static address, or the address requested from DHCP (invalid = none)
+	Subnet  netip.Prefix // Static only
+	Gateway netip.Addr   // Static only
+	DNS     []netip.Addr // Static only
+}
+
+// NetworkConfig returns the address setup from network.text: "dhcp",
+// optionally followed by "request=<addr>", or "static address=<addr>[/bits]
+// [netmask=<mask>] gateway=<addr> [dns=<addr>[,<addr>]]". The DNS servers
+// default to the gateway and the netmask to /24. Empty means plain DHCP.
+//
+// Example: "static address=192.168.1.50/24 gateway=192.168.1.1 dns=1.1.1.1"
+func NetworkConfig() (Network, error) {
+	return parseNetwork(value(settingNetwork), originOf(settingNetwork))
+}
+
+// DHCPRequest returns the address to request from DHCP: the configured one,
+// or 0.0.0.0 (no preference; the server chooses) if none is set.
+func (n Network) DHCPRequest() netip.Addr {
+	if n.Addr.IsValid() {
+		return n.Addr
+	}
+	return netip.AddrFrom4([4]byte{})
+}
+
+func parseNetwork(s string, o origin) (Network, error) {
+	var n Network
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return n, nil
+	}
+	switch strings.ToLower(fields[0]) {
+	case "dhcp":
+	case "static":
+		n.Static = true
+	default:
+		return Network{}, errors.New(o.name + ": expected dhcp or static, got " + strconv.Quote(fields[0]))
+	}
+
+	bits := -1
+	for _, f := range fields[1:] {
+		key, val, ok := strings.Cut(f, "=")
+		if !ok || val == "" {
+			return Network{}, errors.New(o.name + ": invalid option " + strconv.Quote(f))
+		}
+		var err error
+		switch {
+		case key == "request" && !n.Static, key == "address" && n.Static:
+			addr := val
+			if i := strings.IndexByte(val, '/'); i >= 0 && n.Static {
+				addr = val[:i]
+				var p netip.Prefix
+				if p, err = netip.ParsePrefix(val); err == nil {
+					bits = p.Bits()
+				}
+			}
+			if err == nil {
+				n.Addr, err = parseIPv4(addr)
+			}
+		case key == "netmask" && n.Static:
+			bits, err = parseNetmask(val)
+		case key == "gateway" && n.Static:
+			n.Gateway, err = parseIPv4(val)
+		case key == "dns" && n.Static:
+			for _, a := range strings.Split(val, ",") {
+				var ip netip.Addr
+				if ip, err = parseIPv4(a); err != nil {
+					break
+				}
+				n.DNS = append(n.DNS, ip)
+			}
+			if len(n.DNS) > MaxDNSServers {
+				err = errors.New("more than " + strconv.Itoa(MaxDNSServers) + " DNS servers")
+			}
+		default:
+			err = errors.New("unknown option for " + fields[0])
+		}
+		if err != nil {
+			return Network{}, errors.New(o.name + ": " + key + ": " + err.Error())
+		}
+	}
+	if !n.Static {
+		return n, nil
+	}
+
+	if !n.Addr.IsValid() || !n.Gateway.IsValid() {
+		return Network{}, errors.New(o.name + ": static needs address= and gateway=")
+	}
+	if bits < 0 {
+		bits = 24
+	}
+	n.Subnet = netip.PrefixFrom(n.Addr, bits).Masked()
+	if bits == 0 || bits > 30 || !n.Subnet.Contains(n.Gateway) || n.Gateway == n.Addr {
+		return Network{}, errors.New(o.name + ": gateway " + n.Gateway.String() + " is not another host on " + n.Subnet.String())
+	}
+	if len(n.DNS) == 0 {
+		n.DNS = []netip.Addr{n.Gateway}
+	}
+	return n, nil
+}
+
+// parseIPv4 parses a unicast IPv4 address
+func parseIPv4(s string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil || !ip.Is4() || ip.IsUnspecified() || ip.IsMulticast() || ip == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return netip.Addr{}, errors.New("invalid IPv4 address " + strconv.Quote(s))
+	}
+	return ip, nil
+}
+
+// parseNetmask parses a dotted netmask such as 255.255.255.0 into a prefix
+// length
+func parseNetmask(s string) (int, error) {
+	ip, err := netip.ParseAddr(s)
+	if err == nil && ip.Is4() {
+		a := ip.As4()
+		m := uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3])
+		bits := 0
+		for m&(1<<31) != 0 {
+			m <<= 1
+			bits++
+		}
+		if m == 0 {
+			return bits, nil
+		}
+	}
+	return 0, errors.New("invalid netmask " + strconv.Quote(s))
+}
+
+// DefaultMQTTPort is used for brokers given without a port.
+const DefaultMQTTPort = 1883
+
+// MaxBrokers is the maximum number of brokers in broker.text.
+const MaxBrokers = 4
+
+// Broker is an MQTT broker: an IP address or a DNS hostname, and a port.
+type Broker struct {
+	Host string
+	Port uint16
+}
+
+// AddrPort returns the broker address if Host is an IP address; ok is
+// false for hostnames, which must be resolved first.
+func (b Broker) AddrPort() (addr netip.AddrPort, ok bool) {
+	ip, err := netip.ParseAddr(b.Host)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ip, b.Port), true
+}
+
+// String returns the broker as "host:port".
+func (b Broker) String() string {
+	if addr, ok := b.AddrPort(); ok {
+		return addr.String()
+	}
+	return b.Host + ":" + strconv.Itoa(int(b.Port))
+}
+
+// Brokers returns the MQTT brokers from broker.text, in the order they are
+// tried: one "host[:port]" per line, where host is an IP address or a DNS
+// hostname and the port defaults to 1883. '#' starts a comment.
+//
+// Example: "mqtt.example.net:1883" followed by the fallback "192.168.1.100"
+func Brokers() ([]Broker, error) {
+	return parseBrokers(brokerLines(value(settingBroker)), originOf(settingBroker))
+}
+
+func parseBrokers(s string, o origin) ([]Broker, error) {
+	var list []Broker
+	for n, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		at := o.line(n)
+		if len(fields) != 1 {
+			return nil, errors.New(at + ": expected \"host[:port]\"")
+		}
+		b, err := parseBroker(fields[0])
+		if err != nil {
+			return nil, errors.New(at + ": " + err.Error())
+		}
+		list = append(list, b)
+	}
+	if len(list) == 0 {
+		return nil, errors.New(o.name + ": no broker defined")
+	}
+	if len(list) > MaxBrokers {
+		return nil, errors.New(o.name + ": too many brokers (max " + strconv.Itoa(MaxBrokers) + ")")
+	}
+	return list, nil
+}
+
+// parseBroker parses "host[:port]"; IPv6 addresses with a port are
+// bracketed, e.g. "[fd00::1]:1883".
+func parseBroker(s string) (Broker, error) {
+	if addr, err := netip.ParseAddrPort(s); err == nil {
+		return Broker{Host: addr.Addr().String(), Port: addr.Port()}, nil
+	}
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return Broker{Host: ip.String(), Port: DefaultMQTTPort}, nil
+	}
+	b := Broker{Host: s, Port: DefaultMQTTPort}
+	if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		port, err := strconv.ParseUint(s[i+1:], 10, 16)
+		if err != nil || port == 0 {
+			return Broker{}, errors.New("invalid port in " + s)
+		}
+		b.Host, b.Port = s[:i], uint16(port)
+	}
+	if !validHostname(b.Host) {
+		return Broker{}, errors.New("invalid host " + strconv.Quote(b.Host))
+	}
+	return b, nil
+}
+
+// validHostname reports whether s is a DNS hostname: dot-separated labels
+// of letters, digits and '-', none empty, starting or ending with '-'.
+func validHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // ClientID returns the MQTT client ID from clientid.text file.
 func ClientID() string {
-	return strings.TrimSpace(clientID)
+	return strings.TrimSpace(value(settingClientID))
 }
 
 // TelemetryCollectorAddr returns the telemetry collector address from telemetry_collector.text file.
 // Format: "host:port" e.g., "192.168.1.100:4318"
 func TelemetryCollectorAddr() (netip.AddrPort, error) {
-	addr := strings.TrimSpace(telemetryCollector)
+	addr := strings.TrimSpace(value(settingTelemetryCollector))
 	return netip.ParseAddrPort(addr)
 }
 
 // WakeInterval returns how often the device wakes to process LED states.
 // Returns DefaultWakeInterval unless overridden via wake_interval.text.
 func WakeInterval() time.Duration {
-	if override := strings.TrimSpace(wakeIntervalOverride); override != "" {
+	if override := strings.TrimSpace(value(settingWakeInterval)); override != "" {
 		if d, err := time.ParseDuration(override); err == nil {
 			return d
 		}
@@ -76,7 +459,7 @@ func WakeInterval() time.Duration {
 // ScheduleRefreshInterval returns how often the device fetches a new schedule from MQTT.
 // Returns DefaultScheduleRefreshInterval unless overridden via schedule_refresh_interval.text.
 func ScheduleRefreshInterval() time.Duration {
-	if override := strings.TrimSpace(scheduleRefreshIntervalOverride); override != "" {
+	if override := strings.TrimSpace(value(settingScheduleRefreshInterval)); override != "" {
 		if d, err := time.ParseDuration(override); err == nil {
 			return d
 		}
@@ -87,7 +470,7 @@ func ScheduleRefreshInterval() time.Duration {
 // NTPServer returns the NTP server hostname for time synchronization.
 // Returns DefaultNTPServer unless overridden via ntp_server.text.
 func NTPServer() string {
-	if override := strings.TrimSpace(ntpServerOverride); override != "" {
+	if override := strings.TrimSpace(value(settingNTPServer)); override != "" {
 		return override
 	}
 	return DefaultNTPServer
@@ -97,8 +480,717 @@ func NTPServer() string {
 // Returns DefaultTelemetryEnabled unless overridden via telemetry_enabled.text.
 // Set to "false" or "0" to disable.
 func TelemetryEnabled() bool {
-	if override := strings.TrimSpace(telemetryEnabledOverride); override != "" {
+	if override := strings.TrimSpace(value(settingTelemetryEnabled)); override != "" {
 		return override != "false" && override != "0"
 	}
 	return DefaultTelemetryEnabled
 }
+
+// MQTTKeepAlive returns the keepalive of the persistent MQTT session from
+// mqtt_keepalive.text, e.g. "60s". Returns 0 (fetch the schedule with a
+// short request/response session every refresh) if the file is empty or
+// "off". Remote commands and Home Assistant switches are only received by
+// the persistent session, so they need a keepalive.
+func MQTTKeepAlive() (time.Duration, error) {
+	return parseMQTTKeepAlive(value(settingMQTTKeepAlive), originOf(settingMQTTKeepAlive))
+}
+
+func parseMQTTKeepAlive(s string, o origin) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New(o.name + ": invalid duration " + strconv.Quote(s))
+	}
+	if d < MinMQTTKeepAlive || d > MaxMQTTKeepAlive || d%time.Second != 0 {
+		return 0, errors.New(o.name + ": keepalive must be whole seconds between 5s and 18h12m15s")
+	}
+	return d, nil
+}
+
+// DefaultHomeAssistantPrefix is Home Assistant's default MQTT discovery
+// prefix
+const DefaultHomeAssistantPrefix = "homeassistant"
+
+// HomeAssistant configures Home Assistant MQTT discovery
+type HomeAssistant struct {
+	Enabled  bool
+	Switches bool   // Also expose each LED as a switch (persistent session only)
+	Prefix   string // Discovery prefix
+}
+
+// HomeAssistantConfig returns the discovery settings from
+// homeassistant.text: "on" or "switches", optionally followed by
+// "prefix=<topic>". Discovery is off if the file is empty or "off".
+func HomeAssistantConfig() (HomeAssistant, error) {
+	return parseHomeAssistant(homeAssistantOverride, originFor(&homeAssistantOverride))
+}
+
+func parseHomeAssistant(s string, o origin) (HomeAssistant, error) {
+	ha := HomeAssistant{Prefix: DefaultHomeAssistantPrefix}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ha, nil
+	}
+	switch strings.ToLower(fields[0]) {
+	case "off":
+	case "on":
+		ha.Enabled = true
+	case "switches":
+		ha.Enabled, ha.Switches = true, true
+	default:
+		return HomeAssistant{}, errors.New(o.name + ": expected on, off or switches, got " + strconv.Quote(fields[0]))
+	}
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok || key != "prefix" || value == "" || strings.ContainsAny(value, "+#") {
+			return HomeAssistant{}, errors.New(o.name + ": invalid option " + strconv.Quote(f))
+		}
+		ha.Prefix = strings.TrimSuffix(value, "/")
+	}
+	return ha, nil
+}
+
+// Default schedule request, response and retained schedule topics
+const (
+	DefaultRequestTopic  = "bindicator/request"
+	DefaultResponseTopic = "bindicator/response"
+	DefaultScheduleTopic = "bindicator/schedule/{premises}"
+)
+
+// MaxTopicTemplateLen is the longest topic template accepted in topics.text.
+const MaxTopicTemplateLen = 64
+
+// Topics holds the schedule request, response and retained schedule topic
+// templates. "{client}" is replaced with the client ID and "{premises}"
+// with the premises ID.
+type Topics struct {
+	Request  string
+	Response string
+	Schedule string // Persistent session; always contains {premises}
+
+	// Nonceless says whether responses that do not echo the request's
+	// nonce (CSV, older bridges) are accepted; see AcceptNonceless
+	Nonceless NoncelessMode
+}
+
+// NoncelessMode is the "nonceless" setting of topics.text
+type NoncelessMode uint8
+
+const (
+	NoncelessDefault NoncelessMode = iota // No "nonceless" line
+	NoncelessAccept
+	NoncelessReject
+)
+
+// DefaultTopics returns the topics used without a topics.text
+func DefaultTopics() Topics {
+	return Topics{Request: DefaultRequestTopic, Response: DefaultResponseTopic, Schedule: DefaultScheduleTopic}
+}
+
+// AcceptNonceless reports whether responses without a nonce are accepted.
+// They cannot be told apart from another device's response. By default
+// they are still accepted on a shared response topic, where bridges that
+// predate nonces answer, and rejected on a per-device one ({client}),
+// which only a bridge that sends nonces knows about.
+func (t Topics) AcceptNonceless() bool {
+	switch t.Nonceless {
+	case NoncelessAccept:
+		return true
+	case NoncelessReject:
+		return false
+	}
+	return !strings.Contains(t.Response, "{client}")
+}
+
+// TopicTemplates returns the topics from topics.text, one "request
+// <template>", "response <template>" or "schedule <template>" per line,
+// '#' starts a comment. A missing line keeps the default topic. "nonceless
+// accept" or "nonceless reject" overrides the default for responses
+// without a nonce (see AcceptNonceless).
+//
+// Example: "response bindicator/{client}/response"
+func TopicTemplates() (Topics, error) {
+	return parseTopics(topicsOverride, originFor(&topicsOverride))
+}
+
+func parseTopics(s string, o origin) (Topics, error) {
+	t := DefaultTopics()
+	for n, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		at := o.line(n)
+		if len(fields) != 2 {
+			return Topics{}, errors.New(at + ": expected \"request|response|schedule <topic>\"")
+		}
+		if strings.EqualFold(fields[0], "nonceless") {
+			switch strings.ToLower(fields[1]) {
+			case "accept":
+				t.Nonceless = NoncelessAccept
+			case "reject":
+				t.Nonceless = NoncelessReject
+			default:
+				return Topics{}, errors.New(at + ": expected \"nonceless accept|reject\"")
+			}
+			continue
+		}
+		if err := validTopicTemplate(fields[1]); err != "" {
+			return Topics{}, errors.New(at + ": " + err)
+		}
+		switch strings.ToLower(fields[0]) {
+		case "request":
+			t.Request = fields[1]
+		case "response":
+			t.Response = fields[1]
+		case "schedule":
+			// One retained topic per premises
+			if !strings.Contains(fields[1], "{premises}") {
+				return Topics{}, errors.New(at + ": schedule topic needs {premises}")
+			}
+			t.Schedule = fields[1]
+		default:
+			return Topics{}, errors.New(at + ": unknown topic " + strconv.Quote(fields[0]))
+		}
+	}
+	return t, nil
+}
+
+// validTopicTemplate returns why a topic template is unusable, or "" if it
+// is fine: no wildcards, no empty levels at the ends and only the
+// {client} and {premises} placeholders.
+func validTopicTemplate(t string) string {
+	if len(t) > MaxTopicTemplateLen {
+		return "topic longer than " + strconv.Itoa(MaxTopicTemplateLen) + " bytes"
+	}
+	if strings.ContainsAny(t, "+#") {
+		return "wildcards not allowed in " + t
+	}
+	if strings.HasPrefix(t, "/") || strings.HasSuffix(t, "/") {
+		return "leading or trailing / in " + t
+	}
+	rest := t
+	for {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return "unclosed { in " + t
+		}
+		if p := rest[i : i+j+1]; p != "{client}" && p != "{premises}" {
+			return "unknown placeholder " + p
+		}
+		rest = rest[i+j+1:]
+	}
+	if strings.IndexByte(rest, '}') >= 0 {
+		return "unmatched } in " + t
+	}
+	return ""
+}
+
+// Timezone returns the IANA name of the local timezone used for collection
+// windows, console output and log timestamps.
+// Returns DefaultTimezone unless overridden via timezone.text.
+func Timezone() string {
+	if override := strings.TrimSpace(value(settingTimezone)); override != "" {
+		return override
+	}
+	return DefaultTimezone
+}
+
+// Bin describes one bin type in the registry.
+type Bin struct {
+	Name    string   // Display name, e.g. "green"
+	Channel uint8    // GPIO pin driving the bin's LED
+	Aliases []string // Extra schedule names that map to this bin
+	Window  Window   // When the LED is lit (zero value = DefaultWindow)
+	Recur   Recurrence
+}
+
+// Recurrence generates a bin's collections when no fresh schedule is
+// available: every Every days counting from Anchor, a known collection
+// date. The zero value means no rule.
+type Recurrence struct {
+	Every  int       // Days between collections (0 = no rule)
+	Anchor time.Time // Known collection date (midnight UTC)
+}
+
+// MaxRecurDays is the longest supported collection interval.
+const MaxRecurDays = 366
+
+// String formats the rule as in bins.text, e.g. "every=14d anchor=2026-01-06"
+func (r Recurrence) String() string {
+	if r.Every == 0 {
+		return "none"
+	}
+	return "every=" + strconv.Itoa(r.Every) + "d anchor=" + r.Anchor.Format("2006-01-02")
+}
+
+// WindowEdge is one end of a bin's notification window, relative to the
+// collection day in local time. A clock edge is a time of day (At) on the
+// collection day plus Days; an offset edge is At from local midnight at the
+// start of the collection day.
+type WindowEdge struct {
+	Clock bool          // At is a local time of day rather than an offset
+	Days  int           // Day offset from the collection day (clock edges only)
+	At    time.Duration // Time of day, or offset from midnight
+}
+
+// Window is the period around a collection when the bin's LED is lit.
+type Window struct {
+	On  WindowEdge
+	Off WindowEdge
+}
+
+// DefaultWindow lights the LED from noon the day before collection until
+// noon on collection day.
+var DefaultWindow = Window{
+	On:  WindowEdge{Clock: true, Days: -1, At: 12 * time.Hour},
+	Off: WindowEdge{Clock: true, At: 12 * time.Hour},
+}
+
+// DefaultBins is the bin registry used when bins.text is empty.
+var DefaultBins = []Bin{
+	{Name: "green", Channel: 2, Window: DefaultWindow},
+	{Name: "black", Channel: 3, Window: DefaultWindow},
+	{Name: "brown", Channel: 4, Window: DefaultWindow},
+}
+
+// nominal returns the edge's offset from midnight ignoring DST, for ordering
+func (e WindowEdge) nominal() time.Duration {
+	if e.Clock {
+		return time.Duration(e.Days)*24*time.Hour + e.At
+	}
+	return e.At
+}
+
+// String formats the edge as in bins.text, e.g. "-1d18:00" or "-12h0m0s"
+func (e WindowEdge) String() string {
+	if !e.Clock {
+		if e.At >= 0 {
+			return "+" + e.At.String()
+		}
+		return e.At.String()
+	}
+	s := ""
+	if e.Days != 0 {
+		if e.Days > 0 {
+			s = "+"
+		}
+		s += strconv.Itoa(e.Days) + "d"
+	}
+	h := int(e.At / time.Hour)
+	m := int(e.At % time.Hour / time.Minute)
+	return s + pad2(h) + ":" + pad2(m)
+}
+
+// pad2 formats n with at least two digits
+func pad2(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// String formats the window as "on..off"
+func (w Window) String() string {
+	return w.On.String() + ".." + w.Off.String()
+}
+
+// MaxBins is the maximum number of bin types that can be registered.
+const MaxBins = 8
+
+// Bins returns the bin registry from bins.text.
+// Returns DefaultBins unless overridden.
+// Format: one bin per line, "name channel [alias...] [option...]", '#' starts
+// a comment. Options set the bin's notification window:
+//
+//	on=[±Nd]HH:MM  LED on at a local time of day (default day: the day before)
+//	off=[±Nd]HH:MM LED off at a local time of day (default day: collection day)
+//	lead=DUR       LED on DUR before local midnight starting the collection day
+//	trail=DUR      LED off DUR after local midnight starting the collection day
+//
+// Options for generating collections offline (both are required):
+//
+//	every=N[d|w]   Collection interval in days or weeks
+//	anchor=DATE    A known collection date (YYYY-MM-DD)
+//
+// Example: "brown 4 GARDEN on=18:00 off=09:00 every=2w anchor=2026-01-06"
+func Bins() ([]Bin, error) {
+	if strings.TrimSpace(binsOverride) == "" {
+		return DefaultBins, nil
+	}
+	return parseBins(binsOverride, originFor(&binsOverride))
+}
+
+// parseBins parses the bins.text format.
+func parseBins(s string, o origin) ([]Bin, error) {
+	var bins []Bin
+	for n, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		at := o.line(n)
+		if len(fields) < 2 {
+			return nil, errors.New(at + ": expected \"name channel [alias...]\"")
+		}
+		channel, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil || channel > 29 {
+			return nil, errors.New(at + ": invalid channel " + fields[1])
+		}
+		bin := Bin{Name: strings.ToLower(fields[0]), Channel: uint8(channel), Window: DefaultWindow}
+		for _, f := range fields[2:] {
+			key, value, isOption := strings.Cut(f, "=")
+			if !isOption {
+				bin.Aliases = append(bin.Aliases, f)
+				continue
+			}
+			err := parseRecurOption(&bin.Recur, key, value)
+			if err == errNotRecurOption {
+				err = parseWindowOption(&bin.Window, key, value)
+			}
+			if err != nil {
+				return nil, errors.New(at + ": " + err.Error())
+			}
+		}
+		if (bin.Recur.Every == 0) != bin.Recur.Anchor.IsZero() {
+			return nil, errors.New(at + ": every and anchor must be given together")
+		}
+		if bin.Window.On.nominal() >= bin.Window.Off.nominal() {
+			return nil, errors.New(at + ": window " + bin.Window.String() + " must open before it closes")
+		}
+		for i := range bins {
+			if bins[i].Name == bin.Name {
+				return nil, errors.New(at + ": duplicate bin " + bin.Name)
+			}
+			if bins[i].Channel == bin.Channel {
+				return nil, errors.New(at + ": channel " + fields[1] + " already used by " + bins[i].Name)
+			}
+		}
+		bins = append(bins, bin)
+	}
+	if len(bins) == 0 {
+		return nil, errors.New(o.name + ": no bins defined")
+	}
+	if len(bins) > MaxBins {
+		return nil, errors.New(o.name + ": too many bins (max " + strconv.Itoa(MaxBins) + ")")
+	}
+	return bins, nil
+}
+
+// parseWindowOption applies a bins.text window option (on, off, lead, trail).
+func parseWindowOption(w *Window, key, value string) error {
+	switch strings.ToLower(key) {
+	case "on":
+		e, err := parseClockEdge(value, -1)
+		if err != nil {
+			return err
+		}
+		w.On = e
+	case "off":
+		e, err := parseClockEdge(value, 0)
+		if err != nil {
+			return err
+		}
+		w.Off = e
+	case "lead", "trail":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return errors.New("invalid " + key + " duration " + value)
+		}
+		if strings.EqualFold(key, "lead") {
+			w.On = WindowEdge{At: -d}
+		} else {
+			w.Off = WindowEdge{At: d}
+		}
+	default:
+		return errors.New("unknown option " + key)
+	}
+	return nil
+}
+
+// errNotRecurOption is returned by parseRecurOption for other options.
+var errNotRecurOption = errors.New("not a recurrence option")
+
+// parseRecurOption applies a bins.text recurrence option (every, anchor).
+func parseRecurOption(r *Recurrence, key, value string) error {
+	switch strings.ToLower(key) {
+	case "every":
+		num, unit := value, 1
+		switch {
+		case strings.HasSuffix(value, "d"):
+			num = value[:len(value)-1]
+		case strings.HasSuffix(value, "w"):
+			num, unit = value[:len(value)-1], 7
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 || n*unit > MaxRecurDays {
+			return errors.New("invalid interval every=" + value + " (expected N[d|w], up to " + strconv.Itoa(MaxRecurDays) + " days)")
+		}
+		r.Every = n * unit
+	case "anchor":
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return errors.New("invalid anchor date " + value + " (expected YYYY-MM-DD)")
+		}
+		r.Anchor = t
+	default:
+		return errNotRecurOption
+	}
+	return nil
+}
+
+// parseClockEdge parses "[±Nd]HH:MM", using defaultDays if no day offset is given.
+func parseClockEdge(s string, defaultDays int) (WindowEdge, error) {
+	e := WindowEdge{Clock: true, Days: defaultDays}
+	clock := s
+	if i := strings.IndexByte(s, 'd'); i >= 0 {
+		days, err := strconv.Atoi(s[:i])
+		if err != nil || days < -7 || days > 7 {
+			return e, errors.New("invalid day offset in " + s)
+		}
+		e.Days = days
+		clock = s[i+1:]
+	}
+	hh, mm, ok := strings.Cut(clock, ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || len(hh) != 2 || len(mm) != 2 || errH != nil || errM != nil || h > 23 || m > 59 || h < 0 || m < 0 {
+		return e, errors.New("invalid time " + s + " (expected [±Nd]HH:MM)")
+	}
+	e.At = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	return e, nil
+}
+
+// BinAlias maps a schedule bin name to a registered bin.
+type BinAlias struct {
+	Alias string // Name as sent by the council API, e.g. "RECYCLING"
+	Bin   string // Registered bin name, e.g. "green"
+}
+
+// MaxBinAliases is the maximum number of entries in the alias table.
+const MaxBinAliases = 16
+
+// BinAliases returns the alias table from bin_aliases.text.
+// Format: one alias per line, "ALIAS = bin", '#' starts a comment.
+// Aliases may contain spaces, e.g. "GARDEN WASTE = brown".
+func BinAliases() ([]BinAlias, error) {
+	return parseBinAliases(binAliasesOverride, originFor(&binAliasesOverride))
+}
+
+// WithBinAliases returns a copy of bins with every alias of the table added
+// to its bin's aliases, so the registry is the only place schedule names
+// are looked up. An alias naming an unknown bin is an error.
+func WithBinAliases(bins []Bin, aliases []BinAlias) ([]Bin, error) {
+	out := make([]Bin, len(bins))
+	copy(out, bins)
+	for _, a := range aliases {
+		i := 0
+		for i < len(out) && out[i].Name != a.Bin {
+			i++
+		}
+		if i == len(out) {
+			return nil, errors.New("alias " + a.Alias + " refers to unknown bin " + a.Bin)
+		}
+		out[i].Aliases = append(out[i].Aliases[:len(out[i].Aliases):len(out[i].Aliases)], a.Alias)
+	}
+	return out, nil
+}
+
+// parseBinAliases parses the bin_aliases.text format.
+func parseBinAliases(s string, o origin) ([]BinAlias, error) {
+	var aliases []BinAlias
+	for n, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		at := o.line(n)
+		alias, bin, ok := strings.Cut(line, "=")
+		alias = strings.TrimSpace(alias)
+		bin = strings.ToLower(strings.TrimSpace(bin))
+		if !ok || alias == "" || bin == "" || strings.ContainsAny(bin, " \t") {
+			return nil, errors.New(at + ": expected \"ALIAS = bin\"")
+		}
+		for i := range aliases {
+			if strings.EqualFold(aliases[i].Alias, alias) {
+				return nil, errors.New(at + ": duplicate alias " + alias)
+			}
+		}
+		aliases = append(aliases, BinAlias{Alias: alias, Bin: bin})
+	}
+	if len(aliases) > MaxBinAliases {
+		return nil, errors.New(o.name + ": too many aliases (max " + strconv.Itoa(MaxBinAliases) + ")")
+	}
+	return aliases, nil
+}
+
+// Premises is one collection point the device tracks, with its own
+// schedule and LED group.
+type Premises struct {
+	Name string  // Label for the console and logs, e.g. "office"
+	ID   string  // Premises ID sent in the schedule request, e.g. "1"
+	LEDs []uint8 // GPIO pin per bin in registry order (nil = bin channels)
+}
+
+// MaxPremises is the maximum number of premises that can be configured.
+const MaxPremises = 4
+
+// MaxPremisesIDLen is the longest premises ID kept with each job.
+const MaxPremisesIDLen = 12
+
+// DefaultPremises is used when premises.text is empty: a single premises
+// with ID "1" whose LEDs are the bin channels.
+var DefaultPremises = []Premises{{Name: "home", ID: "1"}}
+
+// PremisesList returns the premises from premises.text.
+// Returns DefaultPremises unless overridden.
+// Format: one premises per line, "name id [leds=PIN,PIN,...]", '#' starts a
+// comment. leds gives the LED pin of each bin in bins.text order; without
+// it the premises uses the bin channels, which only one premises may do.
+// IDs may contain letters, digits, '.', '_' and '-'.
+//
+// Example: "parents 37 leds=6,7,8"
+func PremisesList() ([]Premises, error) {
+	if strings.TrimSpace(premisesOverride) == "" {
+		return DefaultPremises, nil
+	}
+	return parsePremises(premisesOverride, originFor(&premisesOverride))
+}
+
+// parsePremises parses the premises.text format.
+func parsePremises(s string, o origin) ([]Premises, error) {
+	var list []Premises
+	defaultLEDs := false
+	for n, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		at := o.line(n)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, errors.New(at + ": expected \"name id [leds=PIN,...]\"")
+		}
+		p := Premises{Name: strings.ToLower(fields[0]), ID: fields[1]}
+		if !validPremisesID(p.ID) {
+			return nil, errors.New(at + ": invalid id " + p.ID)
+		}
+		if len(fields) == 3 {
+			key, value, ok := strings.Cut(fields[2], "=")
+			if !ok || !strings.EqualFold(key, "leds") {
+				return nil, errors.New(at + ": unknown option " + fields[2])
+			}
+			for _, f := range strings.Split(value, ",") {
+				pin, err := strconv.ParseUint(f, 10, 8)
+				if err != nil || pin > 29 {
+					return nil, errors.New(at + ": invalid LED pin " + f)
+				}
+				p.LEDs = append(p.LEDs, uint8(pin))
+			}
+		} else {
+			if defaultLEDs {
+				return nil, errors.New(at + ": leds required (only one premises may use the bin channels)")
+			}
+			defaultLEDs = true
+		}
+		for i := range list {
+			if list[i].Name == p.Name {
+				return nil, errors.New(at + ": duplicate premises " + p.Name)
+			}
+			if list[i].ID == p.ID {
+				return nil, errors.New(at + ": id " + p.ID + " already used by " + list[i].Name)
+			}
+		}
+		list = append(list, p)
+	}
+	if len(list) == 0 {
+		return nil, errors.New(o.name + ": no premises defined")
+	}
+	if len(list) > MaxPremises {
+		return nil, errors.New(o.name + ": too many premises (max " + strconv.Itoa(MaxPremises) + ")")
+	}
+	return list, nil
+}
+
+// validPremisesID reports whether id is a usable premises ID: non-empty,
+// at most MaxPremisesIDLen bytes and safe to embed in a JSON request.
+func validPremisesID(id string) bool {
+	if id == "" || len(id) > MaxPremisesIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// LEDOutput configures how the bin LEDs are driven.
+type LEDOutput struct {
+	PWM        bool  // Dim the LEDs with PWM instead of switching the pins
+	Brightness uint8 // Duty cycle in percent while an LED is lit (PWM only)
+	ActiveLow  bool  // An LED lights when its pin is low (wired to 3V3)
+}
+
+// DefaultLEDOutput switches active-high LEDs on and off.
+var DefaultLEDOutput = LEDOutput{Brightness: 100}
+
+// LEDOutputConfig returns the LED output from led_output.text: "gpio" or
+// "pwm", optionally followed by "brightness=<percent>" (pwm only) and
+// "active=low" for LEDs wired between the pin and 3V3.
+// Returns DefaultLEDOutput unless overridden.
+//
+// Example: "pwm brightness=40 active=low"
+func LEDOutputConfig() (LEDOutput, error) {
+	return parseLEDOutput(ledOutputOverride, originFor(&ledOutputOverride))
+}
+
+func parseLEDOutput(s string, o origin) (LEDOutput, error) {
+	out := DefaultLEDOutput
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return out, nil
+	}
+	switch strings.ToLower(fields[0]) {
+	case "gpio":
+	case "pwm":
+		out.PWM = true
+	default:
+		return LEDOutput{}, errors.New(o.name + ": expected gpio or pwm, got " + strconv.Quote(fields[0]))
+	}
+	for _, f := range fields[1:] {
+		key, value, _ := strings.Cut(f, "=")
+		switch {
+		case key == "brightness" && out.PWM:
+			n, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || n < 1 || n > 100 {
+				return LEDOutput{}, errors.New(o.name + ": brightness must be 1-100, got " + strconv.Quote(value))
+			}
+			out.Brightness = uint8(n)
+		case key == "active" && (value == "low" || value == "high"):
+			out.ActiveLow = value == "low"
+		default:
+			return LEDOutput{}, errors.New(o.name + ": invalid option " + strconv.Quote(f))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,703 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// text is the origin of the values parsed in these tests
+var text = origin{name: "test.text"}
+
+func TestParseBins(t *testing.T) {
+	input := `
+# name channel aliases
+green 2
+Black 3 GENERAL
+glass 5 GLASS BOTTLES  # trailing comment
+`
+	bins, err := parseBins(input, text)
+	if err != nil {
+		t.Fatalf("parseBins: %v", err)
+	}
+	if len(bins) != 3 {
+		t.Fatalf("len(bins) = %d, want 3", len(bins))
+	}
+	if bins[1].Name != "black" || bins[1].Channel != 3 || len(bins[1].Aliases) != 1 {
+		t.Errorf("bins[1] = %+v", bins[1])
+	}
+	if bins[2].Name != "glass" || len(bins[2].Aliases) != 2 || bins[2].Aliases[1] != "BOTTLES" {
+		t.Errorf("bins[2] = %+v", bins[2])
+	}
+}
+
+func TestParseBinsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", "# nothing\n"},
+		{"missing channel", "green\n"},
+		{"bad channel", "green x\n"},
+		{"channel out of range", "green 40\n"},
+		{"duplicate name", "green 2\nGREEN 3\n"},
+		{"duplicate channel", "green 2\nblack 2\n"},
+		{"too many", "a 1\nb 2\nc 3\nd 4\ne 5\nf 6\ng 7\nh 8\ni 9\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseBins(tc.input, text); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseBinAliases(t *testing.T) {
+	input := `
+# council name = bin
+RECYCLING = green
+GARDEN WASTE=Brown
+`
+	aliases, err := parseBinAliases(input, text)
+	if err != nil {
+		t.Fatalf("parseBinAliases: %v", err)
+	}
+	if len(aliases) != 2 {
+		t.Fatalf("len(aliases) = %d, want 2", len(aliases))
+	}
+	if aliases[0] != (BinAlias{Alias: "RECYCLING", Bin: "green"}) {
+		t.Errorf("aliases[0] = %+v", aliases[0])
+	}
+	if aliases[1] != (BinAlias{Alias: "GARDEN WASTE", Bin: "brown"}) {
+		t.Errorf("aliases[1] = %+v", aliases[1])
+	}
+
+	if aliases, err := parseBinAliases("", text); err != nil || len(aliases) != 0 {
+		t.Errorf("empty input = %v, %v; want no aliases", aliases, err)
+	}
+}
+
+func TestWithBinAliases(t *testing.T) {
+	bins, err := WithBinAliases(DefaultBins, []BinAlias{
+		{Alias: "RECYCLING", Bin: "green"},
+		{Alias: "GARDEN WASTE", Bin: "brown"},
+	})
+	if err != nil {
+		t.Fatalf("WithBinAliases: %v", err)
+	}
+	if fmt.Sprint(bins[0].Aliases) != "[RECYCLING]" || len(bins[1].Aliases) != 0 || fmt.Sprint(bins[2].Aliases) != "[GARDEN WASTE]" {
+		t.Errorf("aliases = %q, %q, %q", bins[0].Aliases, bins[1].Aliases, bins[2].Aliases)
+	}
+	if len(DefaultBins[0].Aliases) != 0 {
+		t.Errorf("DefaultBins changed: %q", DefaultBins[0].Aliases)
+	}
+
+	if _, err := WithBinAliases(DefaultBins, []BinAlias{{Alias: "GLASS", Bin: "glass"}}); err == nil {
+		t.Error("alias to an unregistered bin accepted")
+	}
+}
+
+func TestParseBinAliasesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing separator", "RECYCLING green\n"},
+		{"missing bin", "RECYCLING =\n"},
+		{"missing alias", "= green\n"},
+		{"bin with spaces", "RECYCLING = green bin\n"},
+		{"duplicate alias", "RECYCLING = green\nrecycling = black\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseBinAliases(tc.input, text); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseBinsWindows(t *testing.T) {
+	input := `
+green 2
+brown 4 GARDEN on=18:00 off=09:00
+glass 5 lead=6h trail=2h30m
+food  6 on=-2d20:00 off=+0d07:30 CADDY
+`
+	bins, err := parseBins(input, text)
+	if err != nil {
+		t.Fatalf("parseBins: %v", err)
+	}
+
+	tests := []struct {
+		bin     int
+		window  Window
+		aliases int
+		str     string
+	}{
+		{0, DefaultWindow, 0, "-1d12:00..12:00"},
+		{1, Window{
+			On:  WindowEdge{Clock: true, Days: -1, At: 18 * time.Hour},
+			Off: WindowEdge{Clock: true, At: 9 * time.Hour},
+		}, 1, "-1d18:00..09:00"},
+		{2, Window{
+			On:  WindowEdge{At: -6 * time.Hour},
+			Off: WindowEdge{At: 150 * time.Minute},
+		}, 0, "-6h0m0s..+2h30m0s"},
+		{3, Window{
+			On:  WindowEdge{Clock: true, Days: -2, At: 20 * time.Hour},
+			Off: WindowEdge{Clock: true, At: 7*time.Hour + 30*time.Minute},
+		}, 1, "-2d20:00..07:30"},
+	}
+
+	for _, tc := range tests {
+		b := bins[tc.bin]
+		if b.Window != tc.window {
+			t.Errorf("%s window = %+v, want %+v", b.Name, b.Window, tc.window)
+		}
+		if len(b.Aliases) != tc.aliases {
+			t.Errorf("%s aliases = %v, want %d", b.Name, b.Aliases, tc.aliases)
+		}
+		if got := b.Window.String(); got != tc.str {
+			t.Errorf("%s window.String() = %q, want %q", b.Name, got, tc.str)
+		}
+	}
+}
+
+func TestParseBinsWindowErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown option", "green 2 colour=red\n"},
+		{"bad time", "green 2 on=25:00\n"},
+		{"bad time format", "green 2 off=9:00\n"},
+		{"bad day offset", "green 2 on=xd18:00\n"},
+		{"bad duration", "green 2 lead=soon\n"},
+		{"negative duration", "green 2 trail=-1h\n"},
+		{"closes before opening", "green 2 on=+0d10:00 off=09:00\n"},
+		{"empty window", "green 2 lead=0s trail=0s\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseBins(tc.input, text); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseBinsRecurrence(t *testing.T) {
+	bins, err := parseBins("green 2 every=14d anchor=2026-01-06\n"+
+		"black 3 anchor=2026-01-13 every=1w\n"+
+		"brown 4 on=18:00 every=4w anchor=2026-03-02 off=09:00\n"+
+		"blue 5\n", text)
+	if err != nil {
+		t.Fatalf("parseBins() err = %v", err)
+	}
+
+	expected := []struct {
+		every  int
+		anchor string
+	}{
+		{14, "2026-01-06"},
+		{7, "2026-01-13"},
+		{28, "2026-03-02"},
+		{0, ""},
+	}
+	for i, want := range expected {
+		r := bins[i].Recur
+		if r.Every != want.every {
+			t.Errorf("bins[%d].Recur.Every = %d, want %d", i, r.Every, want.every)
+		}
+		if want.anchor != "" && r.Anchor.Format("2006-01-02") != want.anchor {
+			t.Errorf("bins[%d].Recur.Anchor = %v, want %s", i, r.Anchor, want.anchor)
+		}
+	}
+	if got := bins[0].Recur.String(); got != "every=14d anchor=2026-01-06" {
+		t.Errorf("Recur.String() = %q", got)
+	}
+	if bins[2].Window.On.At != 18*time.Hour {
+		t.Errorf("window lost alongside recurrence: %v", bins[2].Window)
+	}
+}
+
+func TestParseBinsRecurrenceErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"every without anchor", "green 2 every=14d\n"},
+		{"anchor without every", "green 2 anchor=2026-01-06\n"},
+		{"zero interval", "green 2 every=0d anchor=2026-01-06\n"},
+		{"interval too long", "green 2 every=53w anchor=2026-01-06\n"},
+		{"bad interval unit", "green 2 every=2m anchor=2026-01-06\n"},
+		{"bad anchor", "green 2 every=14d anchor=2026-02-30\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseBins(tc.input, text); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParsePremises(t *testing.T) {
+	input := `
+# name    id    leds (one pin per bin)
+Office    1
+parents   A-37  leds=6,7,8
+`
+	list, err := parsePremises(input, text)
+	if err != nil {
+		t.Fatalf("parsePremises: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("len(list) = %d, want 2", len(list))
+	}
+	if list[0].Name != "office" || list[0].ID != "1" || list[0].LEDs != nil {
+		t.Errorf("list[0] = %+v", list[0])
+	}
+	if list[1].Name != "parents" || list[1].ID != "A-37" || len(list[1].LEDs) != 3 || list[1].LEDs[2] != 8 {
+		t.Errorf("list[1] = %+v", list[1])
+	}
+}
+
+func TestParsePremisesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"missing id", "office\n"},
+		{"extra field", "office 1 leds=2,3,4 x\n"},
+		{"bad id", "office 1\"2\n"},
+		{"long id", "office 1234567890123\n"},
+		{"unknown option", "office 1 pins=2,3,4\n"},
+		{"bad pin", "office 1 leds=2,x,4\n"},
+		{"pin out of range", "office 1 leds=2,30,4\n"},
+		{"two default groups", "office 1\nparents 2\n"},
+		{"duplicate name", "office 1\noffice 2 leds=6,7,8\n"},
+		{"duplicate id", "office 1\nparents 1 leds=6,7,8\n"},
+		{"too many", "a 1\nb 2 leds=5\nc 3 leds=6\nd 4 leds=7\ne 5 leds=8\n"},
+		{"only comments", "# nothing\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parsePremises(tc.input, text); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParseMQTTKeepAlive(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"off", 0, false},
+		{" 60s\n", time.Minute, false},
+		{"18h12m15s", MaxMQTTKeepAlive, false},
+		{"forever", 0, true},
+		{"1s", 0, true},
+		{"19h", 0, true},
+		{"90500ms", 0, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseMQTTKeepAlive(tc.input, text)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseMQTTKeepAlive(%q) = %v, %v; want %v, error %v", tc.input, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseHomeAssistant(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    HomeAssistant
+		wantErr bool
+	}{
+		{"", HomeAssistant{Prefix: "homeassistant"}, false},
+		{"off\n", HomeAssistant{Prefix: "homeassistant"}, false},
+		{"on", HomeAssistant{Enabled: true, Prefix: "homeassistant"}, false},
+		{"Switches prefix=ha/", HomeAssistant{Enabled: true, Switches: true, Prefix: "ha"}, false},
+		{"yes", HomeAssistant{}, true},
+		{"on prefix=", HomeAssistant{}, true},
+		{"on prefix=ha/#", HomeAssistant{}, true},
+		{"on retain=true", HomeAssistant{}, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseHomeAssistant(tc.input, text)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseHomeAssistant(%q) = %+v, %v; want %+v, error %v", tc.input, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseLEDOutput(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    LEDOutput
+		wantErr bool
+	}{
+		{"", LEDOutput{Brightness: 100}, false},
+		{"gpio\n", LEDOutput{Brightness: 100}, false},
+		{"gpio active=low", LEDOutput{Brightness: 100, ActiveLow: true}, false},
+		{"PWM brightness=40 active=high", LEDOutput{PWM: true, Brightness: 40}, false},
+		{"pwm", LEDOutput{PWM: true, Brightness: 100}, false},
+		{"neopixel", LEDOutput{}, true},
+		{"gpio brightness=40", LEDOutput{}, true},
+		{"pwm brightness=0", LEDOutput{}, true},
+		{"pwm brightness=101", LEDOutput{}, true},
+		{"gpio active=inverted", LEDOutput{}, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseLEDOutput(tc.input, text)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseLEDOutput(%q) = %+v, %v; want %+v, error %v", tc.input, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	ip := netip.MustParseAddr
+	tests := []struct {
+		input string
+		want  string // Network formatted with %v
+	}{
+		{"", "{false invalid IP invalid Prefix invalid IP []}"},
+		{"dhcp\n", "{false invalid IP invalid Prefix invalid IP []}"},
+		{"DHCP request=192.168.1.99", "{false 192.168.1.99 invalid Prefix invalid IP []}"},
+		{"static address=192.168.1.50/24 gateway=192.168.1.1 dns=1.1.1.1,8.8.8.8",
+			"{true 192.168.1.50 192.168.1.0/24 192.168.1.1 [1.1.1.1 8.8.8.8]}"},
+		{"static address=10.0.3.7 netmask=255.255.0.0 gateway=10.0.0.1",
+			"{true 10.0.3.7 10.0.0.0/16 10.0.0.1 [10.0.0.1]}"},
+		{"static address=192.168.1.50 gateway=192.168.1.1",
+			"{true 192.168.1.50 192.168.1.0/24 192.168.1.1 [192.168.1.1]}"},
+	}
+	for _, tc := range tests {
+		got, err := parseNetwork(tc.input, text)
+		if err != nil || fmt.Sprint(got) != tc.want {
+			t.Errorf("parseNetwork(%q) = %v, %v; want %s", tc.input, got, err, tc.want)
+		}
+	}
+	if n, _ := parseNetwork("static address=192.168.1.50 gateway=192.168.1.1", text); n.DNS[0] != ip("192.168.1.1") {
+		t.Errorf("DNS = %v, want the gateway", n.DNS)
+	}
+
+	invalid := []string{
+		"auto",
+		"dhcp request=",
+		"dhcp request=192.168.1.99/24",
+		"dhcp request=fd00::1",
+		"dhcp gateway=192.168.1.1",
+		"static gateway=192.168.1.1",
+		"static address=192.168.1.50",
+		"static address=192.168.1.50 gateway=192.168.2.1",
+		"static address=192.168.1.50 gateway=192.168.1.50",
+		"static address=192.168.1.50/31 gateway=192.168.1.51",
+		"static address=192.168.1.50 netmask=255.0.255.0 gateway=192.168.1.1",
+		"static address=192.168.1.50 gateway=192.168.1.1 dns=1.1.1.1,1.0.0.1,8.8.8.8",
+		"static address=224.0.0.1 gateway=224.0.0.2",
+		"static address=192.168.1.50 gateway=192.168.1.1 request=192.168.1.99",
+	}
+	for _, s := range invalid {
+		if _, err := parseNetwork(s, text); err == nil {
+			t.Errorf("parseNetwork(%q) accepted", s)
+		}
+	}
+}
+
+func TestNetworkDHCPRequest(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "0.0.0.0"}, // No network.text: plain DHCP
+		{"dhcp", "0.0.0.0"},
+		{"dhcp request=192.168.1.99", "192.168.1.99"},
+	}
+	for _, tc := range tests {
+		n, err := parseNetwork(tc.input, text)
+		if err != nil {
+			t.Fatalf("parseNetwork(%q): %v", tc.input, err)
+		}
+		if got := n.DHCPRequest(); !got.Is4() || got.String() != tc.want {
+			t.Errorf("parseNetwork(%q).DHCPRequest() = %v, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestParseTopics(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Topics
+		wantErr bool
+	}{
+		{"", DefaultTopics(), false},
+		{"# defaults\n", DefaultTopics(), false},
+		{"response bindicator/{client}/response",
+			Topics{"bindicator/request", "bindicator/{client}/response", DefaultScheduleTopic, NoncelessDefault}, false},
+		{"Request bins/{client}/{premises}/req\nresponse bins/{client}/{premises}/resp # per premises",
+			Topics{"bins/{client}/{premises}/req", "bins/{client}/{premises}/resp", DefaultScheduleTopic, NoncelessDefault}, false},
+		{"schedule bins/{premises}/schedule",
+			Topics{"bindicator/request", "bindicator/response", "bins/{premises}/schedule", NoncelessDefault}, false},
+		{"nonceless accept", Topics{"bindicator/request", "bindicator/response", DefaultScheduleTopic, NoncelessAccept}, false},
+		{"nonceless accept\nNonceless REJECT", Topics{"bindicator/request", "bindicator/response", DefaultScheduleTopic, NoncelessReject}, false},
+		{"nonceless yes", Topics{}, true},
+		{"response", Topics{}, true},
+		{"reply bindicator/reply", Topics{}, true},
+		{"response bindicator/+/response", Topics{}, true},
+		{"response bindicator/#", Topics{}, true},
+		{"response bindicator/response/", Topics{}, true},
+		{"response bindicator/{clientid}/response", Topics{}, true},
+		{"response bindicator/{client/response", Topics{}, true},
+		{"response bindicator/client}/response", Topics{}, true},
+		{"response bindicator/" + strings.Repeat("x", 60), Topics{}, true},
+		{"schedule bindicator/schedule", Topics{}, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseTopics(tc.input, text)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseTopics(%q) = %+v, %v; want %+v, error %v", tc.input, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestTopicsAcceptNonceless(t *testing.T) {
+	shared, perDevice := DefaultTopics(), DefaultTopics()
+	perDevice.Response = "bindicator/{client}/response"
+	tests := []struct {
+		topics Topics
+		mode   NoncelessMode
+		want   bool
+	}{
+		{shared, NoncelessDefault, true},
+		{perDevice, NoncelessDefault, false},
+		{perDevice, NoncelessAccept, true},
+		{shared, NoncelessReject, false},
+	}
+	for _, tc := range tests {
+		tc.topics.Nonceless = tc.mode
+		if got := tc.topics.AcceptNonceless(); got != tc.want {
+			t.Errorf("response %q, mode %d: AcceptNonceless() = %v, want %v", tc.topics.Response, tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestParseBrokers(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []Broker
+		wantErr bool
+	}{
+		{"192.168.1.100:1883\n", []Broker{{"192.168.1.100", 1883}}, false},
+		{"# primary, then fallbacks\nmqtt.example.net:8883\n192.168.1.100\nbroker.lan # LAN\n",
+			[]Broker{{"mqtt.example.net", 8883}, {"192.168.1.100", 1883}, {"broker.lan", 1883}}, false},
+		{"[fd00::1]:1884\nfd00::2", []Broker{{"fd00::1", 1884}, {"fd00::2", 1883}}, false},
+		{"", nil, true},
+		{"# none\n", nil, true},
+		{"mqtt.example.net:0", nil, true},
+		{"mqtt.example.net:http", nil, true},
+		{"mqtt.example.net:70000", nil, true},
+		{"mqtt_example.net", nil, true},
+		{"-mqtt.example.net", nil, true},
+		{"mqtt..example.net", nil, true},
+		{"mqtt.example.net 1883", nil, true},
+		{"a\nb\nc\nd\ne", nil, true},
+	}
+
+	for _, tc := range tests {
+		got, err := parseBrokers(tc.input, text)
+		if (err != nil) != tc.wantErr || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseBrokers(%q) = %v, %v; want %v, error %v", tc.input, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestBrokerAddrPort(t *testing.T) {
+	addr, ok := Broker{"192.168.1.100", 1883}.AddrPort()
+	if !ok || addr.String() != "192.168.1.100:1883" {
+		t.Errorf("AddrPort() = %v, %v", addr, ok)
+	}
+	if _, ok := (Broker{"mqtt.example.net", 1883}).AddrPort(); ok {
+		t.Error("AddrPort() ok for a hostname")
+	}
+	if s := (Broker{"fd00::1", 1883}).String(); s != "[fd00::1]:1883" {
+		t.Errorf("String() = %q", s)
+	}
+	if s := (Broker{"mqtt.example.net", 8883}).String(); s != "mqtt.example.net:8883" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	t.Cleanup(func() {
+		for _, s := range Settings() {
+			Reset(s.Key)
+		}
+	})
+
+	if v, src, err := Get("wake_interval"); err != nil || src == SourceOverride {
+		t.Fatalf("Get(wake_interval) = %q, %v, %v before any override", v, src, err)
+	}
+	if err := Set("wake_interval", " 5m "); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, src, _ := Get("wake_interval"); v != "5m" || src != SourceOverride {
+		t.Errorf("Get(wake_interval) = %q, %v; want 5m from flash", v, src)
+	}
+	if WakeInterval() != 5*time.Minute {
+		t.Errorf("WakeInterval() = %v, want the override", WakeInterval())
+	}
+
+	if err := Set("broker", "mqtt.example.net, 192.168.1.100:1884"); err != nil {
+		t.Fatalf("Set(broker): %v", err)
+	}
+	brokers, err := Brokers()
+	want := []Broker{{"mqtt.example.net", DefaultMQTTPort}, {"192.168.1.100", 1884}}
+	if err != nil || !reflect.DeepEqual(brokers, want) {
+		t.Errorf("Brokers() = %v, %v; want %v", brokers, err, want)
+	}
+
+	got := Overrides()
+	if len(got) != 2 || got[0] != (Override{"wake_interval", "5m"}) || got[1].Key != "broker" {
+		t.Errorf("Overrides() = %v", got)
+	}
+
+	if err := Reset("wake_interval"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if _, src, _ := Get("wake_interval"); src == SourceOverride {
+		t.Error("override still set after Reset")
+	}
+	if v, src, _ := Get("ntp_server"); src == SourceDefault && v != DefaultNTPServer {
+		t.Errorf("Get(ntp_server) = %q from the default", v)
+	}
+
+	invalid := []struct{ key, value string }{
+		{"wake_interval", "soon"},
+		{"wake_interval", "10ms"},
+		{"ntp_server", "bad host"},
+		{"broker", "mqtt..example"},
+		{"mqtt_keepalive", "1s"},
+		{"telemetry_enabled", "maybe"},
+		{"telemetry_collector", "192.168.1.10"},
+		{"clientid", "kitchen/1"},
+		{"ntp_server", ""},
+		{"ntp_server", strings.Repeat("a", MaxSettingValueLen+1)},
+		{"bins", "green 2"},
+		{"wifi_ssid", strings.Repeat("s", MaxSSIDLen+1)},
+		{"wifi_password", "short"},
+	}
+	for _, tc := range invalid {
+		if err := Set(tc.key, tc.value); err == nil {
+			t.Errorf("Set(%q, %q) accepted", tc.key, tc.value)
+		}
+	}
+	if len(Overrides()) != 1 {
+		t.Errorf("invalid values changed the overrides: %v", Overrides())
+	}
+}
+
+func TestWiFiOverrides(t *testing.T) {
+	savedSSID, savedPassword := wifiSSID, wifiPassword
+	t.Cleanup(func() {
+		wifiSSID, wifiPassword = savedSSID, savedPassword
+		Reset("wifi_ssid")
+		Reset("wifi_password")
+	})
+	wifiSSID, wifiPassword = "House\nGarage\n", "house-pass\ngarage-pass\n"
+
+	networks := func() []WiFiNetwork {
+		t.Helper()
+		list, err := WiFiNetworks()
+		if err != nil {
+			t.Fatalf("WiFiNetworks(): %v", err)
+		}
+		return list
+	}
+	want := []WiFiNetwork{{"House", "house-pass"}, {"Garage", "garage-pass"}}
+	if got := networks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WiFiNetworks() = %v, want %v", got, want)
+	}
+
+	// wifi_ssid alone moves a known network to the front
+	if err := Set("wifi_ssid", "Garage"); err != nil {
+		t.Fatalf("Set(wifi_ssid): %v", err)
+	}
+	want = []WiFiNetwork{{"Garage", "garage-pass"}, {"House", "house-pass"}}
+	if got := networks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WiFiNetworks() = %v, want %v", got, want)
+	}
+
+	// A new network goes first, with its password kept as given
+	if err := SetAll([]Override{{"wifi_ssid", "Home Network"}, {"wifi_password", " pass phrase "}}); err != nil {
+		t.Fatalf("SetAll: %v", err)
+	}
+	want = []WiFiNetwork{{"Home Network", " pass phrase "}, {"House", "house-pass"}, {"Garage", "garage-pass"}}
+	if got := networks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WiFiNetworks() = %v, want %v", got, want)
+	}
+	if got := Redact("wifi_password", " pass phrase "); got != "********" {
+		t.Errorf("Redact(wifi_password) = %q", got)
+	}
+	if got := Redact("wifi_ssid", "Home Network"); got != "Home Network" {
+		t.Errorf("Redact(wifi_ssid) = %q", got)
+	}
+
+	// An unknown network without a password cannot be joined
+	Reset("wifi_password")
+	if _, err := WiFiNetworks(); err == nil {
+		t.Error("WiFiNetworks() accepted wifi_ssid without a password")
+	}
+
+	// SetAll changes nothing when one of the values is invalid
+	err := SetAll([]Override{{"wifi_ssid", "Other"}, {"wifi_password", "short"}})
+	if v, _, _ := Get("wifi_ssid"); err == nil || v != "Home Network" {
+		t.Errorf("SetAll() = %v, wifi_ssid = %q; want an error and no change", err, v)
+	}
+	if err := SetAll([]Override{{"wifi_ssid", "Other"}, {"broker", "mqtt.example.net"}}); err != nil {
+		t.Errorf("SetAll() = %v", err)
+	}
+	Reset("broker")
+}
+
+func TestParseWiFiNetworks(t *testing.T) {
+	got, err := parseWiFiNetworks("House\r\n\nGarage", "house-pass\r\n\ngarage pass\n")
+	want := []WiFiNetwork{{"House", "house-pass"}, {"Garage", "garage pass"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWiFiNetworks() = %v, %v; want %v", got, err, want)
+	}
+	if got, err := parseWiFiNetworks("", ""); err != nil || len(got) != 0 {
+		t.Errorf("empty files: %v, %v", got, err)
+	}
+
+	invalid := []struct{ ssids, passwords string }{
+		{"House\nGarage", "house-pass"},
+		{"House", "house-pass\ngarage-pass"},
+		{strings.Repeat("s", MaxSSIDLen+1), "password"},
+		{"a\nb\nc\nd\ne", "1\n2\n3\n4\n5"},
+	}
+	for _, tc := range invalid {
+		if _, err := parseWiFiNetworks(tc.ssids, tc.passwords); err == nil {
+			t.Errorf("parseWiFiNetworks(%q, %q) accepted", tc.ssids, tc.passwords)
+		}
+	}
+}
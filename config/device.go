@@ -0,0 +1,596 @@
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Structured configuration. device.json holds the whole device setup in one
+// file with a fixed schema:
+//
+//	{
+//	  "network":     {"mode": "static", "address": "192.168.1.50/24", "gateway": "192.168.1.1", "dns": ["1.1.1.1"]},
+//	  "mqtt":        {"brokers": ["mqtt.example.net", "192.168.1.100"], "client_id": "bindicator",
+//	                  "keepalive": "60s", "schedule_refresh_interval": "3h",
+//	                  "topics": {"request": "...", "response": "...", "schedule": "...", "nonceless": false},
+//	                  "home_assistant": {"enabled": true, "switches": false, "prefix": "homeassistant"}},
+//	  "ntp":         {"server": "time.cloudflare.com", "timezone": "Europe/London"},
+//	  "telemetry":   {"enabled": true, "collector": "192.168.1.100:4318"},
+//	  "leds":        {"wake_interval": "15m", "premises": [{"name": "home", "id": "1", "pins": [2, 3, 4]}],
+//	                  "output": {"mode": "pwm", "brightness": 40, "active_low": true}},
+//	  "bins":        [{"name": "green", "channel": 2, "aliases": ["RECYCLING"], "on": "18:00", "off": "09:00",
+//	                   "every": "2w", "anchor": "2026-01-06"}],
+//	  "bin_aliases": {"GARDEN WASTE": "brown"},
+//	  "console":     {"port": 23}
+//	}
+//
+// Every key is optional and // starts a comment. A key that is present
+// replaces the .text file it corresponds to (see deviceKeys), which is
+// still read for the keys the file leaves out. Each value is turned into
+// the .text format and checked by the same parser, so both spellings mean
+// exactly the same thing.
+
+//go:embed device.json
+var deviceJSON string
+
+// consolePortOverride is only set from device.json.
+var consolePortOverride string
+
+// DefaultConsolePort is the telnet port of the debug console.
+const DefaultConsolePort = 23
+
+// deviceKey is a device.json key and the .text file it replaces.
+type deviceKey struct {
+	path   string  // Dotted key, e.g. "mqtt.keepalive"
+	file   string  // .text file replaced
+	target *string // Contents of the file
+	decode func(v jsonValue, path string) (string, error)
+	set    bool // Read from device.json
+}
+
+var deviceKeys = [...]deviceKey{
+	{path: "network", file: "network.text", target: &networkOverride, decode: decodeNetwork},
+	{path: "mqtt.brokers", file: "broker.text", target: &brokerAddr, decode: decodeLines},
+	{path: "mqtt.client_id", file: "clientid.text", target: &clientID, decode: decodeString},
+	{path: "mqtt.keepalive", file: "mqtt_keepalive.text", target: &mqttKeepAliveOverride, decode: decodeString},
+	{path: "mqtt.schedule_refresh_interval", file: "schedule_refresh_interval.text", target: &scheduleRefreshIntervalOverride, decode: decodeString},
+	{path: "mqtt.topics", file: "topics.text", target: &topicsOverride, decode: decodeTopics},
+	{path: "mqtt.home_assistant", file: "homeassistant.text", target: &homeAssistantOverride, decode: decodeHomeAssistant},
+	{path: "ntp.server", file: "ntp_server.text", target: &ntpServerOverride, decode: decodeString},
+	{path: "ntp.timezone", file: "timezone.text", target: &timezoneOverride, decode: decodeString},
+	{path: "telemetry.enabled", file: "telemetry_enabled.text", target: &telemetryEnabledOverride, decode: decodeBool},
+	{path: "telemetry.collector", file: "telemetry_collector.text", target: &telemetryCollector, decode: decodeString},
+	{path: "leds.wake_interval", file: "wake_interval.text", target: &wakeIntervalOverride, decode: decodeString},
+	{path: "leds.premises", file: "premises.text", target: &premisesOverride, decode: decodePremises},
+	{path: "leds.output", file: "led_output.text", target: &ledOutputOverride, decode: decodeLEDOutput},
+	{path: "bins", file: "bins.text", target: &binsOverride, decode: decodeBins},
+	{path: "bin_aliases", file: "bin_aliases.text", target: &binAliasesOverride, decode: decodeBinAliases},
+	{path: "console.port", target: &consolePortOverride, decode: decodeNumber},
+}
+
+// deviceErrs holds the syntax and schema errors of device.json.
+var deviceErrs []error
+
+func init() {
+	deviceErrs = loadDevice(deviceJSON)
+}
+
+// loadDevice applies a device.json document over the .text files. Keys
+// that are invalid are reported and leave their file in charge.
+func loadDevice(data string) []error {
+	for i := range deviceKeys {
+		deviceKeys[i].set = false
+	}
+	if strings.TrimSpace(data) == "" {
+		return nil
+	}
+	root, err := parseJSON(data)
+	if err != nil {
+		return []error{errors.New("device.json " + err.Error())}
+	}
+	if root.kind != jsonObject {
+		return []error{errors.New("device.json: expected an object")}
+	}
+	var errs []error
+	walkDevice(root, "", &errs)
+	return errs
+}
+
+// walkDevice decodes the keys of object v, found at path prefix
+func walkDevice(v jsonValue, prefix string, errs *[]error) {
+	for n, key := range v.keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		item := v.items[n]
+		if k := deviceKeyIndex(path); k >= 0 {
+			s, err := deviceKeys[k].decode(item, path)
+			if err != nil {
+				*errs = append(*errs, errors.New("device.json "+err.Error()))
+				continue
+			}
+			*deviceKeys[k].target = s
+			deviceKeys[k].set = true
+			continue
+		}
+		if !deviceSection(path) {
+			*errs = append(*errs, errors.New("device.json "+path+": unknown key"))
+			continue
+		}
+		if item.kind != jsonObject {
+			*errs = append(*errs, errors.New("device.json "+path+": expected an object"))
+			continue
+		}
+		walkDevice(item, path, errs)
+	}
+}
+
+func deviceKeyIndex(path string) int {
+	for i := range deviceKeys {
+		if deviceKeys[i].path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// deviceSection reports whether path is an object grouping other keys
+func deviceSection(path string) bool {
+	for i := range deviceKeys {
+		if strings.HasPrefix(deviceKeys[i].path, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Files returns the names of the files the firmware embeds from the
+// config directory.
+func Files() []string {
+	names := []string{"device.json"}
+	for i := range deviceKeys {
+		if deviceKeys[i].file != "" {
+			names = append(names, deviceKeys[i].file)
+		}
+	}
+	return names
+}
+
+// Load replaces the embedded configuration with files read elsewhere, so
+// tools can check a configuration before it is built into the firmware.
+// files holds the contents of the Files by name, plus the credentials'
+// ssid.text and password.text; missing names are empty. Runtime overrides
+// are cleared.
+func Load(files map[string]string) {
+	for i := range deviceKeys {
+		*deviceKeys[i].target = files[deviceKeys[i].file]
+	}
+	wifiSSID, wifiPassword = files["ssid.text"], files["password.text"]
+	for i := range settings {
+		overrideValue[i], overrideSet[i] = "", false
+	}
+	deviceErrs = loadDevice(files["device.json"])
+}
+
+// origin names where a value was read from in error messages: a .text
+// file, or the device.json key that replaced it.
+type origin struct {
+	name string // e.g. "bins.text" or "device.json bins"
+	json bool   // Lines are the elements of a JSON array
+}
+
+// line returns the location of line n, counted from 0
+func (o origin) line(n int) string {
+	if o.json {
+		return o.name + "[" + strconv.Itoa(n) + "]"
+	}
+	return o.name + " line " + strconv.Itoa(n+1)
+}
+
+// originFor returns where the contents of a .text variable came from
+func originFor(target *string) origin {
+	for i := range deviceKeys {
+		if deviceKeys[i].target != target {
+			continue
+		}
+		if deviceKeys[i].set {
+			return origin{name: "device.json " + deviceKeys[i].path, json: true}
+		}
+		return origin{name: deviceKeys[i].file}
+	}
+	return origin{name: "config"}
+}
+
+// ConsolePort returns the debug console's telnet port from device.json
+// (console.port), or DefaultConsolePort.
+func ConsolePort() (uint16, error) {
+	s := strings.TrimSpace(consolePortOverride)
+	if s == "" {
+		return DefaultConsolePort, nil
+	}
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return DefaultConsolePort, errors.New("device.json console.port: invalid port " + s)
+	}
+	return uint16(port), nil
+}
+
+// Validate checks the whole configuration: device.json, the .text files
+// and the WiFi credentials. It returns every problem found; each invalid
+// value falls back as its accessor describes.
+func Validate() []error {
+	errs := append([]error(nil), deviceErrs...)
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Plain values are only checked when they apply
+	for _, i := range [...]int{
+		settingWakeInterval, settingScheduleRefreshInterval, settingNTPServer,
+		settingTimezone, settingTelemetryEnabled, settingClientID,
+	} {
+		if v := strings.TrimSpace(value(i)); v != "" {
+			if err := settings[i].check(v); err != nil {
+				add(errors.New(originOf(i).name + ": " + err.Error()))
+			}
+		}
+	}
+	if TelemetryEnabled() {
+		if _, err := TelemetryCollectorAddr(); err != nil {
+			add(errors.New(originOf(settingTelemetryCollector).name + ": invalid collector address (expected host:port)"))
+		}
+	}
+	_, err := Brokers()
+	add(err)
+	_, err = MQTTKeepAlive()
+	add(err)
+	_, err = NetworkConfig()
+	add(err)
+	_, err = HomeAssistantConfig()
+	add(err)
+	_, err = LEDOutputConfig()
+	add(err)
+	_, err = TopicTemplates()
+	add(err)
+	bins, binsErr := Bins()
+	add(binsErr)
+	aliases, err := BinAliases()
+	add(err)
+	premises, err := PremisesList()
+	add(err)
+	_, err = WiFiNetworks()
+	add(err)
+	_, err = ConsolePort()
+	add(err)
+
+	// Cross-checks the firmware makes when it loads the registry
+	if binsErr == nil {
+		for _, a := range aliases {
+			if !hasBin(bins, a.Bin) {
+				add(errors.New(originFor(&binAliasesOverride).name + ": alias " + a.Alias + " refers to unknown bin " + a.Bin))
+			}
+		}
+		for _, p := range premises {
+			if p.LEDs != nil && len(p.LEDs) != len(bins) {
+				add(errors.New(originFor(&premisesOverride).name + ": premises " + p.Name + " needs one LED pin per bin (" +
+					strconv.Itoa(len(bins)) + ")"))
+			}
+		}
+	}
+	return errs
+}
+
+func hasBin(bins []Bin, name string) bool {
+	for i := range bins {
+		if bins[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// JSON decoders, turning a device.json value into the .text format
+
+func decodeString(v jsonValue, path string) (string, error) {
+	if v.kind != jsonString {
+		return "", errors.New(path + ": expected a string")
+	}
+	if strings.ContainsAny(v.text, "\r\n") {
+		return "", errors.New(path + ": line break in value")
+	}
+	return v.text, nil
+}
+
+// decodeWord is a string that becomes one field of a .text line
+func decodeWord(v jsonValue, path string) (string, error) {
+	s, err := decodeString(v, path)
+	if err == nil && (s == "" || strings.ContainsAny(s, " \t#=,")) {
+		err = errors.New(path + ": invalid value " + strconv.Quote(s))
+	}
+	return s, err
+}
+
+func decodeBool(v jsonValue, path string) (string, error) {
+	if v.kind != jsonBool {
+		return "", errors.New(path + ": expected true or false")
+	}
+	return v.text, nil
+}
+
+func decodeNumber(v jsonValue, path string) (string, error) {
+	if v.kind != jsonNumber {
+		return "", errors.New(path + ": expected a number")
+	}
+	if _, err := strconv.ParseUint(v.text, 10, 32); err != nil {
+		return "", errors.New(path + ": expected a whole number, got " + v.text)
+	}
+	return v.text, nil
+}
+
+// decodeList joins the elements of an array decoded by each, separated by
+// sep
+func decodeList(v jsonValue, path, sep string, each func(jsonValue, string) (string, error)) (string, error) {
+	if v.kind != jsonArray {
+		return "", errors.New(path + ": expected an array")
+	}
+	var b strings.Builder
+	for i, item := range v.items {
+		s, err := each(item, path+"["+strconv.Itoa(i)+"]")
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+// decodeLines is an array of words, one per line
+func decodeLines(v jsonValue, path string) (string, error) {
+	return decodeList(v, path, "\n", decodeWord)
+}
+
+// decodeFields checks that v is an object with only the given keys and
+// returns a lookup for them
+func decodeFields(v jsonValue, path string, keys ...string) (func(key string) (jsonValue, bool), error) {
+	if v.kind != jsonObject {
+		return nil, errors.New(path + ": expected an object")
+	}
+	for _, k := range v.keys {
+		known := false
+		for _, want := range keys {
+			known = known || k == want
+		}
+		if !known {
+			return nil, errors.New(path + "." + k + ": unknown key")
+		}
+	}
+	return func(key string) (jsonValue, bool) {
+		for i, k := range v.keys {
+			if k == key && v.items[i].kind != jsonNull {
+				return v.items[i], true
+			}
+		}
+		return jsonValue{}, false
+	}, nil
+}
+
+// decodeOptions appends " key=value" for each of keys present in v
+func decodeOptions(b *strings.Builder, get func(string) (jsonValue, bool), path string, keys ...string) error {
+	for _, key := range keys {
+		item, ok := get(key)
+		if !ok {
+			continue
+		}
+		var s string
+		var err error
+		switch item.kind {
+		case jsonArray:
+			s, err = decodeList(item, path+"."+key, ",", decodeWord)
+		case jsonNumber:
+			s, err = decodeNumber(item, path+"."+key)
+		default:
+			s, err = decodeWord(item, path+"."+key)
+		}
+		if err != nil {
+			return err
+		}
+		b.WriteString(" " + key + "=" + s)
+	}
+	return nil
+}
+
+func decodeNetwork(v jsonValue, path string) (string, error) {
+	get, err := decodeFields(v, path, "mode", "request", "address", "netmask", "gateway", "dns")
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("dhcp")
+	if mode, ok := get("mode"); ok {
+		s, err := decodeWord(mode, path+".mode")
+		if err != nil {
+			return "", err
+		}
+		b.Reset()
+		b.WriteString(s)
+	}
+	err = decodeOptions(&b, get, path, "request", "address", "netmask", "gateway", "dns")
+	return b.String(), err
+}
+
+func decodeTopics(v jsonValue, path string) (string, error) {
+	get, err := decodeFields(v, path, "request", "response", "schedule", "nonceless")
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, key := range [...]string{"request", "response", "schedule"} {
+		if item, ok := get(key); ok {
+			s, err := decodeWord(item, path+"."+key)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, key+" "+s)
+		}
+	}
+	if item, ok := get("nonceless"); ok {
+		if _, err := decodeBool(item, path+".nonceless"); err != nil {
+			return "", err
+		}
+		mode := "reject"
+		if item.text == "true" {
+			mode = "accept"
+		}
+		lines = append(lines, "nonceless "+mode)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func decodeHomeAssistant(v jsonValue, path string) (string, error) {
+	get, err := decodeFields(v, path, "enabled", "switches", "prefix")
+	if err != nil {
+		return "", err
+	}
+	mode := "off"
+	if item, ok := get("enabled"); ok {
+		if _, err := decodeBool(item, path+".enabled"); err != nil {
+			return "", err
+		}
+		if item.text == "true" {
+			mode = "on"
+		}
+	}
+	if item, ok := get("switches"); ok {
+		if _, err := decodeBool(item, path+".switches"); err != nil {
+			return "", err
+		}
+		if item.text == "true" {
+			mode = "switches" // Implies enabled
+		}
+	}
+	var b strings.Builder
+	b.WriteString(mode)
+	err = decodeOptions(&b, get, path, "prefix")
+	return b.String(), err
+}
+
+func decodeLEDOutput(v jsonValue, path string) (string, error) {
+	get, err := decodeFields(v, path, "mode", "brightness", "active_low")
+	if err != nil {
+		return "", err
+	}
+	mode := "gpio"
+	if item, ok := get("mode"); ok {
+		if mode, err = decodeWord(item, path+".mode"); err != nil {
+			return "", err
+		}
+	}
+	var b strings.Builder
+	b.WriteString(mode)
+	if err := decodeOptions(&b, get, path, "brightness"); err != nil {
+		return "", err
+	}
+	if item, ok := get("active_low"); ok {
+		if _, err := decodeBool(item, path+".active_low"); err != nil {
+			return "", err
+		}
+		if item.text == "true" {
+			b.WriteString(" active=low")
+		}
+	}
+	return b.String(), nil
+}
+
+func decodePremises(v jsonValue, path string) (string, error) {
+	return decodeList(v, path, "\n", func(item jsonValue, path string) (string, error) {
+		get, err := decodeFields(item, path, "name", "id", "pins")
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		if err := decodeRequired(&b, get, path, "name", "id"); err != nil {
+			return "", err
+		}
+		if pins, ok := get("pins"); ok {
+			s, err := decodeList(pins, path+".pins", ",", decodeNumber)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(" leds=" + s)
+		}
+		return b.String(), nil
+	})
+}
+
+func decodeBins(v jsonValue, path string) (string, error) {
+	return decodeList(v, path, "\n", func(item jsonValue, path string) (string, error) {
+		get, err := decodeFields(item, path, "name", "channel", "aliases", "on", "off", "lead", "trail", "every", "anchor")
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		if err := decodeRequired(&b, get, path, "name", "channel"); err != nil {
+			return "", err
+		}
+		if aliases, ok := get("aliases"); ok {
+			s, err := decodeList(aliases, path+".aliases", " ", decodeWord)
+			if err != nil {
+				return "", err
+			}
+			if s != "" {
+				b.WriteString(" " + s)
+			}
+		}
+		err = decodeOptions(&b, get, path, "on", "off", "lead", "trail", "every", "anchor")
+		return b.String(), err
+	})
+}
+
+// decodeRequired writes the given keys of an object as space-separated
+// fields; all of them must be present
+func decodeRequired(b *strings.Builder, get func(string) (jsonValue, bool), path string, keys ...string) error {
+	for i, key := range keys {
+		item, ok := get(key)
+		if !ok {
+			return errors.New(path + ": " + key + " is required")
+		}
+		decode := decodeWord
+		if item.kind == jsonNumber {
+			decode = decodeNumber
+		}
+		s, err := decode(item, path+"."+key)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+	}
+	return nil
+}
+
+func decodeBinAliases(v jsonValue, path string) (string, error) {
+	if v.kind != jsonObject {
+		return "", errors.New(path + ": expected an object")
+	}
+	lines := make([]string, len(v.keys))
+	for i, alias := range v.keys {
+		bin, err := decodeWord(v.items[i], path+"."+alias)
+		if err != nil {
+			return "", err
+		}
+		if alias == "" || strings.ContainsAny(alias, "#=\r\n") {
+			return "", errors.New(path + ": invalid alias " + strconv.Quote(alias))
+		}
+		lines[i] = alias + " = " + bin
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useDevice applies a device.json document for the rest of the test
+func useDevice(t *testing.T, data string) []error {
+	t.Helper()
+	var saved [len(deviceKeys)]string
+	for i := range deviceKeys {
+		saved[i] = *deviceKeys[i].target
+	}
+	t.Cleanup(func() {
+		for i := range deviceKeys {
+			*deviceKeys[i].target = saved[i]
+		}
+		loadDevice("")
+	})
+	return loadDevice(data)
+}
+
+func TestParseJSON(t *testing.T) {
+	v, err := parseJSON(` {"a": [1, -2.5e3, true, null], "b": {"c": "x\"\\\/é🗑\n"}} `)
+	if err != nil {
+		t.Fatalf("parseJSON() err = %v", err)
+	}
+	if v.kind != jsonObject || len(v.keys) != 2 || v.keys[0] != "a" || v.keys[1] != "b" {
+		t.Fatalf("parseJSON() = %+v", v)
+	}
+	a := v.items[0]
+	if a.kind != jsonArray || len(a.items) != 4 || a.items[1].text != "-2.5e3" || a.items[2].kind != jsonBool || a.items[3].kind != jsonNull {
+		t.Errorf("a = %+v", a)
+	}
+	if c := v.items[1].items[0]; c.text != "x\"\\/é🗑\n" {
+		t.Errorf("c = %q", c.text)
+	}
+
+	invalid := []string{
+		``,
+		`{`,
+		`{"a": 1,}`,
+		`{"a" 1}`,
+		`{a: 1}`,
+		`{"a": 1, "a": 2}`,
+		`[1 2]`,
+		`{"a": "unterminated}`,
+		`{"a": "tab	in string"}`,
+		`{"a": "\x41"}`,
+		`{"a": 1.2.3}`,
+		`{"a": tru}`,
+		`{} {}`,
+		strings.Repeat("[", maxJSONDepth+2) + strings.Repeat("]", maxJSONDepth+2),
+	}
+	for _, s := range invalid {
+		if _, err := parseJSON(s); err == nil {
+			t.Errorf("parseJSON(%q) accepted", s)
+		}
+	}
+	if v, err := parseJSON("// comment\n{\"a\": \"//\", // trailing\n\"b\": 1}\n// end"); err != nil || v.items[0].text != "//" {
+		t.Errorf("comments: %+v, %v", v, err)
+	}
+	if _, err := parseJSON(`{"a": 1 / 2}`); err == nil {
+		t.Error("parseJSON() accepted a lone /")
+	}
+	if _, err := parseJSON("{\n\"a\": 1,\n}"); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("error = %v, want it on line 3", err)
+	}
+}
+
+func TestDeviceConfig(t *testing.T) {
+	errs := useDevice(t, `{
+		"network": {"mode": "static", "address": "192.168.1.50/24", "gateway": "192.168.1.1", "dns": ["1.1.1.1"]},
+		"mqtt": {
+			"brokers": ["mqtt.example.net:1884", "192.168.1.100"],
+			"client_id": "kitchen",
+			"keepalive": "60s",
+			"schedule_refresh_interval": "6h",
+			"topics": {"response": "bindicator/{client}/response", "schedule": "bins/{premises}", "nonceless": true},
+			"home_assistant": {"enabled": true, "prefix": "ha"}
+		},
+		"ntp": {"server": "pool.ntp.org", "timezone": "Europe/Paris"},
+		"telemetry": {"enabled": false},
+		"leds": {
+				"wake_interval": "5m",
+				"premises": [{"name": "home", "id": "1"}, {"name": "parents", "id": "37", "pins": [6, 7]}],
+				"output": {"mode": "pwm", "brightness": 40, "active_low": true}
+			},
+		"bins": [
+			{"name": "green", "channel": 2, "aliases": ["RECYCLING"], "on": "18:00", "off": "09:00"},
+			{"name": "brown", "channel": 4, "every": "2w", "anchor": "2026-01-06"}
+		],
+		"bin_aliases": {"GARDEN WASTE": "brown"},
+		"console": {"port": 2323}
+	}`)
+	if len(errs) != 0 {
+		t.Fatalf("loadDevice() errs = %v", errs)
+	}
+	if errs := Validate(); len(errs) != 0 {
+		t.Errorf("Validate() = %v", errs)
+	}
+
+	if n, err := NetworkConfig(); err != nil || !n.Static || n.Subnet.String() != "192.168.1.0/24" || len(n.DNS) != 1 {
+		t.Errorf("NetworkConfig() = %v, %v", n, err)
+	}
+	if b, err := Brokers(); err != nil || len(b) != 2 || b[0] != (Broker{"mqtt.example.net", 1884}) {
+		t.Errorf("Brokers() = %v, %v", b, err)
+	}
+	if ClientID() != "kitchen" {
+		t.Errorf("ClientID() = %q", ClientID())
+	}
+	if d, err := MQTTKeepAlive(); err != nil || d != time.Minute {
+		t.Errorf("MQTTKeepAlive() = %v, %v", d, err)
+	}
+	if ScheduleRefreshInterval() != 6*time.Hour || WakeInterval() != 5*time.Minute {
+		t.Errorf("intervals = %v, %v", ScheduleRefreshInterval(), WakeInterval())
+	}
+	if topics, _ := TopicTemplates(); topics != (Topics{DefaultRequestTopic, "bindicator/{client}/response", "bins/{premises}", NoncelessAccept}) {
+		t.Errorf("TopicTemplates() = %v", topics)
+	}
+	if ha, _ := HomeAssistantConfig(); ha != (HomeAssistant{Enabled: true, Prefix: "ha"}) {
+		t.Errorf("HomeAssistantConfig() = %+v", ha)
+	}
+	if NTPServer() != "pool.ntp.org" || Timezone() != "Europe/Paris" || TelemetryEnabled() {
+		t.Errorf("NTPServer() = %q, Timezone() = %q, TelemetryEnabled() = %v", NTPServer(), Timezone(), TelemetryEnabled())
+	}
+	premises, err := PremisesList()
+	if err != nil || len(premises) != 2 || len(premises[1].LEDs) != 2 || premises[1].LEDs[1] != 7 {
+		t.Errorf("PremisesList() = %v, %v", premises, err)
+	}
+	if out, err := LEDOutputConfig(); err != nil || out != (LEDOutput{PWM: true, Brightness: 40, ActiveLow: true}) {
+		t.Errorf("LEDOutputConfig() = %+v, %v", out, err)
+	}
+	bins, err := Bins()
+	if err != nil || len(bins) != 2 || bins[0].Aliases[0] != "RECYCLING" || bins[0].Window.String() != "-1d18:00..09:00" || bins[1].Recur.Every != 14 {
+		t.Errorf("Bins() = %+v, %v", bins, err)
+	}
+	if aliases, _ := BinAliases(); len(aliases) != 1 || aliases[0] != (BinAlias{"GARDEN WASTE", "brown"}) {
+		t.Errorf("BinAliases() = %v", aliases)
+	}
+	if port, err := ConsolePort(); port != 2323 || err != nil {
+		t.Errorf("ConsolePort() = %d, %v", port, err)
+	}
+
+	// Runtime overrides still win over device.json
+	t.Cleanup(func() { Reset("wake_interval") })
+	if v, src, _ := Get("wake_interval"); v != "5m" || src != SourceDevice {
+		t.Errorf("Get(wake_interval) = %q, %v", v, src)
+	}
+	Set("wake_interval", "1m")
+	if WakeInterval() != time.Minute {
+		t.Errorf("WakeInterval() = %v, want the override", WakeInterval())
+	}
+}
+
+func TestDeviceConfigErrors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want string // Start of the only error
+	}{
+		{`[]`, "device.json: expected an object"},
+		{`{"mqtt": {"brokers": "a"}}`, "device.json mqtt.brokers: expected an array"},
+		{`{"mqtt": {"port": 1883}}`, "device.json mqtt.port: unknown key"},
+		{`{"mqtt": []}`, "device.json mqtt: expected an object"},
+		{`{"wifi": {}}`, "device.json wifi: unknown key"},
+		{`{"telemetry": {"enabled": "yes"}}`, "device.json telemetry.enabled: expected true or false"},
+		{`{"bins": [{"name": "green"}]}`, "device.json bins[0]: channel is required"},
+		{`{"bins": [{"name": "green", "channel": 2, "colour": "g"}]}`, "device.json bins[0].colour: unknown key"},
+		{`{"bins": [{"name": "green", "channel": 2.5}]}`, "device.json bins[0].channel: expected a whole number"},
+		{`{"bins": [{"name": "green", "channel": 2, "aliases": ["GARDEN WASTE"]}]}`, "device.json bins[0].aliases[0]: invalid value"},
+		{`{"ntp": {"server": "a\nb"}}`, "device.json ntp.server: line break"},
+		{`{"bin_aliases": {"A=B": "green"}}`, "device.json bin_aliases: invalid alias"},
+		{`{"leds": {"output": {"active_low": "yes"}}}`, "device.json leds.output.active_low: expected true or false"},
+		{`{"console": {"port": -1}}`, "device.json console.port: expected a whole number"},
+	}
+	for _, tc := range tests {
+		errs := useDevice(t, tc.doc)
+		if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), tc.want) {
+			t.Errorf("loadDevice(%s) = %v, want %q", tc.doc, errs, tc.want)
+		}
+	}
+
+	// A key with an error leaves the .text file in charge; the others apply
+	errs := useDevice(t, `{"leds": {"wake_interval": 5}, "ntp": {"server": "pool.ntp.org"}}`)
+	if len(errs) != 1 || WakeInterval() != DefaultWakeInterval || NTPServer() != "pool.ntp.org" {
+		t.Errorf("errs = %v, WakeInterval() = %v, NTPServer() = %q", errs, WakeInterval(), NTPServer())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want []string // Start of errors expected among others
+	}{
+		{`{
+			"leds": {"wake_interval": "soon"},
+			"bins": [{"name": "green", "channel": 2}, {"name": "black", "channel": 2}],
+			"console": {"port": 0}
+		}`, []string{
+			"device.json leds.wake_interval: invalid interval",
+			"device.json bins[1]: channel 2 already used by green",
+			"device.json console.port: invalid port",
+		}},
+		// Cross-checks against the bin registry
+		{`{
+			"leds": {"premises": [{"name": "home", "id": "1", "pins": [6]}]},
+			"bins": [{"name": "green", "channel": 2}, {"name": "black", "channel": 3}],
+			"bin_aliases": {"RECYCLING": "blue"}
+		}`, []string{
+			"device.json bin_aliases: alias RECYCLING refers to unknown bin blue",
+			"device.json leds.premises: premises home needs one LED pin per bin (2)",
+		}},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			useDevice(t, tc.doc)
+			errs := Validate()
+			for _, w := range tc.want {
+				found := false
+				for _, err := range errs {
+					found = found || strings.HasPrefix(err.Error(), w)
+				}
+				if !found {
+					t.Errorf("Validate() = %v, missing %q", errs, w)
+				}
+			}
+		})
+	}
+}
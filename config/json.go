@@ -0,0 +1,274 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Minimal JSON reader for device.json. The file is read once at boot, so
+// values are decoded into a small tree rather than streamed. Besides
+// standard JSON it accepts // comments running to the end of the line.
+
+// jsonKind is the type of a JSON value.
+type jsonKind uint8
+
+const (
+	jsonNull jsonKind = iota
+	jsonBool
+	jsonNumber
+	jsonString
+	jsonArray
+	jsonObject
+)
+
+// String returns the kind as used in error messages.
+func (k jsonKind) String() string {
+	switch k {
+	case jsonBool:
+		return "a boolean"
+	case jsonNumber:
+		return "a number"
+	case jsonString:
+		return "a string"
+	case jsonArray:
+		return "an array"
+	case jsonObject:
+		return "an object"
+	default:
+		return "null"
+	}
+}
+
+// jsonValue is a decoded JSON value.
+type jsonValue struct {
+	kind  jsonKind
+	text  string      // String contents, or the number or literal as written
+	keys  []string    // Object keys, in file order
+	items []jsonValue // Object values or array elements
+}
+
+// maxJSONDepth limits nesting so a malformed file cannot exhaust the stack.
+const maxJSONDepth = 8
+
+// parseJSON decodes a complete JSON document.
+func parseJSON(data string) (jsonValue, error) {
+	p := jsonParser{data: data}
+	v := p.value(0)
+	if p.err == nil {
+		if p.skipSpace(); p.pos < len(p.data) {
+			p.fail("unexpected data after the value")
+		}
+	}
+	if p.err != nil {
+		return jsonValue{}, p.err
+	}
+	return v, nil
+}
+
+type jsonParser struct {
+	data string
+	pos  int
+	err  error
+}
+
+// fail records the first error with its line number.
+func (p *jsonParser) fail(msg string) {
+	if p.err != nil {
+		return
+	}
+	line := 1
+	for i := 0; i < p.pos && i < len(p.data); i++ {
+		if p.data[i] == '\n' {
+			line++
+		}
+	}
+	p.err = errors.New("line " + strconv.Itoa(line) + ": " + msg)
+	p.pos = len(p.data)
+}
+
+// skipSpace skips whitespace and // comments, which device.json allows
+// so a file can explain its settings
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '/':
+			if p.pos+1 >= len(p.data) || p.data[p.pos+1] != '/' {
+				return
+			}
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// next consumes c after optional space, reporting whether it was there.
+func (p *jsonParser) next(c byte) bool {
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jsonParser) value(depth int) jsonValue {
+	if depth > maxJSONDepth {
+		p.fail("nested too deeply")
+		return jsonValue{}
+	}
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		p.fail("unexpected end of file")
+		return jsonValue{}
+	}
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		p.pos++
+		v := jsonValue{kind: jsonObject}
+		if p.next('}') {
+			return v
+		}
+		for p.err == nil {
+			p.skipSpace()
+			if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+				p.fail("expected a quoted key")
+				break
+			}
+			key := p.string()
+			if !p.next(':') {
+				p.fail("expected ':' after " + strconv.Quote(key))
+				break
+			}
+			for _, k := range v.keys {
+				if k == key {
+					p.fail("duplicate key " + strconv.Quote(key))
+				}
+			}
+			v.keys = append(v.keys, key)
+			v.items = append(v.items, p.value(depth+1))
+			if p.next('}') {
+				break
+			}
+			if !p.next(',') {
+				p.fail("expected ',' or '}'")
+			}
+		}
+		return v
+	case c == '[':
+		p.pos++
+		v := jsonValue{kind: jsonArray}
+		if p.next(']') {
+			return v
+		}
+		for p.err == nil {
+			v.items = append(v.items, p.value(depth+1))
+			if p.next(']') {
+				break
+			}
+			if !p.next(',') {
+				p.fail("expected ',' or ']'")
+			}
+		}
+		return v
+	case c == '"':
+		return jsonValue{kind: jsonString, text: p.string()}
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.data) && (p.data[p.pos] >= '0' && p.data[p.pos] <= '9' ||
+			p.data[p.pos] == '-' || p.data[p.pos] == '+' || p.data[p.pos] == '.' || p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+			p.pos++
+		}
+		text := p.data[start:p.pos]
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			p.fail("invalid number " + text)
+		}
+		return jsonValue{kind: jsonNumber, text: text}
+	default:
+		for _, lit := range [...]string{"true", "false", "null"} {
+			if len(p.data)-p.pos >= len(lit) && p.data[p.pos:p.pos+len(lit)] == lit {
+				p.pos += len(lit)
+				if lit == "null" {
+					return jsonValue{}
+				}
+				return jsonValue{kind: jsonBool, text: lit}
+			}
+		}
+		p.fail("unexpected character " + strconv.QuoteRune(rune(c)))
+		return jsonValue{}
+	}
+}
+
+// string reads a quoted string starting at the opening quote.
+func (p *jsonParser) string() string {
+	p.pos++ // Opening quote
+	var buf []byte
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return string(buf)
+		case c < ' ':
+			p.fail("control character in string")
+			return ""
+		case c != '\\':
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.data) {
+			break
+		}
+		esc := p.data[p.pos+1]
+		p.pos += 2
+		switch esc {
+		case '"', '\\', '/':
+			buf = append(buf, esc)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'u':
+			r := p.hex4()
+			if utf16.IsSurrogate(r) && p.pos+1 < len(p.data) && p.data[p.pos] == '\\' && p.data[p.pos+1] == 'u' {
+				p.pos += 2
+				r = utf16.DecodeRune(r, p.hex4())
+			}
+			buf = utf8.AppendRune(buf, r)
+		default:
+			p.pos -= 2
+			p.fail("invalid escape \\" + string(esc))
+			return ""
+		}
+	}
+	p.fail("unterminated string")
+	return ""
+}
+
+// hex4 reads the four hex digits of a \u escape.
+func (p *jsonParser) hex4() rune {
+	if len(p.data)-p.pos < 4 {
+		p.fail("invalid \\u escape")
+		return 0
+	}
+	n, err := strconv.ParseUint(p.data[p.pos:p.pos+4], 16, 16)
+	if err != nil {
+		p.fail("invalid \\u escape")
+		return 0
+	}
+	p.pos += 4
+	return rune(n)
+}
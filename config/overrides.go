@@ -0,0 +1,329 @@
+package config
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Runtime overrides. The settings below can be changed on the device (the
+// console config command, kept in flash) without a rebuild. An override
+// replaces the embedded .text file of the same name; the embedded value,
+// and then the built-in default, remain the fallback when it is removed.
+
+// MaxSettingValueLen is the longest override value accepted.
+const MaxSettingValueLen = 128
+
+// Setting is a configuration key that can be overridden at runtime.
+type Setting struct {
+	Key     string // Name of the .text file it overrides, without the extension
+	Default string // Value used when neither an override nor the file sets one
+	Reboot  bool   // Takes effect after a reboot (otherwise straight away)
+	Secret  bool   // Value is never shown (see Redact)
+
+	embedded *string
+	check    func(string) error
+}
+
+// Source tells where the effective value of a setting comes from.
+type Source uint8
+
+const (
+	SourceDefault  Source = iota // Built-in default
+	SourceEmbedded               // Embedded .text file
+	SourceOverride               // Runtime override
+	SourceDevice                 // device.json
+)
+
+// String returns a short name for the source.
+func (s Source) String() string {
+	switch s {
+	case SourceEmbedded:
+		return "file"
+	case SourceOverride:
+		return "flash"
+	case SourceDevice:
+		return "device.json"
+	default:
+		return "default"
+	}
+}
+
+// Override is a runtime value for a setting.
+type Override struct {
+	Key   string
+	Value string
+}
+
+// Setting indexes
+const (
+	settingWakeInterval = iota
+	settingScheduleRefreshInterval
+	settingNTPServer
+	settingTimezone
+	settingBroker
+	settingMQTTKeepAlive
+	settingTelemetryEnabled
+	settingTelemetryCollector
+	settingClientID
+	settingWiFiSSID
+	settingWiFiPassword
+	settingNetwork
+	numSettings
+)
+
+var settings = [numSettings]Setting{
+	settingWakeInterval: {
+		Key: "wake_interval", Default: DefaultWakeInterval.String(),
+		embedded: &wakeIntervalOverride, check: checkInterval,
+	},
+	settingScheduleRefreshInterval: {
+		Key: "schedule_refresh_interval", Default: DefaultScheduleRefreshInterval.String(),
+		embedded: &scheduleRefreshIntervalOverride, check: checkInterval,
+	},
+	settingNTPServer: {
+		Key: "ntp_server", Default: DefaultNTPServer,
+		embedded: &ntpServerOverride, check: checkHost,
+	},
+	settingTimezone: {
+		Key: "timezone", Default: DefaultTimezone,
+		embedded: &timezoneOverride, check: checkTimezone,
+	},
+	settingBroker: {
+		Key:      "broker",
+		embedded: &brokerAddr, check: func(s string) error { _, err := parseBrokers(brokerLines(s), origin{name: "broker.text"}); return err },
+	},
+	settingMQTTKeepAlive: {
+		Key: "mqtt_keepalive", Default: "off", Reboot: true,
+		embedded: &mqttKeepAliveOverride, check: func(s string) error { _, err := parseMQTTKeepAlive(s, origin{name: "mqtt_keepalive.text"}); return err },
+	},
+	settingTelemetryEnabled: {
+		Key: "telemetry_enabled", Default: strconv.FormatBool(DefaultTelemetryEnabled), Reboot: true,
+		embedded: &telemetryEnabledOverride, check: checkBool,
+	},
+	settingTelemetryCollector: {
+		Key: "telemetry_collector", Reboot: true,
+		embedded: &telemetryCollector, check: func(s string) error { _, err := netip.ParseAddrPort(s); return err },
+	},
+	settingClientID: {
+		Key: "clientid", Reboot: true,
+		embedded: &clientID, check: checkWord,
+	},
+	settingWiFiSSID: {
+		Key: "wifi_ssid", Reboot: true,
+		embedded: &wifiSSID, check: checkSSID,
+	},
+	settingWiFiPassword: {
+		Key: "wifi_password", Reboot: true, Secret: true,
+		embedded: &wifiPassword, check: checkWiFiPassword,
+	},
+	settingNetwork: {
+		Key: "network", Default: "dhcp", Reboot: true,
+		embedded: &networkOverride, check: func(s string) error { _, err := parseNetwork(s, origin{name: "network.text"}); return err },
+	},
+}
+
+// Runtime overrides by setting index
+var (
+	overrideValue [numSettings]string
+	overrideSet   [numSettings]bool
+)
+
+// Settings returns the settings that can be overridden at runtime.
+func Settings() []Setting {
+	return settings[:]
+}
+
+// Get returns the effective value of a setting and where it comes from.
+func Get(key string) (string, Source, error) {
+	i := settingIndex(key)
+	if i < 0 {
+		return "", 0, errUnknownSetting(key)
+	}
+	if overrideSet[i] {
+		return overrideValue[i], SourceOverride, nil
+	}
+	if v := strings.TrimSpace(*settings[i].embedded); v != "" {
+		src := SourceEmbedded
+		if originOf(i).json {
+			src = SourceDevice
+		}
+		return strings.ReplaceAll(v, "\n", ", "), src, nil
+	}
+	return settings[i].Default, SourceDefault, nil
+}
+
+// Set validates value and overrides the setting with it. Brokers are
+// separated by commas instead of lines. Surrounding spaces are trimmed,
+// except from secrets (a passphrase may start or end with one).
+func Set(key, value string) error {
+	i, value, err := checkOverride(key, value)
+	if err != nil {
+		return err
+	}
+	overrideValue[i], overrideSet[i] = value, true
+	return nil
+}
+
+// SetAll overrides several settings at once. If any value is invalid
+// nothing is changed.
+func SetAll(list []Override) error {
+	for _, o := range list {
+		if _, _, err := checkOverride(o.Key, o.Value); err != nil {
+			return err
+		}
+	}
+	for _, o := range list {
+		Set(o.Key, o.Value)
+	}
+	return nil
+}
+
+// checkOverride validates an override, returning the setting index and the
+// value as it would be stored
+func checkOverride(key, value string) (int, string, error) {
+	i := settingIndex(key)
+	if i < 0 {
+		return -1, "", errUnknownSetting(key)
+	}
+	if !settings[i].Secret {
+		value = strings.TrimSpace(value)
+	}
+	if value == "" {
+		return -1, "", errors.New(key + ": empty value (use reset to remove the override)")
+	}
+	if len(value) > MaxSettingValueLen {
+		return -1, "", errors.New(key + ": value longer than " + strconv.Itoa(MaxSettingValueLen) + " bytes")
+	}
+	if err := settings[i].check(value); err != nil {
+		return -1, "", errors.New(key + ": " + err.Error())
+	}
+	return i, value, nil
+}
+
+// Reset removes the override of a setting, going back to the embedded
+// value or the default.
+func Reset(key string) error {
+	i := settingIndex(key)
+	if i < 0 {
+		return errUnknownSetting(key)
+	}
+	overrideValue[i], overrideSet[i] = "", false
+	return nil
+}
+
+// Overrides returns the settings currently overridden, in Settings order.
+func Overrides() []Override {
+	var list []Override
+	for i := range settings {
+		if overrideSet[i] {
+			list = append(list, Override{Key: settings[i].Key, Value: overrideValue[i]})
+		}
+	}
+	return list
+}
+
+// Redact returns value as it may be shown or logged for the setting with
+// the given key: secrets are masked.
+func Redact(key, value string) string {
+	if i := settingIndex(key); i >= 0 && settings[i].Secret && value != "" {
+		return "********"
+	}
+	return value
+}
+
+// Lookup returns the setting with the given key.
+func Lookup(key string) (Setting, bool) {
+	i := settingIndex(key)
+	if i < 0 {
+		return Setting{}, false
+	}
+	return settings[i], true
+}
+
+func settingIndex(key string) int {
+	for i := range settings {
+		if settings[i].Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func errUnknownSetting(key string) error {
+	return errors.New("config: unknown setting " + strconv.Quote(key))
+}
+
+// value returns the raw value of setting i: the override, or the embedded
+// file's contents
+func value(i int) string {
+	if overrideSet[i] {
+		return overrideValue[i]
+	}
+	return *settings[i].embedded
+}
+
+// originOf returns where the embedded value of setting i came from
+func originOf(i int) origin {
+	return originFor(settings[i].embedded)
+}
+
+// brokerLines turns a comma-separated broker override into broker.text lines
+func brokerLines(s string) string {
+	return strings.ReplaceAll(s, ",", "\n")
+}
+
+func checkInterval(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Second {
+		return errors.New("invalid interval " + strconv.Quote(s) + " (e.g. 15m, at least 1s)")
+	}
+	return nil
+}
+
+func checkHost(s string) error {
+	if _, err := netip.ParseAddr(s); err != nil && !validHostname(s) {
+		return errors.New("invalid hostname " + strconv.Quote(s))
+	}
+	return nil
+}
+
+func checkWord(s string) error {
+	if strings.ContainsAny(s, " \t/+#") {
+		return errors.New("invalid value " + strconv.Quote(s) + " (no spaces, '/', '+' or '#')")
+	}
+	return nil
+}
+
+// checkTimezone only rejects what cannot be a zone name; the firmware's
+// zone table decides the rest when the setting is applied
+func checkTimezone(s string) error {
+	if strings.ContainsAny(s, " \t") {
+		return errors.New("invalid timezone " + strconv.Quote(s))
+	}
+	return nil
+}
+
+func checkSSID(s string) error {
+	if len(s) > MaxSSIDLen {
+		return errors.New("SSID longer than " + strconv.Itoa(MaxSSIDLen) + " bytes")
+	}
+	return nil
+}
+
+func checkWiFiPassword(s string) error {
+	if len(s) < MinWiFiPasswordLen || len(s) > MaxWiFiPasswordLen {
+		return errors.New("WPA2 passphrase must be " + strconv.Itoa(MinWiFiPasswordLen) + "-" + strconv.Itoa(MaxWiFiPasswordLen) + " characters")
+	}
+	return nil
+}
+
+func checkBool(s string) error {
+	switch s {
+	case "true", "false", "1", "0":
+		return nil
+	}
+	return errors.New("invalid boolean " + strconv.Quote(s) + " (true or false)")
+}
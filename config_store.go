@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"openenterprise/bindicator/config"
+)
+
+// Runtime configuration record persisted to flash (little-endian), in its
+// own sector so saving a setting never touches the schedule record:
+//
+//	magic   uint32 "BCFG"
+//	version uint16
+//	count   uint16
+//	entries count * (keylen uint8, vallen uint8, key, value)
+//	crc     uint32 CRC-32 (IEEE) of everything before it
+//
+// Entries are config overrides by key, so a record written by another
+// firmware build still loads: keys it does not know are skipped.
+const (
+	configMagic       = 0x47464342 // "BCFG"
+	configVersion     = 1
+	configHeaderSize  = 8
+	configMaxKeyLen   = 32
+	configMaxEntries  = 16
+	configEntryMaxLen = 2 + configMaxKeyLen + config.MaxSettingValueLen
+	configMaxSize     = configHeaderSize + configMaxEntries*configEntryMaxLen + 4
+)
+
+// Config store errors
+var (
+	errConfigEmpty   = errors.New("config store: no settings saved")
+	errConfigVersion = errors.New("config store: unsupported record version")
+	errConfigCorrupt = errors.New("config store: corrupt record")
+)
+
+// encodeConfig writes a config record with the overrides into buf (at
+// least configMaxSize bytes) and returns its length. Overrides beyond
+// configMaxEntries or with oversized keys or values are left out.
+func encodeConfig(buf []byte, overrides []config.Override) int {
+	le := binary.LittleEndian
+	le.PutUint32(buf[0:], configMagic)
+	le.PutUint16(buf[4:], configVersion)
+
+	pos, count := configHeaderSize, 0
+	for _, o := range overrides {
+		if count == configMaxEntries || len(o.Key) > configMaxKeyLen || len(o.Value) > config.MaxSettingValueLen {
+			continue
+		}
+		buf[pos] = uint8(len(o.Key))
+		buf[pos+1] = uint8(len(o.Value))
+		pos += 2
+		pos += copy(buf[pos:], o.Key)
+		pos += copy(buf[pos:], o.Value)
+		count++
+	}
+	le.PutUint16(buf[6:], uint16(count))
+	le.PutUint32(buf[pos:], crc32IEEE(buf[:pos]))
+	return pos + 4
+}
+
+// decodeConfig validates a config record and returns its overrides
+func decodeConfig(buf []byte) ([]config.Override, error) {
+	le := binary.LittleEndian
+	if len(buf) < configHeaderSize+4 {
+		return nil, errConfigCorrupt
+	}
+	switch le.Uint32(buf[0:]) {
+	case configMagic:
+	case 0xFFFFFFFF:
+		return nil, errConfigEmpty // Erased flash
+	default:
+		return nil, errConfigCorrupt
+	}
+	if le.Uint16(buf[4:]) != configVersion {
+		return nil, errConfigVersion
+	}
+	count := int(le.Uint16(buf[6:]))
+	if count > configMaxEntries {
+		return nil, errConfigCorrupt
+	}
+
+	// Find the end of the entries before trusting any of them
+	pos := configHeaderSize
+	for i := 0; i < count; i++ {
+		if pos+2 > len(buf) {
+			return nil, errConfigCorrupt
+		}
+		pos += 2 + int(buf[pos]) + int(buf[pos+1])
+	}
+	if pos+4 > len(buf) || le.Uint32(buf[pos:]) != crc32IEEE(buf[:pos]) {
+		return nil, errConfigCorrupt
+	}
+
+	overrides := make([]config.Override, 0, count)
+	pos = configHeaderSize
+	for i := 0; i < count; i++ {
+		keyLen, valueLen := int(buf[pos]), int(buf[pos+1])
+		pos += 2
+		key := string(buf[pos : pos+keyLen])
+		pos += keyLen
+		overrides = append(overrides, config.Override{Key: key, Value: string(buf[pos : pos+valueLen])})
+		pos += valueLen
+	}
+	return overrides, nil
+}
+
+// loadOverrides sets the overrides read from flash. Returns those that
+// were skipped because the key is unknown to this build or the value no
+// longer validates.
+func loadOverrides(overrides []config.Override) (skipped []config.Override) {
+	for _, o := range overrides {
+		if err := config.Set(o.Key, o.Value); err != nil {
+			skipped = append(skipped, o)
+		}
+	}
+	return skipped
+}
+
+// parseConfigCommand splits the arguments of the console config command:
+// "<op> [key [value...]]". The value is the rest of the line, so it may
+// contain spaces (broker lists).
+func parseConfigCommand(args []byte) (op, key, value []byte) {
+	op, rest := nextField(args)
+	key, rest = nextField(rest)
+	for len(rest) > 0 && rest[0] == ' ' {
+		rest = rest[1:]
+	}
+	return op, key, rest
+}
+
+// nextField returns the first space-separated field of s and what follows it
+func nextField(s []byte) (field, rest []byte) {
+	i := 0
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	start := i
+	for i < len(s) && s[i] != ' ' {
+		i++
+	}
+	return s[start:i], s[i:]
+}
@@ -0,0 +1,86 @@
+//go:build tinygo
+
+package main
+
+import (
+	"openenterprise/bindicator/config"
+	"openenterprise/bindicator/ota"
+)
+
+// Flash location of the config record: the last sector of the reserved
+// region, clear of the schedule record at its start
+const (
+	configStoreOffset  = ota.ReservedOffset + ota.ReservedSize - ota.SectorSize
+	configStoreBufSize = (configMaxSize + ota.PageSize - 1) / ota.PageSize * ota.PageSize
+)
+
+// configStoreBuf holds the encoded record
+var configStoreBuf [configStoreBufSize]byte
+
+// configStoreLastErr is the last config save/restore error (for the console)
+var configStoreLastErr error
+
+// saveConfig persists the current overrides to flash. Nothing is written
+// if the record in flash already matches.
+func saveConfig() error {
+	n := encodeConfig(configStoreBuf[:], config.Overrides())
+	size := (n + ota.PageSize - 1) / ota.PageSize * ota.PageSize
+	for i := n; i < size; i++ {
+		configStoreBuf[i] = 0xFF // Erased flash value
+	}
+	if flashMatches(configStoreOffset, configStoreBuf[:n]) {
+		return nil
+	}
+
+	if err := ota.EraseSector(configStoreOffset); err != nil {
+		configStoreLastErr = err
+		return err
+	}
+	if err := ota.WriteChunk(configStoreOffset, configStoreBuf[:size]); err != nil {
+		configStoreLastErr = err
+		return err
+	}
+	if !flashMatches(configStoreOffset, configStoreBuf[:n]) {
+		configStoreLastErr = ota.ErrFlashWriteFailed
+		return ota.ErrFlashWriteFailed
+	}
+	configStoreLastErr = nil
+	return nil
+}
+
+// restoreConfig loads the overrides saved in flash into the config
+// package. Returns the number loaded and those skipped (see loadOverrides).
+func restoreConfig() (loaded int, skipped []config.Override, err error) {
+	ota.ReadFlash(configStoreOffset, configStoreBuf[:])
+	overrides, err := decodeConfig(configStoreBuf[:])
+	configStoreLastErr = err
+	if err != nil {
+		return 0, nil, err
+	}
+	skipped = loadOverrides(overrides)
+	return len(overrides) - len(skipped), skipped, nil
+}
+
+// applySetting puts a changed setting into effect. Settings marked Reboot
+// are left for the next boot; the rest are applied here: the intervals
+// from the next wake cycle, the brokers from the next connection and the
+// NTP server from the next sync (it is read every time).
+func applySetting(key string) error {
+	switch key {
+	case "wake_interval":
+		wakeInterval = config.WakeInterval()
+		updateDiscoveryExpiry()
+	case "schedule_refresh_interval":
+		scheduleRefreshInterval = config.ScheduleRefreshInterval()
+		updateDiscoveryExpiry()
+	case "timezone":
+		return setTimezone(config.Timezone())
+	case "broker":
+		brokers, err := config.Brokers()
+		if err != nil {
+			return err
+		}
+		setBrokers(brokers)
+	}
+	return nil
+}
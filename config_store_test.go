@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"openenterprise/bindicator/config"
+)
+
+func TestEncodeConfigRoundTrip(t *testing.T) {
+	want := []config.Override{
+		{Key: "wake_interval", Value: "5m"},
+		{Key: "broker", Value: "mqtt.example.net, 192.168.1.100:1884"},
+	}
+	var buf [configMaxSize]byte
+	n := encodeConfig(buf[:], want)
+	got, err := decodeConfig(buf[:n])
+	if err != nil {
+		t.Fatalf("decodeConfig() err = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decodeConfig() = %v, want %v", got, want)
+	}
+
+	// An empty record (all overrides reset) is valid
+	n = encodeConfig(buf[:], nil)
+	if got, err := decodeConfig(buf[:n]); err != nil || len(got) != 0 {
+		t.Errorf("empty record: %v, %v", got, err)
+	}
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	var valid [configMaxSize]byte
+	n := encodeConfig(valid[:], []config.Override{{Key: "ntp_server", Value: "pool.ntp.org"}})
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+		err    error
+	}{
+		{"erased flash", func(b []byte) []byte {
+			for i := range b {
+				b[i] = 0xFF
+			}
+			return b
+		}, errConfigEmpty},
+		{"bad magic", func(b []byte) []byte { b[0] = 'X'; return b }, errConfigCorrupt},
+		{"future version", func(b []byte) []byte { b[4] = 9; return b }, errConfigVersion},
+		{"flipped bit", func(b []byte) []byte { b[configHeaderSize+3] ^= 1; return b }, errConfigCorrupt},
+		{"bad length", func(b []byte) []byte { b[configHeaderSize+1] = 200; return b }, errConfigCorrupt},
+		{"count too large", func(b []byte) []byte { b[6] = 0xFF; return b }, errConfigCorrupt},
+		{"truncated", func(b []byte) []byte { return b[:n-2] }, errConfigCorrupt},
+	}
+	for _, tc := range tests {
+		var buf [configMaxSize]byte
+		copy(buf[:], valid[:n])
+		if _, err := decodeConfig(tc.modify(buf[:n])); err != tc.err {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	t.Cleanup(func() {
+		for _, s := range config.Settings() {
+			config.Reset(s.Key)
+		}
+	})
+	skipped := loadOverrides([]config.Override{
+		{Key: "wake_interval", Value: "2m"},
+		{Key: "removed_setting", Value: "x"},
+		{Key: "ntp_server", Value: "not a host"},
+	})
+	if len(skipped) != 2 || skipped[0].Key != "removed_setting" || skipped[1].Key != "ntp_server" {
+		t.Errorf("skipped = %v", skipped)
+	}
+	if v, src, _ := config.Get("wake_interval"); v != "2m" || src != config.SourceOverride {
+		t.Errorf("wake_interval = %q (%v), want the stored 2m", v, src)
+	}
+}
+
+func TestParseConfigCommand(t *testing.T) {
+	tests := []struct {
+		input, op, key, value string
+	}{
+		{"", "", "", ""},
+		{"list", "list", "", ""},
+		{" get  wake_interval", "get", "wake_interval", ""},
+		{"set broker mqtt.example.net, 192.168.1.100:1884", "set", "broker", "mqtt.example.net, 192.168.1.100:1884"},
+		{"reset", "reset", "", ""},
+	}
+	for _, tt := range tests {
+		op, key, value := parseConfigCommand([]byte(tt.input))
+		if string(op) != tt.op || string(key) != tt.key || string(value) != tt.value {
+			t.Errorf("parseConfigCommand(%q) = %q, %q, %q; want %q, %q, %q",
+				tt.input, op, key, value, tt.op, tt.key, tt.value)
+		}
+	}
+}
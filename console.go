@@ -21,10 +21,10 @@ import (
 	"github.com/soypat/lneto/x/xnet"
 )
 
-const (
-	consolePort    = uint16(23) // Telnet port
-	consoleBufSize = 1024
-)
+const consoleBufSize = 1024
+
+// Telnet port (console.port in device.json)
+var consolePort = uint16(config.DefaultConsolePort)
 
 // Pre-allocated console buffers
 var (
@@ -52,9 +52,7 @@ const (
 	cmdNet             = "net"
 	cmdWifi            = "wifi"
 	cmdSleep           = "sleep"
-	cmdLedGreen        = "led-green"
-	cmdLedBlack        = "led-black"
-	cmdLedBrown        = "led-brown"
+	cmdLedPrefix       = "led-" // led-<bin> toggles a bin LED
 	cmdNextJob         = "next"
 	cmdOTA             = "ota"
 	cmdOTAEnable       = "ota-enable"
@@ -63,6 +61,11 @@ const (
 	cmdTelemetryFlush  = "telemetry-flush"
 	cmdNTP             = "ntp"
 	cmdNTPSync         = "ntp-sync"
+	cmdScheduleErrors  = "schedule-errors"
+	cmdPremises        = "premises"
+	cmdExceptions      = "exceptions"
+	cmdException       = "exception " // exception <add|move|cancel|del|clear> ...
+	cmdConfig          = "config"     // config [list|get|set|reset] ...
 )
 
 // consoleServer runs a TCP debug console on port 23
@@ -248,12 +251,36 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 		}
 	}()
 
+	// Commands that change the device's state are shared with the MQTT
+	// command channel
+	if handled, _, reboot := runDeviceCommand(conn, stack, cmd, logger, refreshChan); handled {
+		if reboot {
+			conn.Flush()
+			time.Sleep(100 * time.Millisecond)
+			ota.Reboot()
+		}
+		return
+	}
+
 	switch {
 	case bytesEqual(cmd, []byte(cmdHelp)):
 		writeConsole(conn, "Commands: help version status net wifi time jobs next leds ota ntp\r\n")
+		writeConsole(conn, "  premises, jobs <premises>, led-<bin> <premises>\r\n")
 		writeConsole(conn, "  refresh, sleep <dur>, ota-enable [dur], ntp-sync, reboot\r\n")
-		writeConsole(conn, "  led-green, led-black, led-brown\r\n")
-		writeConsole(conn, "  telemetry, telemetry-flush\r\n")
+		writeConsole(conn, "  ")
+		for bt := BinType(1); int(bt) <= numBins(); bt++ {
+			if bt > 1 {
+				writeConsole(conn, ", ")
+			}
+			writeConsole(conn, cmdLedPrefix)
+			writeConsole(conn, bt.String())
+		}
+		writeConsole(conn, "\r\n")
+		writeConsole(conn, "  telemetry, telemetry-flush, schedule-errors, exceptions\r\n")
+		writeConsole(conn, "  exception <add|move|cancel> YYYY-MM-DD <bin> [YYYY-MM-DD]\r\n")
+		writeConsole(conn, "  exception del <n>, exception clear\r\n")
+		writeConsole(conn, "  config [list], config get <key>, config set <key> <value>\r\n")
+		writeConsole(conn, "  config reset [key]\r\n")
 
 	case bytesEqual(cmd, []byte(cmdStatus)):
 		if systemHealthy {
@@ -263,6 +290,13 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 		}
 		writeConsole(conn, "Jobs loaded: ")
 		writeInt(conn, jobCount)
+		writeConsole(conn, "/")
+		writeInt(conn, maxJobs)
+		if generatedJobs > 0 {
+			writeConsole(conn, " (")
+			writeInt(conn, generatedJobs)
+			writeConsole(conn, " generated)")
+		}
 		writeConsole(conn, "\r\n")
 		writeConsole(conn, "Failures: ")
 		writeInt(conn, consecutiveFailures)
@@ -273,92 +307,99 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 		if lastSuccessfulRefresh.IsZero() {
 			writeConsole(conn, "never\r\n")
 		} else {
-			writeConsole(conn, lastSuccessfulRefresh.Format("15:04:05"))
+			writeConsole(conn, localTime(lastSuccessfulRefresh).Format("15:04:05"))
 			writeConsole(conn, " (")
 			mins := int(time.Since(lastSuccessfulRefresh).Minutes())
 			writeInt(conn, mins)
 			writeConsole(conn, "m ago)\r\n")
 		}
-
-	case bytesEqual(cmd, []byte(cmdRefresh)):
-		writeConsole(conn, "Triggering refresh...\r\n")
-		select {
-		case refreshChan <- struct{}{}:
-			writeConsole(conn, "Refresh triggered\r\n")
-		default:
-			writeConsole(conn, "Refresh already pending\r\n")
+		writeConsole(conn, "Stored schedule: ")
+		if storedFetchTime.IsZero() {
+			writeConsole(conn, "none")
+		} else {
+			writeConsole(conn, localTime(storedFetchTime).Format("2006-01-02 15:04 MST"))
+			writeConsole(conn, " (")
+			writeInt(conn, storeWrites)
+			writeConsole(conn, " writes since boot)")
+		}
+		if storeLastErr != nil && storeLastErr != errStoreEmpty {
+			writeConsole(conn, " - ")
+			writeConsole(conn, storeLastErr.Error())
 		}
+		writeConsole(conn, "\r\n")
 
 	case bytesEqual(cmd, []byte(cmdTime)):
 		now := time.Now()
 		writeConsole(conn, "Time: ")
-		writeConsole(conn, now.Format("2006-01-02 15:04:05"))
-		writeConsole(conn, " UTC\r\n")
+		writeConsole(conn, localTime(now).Format("2006-01-02 15:04:05 MST"))
+		writeConsole(conn, " (")
+		writeConsole(conn, timezoneName())
+		writeConsole(conn, ")\r\nUTC:  ")
+		writeConsole(conn, now.UTC().Format("2006-01-02 15:04:05"))
+		writeConsole(conn, "\r\n")
 
-	case bytesEqual(cmd, []byte(cmdJobs)):
-		jobs := getJobs()
+	case bytesEqual(cmd, []byte(cmdJobs)) || hasPrefix(cmd, []byte(cmdJobs+" ")):
+		jobs := applyExceptions(getJobs())
+		only := -1
+		if len(cmd) > len(cmdJobs) {
+			if only = lookupPremises(cmd[len(cmdJobs)+1:]); only < 0 {
+				writeConsole(conn, "Unknown premises (see 'premises')\r\n")
+				break
+			}
+		}
 		if len(jobs) == 0 {
 			writeConsole(conn, "No jobs loaded\r\n")
-		} else {
-			for i := 0; i < len(jobs); i++ {
-				job := &jobs[i]
-				writeInt(conn, int(job.Year))
-				writeConsole(conn, "-")
-				writeInt2(conn, int(job.Month))
-				writeConsole(conn, "-")
-				writeInt2(conn, int(job.Day))
-				writeConsole(conn, " : ")
-				writeBinType(conn, job.Bin)
-				writeConsole(conn, "\r\n")
+			break
+		}
+		for p := 0; p < numPremises(); p++ {
+			if only < 0 || only == p {
+				writeJobs(conn, jobs, p)
 			}
 		}
+		if only < 0 {
+			writeJobs(conn, jobs, -1) // Premises no longer configured
+		}
 
 	case bytesEqual(cmd, []byte(cmdNextJob)):
-		jobs := getJobs()
-		now := time.Now()
-		found := false
-		for i := 0; i < len(jobs); i++ {
-			job := &jobs[i]
-			jobDate := time.Date(int(job.Year), time.Month(job.Month), int(job.Day), 0, 0, 0, 0, time.UTC)
-			// Show job if it's today or in the future
-			if !jobDate.Before(now.Truncate(24 * time.Hour)) {
-				writeConsole(conn, "Next: ")
-				writeInt(conn, int(job.Year))
-				writeConsole(conn, "-")
-				writeInt2(conn, int(job.Month))
-				writeConsole(conn, "-")
-				writeInt2(conn, int(job.Day))
-				writeConsole(conn, " ")
-				writeBinType(conn, job.Bin)
-				// Calculate days until
-				days := int(jobDate.Sub(now.Truncate(24*time.Hour)).Hours() / 24)
-				writeConsole(conn, " (")
-				if days == 0 {
-					writeConsole(conn, "TODAY")
-				} else if days == 1 {
-					writeConsole(conn, "tomorrow")
-				} else {
-					writeInt(conn, days)
-					writeConsole(conn, " days")
+		jobs := applyExceptions(getJobs())
+		for p := 0; p < numPremises(); p++ {
+			writeNextJob(conn, jobs, p)
+		}
+
+	case bytesEqual(cmd, []byte(cmdPremises)):
+		for p := 0; p < numPremises(); p++ {
+			n := 0
+			for i := 0; i < jobCount; i++ {
+				if jobPremises(&jobStorage[i]) == p {
+					n++
 				}
-				writeConsole(conn, ")\r\n")
-				found = true
-				break
 			}
-		}
-		if !found {
-			writeConsole(conn, "No upcoming jobs\r\n")
+			writeConsole(conn, premisesName(p))
+			writeConsole(conn, ": id ")
+			writeConsole(conn, premisesID(p))
+			writeConsole(conn, ", LEDs ")
+			writeConsole(conn, premisesPins(p))
+			writeConsole(conn, ", ")
+			writeInt(conn, n)
+			writeConsole(conn, " jobs\r\n")
 		}
 
 	case bytesEqual(cmd, []byte(cmdLeds)):
 		writeConsole(conn, "LED States:\r\n")
-		writeConsole(conn, "  GREEN: ")
-		writeBool(conn, ledState.green)
-		writeConsole(conn, "\r\n  BLACK: ")
-		writeBool(conn, ledState.black)
-		writeConsole(conn, "\r\n  BROWN: ")
-		writeBool(conn, ledState.brown)
-		writeConsole(conn, "\r\n")
+		for p := 0; p < numPremises(); p++ {
+			if numPremises() > 1 {
+				writeConsole(conn, " ")
+				writeConsole(conn, premisesName(p))
+				writeConsole(conn, ":\r\n")
+			}
+			for bt := BinType(1); int(bt) <= numBins(); bt++ {
+				writeConsole(conn, "  ")
+				writeBinType(conn, bt)
+				writeConsole(conn, ": ")
+				writeBool(conn, ledState[p][bt])
+				writeConsole(conn, "\r\n")
+			}
+		}
 
 	case bytesEqual(cmd, []byte(cmdVersion)):
 		writeConsole(conn, "Openenterprise Bindicator\r\n")
@@ -372,8 +413,34 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 
 	case bytesEqual(cmd, []byte(cmdNet)):
 		writeConsole(conn, "Network Status:\r\n")
-		writeConsole(conn, "  IP Address: ")
+		writeConsole(conn, "  Mode:       ")
+		if netConfig.Static {
+			writeConsole(conn, "static")
+		} else if netConfig.Addr.IsValid() {
+			writeConsole(conn, "DHCP (requested ")
+			writeConsole(conn, netConfig.Addr.String())
+			writeConsole(conn, ")")
+		} else {
+			writeConsole(conn, "DHCP")
+		}
+		writeConsole(conn, "\r\n  IP Address: ")
 		writeConsole(conn, stack.Addr().String())
+		writeConsole(conn, "\r\n  Gateway:    ")
+		if gatewayAddr.IsValid() {
+			writeConsole(conn, gatewayAddr.String())
+		} else {
+			writeConsole(conn, "none")
+		}
+		writeConsole(conn, "\r\n  DNS:        ")
+		for i, addr := range dnsServers {
+			if i > 0 {
+				writeConsole(conn, ", ")
+			}
+			writeConsole(conn, addr.String())
+		}
+		if len(dnsServers) == 0 {
+			writeConsole(conn, "none")
+		}
 		writeConsole(conn, "\r\n  Console:    port ")
 		writeInt(conn, int(consolePort))
 		writeConsole(conn, "\r\n  Uptime:     ")
@@ -382,6 +449,17 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 
 	case bytesEqual(cmd, []byte(cmdWifi)):
 		writeConsole(conn, "WiFi Quality:\r\n")
+		// Network in use and its priority
+		writeConsole(conn, "  Network:       ")
+		writeConsole(conn, wifiStats.ssid)
+		if wifiStats.networks > 1 {
+			writeConsole(conn, " (")
+			writeInt(conn, wifiStats.network+1)
+			writeConsole(conn, " of ")
+			writeInt(conn, wifiStats.networks)
+			writeConsole(conn, ")")
+		}
+		writeConsole(conn, "\r\n")
 		// Connection uptime
 		writeConsole(conn, "  Connected:     ")
 		if wifiStats.connectTime.IsZero() {
@@ -408,61 +486,40 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 		if wifiStats.lastMQTTSuccess.IsZero() {
 			writeConsole(conn, "never\r\n")
 		} else {
-			writeConsole(conn, wifiStats.lastMQTTSuccess.Format("15:04:05"))
+			writeConsole(conn, localTime(wifiStats.lastMQTTSuccess).Format("15:04:05"))
 			writeConsole(conn, " (")
 			mins := int(time.Since(wifiStats.lastMQTTSuccess).Minutes())
 			writeInt(conn, mins)
 			writeConsole(conn, "m ago)\r\n")
 		}
-		// Consecutive failures
-		writeConsole(conn, "  Consecutive failures: ")
-		writeInt(conn, consecutiveFailures)
-		writeConsole(conn, "\r\n")
-
-	case len(cmd) >= 5 && bytesEqual(cmd[:5], []byte(cmdSleep)):
-		// Parse sleep duration: "sleep 30s", "sleep 1m", "sleep 0"
-		if len(cmd) <= 6 {
-			// Just "sleep" with no arg - show current
-			writeConsole(conn, "Sleep override: ")
-			if debugSleepDuration == 0 {
-				writeConsole(conn, "off (using default 3h)\r\n")
-			} else {
-				writeInt(conn, int(debugSleepDuration.Seconds()))
-				writeConsole(conn, "s\r\n")
+		// Broker of the last connection
+		if activeBrokerAddr.IsValid() {
+			writeConsole(conn, "  MQTT broker:   ")
+			writeConsole(conn, activeBrokerAddr.String())
+			if activeBroker > 0 {
+				writeConsole(conn, " (fallback ")
+				writeInt(conn, activeBroker)
+				writeConsole(conn, ")")
 			}
-		} else {
-			// Parse the duration argument
-			arg := cmd[6:] // skip "sleep "
-			dur := parseDuration(arg)
-			debugSleepDuration = dur
-			writeConsole(conn, "Sleep override set to: ")
-			if dur == 0 {
-				writeConsole(conn, "off (using default 3h)\r\n")
+			writeConsole(conn, "\r\n")
+		}
+		// Persistent session
+		if mqttKeepAlive > 0 {
+			writeConsole(conn, "  MQTT session:  ")
+			if sessionConnected {
+				writeConsole(conn, "up ")
+				writeInt(conn, int(time.Since(sessionSince).Minutes()))
+				writeConsole(conn, "m")
 			} else {
-				writeInt(conn, int(dur.Seconds()))
-				writeConsole(conn, "s\r\n")
+				writeConsole(conn, "reconnecting")
 			}
+			writeConsole(conn, " (")
+			writeInt(conn, sessionReconnects)
+			writeConsole(conn, " reconnects)\r\n")
 		}
-
-	case bytesEqual(cmd, []byte(cmdLedGreen)):
-		ledState.green = !ledState.green
-		setLED(BinGreen, ledState.green)
-		writeConsole(conn, "GREEN LED: ")
-		writeBool(conn, ledState.green)
-		writeConsole(conn, "\r\n")
-
-	case bytesEqual(cmd, []byte(cmdLedBlack)):
-		ledState.black = !ledState.black
-		setLED(BinBlack, ledState.black)
-		writeConsole(conn, "BLACK LED: ")
-		writeBool(conn, ledState.black)
-		writeConsole(conn, "\r\n")
-
-	case bytesEqual(cmd, []byte(cmdLedBrown)):
-		ledState.brown = !ledState.brown
-		setLED(BinBrown, ledState.brown)
-		writeConsole(conn, "BROWN LED: ")
-		writeBool(conn, ledState.brown)
+		// Consecutive failures
+		writeConsole(conn, "  Consecutive failures: ")
+		writeInt(conn, consecutiveFailures)
 		writeConsole(conn, "\r\n")
 
 	case bytesEqual(cmd, []byte(cmdOTA)):
@@ -500,30 +557,6 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 		writeInt(conn, int(ota.GetPartitionMaxSize()/1024))
 		writeConsole(conn, " KB\r\n")
 
-	case bytesEqual(cmd, []byte(cmdOTAEnable)) || hasPrefix(cmd, []byte(cmdOTAEnable+" ")):
-		// Parse optional timeout (e.g., "ota-enable 5m")
-		timeout := time.Duration(0) // Use default
-		if len(cmd) > len(cmdOTAEnable)+1 {
-			durationBytes := cmd[len(cmdOTAEnable)+1:]
-			parsed := parseDuration(durationBytes)
-			if parsed > 0 {
-				timeout = parsed
-			}
-		}
-		OTAEnable(timeout)
-		writeConsole(conn, "OTA server enabled on port 4242\r\n")
-		writeConsole(conn, "  Timeout: ")
-		remaining := OTATimeRemaining()
-		writeInt(conn, int(remaining.Minutes()))
-		writeConsole(conn, " minutes\r\n")
-		writeConsole(conn, "  Push updates with: bindicator-cli <ip> ota-push <file.uf2>\r\n")
-
-	case bytesEqual(cmd, []byte(cmdReboot)):
-		writeConsole(conn, "Rebooting device...\r\n")
-		conn.Flush()
-		time.Sleep(100 * time.Millisecond)
-		ota.Reboot()
-
 	case bytesEqual(cmd, []byte(cmdTelemetry)):
 		enabled, qLogs, qMetrics, qSpans, sLogs, sMetrics, sSpans, errs, collector := telemetry.Status()
 		writeConsole(conn, "Telemetry Status:\r\n")
@@ -566,7 +599,7 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 		if lastNTPSync.IsZero() {
 			writeConsole(conn, "never\r\n")
 		} else {
-			writeConsole(conn, lastNTPSync.Format("15:04:05"))
+			writeConsole(conn, localTime(lastNTPSync).Format("15:04:05"))
 			writeConsole(conn, " (")
 			mins := int(time.Since(lastNTPSync).Minutes())
 			writeInt(conn, mins)
@@ -585,24 +618,149 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 		writeInt(conn, ntpFailCount)
 		writeConsole(conn, "\r\n")
 
-	case bytesEqual(cmd, []byte(cmdNTPSync)):
-		writeConsole(conn, "Triggering NTP sync...\r\n")
-		conn.Flush()
-		offset, err := syncNTP(stack, dnsServers, logger)
-		if err != nil {
-			writeConsole(conn, "NTP sync failed: ")
-			writeConsole(conn, err.Error())
+	case bytesEqual(cmd, []byte(cmdScheduleErrors)):
+		res := &lastParseResult
+		if res.Version == 0 {
+			writeConsole(conn, "No schedule parsed yet\r\n")
+			break
+		}
+		writeConsole(conn, "Last schedule parse:\r\n")
+		if numPremises() > 1 {
+			writeConsole(conn, "  Request:   ")
+			writeConsole(conn, premisesName(res.Request))
 			writeConsole(conn, "\r\n")
+		}
+		writeConsole(conn, "  Format:    ")
+		if res.Version == payloadJSONv2 {
+			writeConsole(conn, "v2 (JSON)\r\n")
 		} else {
-			writeConsole(conn, "NTP sync complete\r\n")
-			writeConsole(conn, "  Time:   ")
-			writeConsole(conn, time.Now().Format("2006-01-02 15:04:05"))
-			writeConsole(conn, " UTC\r\n")
-			writeConsole(conn, "  Offset: ")
-			writeInt(conn, int(offset.Milliseconds()))
-			writeConsole(conn, "ms\r\n")
+			writeConsole(conn, "v1 (CSV)\r\n")
+		}
+		if res.Err != nil {
+			writeConsole(conn, "  Error:     ")
+			writeConsole(conn, res.Err.Error())
+			writeConsole(conn, " (cached jobs kept)\r\n")
+			break
+		}
+		if res.Version == payloadJSONv2 {
+			writeConsole(conn, "  Revision:  ")
+			writeInt(conn, int(res.Revision))
+			if res.Chunks > 0 {
+				writeConsole(conn, "\r\n  Chunks:    ")
+				writeInt(conn, int(res.Chunk))
+				writeConsole(conn, " of ")
+				writeInt(conn, int(res.Chunks))
+				if !res.Complete() {
+					writeConsole(conn, " (incomplete)")
+				}
+			}
+			writeConsole(conn, "\r\n  Premises:  ")
+			writeConsole(conn, res.PremisesID())
+			writeConsole(conn, "\r\n  Exceptions: ")
+			writeInt(conn, res.Exceptions)
+			writeConsole(conn, " accepted\r\n")
+		}
+		writeConsole(conn, "  Timestamp: ")
+		if res.Timestamp == 0 {
+			writeConsole(conn, "missing\r\n")
+		} else {
+			writeConsole(conn, localTime(time.Unix(res.Timestamp, 0)).Format("2006-01-02 15:04:05 MST"))
+			writeConsole(conn, "\r\n")
+		}
+		writeConsole(conn, "  Accepted:  ")
+		writeInt(conn, res.Accepted())
+		writeConsole(conn, "\r\n  Rejected:  ")
+		writeInt(conn, res.RejectedCount)
+		writeConsole(conn, "\r\n")
+		rejected := res.RejectedEntries()
+		for i := range rejected {
+			writeConsole(conn, "    ")
+			writeConsole(conn, rejected[i].String())
+			writeConsole(conn, " (")
+			writeConsole(conn, rejected[i].Reason.String())
+			writeConsole(conn, ")\r\n")
+		}
+		if res.RejectedCount > len(rejected) {
+			writeConsole(conn, "    ... and ")
+			writeInt(conn, res.RejectedCount-len(rejected))
+			writeConsole(conn, " more\r\n")
+		}
+		writeConsole(conn, "  Duplicates: ")
+		writeInt(conn, res.Duplicates)
+		writeConsole(conn, "\r\n")
+		writeConsole(conn, "  Truncated: ")
+		if res.Truncated {
+			writeConsole(conn, "yes (")
+			writeInt(conn, res.Dropped)
+			writeConsole(conn, " dropped, max ")
+			writeInt(conn, maxJobs)
+			writeConsole(conn, ")\r\n")
+		} else {
+			writeConsole(conn, "no\r\n")
+		}
+
+	case bytesEqual(cmd, []byte(cmdExceptions)):
+		exceptions := getExceptions()
+		if len(exceptions) == 0 {
+			writeConsole(conn, "No exceptions\r\n")
+			break
+		}
+		applyExceptions(getJobs()) // Refresh the matched flags
+		for i := range exceptions {
+			writeInt(conn, i+1)
+			writeConsole(conn, ": ")
+			writeException(conn, &exceptions[i])
+			if exceptions[i].Manual {
+				writeConsole(conn, " (console")
+			} else {
+				writeConsole(conn, " (payload")
+			}
+			if !exceptionMatched[i] {
+				writeConsole(conn, ", no match")
+			}
+			writeConsole(conn, ")\r\n")
 		}
 
+	case hasPrefix(cmd, []byte(cmdException)):
+		args := cmd[len(cmdException):]
+		changed := true
+		switch {
+		case bytesEqual(args, []byte("clear")):
+			clearExceptions()
+			writeConsole(conn, "Exceptions cleared\r\n")
+		case hasPrefix(args, []byte("del ")):
+			i := parseIndex(args[4:]) - 1
+			if i < 0 || i >= len(getExceptions()) {
+				writeConsole(conn, "No such exception (see 'exceptions')\r\n")
+				changed = false
+				break
+			}
+			removeException(i)
+			writeConsole(conn, "Exception removed\r\n")
+		default:
+			ex, err := parseExceptionCommand(args)
+			if err == nil {
+				err = addException(&ex)
+			}
+			if err != nil {
+				writeConsole(conn, err.Error())
+				writeConsole(conn, "\r\n")
+				changed = false
+				break
+			}
+			writeConsole(conn, "Exception added: ")
+			writeException(conn, &ex)
+			writeConsole(conn, "\r\n")
+		}
+		if changed {
+			logger.Info("console:exceptions", slog.Int("count", len(getExceptions())))
+			saveExceptions(conn, logger)
+			updateLEDsFromSchedule(getJobs(), time.Now())
+		}
+
+	case bytesEqual(cmd, []byte(cmdConfig)) || hasPrefix(cmd, []byte(cmdConfig+" ")):
+		configCommand(conn, cmd[len(cmdConfig):], logger)
+
 	default:
 		writeConsole(conn, "Unknown command: ")
 		conn.Write(cmd)
@@ -613,30 +771,284 @@ func processCommand(conn *tcp.Conn, stack *xnet.StackAsync, cmd []byte, logger *
 	time.Sleep(50 * time.Millisecond)
 }
 
-// writeConsole writes a string to the console connection (no flush)
-func writeConsole(conn *tcp.Conn, s string) {
-	conn.Write([]byte(s))
+// configCommand runs "config list|get|set|reset". A changed setting is
+// applied (see applySetting) and saved to flash; a value that cannot be
+// applied is rolled back.
+func configCommand(conn *tcp.Conn, args []byte, logger *slog.Logger) {
+	op, keyBytes, value := parseConfigCommand(args)
+	key := string(keyBytes)
+	switch {
+	case len(op) == 0 || bytesEqual(op, []byte("list")):
+		for _, s := range config.Settings() {
+			writeSetting(conn, s.Key)
+		}
+		if configStoreLastErr != nil && configStoreLastErr != errConfigEmpty {
+			writeConsole(conn, "Store: ")
+			writeConsole(conn, configStoreLastErr.Error())
+			writeConsole(conn, "\r\n")
+		}
+		return
+
+	case bytesEqual(op, []byte("get")) && key != "":
+		if _, ok := config.Lookup(key); !ok {
+			writeConsole(conn, "Unknown setting (see 'config list')\r\n")
+			return
+		}
+		writeSetting(conn, key)
+		return
+
+	case bytesEqual(op, []byte("set")) && key != "":
+		old, src, err := config.Get(key)
+		if err == nil {
+			err = config.Set(key, string(value))
+		}
+		if err == nil {
+			if err = applySetting(key); err != nil {
+				if src == config.SourceOverride {
+					config.Set(key, old)
+				} else {
+					config.Reset(key)
+				}
+				applySetting(key)
+			}
+		}
+		if err != nil {
+			writeConsole(conn, err.Error())
+			writeConsole(conn, "\r\n")
+			return
+		}
+		logger.Info("config:set", slog.String("key", key), slog.String("value", config.Redact(key, string(value))))
+
+	case bytesEqual(op, []byte("reset")):
+		if key == "" {
+			for _, o := range config.Overrides() {
+				config.Reset(o.Key)
+				applySetting(o.Key)
+			}
+			logger.Info("config:reset-all")
+			writeConsole(conn, "All settings reset\r\n")
+			break
+		}
+		if err := config.Reset(key); err != nil {
+			writeConsole(conn, err.Error())
+			writeConsole(conn, "\r\n")
+			return
+		}
+		if err := applySetting(key); err != nil {
+			writeConsole(conn, err.Error())
+			writeConsole(conn, "\r\n")
+		}
+		logger.Info("config:reset", slog.String("key", key))
+
+	default:
+		writeConsole(conn, "Usage: config [list], config get <key>, config set <key> <value>, config reset [key]\r\n")
+		return
+	}
+
+	if key != "" {
+		writeSetting(conn, key)
+	}
+	if err := saveConfig(); err != nil {
+		logger.Error("config:save-failed", slog.String("err", err.Error()))
+		writeConsole(conn, "Not saved: ")
+		writeConsole(conn, err.Error())
+		writeConsole(conn, " (lost at reboot)\r\n")
+	}
 }
 
-// flushConsole flushes the console output
-func flushConsole(conn *tcp.Conn) {
-	conn.Flush()
+// writeSetting writes "key = value (source)" for a setting, noting when a
+// change needs a reboot
+func writeSetting(conn *tcp.Conn, key string) {
+	v, src, _ := config.Get(key)
+	v = config.Redact(key, v)
+	s, _ := config.Lookup(key)
+	writeConsole(conn, "  ")
+	writeConsole(conn, key)
+	writeConsole(conn, " = ")
+	if v == "" {
+		writeConsole(conn, "(unset)")
+	} else {
+		writeConsole(conn, v)
+	}
+	writeConsole(conn, " (")
+	writeConsole(conn, src.String())
+	if s.Reboot {
+		writeConsole(conn, ", applied at boot")
+	}
+	writeConsole(conn, ")\r\n")
+}
+
+// writeJobs lists the jobs of premises p (-1: jobs whose premises is not
+// configured), under a heading when there are several premises
+func writeJobs(conn *tcp.Conn, jobs []BinJob, p int) {
+	heading := numPremises() == 1
+	for i := 0; i < len(jobs); i++ {
+		job := &jobs[i]
+		if jobPremises(job) != p {
+			continue
+		}
+		if !heading {
+			if p < 0 {
+				writeConsole(conn, "Other premises:\r\n")
+			} else {
+				writeConsole(conn, premisesName(p))
+				writeConsole(conn, " (")
+				writeConsole(conn, premisesID(p))
+				writeConsole(conn, "):\r\n")
+			}
+			heading = true
+		}
+		if numPremises() > 1 {
+			writeConsole(conn, "  ")
+		}
+		writeInt(conn, int(job.Year))
+		writeConsole(conn, "-")
+		writeInt2(conn, int(job.Month))
+		writeConsole(conn, "-")
+		writeInt2(conn, int(job.Day))
+		writeConsole(conn, " : ")
+		writeBinType(conn, job.Bin)
+		if job.PremisesLen > 0 && numPremises() == 1 {
+			writeConsole(conn, " [")
+			writeConsole(conn, job.PremisesID())
+			writeConsole(conn, "]")
+		}
+		if job.NoteLen > 0 {
+			writeConsole(conn, " - ")
+			writeConsole(conn, job.NoteString())
+		}
+		switch {
+		case job.Flags&jobAdded != 0:
+			writeConsole(conn, " (added")
+		case job.Generated():
+			writeConsole(conn, " (generated")
+		default:
+			writeConsole(conn, " (fetched")
+		}
+		if job.Flags&jobMoved != 0 {
+			writeConsole(conn, ", moved")
+		}
+		writeConsole(conn, ")\r\n")
+	}
+	if !heading && p >= 0 {
+		writeConsole(conn, premisesName(p))
+		writeConsole(conn, ": no jobs\r\n")
+	}
+}
+
+// writeNextJob shows the next collection today or later at premises p
+func writeNextJob(conn *tcp.Conn, jobs []BinJob, p int) {
+	// Compare calendar days in local time
+	local := localTime(time.Now())
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	if numPremises() > 1 {
+		writeConsole(conn, "[")
+		writeConsole(conn, premisesName(p))
+		writeConsole(conn, "] ")
+	}
+	for i := 0; i < len(jobs); i++ {
+		job := &jobs[i]
+		if jobPremises(job) != p {
+			continue
+		}
+		jobDate := time.Date(int(job.Year), time.Month(job.Month), int(job.Day), 0, 0, 0, 0, time.UTC)
+		// Show job if it's today or in the future
+		if !jobDate.Before(today) {
+			writeConsole(conn, "Next: ")
+			writeInt(conn, int(job.Year))
+			writeConsole(conn, "-")
+			writeInt2(conn, int(job.Month))
+			writeConsole(conn, "-")
+			writeInt2(conn, int(job.Day))
+			writeConsole(conn, " ")
+			writeBinType(conn, job.Bin)
+			// Calculate days until
+			days := int(jobDate.Sub(today).Hours() / 24)
+			writeConsole(conn, " (")
+			if days == 0 {
+				writeConsole(conn, "TODAY")
+			} else if days == 1 {
+				writeConsole(conn, "tomorrow")
+			} else {
+				writeInt(conn, days)
+				writeConsole(conn, " days")
+			}
+			writeConsole(conn, ")\r\n")
+			on, off := collectionWindow(job)
+			writeConsole(conn, "  LED: ")
+			writeConsole(conn, on.Format("Mon 15:04"))
+			writeConsole(conn, " - ")
+			writeConsole(conn, off.Format("Mon 15:04 MST"))
+			writeConsole(conn, "\r\n")
+			return
+		}
+	}
+	writeConsole(conn, "No upcoming jobs\r\n")
+}
+
+// writeException writes "<action> YYYY-MM-DD <bin> [-> YYYY-MM-DD]"
+func writeException(conn *tcp.Conn, ex *ScheduleException) {
+	var date [10]byte
+	writeConsole(conn, ex.Action.String())
+	writeConsole(conn, " ")
+	writeDate(date[:], ex.Year, ex.Month, ex.Day)
+	conn.Write(date[:])
+	writeConsole(conn, " ")
+	writeBinType(conn, ex.Bin)
+	if ex.Action == ExceptionMove {
+		writeConsole(conn, " -> ")
+		writeDate(date[:], ex.ToYear, ex.ToMonth, ex.ToDay)
+		conn.Write(date[:])
+	}
+}
+
+// saveExceptions persists the exceptions with the stored schedule (the
+// fetch time is kept, so only the changed record is written)
+func saveExceptions(conn *tcp.Conn, logger *slog.Logger) {
+	if _, err := saveSchedule(storedFetchTime); err != nil {
+		logger.Error("store:save-failed", slog.String("err", err.Error()))
+		writeConsole(conn, "Save failed: ")
+		writeConsole(conn, err.Error())
+		writeConsole(conn, "\r\n")
+	}
+}
+
+// writeConsole writes a string to the console connection, or the output
+// of a remote command (no flush)
+func writeConsole(w io.Writer, s string) {
+	w.Write([]byte(s))
+}
+
+// flushConsole flushes the console output (a remote command's output is
+// sent as one reply, so there is nothing to flush)
+func flushConsole(w io.Writer) {
+	if conn, ok := w.(*tcp.Conn); ok {
+		conn.Flush()
+	}
 }
 
 // writeInt writes an integer to the console
-func writeInt(conn *tcp.Conn, n int) {
+func writeInt(w io.Writer, n int) {
 	if n == 0 {
-		conn.Write([]byte{'0'})
+		w.Write([]byte{'0'})
 		return
 	}
-	var buf [10]byte
+	var buf [11]byte
 	i := len(buf)
+	neg := n < 0
+	if neg {
+		n = -n
+	}
 	for n > 0 {
 		i--
 		buf[i] = byte('0' + n%10)
 		n /= 10
 	}
-	conn.Write(buf[i:])
+	if neg {
+		i--
+		buf[i] = '-'
+	}
+	w.Write(buf[i:])
 }
 
 // writeInt2 writes a 2-digit zero-padded integer
@@ -665,26 +1077,17 @@ func writeHex(conn *tcp.Conn, n uint32) {
 }
 
 // writeBool writes ON/OFF for boolean
-func writeBool(conn *tcp.Conn, b bool) {
+func writeBool(w io.Writer, b bool) {
 	if b {
-		conn.Write([]byte("ON"))
+		w.Write([]byte("ON"))
 	} else {
-		conn.Write([]byte("OFF"))
+		w.Write([]byte("OFF"))
 	}
 }
 
 // writeBinType writes the bin type name
-func writeBinType(conn *tcp.Conn, bt BinType) {
-	switch bt {
-	case BinGreen:
-		conn.Write([]byte("GREEN"))
-	case BinBlack:
-		conn.Write([]byte("BLACK"))
-	case BinBrown:
-		conn.Write([]byte("BROWN"))
-	default:
-		conn.Write([]byte("UNKNOWN"))
-	}
+func writeBinType(w io.Writer, bt BinType) {
+	writeConsole(w, bt.Label())
 }
 
 // writeUptime writes the uptime in human-readable format
@@ -899,6 +1302,18 @@ func parseDuration(s []byte) time.Duration {
 	}
 }
 
+// parseIndex parses a positive decimal number, returning 0 if s is not one
+func parseIndex(s []byte) int {
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' || n > 1000 {
+			return 0
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
 // formatRemoteIP formats a remote IP address as a string for logging
 func formatRemoteIP(addr []byte) string {
 	if len(addr) == 4 {
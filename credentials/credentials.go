@@ -11,6 +11,12 @@ var (
 	pass string
 	//go:embed console_password.text
 	consolePass string
+	//go:embed command_secret.text
+	commandSecret string
+	//go:embed mqtt_username.text
+	mqttUsername string
+	//go:embed mqtt_password.text
+	mqttPassword string
 )
 
 // SSID returns the contents of ssid.text file predefined by user in this package.
@@ -40,3 +46,27 @@ func Password() string {
 func ConsolePassword() string {
 	return consolePass
 }
+
+// CommandSecret returns the contents of command_secret.text file predefined by user in this package.
+// Used to authenticate remote commands sent over MQTT. An empty file disables them.
+//
+// Deprecated: Marked as deprecated so IDE warns users agains its use. Your command secret should be defined outside of this repo for security reasons!
+func CommandSecret() string {
+	return commandSecret
+}
+
+// MQTTUsername returns the contents of mqtt_username.text file predefined by user in this package.
+// Sent in the MQTT CONNECT packet. An empty file connects anonymously.
+//
+// Deprecated: Marked as deprecated so IDE warns users agains its use. Your MQTT credentials should be defined outside of this repo for security reasons!
+func MQTTUsername() string {
+	return mqttUsername
+}
+
+// MQTTPassword returns the contents of mqtt_password.text file predefined by user in this package.
+// Only sent together with a username.
+//
+// Deprecated: Marked as deprecated so IDE warns users agains its use. Your MQTT credentials should be defined outside of this repo for security reasons!
+func MQTTPassword() string {
+	return mqttPassword
+}
@@ -0,0 +1,120 @@
+//go:build tinygo
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/soypat/lneto/x/xnet"
+)
+
+// runDeviceCommand runs cmd if it is one of the operational commands that
+// change the device's state (refresh, reboot, ota-enable, ntp-sync, sleep,
+// led-<bin>), writing its output to w. The console and the MQTT command
+// channel both run them here; read-only commands stay on the console.
+//
+// handled is false for other commands and ok is false when the command
+// failed. reboot is set when the caller should restart the device once
+// the output is out.
+func runDeviceCommand(w io.Writer, stack *xnet.StackAsync, cmd []byte, logger *slog.Logger, refreshChan chan struct{}) (handled, ok, reboot bool) {
+	switch {
+	case bytesEqual(cmd, []byte(cmdRefresh)):
+		writeConsole(w, "Triggering refresh...\r\n")
+		select {
+		case refreshChan <- struct{}{}:
+			writeConsole(w, "Refresh triggered\r\n")
+		default:
+			writeConsole(w, "Refresh already pending\r\n")
+		}
+
+	case bytesEqual(cmd, []byte(cmdReboot)):
+		writeConsole(w, "Rebooting device...\r\n")
+		return true, true, true
+
+	case bytesEqual(cmd, []byte(cmdOTAEnable)) || hasPrefix(cmd, []byte(cmdOTAEnable+" ")):
+		// Parse optional timeout (e.g., "ota-enable 5m")
+		timeout := time.Duration(0) // Use default
+		if len(cmd) > len(cmdOTAEnable)+1 {
+			if parsed := parseDuration(cmd[len(cmdOTAEnable)+1:]); parsed > 0 {
+				timeout = parsed
+			}
+		}
+		OTAEnable(timeout)
+		writeConsole(w, "OTA server enabled on port 4242\r\n")
+		writeConsole(w, "  Timeout: ")
+		writeInt(w, int(OTATimeRemaining().Minutes()))
+		writeConsole(w, " minutes\r\n")
+		writeConsole(w, "  Push updates with: bindicator-cli <ip> ota-push <file.uf2>\r\n")
+
+	case bytesEqual(cmd, []byte(cmdNTPSync)):
+		writeConsole(w, "Triggering NTP sync...\r\n")
+		flushConsole(w)
+		offset, err := syncNTP(stack, dnsServers, logger)
+		if err != nil {
+			writeConsole(w, "NTP sync failed: ")
+			writeConsole(w, err.Error())
+			writeConsole(w, "\r\n")
+			return true, false, false
+		}
+		writeConsole(w, "NTP sync complete\r\n")
+		writeConsole(w, "  Time:   ")
+		writeConsole(w, localTime(time.Now()).Format("2006-01-02 15:04:05 MST"))
+		writeConsole(w, "\r\n")
+		writeConsole(w, "  Offset: ")
+		writeInt(w, int(offset.Milliseconds()))
+		writeConsole(w, "ms\r\n")
+
+	case bytesEqual(cmd, []byte(cmdSleep)) || hasPrefix(cmd, []byte(cmdSleep+" ")):
+		// "sleep 30s", "sleep 1m", "sleep 0"; just "sleep" shows the override
+		if len(cmd) > len(cmdSleep)+1 {
+			debugSleepDuration = parseDuration(cmd[len(cmdSleep)+1:])
+			writeConsole(w, "Sleep override set to: ")
+		} else {
+			writeConsole(w, "Sleep override: ")
+		}
+		if debugSleepDuration == 0 {
+			writeConsole(w, "off (using default 3h)\r\n")
+		} else {
+			writeInt(w, int(debugSleepDuration.Seconds()))
+			writeConsole(w, "s\r\n")
+		}
+
+	case hasPrefix(cmd, []byte(cmdLedPrefix)):
+		// led-<bin> [premises] (default: the first premises)
+		var buf [2][]byte
+		args := splitFields(cmd[len(cmdLedPrefix):], buf[:])
+		bt := BinUnknown
+		if len(args) > 0 {
+			bt = lookupBin(args[0])
+		}
+		if bt == BinUnknown {
+			writeConsole(w, "Unknown bin: ")
+			w.Write(cmd[len(cmdLedPrefix):])
+			writeConsole(w, "\r\n")
+			return true, false, false
+		}
+		p := 0
+		if len(args) > 1 {
+			if p = lookupPremises(args[1]); p < 0 {
+				writeConsole(w, "Unknown premises (see 'premises')\r\n")
+				return true, false, false
+			}
+		}
+		setLED(p, bt, !ledState[p][bt])
+		statePending = true
+		if numPremises() > 1 {
+			writeConsole(w, premisesName(p))
+			writeConsole(w, " ")
+		}
+		writeBinType(w, bt)
+		writeConsole(w, " LED: ")
+		writeBool(w, ledState[p][bt])
+		writeConsole(w, "\r\n")
+
+	default:
+		return false, false, false
+	}
+	return true, true, false
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// Home Assistant MQTT discovery. Every entity reads the retained state
+// topic (see state.go) through a value template and uses the availability
+// topic, so the device only publishes the discovery configs once and the
+// state as usual. Without a persistent session the device disconnects
+// cleanly after each fetch, so the Last Will never fires; the entities
+// expire instead when the state stops coming (see haExpireAfter).
+
+// haEntityKind is the kind of a discovered entity
+type haEntityKind uint8
+
+const (
+	haLED         haEntityKind = iota // binary_sensor per bin LED
+	haLEDSwitch                       // switch per bin LED (optional)
+	haNextDate                        // sensor per premises
+	haNextBin                         // sensor per premises
+	haVersion                         // diagnostic sensor for the device
+	haLastRefresh                     // diagnostic sensor for the device
+)
+
+// haEntity is one discovered entity: kind, premises and bin where they apply
+type haEntity struct {
+	kind haEntityKind
+	p    int
+	bt   BinType
+}
+
+// LED command topic parts: bindicator/<clientid>/led/<premises>/<bin>/set,
+// where <premises> is the premises index
+const (
+	topicLEDInfix  = "/led/"
+	topicSetSuffix = "/set"
+)
+
+// Discovery settings and topics (set by setHomeAssistant)
+var (
+	haConfig       config.HomeAssistant
+	haNodeID       [maxStatusClientIDLen]byte
+	haNodeIDLen    int
+	haTopicBuf     [128]byte
+	ledFilterBuf   [maxStatusTopicLen + len(topicLEDInfix) + len("+/+") + len(topicSetSuffix)]byte
+	ledFilterLen   int
+	ledCommandBase int    // Length of "bindicator/<clientid>/led/"
+	haStatusTopic  []byte // <prefix>/status: Home Assistant's birth message
+
+	// How long Home Assistant keeps the sensors without a state update
+	// before showing them unavailable (0: never, as with a persistent
+	// session, whose Last Will marks the device offline)
+	haExpireAfter time.Duration
+)
+
+// setHomeAssistant enables discovery for a client ID (as passed to
+// setStatusTopics, which must be called first)
+func setHomeAssistant(ha config.HomeAssistant, clientID string) {
+	haConfig = ha
+	haStatusTopic = []byte(ha.Prefix + "/status")
+	if len(clientID) > maxStatusClientIDLen {
+		clientID = clientID[:maxStatusClientIDLen]
+	}
+	for i := 0; i < len(clientID); i++ {
+		haNodeID[i] = idChar(clientID[i])
+	}
+	haNodeIDLen = len(clientID)
+
+	base := statusTopicBase()
+	n := copy(ledFilterBuf[:], base)
+	n += copy(ledFilterBuf[n:], topicLEDInfix)
+	ledCommandBase = n
+	n += copy(ledFilterBuf[n:], "+/+")
+	ledFilterLen = n + copy(ledFilterBuf[n:], topicSetSuffix)
+}
+
+// ledCommandFilter returns the subscription for the LED switches:
+// bindicator/<clientid>/led/+/+/set
+func ledCommandFilter() []byte {
+	return ledFilterBuf[:ledFilterLen]
+}
+
+// parseLEDCommand decodes a switch command. Returns ok false unless topic
+// is the command topic of a configured LED and payload is ON or OFF.
+func parseLEDCommand(topic, payload []byte) (p int, bt BinType, on, ok bool) {
+	base := ledFilterBuf[:ledCommandBase]
+	if len(topic) < len(base)+2+len(topicSetSuffix) || string(topic[:len(base)]) != string(base) ||
+		string(topic[len(topic)-len(topicSetSuffix):]) != topicSetSuffix {
+		return 0, 0, false, false
+	}
+	rest := topic[len(base) : len(topic)-len(topicSetSuffix)]
+	if len(rest) < 3 || rest[1] != '/' || rest[0] < '0' || int(rest[0]-'0') >= numPremises() {
+		return 0, 0, false, false
+	}
+	p = int(rest[0] - '0')
+	if bt = lookupBin(rest[2:]); bt == BinUnknown {
+		return 0, 0, false, false
+	}
+	switch string(payload) {
+	case "ON":
+		on = true
+	case "OFF":
+	default:
+		return 0, 0, false, false
+	}
+	return p, bt, on, true
+}
+
+// haEntityAt returns discovery entity i, ok false past the last one. Each
+// premises has its LEDs, their switches (if enabled) and next collection
+// sensors; the device sensors come last.
+func haEntityAt(i int) (haEntity, bool) {
+	perPremises := numBins() + 2
+	if haConfig.Switches {
+		perPremises += numBins()
+	}
+	if i < perPremises*numPremises() {
+		p, j := i/perPremises, i%perPremises
+		if j < numBins() {
+			return haEntity{haLED, p, BinType(j + 1)}, true
+		}
+		j -= numBins()
+		if haConfig.Switches {
+			if j < numBins() {
+				return haEntity{haLEDSwitch, p, BinType(j + 1)}, true
+			}
+			j -= numBins()
+		}
+		return haEntity{haNextDate + haEntityKind(j), p, 0}, true
+	}
+	switch i - perPremises*numPremises() {
+	case 0:
+		return haEntity{kind: haVersion}, true
+	case 1:
+		return haEntity{kind: haLastRefresh}, true
+	}
+	return haEntity{}, false
+}
+
+// encodeDiscovery writes the discovery topic of e to haTopicBuf and its
+// config JSON to buf, e.g. for the black bin LED:
+//
+//	homeassistant/binary_sensor/kitchen/black/config
+//	{"name":"Black bin","unique_id":"kitchen_black","state_topic":"bindicator/kitchen/state",
+//	 "value_template":"{{ 'ON' if value_json.premises[0].leds['black'] else 'OFF' }}",
+//	 "availability_topic":"bindicator/kitchen/availability","icon":"mdi:trash-can",
+//	 "device":{"identifiers":["bindicator_kitchen"],"name":"Bindicator kitchen",
+//	   "manufacturer":"Openenterprise","model":"Bindicator","sw_version":"v1.2.0"}}
+//
+// Returns the topic and the payload length.
+func encodeDiscovery(e haEntity, swVersion string, buf []byte) (topic []byte, n int, err error) {
+	component := "sensor"
+	switch e.kind {
+	case haLED:
+		component = "binary_sensor"
+	case haLEDSwitch:
+		component = "switch"
+	}
+
+	// <prefix>/<component>/<node>/<object>/config
+	t := stateWriter{buf: haTopicBuf[:]}
+	t.raw(haConfig.Prefix)
+	t.raw("/")
+	t.raw(component)
+	t.raw("/")
+	t.bytes(haNodeID[:haNodeIDLen])
+	t.raw("/")
+	objectStart := t.n
+	writeObjectID(&t, e)
+	object := haTopicBuf[objectStart:t.n]
+	t.raw("/config")
+
+	w := stateWriter{buf: buf}
+	w.raw(`{"name":"`)
+	switch e.kind {
+	case haLED:
+		w.title(e.bt.String())
+		w.raw(" bin")
+	case haLEDSwitch:
+		w.title(e.bt.String())
+		w.raw(" LED")
+	case haNextDate:
+		w.raw("Next collection")
+	case haNextBin:
+		w.raw("Next bin")
+	case haVersion:
+		w.raw("Firmware")
+	case haLastRefresh:
+		w.raw("Last refresh")
+	}
+	if e.kind <= haNextBin && numPremises() > 1 {
+		w.raw(" (")
+		w.esc(premisesName(e.p))
+		w.raw(")")
+	}
+	w.raw(`","unique_id":"`)
+	w.bytes(haNodeID[:haNodeIDLen])
+	w.raw("_")
+	w.bytes(object)
+	w.raw(`","state_topic":"`)
+	w.bytes(stateTopic())
+	w.raw(`","value_template":"`)
+	switch e.kind {
+	case haLED, haLEDSwitch:
+		w.raw(`{{ 'ON' if value_json.premises[`)
+		w.int(int64(e.p))
+		w.raw(`].leds['`)
+		w.raw(e.bt.String())
+		w.raw(`'] else 'OFF' }}`)
+	case haNextDate, haNextBin:
+		field := "date"
+		if e.kind == haNextBin {
+			field = "bin"
+		}
+		w.raw(`{{ value_json.premises[`)
+		w.int(int64(e.p))
+		w.raw(`].next.`)
+		w.raw(field)
+		w.raw(` if value_json.premises[`)
+		w.int(int64(e.p))
+		w.raw(`].next else 'None' }}`)
+	case haVersion:
+		w.raw(`{{ value_json.version }}`)
+	case haLastRefresh:
+		w.raw(`{{ value_json.wifi.last_success | timestamp_custom('%Y-%m-%dT%H:%M:%SZ', false) if value_json.wifi.last_success else 'None' }}`)
+	}
+	w.raw(`","availability_topic":"`)
+	w.bytes(availabilityTopic())
+	w.raw(`"`)
+	if haExpireAfter > 0 && e.kind != haLEDSwitch { // Switches cannot expire
+		w.raw(`,"expire_after":`)
+		w.int(int64(haExpireAfter / time.Second))
+	}
+	switch e.kind {
+	case haLED, haNextBin:
+		w.raw(`,"icon":"mdi:trash-can"`)
+	case haLEDSwitch:
+		w.raw(`,"icon":"mdi:led-on","command_topic":"`)
+		w.bytes(ledFilterBuf[:ledCommandBase])
+		w.int(int64(e.p))
+		w.raw("/")
+		w.raw(e.bt.String())
+		w.raw(topicSetSuffix)
+		w.raw(`"`)
+	case haNextDate:
+		w.raw(`,"device_class":"date"`)
+	case haVersion:
+		w.raw(`,"entity_category":"diagnostic"`)
+	case haLastRefresh:
+		w.raw(`,"device_class":"timestamp","entity_category":"diagnostic"`)
+	}
+	w.raw(`,"device":{"identifiers":["bindicator_`)
+	w.bytes(haNodeID[:haNodeIDLen])
+	w.raw(`"],"name":"Bindicator `)
+	w.bytes(haNodeID[:haNodeIDLen])
+	w.raw(`","manufacturer":"Openenterprise","model":"Bindicator","sw_version":`)
+	w.str(swVersion)
+	w.raw("}}")
+
+	if t.overflow || w.overflow {
+		return nil, 0, errStateTooLarge
+	}
+	return haTopicBuf[:t.n], w.n, nil
+}
+
+// writeObjectID writes the entity's object ID: the bin, next_date and so
+// on, prefixed with the premises name when there are several
+func writeObjectID(t *stateWriter, e haEntity) {
+	if e.kind <= haNextBin && numPremises() > 1 {
+		t.id(premisesName(e.p))
+		t.raw("_")
+	}
+	switch e.kind {
+	case haLED:
+		t.id(e.bt.String())
+	case haLEDSwitch:
+		t.id(e.bt.String())
+		t.raw("_led")
+	case haNextDate:
+		t.raw("next_date")
+	case haNextBin:
+		t.raw("next_bin")
+	case haVersion:
+		t.raw("version")
+	case haLastRefresh:
+		t.raw("last_refresh")
+	}
+}
+
+// idChar maps a character to one allowed in discovery IDs ([A-Za-z0-9_-])
+func idChar(c byte) byte {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' {
+		return c
+	}
+	return '_'
+}
+
+// id writes s with the characters not allowed in discovery IDs replaced
+func (w *stateWriter) id(s string) {
+	for i := 0; i < len(s); i++ {
+		w.byte(idChar(s[i]))
+	}
+}
+
+// title writes s with its first letter upper-cased
+func (w *stateWriter) title(s string) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if i == 0 && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		w.byte(c)
+	}
+}
+
+func (w *stateWriter) byte(c byte) {
+	if w.n >= len(w.buf) {
+		w.overflow = true
+		return
+	}
+	w.buf[w.n] = c
+	w.n++
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// useTestDiscovery enables discovery with switches for client ID "Kitchen 1"
+func useTestDiscovery(t *testing.T) {
+	t.Helper()
+	setStatusTopics("Kitchen 1")
+	setHomeAssistant(config.HomeAssistant{Enabled: true, Switches: true, Prefix: "homeassistant"}, "Kitchen 1")
+	t.Cleanup(func() { haConfig = config.HomeAssistant{} })
+}
+
+func TestEncodeDiscovery(t *testing.T) {
+	useTestPremises(t)
+	useTestDiscovery(t)
+
+	// 2 premises x (3 LEDs + 3 switches + 2 sensors) + 2 device sensors
+	var topics []string
+	for i := 0; ; i++ {
+		e, ok := haEntityAt(i)
+		if !ok {
+			break
+		}
+		topic, n, err := encodeDiscovery(e, "v1.2.0", stateBuf[:])
+		if err != nil {
+			t.Fatalf("encodeDiscovery(%d): %v", i, err)
+		}
+		var payload map[string]any
+		if err := json.Unmarshal(stateBuf[:n], &payload); err != nil {
+			t.Fatalf("entity %d: invalid JSON %s: %v", i, stateBuf[:n], err)
+		}
+		topics = append(topics, string(topic))
+
+		if i == 4 {
+			// The first premises' black LED switch
+			want := map[string]any{
+				"name":          "Black LED (office)",
+				"unique_id":     "Kitchen_1_office_black_led",
+				"command_topic": "bindicator/Kitchen 1/led/0/black/set",
+				"state_topic":   "bindicator/Kitchen 1/state",
+			}
+			for k, v := range want {
+				if payload[k] != v {
+					t.Errorf("switch %s = %v, want %v", k, payload[k], v)
+				}
+			}
+		}
+	}
+	if len(topics) != 18 {
+		t.Fatalf("%d entities, want 18: %v", len(topics), topics)
+	}
+	expected := map[int]string{
+		0:  "homeassistant/binary_sensor/Kitchen_1/office_green/config",
+		6:  "homeassistant/sensor/Kitchen_1/office_next_date/config",
+		15: "homeassistant/sensor/Kitchen_1/parents_next_bin/config",
+		17: "homeassistant/sensor/Kitchen_1/last_refresh/config",
+	}
+	for i, want := range expected {
+		if topics[i] != want {
+			t.Errorf("topic[%d] = %q, want %q", i, topics[i], want)
+		}
+	}
+}
+
+func TestDiscoveryExpireAfter(t *testing.T) {
+	useTestPremises(t)
+	useTestDiscovery(t)
+	haExpireAfter = 6*time.Hour + 15*time.Minute
+	defer func() { haExpireAfter = 0 }()
+
+	// Sensors expire when the state stops coming; switches cannot
+	for i, want := range map[int]any{0: 22500.0, 4: nil, 6: 22500.0, 17: 22500.0} {
+		e, _ := haEntityAt(i)
+		_, n, err := encodeDiscovery(e, "v1.2.0", stateBuf[:])
+		if err != nil {
+			t.Fatalf("encodeDiscovery(%d): %v", i, err)
+		}
+		var payload map[string]any
+		if err := json.Unmarshal(stateBuf[:n], &payload); err != nil {
+			t.Fatalf("entity %d: invalid JSON %s: %v", i, stateBuf[:n], err)
+		}
+		if payload["expire_after"] != want {
+			t.Errorf("entity %d expire_after = %v, want %v", i, payload["expire_after"], want)
+		}
+	}
+
+	// Never with a persistent session
+	haExpireAfter = 0
+	e, _ := haEntityAt(0)
+	_, n, _ := encodeDiscovery(e, "v1.2.0", stateBuf[:])
+	if bytes.Contains(stateBuf[:n], []byte("expire_after")) {
+		t.Errorf("expire_after without expiry: %s", stateBuf[:n])
+	}
+}
+
+func TestParseLEDCommand(t *testing.T) {
+	useTestPremises(t)
+	useTestDiscovery(t)
+
+	if got := string(ledCommandFilter()); got != "bindicator/Kitchen 1/led/+/+/set" {
+		t.Errorf("ledCommandFilter() = %q", got)
+	}
+	tests := []struct {
+		topic, payload string
+		p              int
+		bt             BinType
+		on, ok         bool
+	}{
+		{"bindicator/Kitchen 1/led/1/brown/set", "ON", 1, BinBrown, true, true},
+		{"bindicator/Kitchen 1/led/0/green/set", "OFF", 0, BinGreen, false, true},
+		{"bindicator/Kitchen 1/led/2/green/set", "ON", 0, 0, false, false},
+		{"bindicator/Kitchen 1/led/0/blue/set", "ON", 0, 0, false, false},
+		{"bindicator/Kitchen 1/led/0/green/set", "TOGGLE", 0, 0, false, false},
+		{"bindicator/Kitchen 2/led/0/green/set", "ON", 0, 0, false, false},
+		{"bindicator/Kitchen 1/led/0/green", "ON", 0, 0, false, false},
+	}
+	for _, tc := range tests {
+		p, bt, on, ok := parseLEDCommand([]byte(tc.topic), []byte(tc.payload))
+		if p != tc.p || bt != tc.bt || on != tc.on || ok != tc.ok {
+			t.Errorf("parseLEDCommand(%q, %q) = %d, %v, %v, %v", tc.topic, tc.payload, p, bt, on, ok)
+		}
+	}
+}
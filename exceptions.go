@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// maxExceptions is the number of schedule exceptions kept (payload and
+// console combined)
+const maxExceptions = 16
+
+// ExceptionAction is what a schedule exception does to a job
+type ExceptionAction uint8
+
+const (
+	ExceptionCancel ExceptionAction = iota + 1 // Remove the job
+	ExceptionMove                              // Move the job to another date
+	ExceptionAdd                               // Add an extra job
+)
+
+// String returns the action name used in payloads
+func (a ExceptionAction) String() string {
+	switch a {
+	case ExceptionCancel:
+		return "cancel"
+	case ExceptionMove:
+		return "move"
+	case ExceptionAdd:
+		return "add"
+	default:
+		return "unknown"
+	}
+}
+
+// parseExceptionAction parses an action name (case-insensitive), returning
+// 0 if it is unknown
+func parseExceptionAction(s []byte) ExceptionAction {
+	for a := ExceptionCancel; a <= ExceptionAdd; a++ {
+		if equalFoldASCII(s, a.String()) {
+			return a
+		}
+	}
+	return 0
+}
+
+// ScheduleException changes a single collection (e.g. a bank holiday move).
+// Exceptions are kept apart from the jobs and applied when the LEDs are
+// updated, so they also cover generated jobs and survive schedule fetches.
+type ScheduleException struct {
+	Action      ExceptionAction
+	Bin         BinType
+	Manual      bool // Added from the console (a payload only replaces its own)
+	Year        uint16
+	Month       uint8
+	Day         uint8
+	ToYear      uint16 // Target date (move only)
+	ToMonth     uint8
+	ToDay       uint8
+	PremisesLen uint8
+	Premises    [maxPremisesLen]byte // Premises ID (empty on the console: every premises)
+}
+
+// allPremises is returned by ScheduleException.premises for exceptions
+// that apply to every premises
+const allPremises = maxPremises
+
+// premises returns the premises the exception applies to: allPremises for
+// a console exception without a premises ID, -1 if the ID is unknown
+func (ex *ScheduleException) premises() int {
+	if ex.Manual && ex.PremisesLen == 0 {
+		return allPremises
+	}
+	return premisesIndex(ex.Premises[:ex.PremisesLen])
+}
+
+// Exception errors
+var (
+	errExceptionFull   = errors.New("exceptions: list full")
+	errExceptionSyntax = errors.New("exceptions: expected <add|move|cancel> YYYY-MM-DD <bin> [YYYY-MM-DD]")
+	errExceptionDate   = errors.New("exceptions: invalid date")
+	errExceptionBin    = errors.New("exceptions: unknown bin")
+)
+
+// Exception list, in the order they are applied
+var (
+	scheduleExceptions [maxExceptions]ScheduleException
+	exceptionMatched   [maxExceptions]bool // Set by applyExceptions
+	exceptionCount     int
+)
+
+// getExceptions returns the current exceptions
+func getExceptions() []ScheduleException {
+	return scheduleExceptions[:exceptionCount]
+}
+
+// addException appends an exception, replacing one for the same
+// collection (date, bin and premises) from the same source
+func addException(ex *ScheduleException) error {
+	for i := 0; i < exceptionCount; i++ {
+		e := &scheduleExceptions[i]
+		if e.Manual == ex.Manual && e.Bin == ex.Bin && e.premises() == ex.premises() &&
+			e.Year == ex.Year && e.Month == ex.Month && e.Day == ex.Day {
+			*e = *ex
+			return nil
+		}
+	}
+	if exceptionCount == maxExceptions {
+		return errExceptionFull
+	}
+	scheduleExceptions[exceptionCount] = *ex
+	exceptionMatched[exceptionCount] = false
+	exceptionCount++
+	return nil
+}
+
+// removeException deletes the exception at index i
+func removeException(i int) {
+	copy(scheduleExceptions[i:exceptionCount], scheduleExceptions[i+1:exceptionCount])
+	copy(exceptionMatched[i:exceptionCount], exceptionMatched[i+1:exceptionCount])
+	exceptionCount--
+}
+
+// clearExceptions removes all exceptions
+func clearExceptions() {
+	exceptionCount = 0
+}
+
+// clearPayloadExceptions removes the exceptions from payloads for premises p
+func clearPayloadExceptions(p int) {
+	for i := exceptionCount - 1; i >= 0; i-- {
+		if ex := &scheduleExceptions[i]; !ex.Manual && ex.premises() == p {
+			removeException(i)
+		}
+	}
+}
+
+// parseExceptionCommand parses a console exception:
+// "<add|move|cancel> YYYY-MM-DD <bin> [YYYY-MM-DD]" (the target date is
+// required for move only)
+func parseExceptionCommand(args []byte) (ScheduleException, error) {
+	var buf [5][]byte
+	fields := splitFields(args, buf[:])
+	n := len(fields)
+	ex := ScheduleException{Manual: true}
+	if n < 3 {
+		return ex, errExceptionSyntax
+	}
+	if ex.Action = parseExceptionAction(fields[0]); ex.Action == 0 {
+		return ex, errExceptionSyntax
+	}
+	if (ex.Action == ExceptionMove) != (n == 4) || n > 4 {
+		return ex, errExceptionSyntax
+	}
+	year, month, day, reason := parseDate(fields[1])
+	if reason != 0 {
+		return ex, errExceptionDate
+	}
+	ex.Year, ex.Month, ex.Day = uint16(year), uint8(month), uint8(day)
+	if ex.Bin = parseBinType(fields[2]); ex.Bin == BinUnknown {
+		return ex, errExceptionBin
+	}
+	if ex.Action == ExceptionMove {
+		year, month, day, reason := parseDate(fields[3])
+		if reason != 0 {
+			return ex, errExceptionDate
+		}
+		ex.ToYear, ex.ToMonth, ex.ToDay = uint16(year), uint8(month), uint8(day)
+	}
+	return ex, nil
+}
+
+// splitFields splits s at spaces into out, returning the fields found (at
+// most len(out))
+func splitFields(s []byte, out [][]byte) [][]byte {
+	n := 0
+	for i := 0; i < len(s) && n < len(out); {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != ' ' {
+			i++
+		}
+		if i > start {
+			out[n] = s[start:i]
+			n++
+		}
+	}
+	return out[:n]
+}
+
+// effectiveJobs holds the jobs with exceptions applied. It is shared by
+// the LED update and the console; the cooperative scheduler means neither
+// is interrupted while filling it.
+var effectiveJobs [maxJobs + maxExceptions]BinJob
+
+// applyExceptions returns jobs with the exceptions applied in order, sorted
+// by date. Cancel and move act on the first job with the same date, bin
+// and premises (fetched or generated); exceptionMatched records whether one
+// was found. Moved and added jobs are flagged jobMoved and jobAdded.
+// Console exceptions apply to every premises.
+func applyExceptions(jobs []BinJob) []BinJob {
+	n := copy(effectiveJobs[:], jobs)
+	for i := 0; i < exceptionCount; i++ {
+		ex := &scheduleExceptions[i]
+		exPremises := ex.premises()
+		exceptionMatched[i] = ex.Action == ExceptionAdd
+		for p := 0; p < numPremises(); p++ {
+			if exPremises != allPremises && p != exPremises {
+				continue
+			}
+			idx := -1
+			for j := 0; j < n; j++ {
+				job := &effectiveJobs[j]
+				if job.Year == ex.Year && job.Month == ex.Month && job.Day == ex.Day &&
+					job.Bin == ex.Bin && jobPremises(job) == p {
+					idx = j
+					break
+				}
+			}
+
+			switch ex.Action {
+			case ExceptionAdd:
+				if idx < 0 && n < len(effectiveJobs) {
+					job := &effectiveJobs[n]
+					*job = BinJob{Year: ex.Year, Month: ex.Month, Day: ex.Day, Bin: ex.Bin, Flags: jobAdded}
+					if numPremises() > 1 {
+						job.PremisesLen = uint8(copy(job.Premises[:], premisesID(p)))
+					} else {
+						job.Premises, job.PremisesLen = ex.Premises, ex.PremisesLen
+					}
+					n++
+				}
+			case ExceptionMove:
+				if idx >= 0 {
+					job := &effectiveJobs[idx]
+					job.Year, job.Month, job.Day = ex.ToYear, ex.ToMonth, ex.ToDay
+					job.Flags |= jobMoved
+					exceptionMatched[i] = true
+				}
+			case ExceptionCancel:
+				if idx >= 0 {
+					copy(effectiveJobs[idx:n], effectiveJobs[idx+1:n])
+					n--
+					exceptionMatched[i] = true
+				}
+			}
+		}
+	}
+	sortJobs(effectiveJobs[:n])
+	return effectiveJobs[:n]
+}
+
+// movedWindowOff returns when the window of job closes once the move
+// exceptions that apply to it are applied in order, so a collection moved
+// to a later date (e.g. after a bank holiday) is kept until then
+func movedWindowOff(job *BinJob) time.Time {
+	moved := *job
+	p := jobPremises(job)
+	for i := 0; i < exceptionCount; i++ {
+		ex := &scheduleExceptions[i]
+		if ex.Action != ExceptionMove || ex.Bin != moved.Bin ||
+			ex.Year != moved.Year || ex.Month != moved.Month || ex.Day != moved.Day {
+			continue
+		}
+		if exPremises := ex.premises(); exPremises == allPremises || exPremises == p {
+			moved.Year, moved.Month, moved.Day = ex.ToYear, ex.ToMonth, ex.ToDay
+		}
+	}
+	_, off := collectionWindow(&moved)
+	return off
+}
+
+// sortJobs sorts jobs in place (insertion sort: the input is nearly sorted)
+func sortJobs(jobs []BinJob) {
+	for i := 1; i < len(jobs); i++ {
+		for j := i; j > 0 && compareJobs(&jobs[j-1], &jobs[j]) > 0; j-- {
+			jobs[j-1], jobs[j] = jobs[j], jobs[j-1]
+		}
+	}
+}
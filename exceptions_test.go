@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+func TestApplyExceptions(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+	parseScheduleResponse([]byte(`{"v":2,"ts":1,"exceptions":[` +
+		`{"action":"move","date":"2026-01-31","bin":"GREEN","to":"2026-02-01"},` +
+		`{"action":"cancel","date":"2026-01-17","bin":"BLACK"},` +
+		`{"action":"add","date":"2026-02-20","bin":"BROWN"},` +
+		`{"action":"cancel","date":"2026-03-01","bin":"BLACK"}],` +
+		`"jobs":[{"date":"2026-01-17","bin":"BLACK"},{"date":"2026-01-31","bin":"GREEN"},` +
+		`{"date":"2026-02-14","bin":"BLACK"}]}`))
+
+	// Generated jobs are covered too
+	job := BinJob{Year: 2026, Month: 2, Day: 28, Bin: BinGreen, Flags: jobGenerated}
+	insertJob(&job)
+	moveGenerated := ScheduleException{Action: ExceptionMove, Bin: BinGreen, Manual: true,
+		Year: 2026, Month: 2, Day: 28, ToYear: 2026, ToMonth: 1, ToDay: 30}
+	if err := addException(&moveGenerated); err != nil {
+		t.Fatalf("addException: %v", err)
+	}
+
+	expected := []struct {
+		job   string
+		flags uint8
+	}{
+		{"2026-01-30 green", jobGenerated | jobMoved},
+		{"2026-02-01 green", jobMoved},
+		{"2026-02-14 black", 0},
+		{"2026-02-20 brown", jobAdded},
+	}
+	jobs := applyExceptions(getJobs())
+	if len(jobs) != len(expected) {
+		t.Fatalf("len(applyExceptions()) = %d, want %d", len(jobs), len(expected))
+	}
+	for i, want := range expected {
+		if got := jobString(&jobs[i]); got != want.job || jobs[i].Flags != want.flags {
+			t.Errorf("job[%d] = %q flags %b, want %q flags %b", i, got, jobs[i].Flags, want.job, want.flags)
+		}
+	}
+	for i, want := range []bool{true, true, true, false, true} {
+		if exceptionMatched[i] != want {
+			t.Errorf("exceptionMatched[%d] = %v, want %v", i, exceptionMatched[i], want)
+		}
+	}
+
+	// The store itself is unchanged
+	if len(getJobs()) != 4 {
+		t.Errorf("len(getJobs()) = %d, want 4", len(getJobs()))
+	}
+}
+
+func TestPayloadReplacesOwnExceptions(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+
+	manual, err := parseExceptionCommand([]byte("cancel 2026-12-25 black"))
+	if err != nil {
+		t.Fatalf("parseExceptionCommand: %v", err)
+	}
+	addException(&manual)
+	parseScheduleResponse([]byte(`{"v":2,"jobs":[{"date":"2026-12-25","bin":"BLACK"}],` +
+		`"exceptions":[{"action":"add","date":"2026-12-27","bin":"GREEN"}]}`))
+	if len(getExceptions()) != 2 {
+		t.Fatalf("len(getExceptions()) = %d, want 2", len(getExceptions()))
+	}
+
+	// Exceptions-only payload: replaces the payload exception, keeps jobs
+	res := parseScheduleResponse([]byte(`{"v":2,"exceptions":[` +
+		`{"action":"move","date":"2026-12-25","bin":"BLACK","to":"2026-12-28"}]}`))
+	if res.Err != nil || res.Jobs != 0 {
+		t.Fatalf("Err = %v, Jobs = %d, want no error and no jobs", res.Err, res.Jobs)
+	}
+	if len(getJobs()) != 1 {
+		t.Errorf("len(getJobs()) = %d, want cached 1", len(getJobs()))
+	}
+	exceptions := getExceptions()
+	if len(exceptions) != 2 || !exceptions[0].Manual || exceptions[1].Action != ExceptionMove {
+		t.Errorf("exceptions = %+v, want manual cancel then payload move", exceptions)
+	}
+
+	// CSV payloads carry no exceptions and leave them alone
+	parseScheduleResponse([]byte("1,2026-12-25:BLACK"))
+	if len(getExceptions()) != 2 {
+		t.Errorf("len(getExceptions()) after CSV = %d, want 2", len(getExceptions()))
+	}
+}
+
+func TestMovedJobSurvivesPruning(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+	defer clearJobs()
+	logger := slog.New(slog.DiscardHandler)
+
+	// Christmas collection moved to the Sunday after
+	parseScheduleResponse([]byte("1,2025-12-25:GREEN"))
+	move, _ := parseExceptionCommand([]byte("move 2025-12-25 green 2025-12-27"))
+	addException(&move)
+
+	boxingDay := time.Date(2025, 12, 26, 18, 0, 0, 0, time.UTC)
+	maintainSchedule(logger, boxingDay)
+	updateLEDsFromSchedule(getJobs(), boxingDay)
+	if len(getJobs()) != 1 || !ledState[0][BinGreen] {
+		t.Errorf("after maintainSchedule: %d jobs, green LED %v; want the moved job lit", len(getJobs()), ledState[0][BinGreen])
+	}
+
+	// Pruned once the moved window closes
+	after := time.Date(2025, 12, 27, 13, 0, 0, 0, time.UTC)
+	maintainSchedule(logger, after)
+	updateLEDsFromSchedule(getJobs(), after)
+	if len(getJobs()) != 0 || ledState[0][BinGreen] {
+		t.Errorf("after the moved window: %d jobs, green LED %v; want none", len(getJobs()), ledState[0][BinGreen])
+	}
+}
+
+func TestMovedGeneratedJob(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+	clearJobs()
+	defer clearJobs()
+	bins := []config.Bin{{Name: "green", Channel: 2, Window: config.DefaultWindow,
+		Recur: config.Recurrence{Every: 14, Anchor: time.Date(2025, 12, 11, 0, 0, 0, 0, time.UTC)}}}
+	if err := loadBinRegistry(bins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	// The rule's 2025-12-25 collection is generated while it is moved
+	move, _ := parseExceptionCommand([]byte("move 2025-12-25 green 2025-12-27"))
+	addException(&move)
+	boxingDay := time.Date(2025, 12, 26, 18, 0, 0, 0, time.UTC)
+	maintainSchedule(slog.New(slog.DiscardHandler), boxingDay)
+	updateLEDsFromSchedule(getJobs(), boxingDay)
+	if !ledState[0][BinGreen] {
+		t.Error("green LED off, want the moved generated collection lit")
+	}
+}
+
+func TestParseExceptionCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		want  ScheduleException
+		err   error
+	}{
+		{"cancel 2026-12-25 black", ScheduleException{Action: ExceptionCancel, Bin: BinBlack, Manual: true, Year: 2026, Month: 12, Day: 25}, nil},
+		{"  add 2026-12-27   GREEN ", ScheduleException{Action: ExceptionAdd, Bin: BinGreen, Manual: true, Year: 2026, Month: 12, Day: 27}, nil},
+		{"move 2026-12-25 brown 2026-12-28", ScheduleException{Action: ExceptionMove, Bin: BinBrown, Manual: true,
+			Year: 2026, Month: 12, Day: 25, ToYear: 2026, ToMonth: 12, ToDay: 28}, nil},
+		{"move 2026-12-25 brown", ScheduleException{}, errExceptionSyntax},
+		{"cancel 2026-12-25 brown 2026-12-28", ScheduleException{}, errExceptionSyntax},
+		{"swap 2026-12-25 brown", ScheduleException{}, errExceptionSyntax},
+		{"cancel 2026-12-25", ScheduleException{}, errExceptionSyntax},
+		{"cancel 2026-02-30 black", ScheduleException{}, errExceptionDate},
+		{"move 2026-12-25 black 28/12", ScheduleException{}, errExceptionDate},
+		{"cancel 2026-12-25 blue", ScheduleException{}, errExceptionBin},
+	}
+
+	for _, tc := range tests {
+		got, err := parseExceptionCommand([]byte(tc.input))
+		if err != tc.err {
+			t.Errorf("parseExceptionCommand(%q) err = %v, want %v", tc.input, err, tc.err)
+			continue
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("parseExceptionCommand(%q) = %+v, want %+v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestAddExceptionFull(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+
+	for i := 0; i < maxExceptions; i++ {
+		ex := ScheduleException{Action: ExceptionCancel, Bin: BinBlack, Manual: true, Year: 2026, Month: 1, Day: uint8(i + 1)}
+		if err := addException(&ex); err != nil {
+			t.Fatalf("addException(%d): %v", i, err)
+		}
+	}
+
+	// Same collection replaces, a new one does not fit
+	replace := ScheduleException{Action: ExceptionMove, Bin: BinBlack, Manual: true, Year: 2026, Month: 1, Day: 1, ToYear: 2026, ToMonth: 1, ToDay: 2}
+	if err := addException(&replace); err != nil {
+		t.Errorf("addException(replace) = %v", err)
+	}
+	extra := ScheduleException{Action: ExceptionCancel, Bin: BinBlack, Manual: true, Year: 2026, Month: 2, Day: 1}
+	if err := addException(&extra); err != errExceptionFull {
+		t.Errorf("addException(extra) = %v, want %v", err, errExceptionFull)
+	}
+	if getExceptions()[0].Action != ExceptionMove {
+		t.Errorf("exception[0] = %+v, want replaced by move", getExceptions()[0])
+	}
+}
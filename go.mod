@@ -6,6 +6,7 @@ require (
 	github.com/soypat/cyw43439 v0.0.0-20260112220010-064a839f63bb
 	github.com/soypat/lneto v0.0.0-20260118173607-6eaf04c4fdac
 	github.com/soypat/natiu-mqtt v0.6.0
+	golang.org/x/term v0.39.0
 )
 
 require (
@@ -13,5 +14,4 @@ require (
 	github.com/tinygo-org/pio v0.2.0 // indirect
 	golang.org/x/exp v0.0.0-20240808152545-0cdaa3abc0fa // indirect
 	golang.org/x/sys v0.40.0 // indirect
-	golang.org/x/term v0.39.0 // indirect
 )
@@ -0,0 +1,55 @@
+package main
+
+import "openenterprise/bindicator/config"
+
+// Indicator is the output of one bin LED. setLED drives it with the
+// logical state; the implementation takes care of the wiring (polarity,
+// dimming), so the LED logic runs unchanged on the host with a mock.
+type Indicator interface {
+	Set(on bool)
+}
+
+// ledOutput is how the bin LEDs are driven (led_output.text)
+var ledOutput = config.DefaultLEDOutput
+
+// pinOut is a digital output, such as a machine.Pin
+type pinOut interface {
+	Set(high bool)
+}
+
+// gpioIndicator switches an LED on and off with its GPIO pin
+type gpioIndicator struct {
+	pin       pinOut
+	activeLow bool // LED wired to 3V3: lit while the pin is low
+}
+
+func (g gpioIndicator) Set(on bool) {
+	g.pin.Set(on != g.activeLow)
+}
+
+// pwmOut is a PWM slice, such as machine.PWM0
+type pwmOut interface {
+	Top() uint32
+	Set(channel uint8, value uint32)
+}
+
+// pwmIndicator dims an LED with a PWM channel. Active-low LEDs are lit
+// while the output is low, so their duty cycle is inverted.
+type pwmIndicator struct {
+	pwm        pwmOut
+	channel    uint8
+	brightness uint8 // Percent while lit
+	activeLow  bool
+}
+
+func (p pwmIndicator) Set(on bool) {
+	top := p.pwm.Top()
+	duty := uint32(0)
+	if on {
+		duty = uint32(uint64(top) * uint64(p.brightness) / 100)
+	}
+	if p.activeLow {
+		duty = top - duty
+	}
+	p.pwm.Set(p.channel, duty)
+}
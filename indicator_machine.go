@@ -0,0 +1,60 @@
+//go:build tinygo
+
+package main
+
+import "machine"
+
+// pwmPeriod keeps PWM-dimmed LEDs well clear of visible flicker
+const pwmPeriod = 1e9 / 1000 // ns, 1 kHz
+
+// pwmSlice is a PWM slice of the RP2350 (machine.PWM0 ... machine.PWM7)
+type pwmSlice interface {
+	pwmOut
+	Configure(config machine.PWMConfig) error
+	Channel(pin machine.Pin) (uint8, error)
+}
+
+var pwmSlices = [...]pwmSlice{
+	machine.PWM0, machine.PWM1, machine.PWM2, machine.PWM3,
+	machine.PWM4, machine.PWM5, machine.PWM6, machine.PWM7,
+}
+
+// pwmConfigured marks the slices already set up; both channels of a
+// slice share its period
+var pwmConfigured [len(pwmSlices)]bool
+
+// openLED returns the output of the LED on pin as ledOutput says. Every
+// RP2350 GPIO has a PWM channel; a pin that cannot be dimmed anyway falls
+// back to switching it.
+func openLED(pin uint8) Indicator {
+	p := machine.Pin(pin)
+	if ledOutput.PWM {
+		if led, err := openPWM(p); err == nil {
+			return led
+		}
+	}
+	p.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	return gpioIndicator{pin: p, activeLow: ledOutput.ActiveLow}
+}
+
+func openPWM(pin machine.Pin) (Indicator, error) {
+	slice, err := machine.PWMPeripheral(pin)
+	if err != nil {
+		return nil, err
+	}
+	if int(slice) >= len(pwmSlices) {
+		return nil, machine.ErrInvalidOutputPin
+	}
+	pwm := pwmSlices[slice]
+	if !pwmConfigured[slice] {
+		if err := pwm.Configure(machine.PWMConfig{Period: pwmPeriod}); err != nil {
+			return nil, err
+		}
+		pwmConfigured[slice] = true
+	}
+	channel, err := pwm.Channel(pin)
+	if err != nil {
+		return nil, err
+	}
+	return pwmIndicator{pwm: pwm, channel: channel, brightness: ledOutput.Brightness, activeLow: ledOutput.ActiveLow}, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// mockIndicator records the transitions of an LED
+type mockIndicator struct {
+	pin     uint8
+	on      bool
+	changes []bool // Each state it changed to, in order
+}
+
+func (m *mockIndicator) Set(on bool) {
+	if on != m.on {
+		m.changes = append(m.changes, on)
+	}
+	m.on = on
+}
+
+// useMockLEDs opens every LED of the current premises on a mockIndicator
+// for the rest of the test; the mocks are returned by pin
+func useMockLEDs(t *testing.T) map[uint8]*mockIndicator {
+	t.Helper()
+	mocks := make(map[uint8]*mockIndicator)
+	initLEDs(func(pin uint8) Indicator {
+		m := &mockIndicator{pin: pin, on: true} // Unknown state until set
+		mocks[pin] = m
+		return m
+	})
+	for _, m := range mocks {
+		m.changes = nil
+	}
+	t.Cleanup(func() {
+		leds = [maxPremises][maxBins + 1]Indicator{}
+		ledState = [maxPremises][maxBins + 1]bool{}
+	})
+	return mocks
+}
+
+func TestInitLEDs(t *testing.T) {
+	useTestPremises(t)
+	mocks := useMockLEDs(t)
+
+	// Office on the bin channels, parents on their own pins, all off
+	for _, pin := range []uint8{2, 3, 4, 6, 7, 8} {
+		if m, ok := mocks[pin]; !ok || m.on {
+			t.Errorf("pin %d: opened %v, on %v; want opened and off", pin, ok, m != nil && m.on)
+		}
+	}
+	if len(mocks) != 6 {
+		t.Errorf("opened %d pins, want 6", len(mocks))
+	}
+
+	setLED(1, BinBrown, true)
+	if !mocks[8].on || mocks[4].on {
+		t.Error("setLED(parents, brown) did not light pin 8 alone")
+	}
+	setLED(2, BinBrown, true) // No such premises
+	setLED(0, BinUnknown, true)
+	if mocks[4].on {
+		t.Error("setLED() out of range changed an LED")
+	}
+}
+
+func TestLEDTransitions(t *testing.T) {
+	useTestPremises(t)
+	mocks := useMockLEDs(t)
+	parsePremisesResponse([]byte("1,2026-01-20:BLACK"), 0)
+
+	// Step through three days at the wake interval, noting when the office
+	// black LED (pin 3) changes
+	var at []time.Time
+	for now := time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC); now.Before(time.Date(2026, 1, 21, 0, 0, 0, 0, time.UTC)); now = now.Add(15 * time.Minute) {
+		n := len(mocks[3].changes)
+		updateLEDsFromSchedule(getJobs(), now)
+		if len(mocks[3].changes) != n {
+			at = append(at, now)
+		}
+	}
+
+	// Lit from noon the day before collection until noon on the day; the
+	// window is open strictly after noon, so the first wake after it
+	want := []time.Time{
+		time.Date(2026, 1, 19, 12, 15, 0, 0, time.UTC),
+		time.Date(2026, 1, 20, 12, 0, 0, 0, time.UTC),
+	}
+	if len(at) != len(want) || !at[0].Equal(want[0]) || !at[1].Equal(want[1]) {
+		t.Errorf("black LED changed at %v, want %v", at, want)
+	}
+	if c := mocks[3].changes; len(c) != 2 || !c[0] || c[1] {
+		t.Errorf("black LED changes = %v, want [true false]", c)
+	}
+	for pin, m := range mocks {
+		if pin != 3 && len(m.changes) != 0 {
+			t.Errorf("pin %d changed %v, want no change", pin, m.changes)
+		}
+	}
+}
+
+func TestLEDsPaused(t *testing.T) {
+	mocks := useMockLEDs(t)
+	job := []BinJob{{Year: 2026, Month: 1, Day: 20, Bin: BinGreen}}
+
+	SetBindicatorPaused(true)
+	defer SetBindicatorPaused(false)
+	updateLEDsFromSchedule(job, time.Date(2026, 1, 20, 8, 0, 0, 0, time.UTC))
+	if mocks[2].on {
+		t.Error("green LED lit during OTA pause")
+	}
+	SetBindicatorPaused(false)
+	updateLEDsFromSchedule(job, time.Date(2026, 1, 20, 8, 0, 0, 0, time.UTC))
+	if !mocks[2].on {
+		t.Error("green LED off after the pause")
+	}
+}
+
+// mockPin records the level of a GPIO pin
+type mockPin struct{ high bool }
+
+func (p *mockPin) Set(high bool) { p.high = high }
+
+func TestGPIOIndicator(t *testing.T) {
+	for _, activeLow := range []bool{false, true} {
+		pin := &mockPin{}
+		led := gpioIndicator{pin: pin, activeLow: activeLow}
+		led.Set(true)
+		if pin.high == activeLow {
+			t.Errorf("activeLow %v: on drives the pin high=%v", activeLow, pin.high)
+		}
+		led.Set(false)
+		if pin.high != activeLow {
+			t.Errorf("activeLow %v: off drives the pin high=%v", activeLow, pin.high)
+		}
+	}
+}
+
+// mockPWM records the level of each channel of a PWM slice
+type mockPWM struct {
+	top   uint32
+	level [2]uint32
+}
+
+func (p *mockPWM) Top() uint32                     { return p.top }
+func (p *mockPWM) Set(channel uint8, value uint32) { p.level[channel] = value }
+
+func TestPWMIndicator(t *testing.T) {
+	tests := []struct {
+		brightness uint8
+		activeLow  bool
+		on, off    uint32
+	}{
+		{100, false, 62500, 0},
+		{40, false, 25000, 0},
+		{40, true, 37500, 62500},
+		{1, false, 625, 0},
+	}
+	for _, tc := range tests {
+		pwm := &mockPWM{top: 62500}
+		led := pwmIndicator{pwm: pwm, channel: 1, brightness: tc.brightness, activeLow: tc.activeLow}
+		led.Set(true)
+		on := pwm.level[1]
+		led.Set(false)
+		if on != tc.on || pwm.level[1] != tc.off || pwm.level[0] != 0 {
+			t.Errorf("brightness %d, activeLow %v: on %d, off %d; want %d, %d",
+				tc.brightness, tc.activeLow, on, pwm.level[1], tc.on, tc.off)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// BinJob represents a scheduled bin collection
+type BinJob struct {
+	Year        uint16
+	Month       uint8
+	Day         uint8
+	Bin         BinType
+	NoteLen     uint8
+	PremisesLen uint8
+	Flags       uint8                // Job flags (jobGenerated, ...)
+	Note        [maxNoteLen]byte     // Free-text note (v2 payload only)
+	Premises    [maxPremisesLen]byte // Premises ID (v2 payload only)
+}
+
+// BinJob flags
+const (
+	jobGenerated = 1 << iota // Generated from a recurrence rule, not fetched
+	jobMoved                 // Moved by an exception
+	jobAdded                 // Added by an exception
+)
+
+// Generated reports whether the job came from a recurrence rule
+func (j *BinJob) Generated() bool {
+	return j.Flags&jobGenerated != 0
+}
+
+// Job store limits. The store holds a year of fortnightly collections for
+// three bins with room to spare; jobs further out than jobHorizon are
+// pruned so a long payload cannot crowd out the near-term collections.
+const (
+	maxJobs    = 96
+	jobHorizon = 366 * 24 * time.Hour
+)
+
+// Pre-allocated storage for bin jobs (avoids heap allocation), kept sorted
+// by date, then bin, then premises
+var (
+	jobStorage [maxJobs]BinJob
+	jobCount   int
+)
+
+// getJobs returns the current job storage slice
+func getJobs() []BinJob {
+	return jobStorage[:jobCount]
+}
+
+// clearJobs resets the job count
+func clearJobs() {
+	jobCount = 0
+}
+
+// clearPremisesJobs removes the jobs of premises p (all jobs when there is
+// a single premises)
+func clearPremisesJobs(p int) {
+	n := 0
+	for i := 0; i < jobCount; i++ {
+		if jobPremises(&jobStorage[i]) != p {
+			if n != i {
+				jobStorage[n] = jobStorage[i]
+			}
+			n++
+		}
+	}
+	jobCount = n
+}
+
+// countPremisesJobs returns the number of stored jobs of premises p (all
+// jobs when there is a single premises)
+func countPremisesJobs(p int) int {
+	n := 0
+	for i := 0; i < jobCount; i++ {
+		if jobPremises(&jobStorage[i]) == p {
+			n++
+		}
+	}
+	return n
+}
+
+// compareJobs orders jobs by date, then bin, then premises ID. Returns
+// -1, 0 or +1; 0 means the jobs are duplicates.
+func compareJobs(a, b *BinJob) int {
+	switch {
+	case a.Year != b.Year:
+		return cmpInt(int(a.Year), int(b.Year))
+	case a.Month != b.Month:
+		return cmpInt(int(a.Month), int(b.Month))
+	case a.Day != b.Day:
+		return cmpInt(int(a.Day), int(b.Day))
+	case a.Bin != b.Bin:
+		return cmpInt(int(a.Bin), int(b.Bin))
+	}
+	pa, pb := a.Premises[:a.PremisesLen], b.Premises[:b.PremisesLen]
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] != pb[i] {
+			return cmpInt(int(pa[i]), int(pb[i]))
+		}
+	}
+	return cmpInt(len(pa), len(pb))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// insertJob copies job into the store in sorted order. A duplicate (same
+// date, bin and premises) is ignored and the stored copy returned. When the
+// store is full the latest job is dropped, which may be job itself, so the
+// store always keeps the earliest collections. Returns the stored job (nil
+// if job was dropped) and whether it was a duplicate or anything was dropped.
+func insertJob(job *BinJob) (stored *BinJob, duplicate, dropped bool) {
+	// Find the first job that sorts after job (the store is small, so a
+	// linear scan from the end is fine and is fastest for in-order input)
+	i := jobCount
+	for i > 0 {
+		c := compareJobs(&jobStorage[i-1], job)
+		if c == 0 {
+			return &jobStorage[i-1], true, false
+		}
+		if c < 0 {
+			break
+		}
+		i--
+	}
+
+	if jobCount == maxJobs {
+		if i == maxJobs {
+			return nil, false, true
+		}
+		jobCount-- // Evict the latest job
+		dropped = true
+	}
+	copy(jobStorage[i+1:jobCount+1], jobStorage[i:jobCount])
+	jobStorage[i] = *job
+	jobCount++
+	return &jobStorage[i], false, dropped
+}
+
+// pruneJobs removes jobs whose notification window has closed and jobs
+// more than jobHorizon ahead of now. A job moved by an exception is kept
+// until the window of its new date closes (see movedWindowOff). Does
+// nothing until the clock is set, since every job would look like it is in
+// the future. Returns the number of past and beyond-horizon jobs removed.
+func pruneJobs(now time.Time) (past, beyond int) {
+	if !clockIsSet(now) {
+		return 0, 0
+	}
+	horizon := now.Add(jobHorizon)
+	n := 0
+	for i := 0; i < jobCount; i++ {
+		job := &jobStorage[i]
+		if !now.Before(movedWindowOff(job)) {
+			past++
+			continue
+		}
+		if localDate(int(job.Year), time.Month(job.Month), int(job.Day), 0, 0).After(horizon) {
+			beyond++
+			continue
+		}
+		if n != i {
+			jobStorage[n] = *job
+		}
+		n++
+	}
+	jobCount = n
+	return past, beyond
+}
+
+// clockIsSet reports whether t comes from a set clock rather than the
+// time since boot
+func clockIsSet(t time.Time) bool {
+	return t.Year() >= 2024
+}
+
+// generatedJobs is the number of jobs generated from recurrence rules
+var generatedJobs int
+
+// maintainSchedule drops past and beyond-horizon jobs from the store and
+// fills in collections from the recurrence rules
+func maintainSchedule(logger *slog.Logger, now time.Time) {
+	past, beyond := pruneJobs(now)
+	if past > 0 || beyond > 0 {
+		logger.Info("schedule:pruned",
+			slog.Int("past", past),
+			slog.Int("beyond_horizon", beyond),
+			slog.Int("jobs", len(getJobs())),
+		)
+	}
+	generated := generateJobs(now)
+	if generated != generatedJobs {
+		logger.Info("schedule:generated",
+			slog.Int("generated", generated),
+			slog.Int("jobs", len(getJobs())),
+		)
+	}
+	generatedJobs = generated
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInsertJobPremises(t *testing.T) {
+	clearJobs()
+	a := BinJob{Year: 2026, Month: 1, Day: 17, Bin: BinBlack, Premises: [maxPremisesLen]byte{'2'}, PremisesLen: 1}
+	b := BinJob{Year: 2026, Month: 1, Day: 17, Bin: BinBlack, Premises: [maxPremisesLen]byte{'1'}, PremisesLen: 1}
+	c := BinJob{Year: 2026, Month: 1, Day: 17, Bin: BinBlack}
+
+	for _, job := range []*BinJob{&a, &b, &c} {
+		if _, dup, _ := insertJob(job); dup {
+			t.Errorf("insertJob(premises %q) duplicate = true", job.PremisesID())
+		}
+	}
+	if _, dup, _ := insertJob(&b); !dup {
+		t.Error("insertJob(same premises) duplicate = false, want true")
+	}
+
+	jobs := getJobs()
+	if len(jobs) != 3 {
+		t.Fatalf("len(jobs) = %d, want 3", len(jobs))
+	}
+	for i, want := range []string{"", "1", "2"} {
+		if got := jobs[i].PremisesID(); got != want {
+			t.Errorf("job[%d] premises = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestInsertJobFull(t *testing.T) {
+	clearJobs()
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxJobs; i++ {
+		d := start.AddDate(0, 0, i)
+		job := BinJob{Year: uint16(d.Year()), Month: uint8(d.Month()), Day: uint8(d.Day()), Bin: BinGreen}
+		if _, _, dropped := insertJob(&job); dropped {
+			t.Fatalf("insertJob(%d) dropped before store was full", i)
+		}
+	}
+
+	// Later than everything stored: the new job is dropped
+	late := BinJob{Year: 2027, Month: 12, Day: 1, Bin: BinGreen}
+	if stored, _, dropped := insertJob(&late); stored != nil || !dropped {
+		t.Errorf("insertJob(late) = %v, %v, want nil, true", stored, dropped)
+	}
+
+	// Earlier than everything stored: the latest job is evicted
+	early := BinJob{Year: 2026, Month: 2, Day: 1, Bin: BinGreen}
+	stored, _, dropped := insertJob(&early)
+	if stored == nil || !dropped {
+		t.Fatalf("insertJob(early) = %v, %v, want stored, true", stored, dropped)
+	}
+	jobs := getJobs()
+	if len(jobs) != maxJobs || jobs[0] != early {
+		t.Errorf("jobs[0] = %+v, want %+v", jobs[0], early)
+	}
+	last := start.AddDate(0, 0, maxJobs-2)
+	if j := jobs[maxJobs-1]; int(j.Day) != last.Day() || time.Month(j.Month) != last.Month() {
+		t.Errorf("last job = %d-%d-%d, want %s", j.Year, j.Month, j.Day, last.Format("2006-01-02"))
+	}
+}
+
+func TestPruneJobs(t *testing.T) {
+	fill := func() {
+		clearJobs()
+		for _, job := range []BinJob{
+			{Year: 2026, Month: 1, Day: 10, Bin: BinGreen}, // Past
+			{Year: 2026, Month: 1, Day: 20, Bin: BinBlack}, // Window open
+			{Year: 2026, Month: 1, Day: 27, Bin: BinGreen},
+			{Year: 2027, Month: 1, Day: 19, Bin: BinBrown}, // Inside horizon
+			{Year: 2027, Month: 3, Day: 1, Bin: BinBrown},  // Beyond horizon
+		} {
+			insertJob(&job)
+		}
+	}
+
+	fill()
+	past, beyond := pruneJobs(time.Date(2026, 1, 19, 18, 0, 0, 0, time.UTC))
+	if past != 1 || beyond != 1 {
+		t.Errorf("pruneJobs() = %d, %d, want 1, 1", past, beyond)
+	}
+	jobs := getJobs()
+	if len(jobs) != 3 || jobs[0].Day != 20 || jobs[2].Year != 2027 {
+		t.Errorf("jobs = %+v, want Jan 20, Jan 27, 2027-01-19", jobs)
+	}
+
+	// Time since boot (clock not set yet) must not drop anything
+	fill()
+	if past, beyond := pruneJobs(time.Unix(120, 0)); past != 0 || beyond != 0 || len(getJobs()) != 5 {
+		t.Errorf("pruneJobs(unset clock) = %d, %d with %d jobs, want 0, 0 with 5", past, beyond, len(getJobs()))
+	}
+}
@@ -5,6 +5,7 @@ package main
 // WARNING: default -scheduler=cores unsupported, compile with -scheduler=tasks set!
 
 import (
+	"context"
 	"log/slog"
 	"machine"
 	"net/netip"
@@ -18,7 +19,6 @@ import (
 	"openenterprise/bindicator/version"
 
 	"github.com/soypat/cyw43439"
-	"github.com/soypat/cyw43439/examples/cywnet"
 	"github.com/soypat/lneto/x/xnet"
 )
 
@@ -28,12 +28,14 @@ var (
 	scheduleRefreshInterval = 3 * time.Hour    // How often to fetch schedule from MQTT
 )
 
-// Global WiFi stack reference for shutdown
-var globalCyStack *cywnet.Stack
-
 const pollTime = 5 * time.Millisecond
 
-var requestedIP = [4]byte{192, 168, 1, 99}
+// Address setup of the WiFi interface (config.NetworkConfig) and the
+// gateway in use
+var (
+	netConfig   config.Network
+	gatewayAddr netip.Addr
+)
 
 // Channel for manual refresh requests from console
 var refreshChan = make(chan struct{}, 1)
@@ -41,13 +43,18 @@ var refreshChan = make(chan struct{}, 1)
 // Debug sleep override duration (0 = use default wakeInterval)
 var debugSleepDuration time.Duration
 
-// Functional watchdog state
+// Functional watchdog state (updated by the main loop only; other
+// goroutines report through refreshResults)
 var (
 	lastSuccessfulRefresh time.Time
 	consecutiveFailures   int
 	systemHealthy         = true // When false, stop feeding watchdog to trigger reset
 )
 
+// Refresh outcomes of the persistent session, applied by the main loop
+// (see reportRefresh)
+var refreshResults = make(chan bool, 4)
+
 // Schedule refresh tracking (separate from watchdog state)
 var lastScheduleFetch time.Time
 
@@ -61,7 +68,7 @@ var (
 	ntpSyncCount  int
 	ntpFailCount  int
 	ntpTimeOffset time.Duration  // Last known offset from NTP
-	dnsServers    []netip.Addr   // DNS servers from DHCP (for NTP lookups)
+	dnsServers    []netip.Addr   // DNS servers from DHCP or network.text (for NTP lookups)
 )
 
 // Functional watchdog thresholds
@@ -97,7 +104,10 @@ var wifiStats struct {
 	lastMQTTAttempt  time.Time // Last MQTT attempt
 	mqttSuccessCount int       // Total successful MQTT operations
 	mqttFailCount    int       // Total failed MQTT operations
-	reconnectCount   int       // Number of reconnects (future use)
+	reconnectCount   int       // Number of rejoins after a link loss
+	ssid             string    // Network in use
+	network          int       // Its index in config.WiFiNetworks
+	networks         int       // Number of networks configured
 }
 
 func main() {
@@ -113,24 +123,59 @@ func main() {
 	println("  Built:  ", version.BuildDate)
 	println("========================================")
 
+	// The WiFi credentials are embedded here rather than in config, which
+	// the CLI imports too. Settings changed on the device override the
+	// embedded config, so they are loaded before anything reads it.
+	config.SetWiFiCredentials(credentials.SSID(), credentials.Password())
+	configLoaded, configSkipped, configErr := restoreConfig()
+
+	// Load bin registry and configure LEDs before the boot blink
+	// (an invalid alias table leaves the registry without its aliases)
+	bins, binsErr := config.Bins()
+	aliases, aliasesErr := config.BinAliases()
+	if binsErr == nil {
+		if aliasesErr == nil {
+			var withAliases []config.Bin
+			if withAliases, aliasesErr = config.WithBinAliases(bins, aliases); aliasesErr == nil {
+				bins = withAliases
+			}
+		}
+		binsErr = loadBinRegistry(bins)
+	}
+	premises, premisesErr := config.PremisesList()
+	if premisesErr == nil {
+		premisesErr = loadPremises(premises)
+	}
+	if premisesErr != nil {
+		loadPremises(config.DefaultPremises) // LEDs on the bin channels
+	}
+	output, ledOutputErr := config.LEDOutputConfig()
+	if ledOutputErr == nil {
+		ledOutput = output
+	}
+	initLEDs(openLED)
+
+	// Select local timezone (invalid timezone.text keeps the default)
+	tzErr := setTimezone(config.Timezone())
+
 	// Show which partition we booted from
 	currentPart := ota.GetCurrentPartition()
 	if currentPart == ota.PartitionA {
 		println("OTA: booted from partition A")
 		// Blink 2 times slow (A)
 		for i := 0; i < 2; i++ {
-			setLED(BinGreen, true)
+			setLED(0, BinType(1), true)
 			time.Sleep(500 * time.Millisecond)
-			setLED(BinGreen, false)
+			setLED(0, BinType(1), false)
 			time.Sleep(500 * time.Millisecond)
 		}
 	} else {
 		println("OTA: booted from partition B")
 		// Blink 10 times fast (B)
 		for i := 0; i < 10; i++ {
-			setLED(BinGreen, true)
+			setLED(0, BinType(1), true)
 			time.Sleep(100 * time.Millisecond)
-			setLED(BinGreen, false)
+			setLED(0, BinType(1), false)
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
@@ -145,18 +190,19 @@ func main() {
 	// Setup application logger (debug level for our code)
 	// Uses telemetry.SlogHandler to bridge logs to both console and OpenTelemetry
 	logger := slog.New(telemetry.NewSlogHandler(machine.Serial, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
+		Level:       slog.LevelDebug,
+		ReplaceAttr: localLogTime,
 	}))
 
 	// Setup network stack logger (error+4 level to suppress all network noise)
-	// The cywnet library logs "packet dropped" at ERROR level which is normal for WiFi
+	// The cyw43439 driver logs "packet dropped" at ERROR level which is normal for WiFi
 	netLogger := slog.New(slog.NewTextHandler(machine.Serial, &slog.HandlerOptions{
-		Level: slog.Level(12), // Higher than ERROR(8) to suppress all network stack logging
+		Level:       slog.Level(12), // Higher than ERROR(8) to suppress all network stack logging
+		ReplaceAttr: localLogTime,
 	}))
 
 	// Initialize modules
 	bindicatorLogger = logger // Set logger for bindicator module
-	initLEDs()
 	initConsole()
 
 	// Configure watchdog for reliability (8 second timeout)
@@ -181,13 +227,131 @@ func main() {
 		slog.String("partition", bootPartition),
 	)
 
-	// Get MQTT broker address from config
-	brokerAddr, err := config.BrokerAddr()
+	// Report the settings restored from flash
+	if configErr != nil && configErr != errConfigEmpty {
+		logger.Warn("config:store-restore-failed", slog.String("err", configErr.Error()))
+	}
+	for _, o := range config.Overrides() {
+		logger.Info("config:override", slog.String("key", o.Key), slog.String("value", config.Redact(o.Key, o.Value)))
+	}
+	for _, o := range configSkipped {
+		logger.Warn("config:override-skipped", slog.String("key", o.Key), slog.String("value", config.Redact(o.Key, o.Value)))
+	}
+	if configLoaded > 0 {
+		logger.Info("config:store-restored", slog.Int("overrides", configLoaded))
+	}
+
+	// Report every problem in device.json and the .text files up front;
+	// each invalid value falls back as logged further down
+	configErrs := config.Validate()
+	for _, err := range configErrs {
+		logger.Error("config:invalid", slog.String("err", err.Error()))
+	}
+	if len(configErrs) > 0 {
+		logger.Warn("config:validated", slog.Int("errors", len(configErrs)))
+	}
+	consolePort, _ = config.ConsolePort()
+
+	// Get the MQTT brokers from config (tried in order) and credentials
+	brokers, err := config.Brokers()
 	if err != nil {
 		logger.Error("config:broker-invalid", slog.String("err", err.Error()))
 		fatalError("Invalid broker address - waiting for reset...")
 	}
-	logger.Info("config:broker", slog.String("addr", brokerAddr.String()))
+	for i := range brokers {
+		logger.Info("config:broker",
+			slog.String("addr", brokers[i].String()),
+			slog.Int("index", i),
+		)
+	}
+	setBrokers(brokers)
+	mqttUsername = []byte(trimSecret(credentials.MQTTUsername()))
+	mqttPassword = []byte(trimSecret(credentials.MQTTPassword()))
+	if len(mqttUsername) > 0 {
+		logger.Info("config:mqtt-auth", slog.String("username", string(mqttUsername)))
+	} else if len(mqttPassword) > 0 {
+		logger.Warn("config:mqtt-password-without-username")
+	}
+	setStatusTopics(config.ClientID())
+	topics, err := config.TopicTemplates()
+	if err != nil {
+		logger.Error("config:topics-invalid", slog.String("err", err.Error()))
+		topics = config.DefaultTopics()
+	}
+	setTopicTemplates(topics, config.ClientID())
+	logger.Info("config:topics",
+		slog.String("request", string(requestTopic(0))),
+		slog.String("response", string(responseTopic(0))),
+		slog.String("schedule", string(scheduleTopic(0))),
+		slog.Bool("nonceless", acceptNonceless),
+	)
+	if acceptNonceless && topics.Nonceless == config.NoncelessDefault {
+		// Any device's CSV or nonce-less answer on the shared topic is
+		// taken as this device's, until the bridge echoes nonces
+		logger.Warn("config:nonceless-accepted", slog.String("response", string(responseTopic(0))))
+	}
+
+	// Address setup (an invalid network.text falls back to plain DHCP)
+	if netConfig, err = config.NetworkConfig(); err != nil {
+		logger.Error("config:network-invalid", slog.String("err", err.Error()))
+		netConfig = config.Network{}
+	}
+	if netConfig.Static {
+		logger.Info("config:network",
+			slog.String("mode", "static"),
+			slog.String("addr", netConfig.Subnet.String()),
+			slog.String("gateway", netConfig.Gateway.String()),
+		)
+	} else if netConfig.Addr.IsValid() {
+		logger.Info("config:network", slog.String("mode", "dhcp"), slog.String("request", netConfig.Addr.String()))
+	}
+
+	// Report bin registry (invalid bins.text falls back to the defaults)
+	if binsErr != nil {
+		logger.Error("config:bins-invalid", slog.String("err", binsErr.Error()))
+	}
+	if aliasesErr != nil {
+		logger.Error("config:bin-aliases-invalid", slog.String("err", aliasesErr.Error()))
+	}
+	for bt := BinType(1); int(bt) <= numBins(); bt++ {
+		logger.Info("config:bin",
+			slog.String("name", bt.String()),
+			slog.Int("channel", int(bt.Channel())),
+			slog.String("window", bt.Window().String()),
+			slog.String("recur", bt.Recurrence().String()),
+		)
+	}
+	if premisesErr != nil {
+		logger.Error("config:premises-invalid", slog.String("err", premisesErr.Error()))
+	}
+	for p := 0; p < numPremises(); p++ {
+		logger.Info("config:premises",
+			slog.String("name", premisesName(p)),
+			slog.String("id", premisesID(p)),
+			slog.String("leds", premisesPins(p)),
+		)
+	}
+	if ledOutputErr != nil {
+		logger.Error("config:led-output-invalid", slog.String("err", ledOutputErr.Error()))
+	}
+	ledMode := "gpio"
+	if ledOutput.PWM {
+		ledMode = "pwm"
+	}
+	logger.Info("config:led-output",
+		slog.String("mode", ledMode),
+		slog.Int("brightness", int(ledOutput.Brightness)),
+		slog.Bool("active_low", ledOutput.ActiveLow),
+	)
+
+	// Report timezone
+	if tzErr != nil {
+		logger.Error("config:timezone-invalid", slog.String("err", tzErr.Error()))
+	}
+	logger.Info("config:timezone",
+		slog.String("zone", timezoneName()),
+		slog.String("local", localTime(time.Now()).Format("2006-01-02 15:04 MST")),
+	)
 
 	// Load timing configuration
 	wakeInterval = config.WakeInterval()
@@ -196,26 +360,84 @@ func main() {
 		slog.Duration("wake_interval", wakeInterval),
 		slog.Duration("schedule_refresh_interval", scheduleRefreshInterval),
 	)
+	if mqttKeepAlive, err = config.MQTTKeepAlive(); err != nil {
+		logger.Error("config:mqtt-keepalive-invalid", slog.String("err", err.Error()))
+	}
+	if mqttKeepAlive > 0 {
+		logger.Info("config:mqtt-session", slog.Duration("keepalive", mqttKeepAlive))
+	}
+
+	// Home Assistant discovery (switches need the persistent session to
+	// receive commands)
+	if ha, err := config.HomeAssistantConfig(); err != nil {
+		logger.Error("config:homeassistant-invalid", slog.String("err", err.Error()))
+	} else if ha.Enabled {
+		if ha.Switches && mqttKeepAlive == 0 {
+			logger.Warn("config:homeassistant-switches-need-session")
+			ha.Switches = false
+		}
+		setHomeAssistant(ha, config.ClientID())
+		updateDiscoveryExpiry()
+		logger.Info("config:homeassistant",
+			slog.String("prefix", ha.Prefix),
+			slog.Bool("switches", ha.Switches),
+			slog.Duration("expire_after", haExpireAfter),
+		)
+	}
+
+	// Remote commands are received by the persistent session only: a
+	// command secret without mqtt_keepalive is a configuration error
+	if commands.setSecret(credentials.CommandSecret()); commands.enabled() {
+		if mqttKeepAlive == 0 {
+			logger.Error("config:commands-need-session",
+				slog.String("missing", "mqtt_keepalive"),
+				slog.Bool("commands", false),
+			)
+			commands.setSecret("")
+		} else {
+			logger.Info("config:commands", slog.String("topic", string(commandTopic())))
+		}
+	}
+
+	// Restore the cached schedule from flash so the LEDs are right before the
+	// network comes up. The clock is only known this early after a watchdog
+	// or soft reset; after power loss the LEDs wait for NTP/MQTT time.
+	if hint, ok := restoreClockHint(); ok {
+		logger.Info("store:clock-hint", slog.String("time", localTime(hint).Format("2006-01-02 15:04 MST")))
+	}
+	if fetched, skipped, err := restoreSchedule(); err != nil {
+		if err == errStoreEmpty {
+			logger.Info("store:empty")
+		} else {
+			logger.Warn("store:restore-failed", slog.String("err", err.Error()))
+		}
+	} else {
+		scheduleFetched = fetched
+		logger.Info("store:restored",
+			slog.Int("jobs", len(getJobs())),
+			slog.Int("skipped", skipped),
+			slog.String("fetched", localTime(fetched).Format("2006-01-02 15:04 MST")),
+		)
+		if now := time.Now(); clockIsSet(now) {
+			maintainSchedule(logger, now)
+			updateLEDsFromSchedule(getJobs(), now)
+			logLEDState(logger)
+		}
+	}
 
-	// Initialize WiFi (use quieter logger for network stack)
+	// Join the network (use quieter logger for network stack). After
+	// repeated failures the setup access point is started instead.
 	devcfg := cyw43439.DefaultWifiConfig()
 	devcfg.Logger = netLogger
-	cystack, err := cywnet.NewConfiguredPicoWithStack(
-		credentials.SSID(),
-		credentials.Password(),
-		devcfg,
-		cywnet.StackConfig{
-			Hostname:    "bindicator",
-			MaxTCPPorts: 3, // MQTT + debug console + OTA
-		},
-	)
-	if err != nil {
-		logger.Error("wifi:setup-failed", slog.String("err", err.Error()))
-		fatalError("WiFi setup failed - waiting for reset...")
+	if err := wifi.init(devcfg); err != nil {
+		logger.Error("wifi:init-failed", slog.String("err", err.Error()))
+		fatalError("WiFi init failed - waiting for reset...")
 	}
-
-	// Store global reference for OTA shutdown
-	globalCyStack = cystack
+	go loopForeverStack() // Background network stack processing
+	if err := connectWiFi(logger); err != nil {
+		runProvisioning(logger, err) // Reboots
+	}
+	go watchLink(logger) // Asks the main loop to rejoin after a link loss
 
 	// Register WiFi shutdown callback for OTA (like Pico SDK's cyw43_arch_deinit)
 	ota.SetWiFiShutdown(func() {
@@ -225,27 +447,11 @@ func main() {
 		time.Sleep(100 * time.Millisecond) // Allow pending packets to drain
 	})
 
-	// Start background goroutine for network stack processing
-	go loopForeverStack(cystack)
-
-	// DHCP
-	dhcpResults, err := cystack.SetupWithDHCP(cywnet.DHCPConfig{
-				RequestedAddr: netip.AddrFrom4(requestedIP),
-	})
-	if err != nil {
-		logger.Error("dhcp:failed", slog.String("err", err.Error()))
-		fatalError("DHCP failed - waiting for reset...")
-	}
-	logger.Info("dhcp:complete", slog.String("addr", dhcpResults.AssignedAddr.String()))
-
 	// Track WiFi connection time
 	wifiStats.connectTime = time.Now()
 
-	// Store DNS servers for NTP lookups
-	dnsServers = dhcpResults.DNSServers
-
 	// Get network stack reference
-	stack := cystack.LnetoStack()
+	stack := &wifi.s
 
 	// Sync time via NTP before telemetry init (so telemetry has correct timestamps)
 	logger.Info("ntp:init", slog.String("server", config.NTPServer()))
@@ -270,6 +476,12 @@ func main() {
 	// Start debug console server
 	go consoleServer(stack, logger, refreshChan)
 
+	// Keep a persistent MQTT session if configured (otherwise the main loop
+	// fetches the schedule every scheduleRefreshInterval)
+	if mqttKeepAlive > 0 {
+		go runMQTTSession(stack, logger)
+	}
+
 	// Initialize OTA update server (starts disabled, enable via 'ota-enable' console command)
 	otaServerInit(stack, logger)
 
@@ -316,88 +528,15 @@ func main() {
 
 			feedWatchdogIfHealthy()
 
-			// MQTT retry with exponential backoff: 16s -> 32s -> 60s (max)
-			const (
-				mqttMinBackoff = 16 * time.Second
-				mqttMaxBackoff = 60 * time.Second
-				mqttMaxRetries = 3
-			)
-			var mqttSuccess bool
-			mqttBackoff := mqttMinBackoff
-
-			// Start child span for MQTT refresh (covers all retries)
-			mqttSpanIdx := telemetry.StartSpan(stack, "mqtt-refresh")
-
-			for attempt := 0; attempt <= mqttMaxRetries; attempt++ {
-				// Track MQTT attempt
-				wifiStats.lastMQTTAttempt = time.Now()
-
-				if attempt > 0 {
-					logger.Info("mqtt:backoff",
-						slog.Int("attempt", attempt+1),
-						slog.Duration("wait", mqttBackoff),
-					)
-					sleepWithWatchdog(mqttBackoff)
-					// Exponential backoff, capped at max
-					mqttBackoff = mqttBackoff * 2
-					if mqttBackoff > mqttMaxBackoff {
-						mqttBackoff = mqttMaxBackoff
-					}
-				}
-
-				feedWatchdogIfHealthy()
-				logger.Info("schedule:fetching", slog.Int("attempt", attempt+1))
-
-				// Fetch schedule via MQTT
-				jobs, err := fetchScheduleViaMQTT(stack, brokerAddr, logger)
-				if err != nil {
-					logger.Error("mqtt:failed",
-						slog.String("err", err.Error()),
-						slog.Int("attempt", attempt+1),
-					)
-					wifiStats.mqttFailCount++
-
-					// If more retries available, continue; otherwise fail
-					if attempt < mqttMaxRetries {
-						continue
-					}
-
-					// All retries exhausted
-					telemetry.SetSpanStatus(mqttSpanIdx, err.Error())
-					telemetry.EndSpan(mqttSpanIdx, false)
-					consecutiveFailures++
-					logger.Warn("watchdog:failure-count",
-						slog.Int("consecutive", consecutiveFailures),
-						slog.Int("max", maxConsecutiveFailures),
-					)
-					logger.Info("schedule:using-cached",
-						slog.Int("cached_jobs", len(getJobs())),
-					)
-					checkSystemHealth(logger)
-				} else {
-					// Success
-					telemetry.SetSpanStatus(mqttSpanIdx, formatJobCount(len(jobs)))
-					telemetry.EndSpan(mqttSpanIdx, true)
-					wifiStats.lastMQTTSuccess = time.Now()
-					wifiStats.mqttSuccessCount++
-					lastScheduleFetch = time.Now()
-
-					// Record metrics
-					telemetry.RecordCounter("mqtt.success.count", int64(wifiStats.mqttSuccessCount))
-					telemetry.RecordCounter("mqtt.fail.count", int64(wifiStats.mqttFailCount))
-
-					// Success - reset failure count and update timestamp
-					consecutiveFailures = 0
-					lastSuccessfulRefresh = time.Now()
-					logger.Info("schedule:fetched",
-						slog.Int("jobs", len(jobs)),
-						slog.String("time", lastSuccessfulRefresh.Format("15:04:05")),
-					)
-					mqttSuccess = true
-					break // Exit retry loop on success
-				}
+			if mqttKeepAlive > 0 {
+				// The persistent session applies schedules as the bridge
+				// pushes them; ask it to publish them again
+				requestSessionRefresh()
+				applyRefreshResults(logger)
+				checkSystemHealth(logger)
+			} else {
+				refreshScheduleViaMQTT(stack, mqttBrokers, logger)
 			}
-			_ = mqttSuccess // Avoid unused variable warning
 		}
 
 		feedWatchdogIfHealthy()
@@ -405,19 +544,26 @@ func main() {
 		// Always process LEDs based on current schedule (cached or fresh)
 		// This ensures LEDs respond to 12-hour thresholds within wakeInterval
 		ledSpanIdx := telemetry.StartSpan(stack, "led-update")
-		jobs := getJobs()
 		now := time.Now()
+		maintainSchedule(logger, now)
+		jobs := getJobs()
 		logger.Info("leds:processing",
 			slog.Int("jobs", len(jobs)),
-			slog.String("time", now.Format("15:04:05")),
+			slog.String("time", localTime(now).Format("15:04:05")),
 		)
 		updateLEDsFromSchedule(jobs, now)
 		logLEDState(logger)
+		if mqttKeepAlive > 0 {
+			requestStatePublish()
+		}
 		telemetry.EndSpan(ledSpanIdx, true)
 
 		// End wake cycle span
 		telemetry.EndSpan(cycleSpanIdx, true)
 
+		// Leave the time for the next boot in case of a watchdog reset
+		saveClockHint(time.Now())
+
 		// Sleep until next cycle, but wake early on manual refresh request
 		logger.Info("sleep:starting",
 			slog.Duration("duration", wakeInterval),
@@ -428,6 +574,98 @@ func main() {
 	}
 }
 
+// refreshScheduleViaMQTT fetches the schedule with retries, saves it and
+// updates the watchdog state
+func refreshScheduleViaMQTT(stack *xnet.StackAsync, brokers []config.Broker, logger *slog.Logger) {
+	// MQTT retry with exponential backoff: 16s -> 32s -> 60s (max)
+	const (
+		mqttMinBackoff = 16 * time.Second
+		mqttMaxBackoff = 60 * time.Second
+		mqttMaxRetries = 3
+	)
+	mqttBackoff := mqttMinBackoff
+
+	// Start child span for MQTT refresh (covers all retries)
+	mqttSpanIdx := telemetry.StartSpan(stack, "mqtt-refresh")
+
+	for attempt := 0; attempt <= mqttMaxRetries; attempt++ {
+		// Track MQTT attempt
+		wifiStats.lastMQTTAttempt = time.Now()
+
+		if attempt > 0 {
+			logger.Info("mqtt:backoff",
+				slog.Int("attempt", attempt+1),
+				slog.Duration("wait", mqttBackoff),
+			)
+			sleepWithWatchdog(mqttBackoff)
+			// Exponential backoff, capped at max
+			mqttBackoff = mqttBackoff * 2
+			if mqttBackoff > mqttMaxBackoff {
+				mqttBackoff = mqttMaxBackoff
+			}
+		}
+
+		feedWatchdogIfHealthy()
+		logger.Info("schedule:fetching", slog.Int("attempt", attempt+1))
+
+		// Fetch schedule via MQTT
+		jobs, err := fetchScheduleViaMQTT(stack, brokers, logger)
+		if err != nil {
+			logger.Error("mqtt:failed",
+				slog.String("err", err.Error()),
+				slog.Int("attempt", attempt+1),
+			)
+			wifiStats.mqttFailCount++
+
+			// If more retries available, continue; otherwise fail
+			if attempt < mqttMaxRetries {
+				continue
+			}
+
+			// All retries exhausted
+			telemetry.SetSpanStatus(mqttSpanIdx, err.Error())
+			telemetry.EndSpan(mqttSpanIdx, false)
+			consecutiveFailures++
+			logger.Warn("watchdog:failure-count",
+				slog.Int("consecutive", consecutiveFailures),
+				slog.Int("max", maxConsecutiveFailures),
+			)
+			logger.Info("schedule:using-cached",
+				slog.Int("cached_jobs", len(getJobs())),
+			)
+			checkSystemHealth(logger)
+		} else {
+			// Success
+			telemetry.SetSpanStatus(mqttSpanIdx, formatJobCount(len(jobs)))
+			telemetry.EndSpan(mqttSpanIdx, true)
+			wifiStats.lastMQTTSuccess = time.Now()
+			wifiStats.mqttSuccessCount++
+			lastScheduleFetch = time.Now()
+			scheduleFetched = lastScheduleFetch
+
+			// Persist the schedule so it survives a reboot
+			if wrote, err := saveSchedule(lastScheduleFetch); err != nil {
+				logger.Error("store:save-failed", slog.String("err", err.Error()))
+			} else if wrote {
+				logger.Info("store:saved", slog.Int("jobs", len(jobs)))
+			}
+
+			// Record metrics
+			telemetry.RecordCounter("mqtt.success.count", int64(wifiStats.mqttSuccessCount))
+			telemetry.RecordCounter("mqtt.fail.count", int64(wifiStats.mqttFailCount))
+
+			// Success - reset failure count and update timestamp
+			consecutiveFailures = 0
+			lastSuccessfulRefresh = time.Now()
+			logger.Info("schedule:fetched",
+				slog.Int("jobs", len(jobs)),
+				slog.String("time", localTime(lastSuccessfulRefresh).Format("15:04:05")),
+			)
+			return
+		}
+	}
+}
+
 // sleepWithRefreshCheck sleeps for the given duration but wakes early on refresh request
 func sleepWithRefreshCheck(duration time.Duration, refreshChan chan struct{}, logger *slog.Logger) {
 	// Use debug override if set
@@ -450,12 +688,56 @@ func sleepWithRefreshCheck(duration time.Duration, refreshChan chan struct{}, lo
 			logger.Info("sleep:manual-refresh-triggered")
 			forceScheduleRefresh = true // Force schedule fetch on next cycle
 			return
+		case ok := <-refreshResults:
+			applyRefreshResult(ok, logger)
+		case <-rejoinChan:
+			rejoinWiFi(logger)
 		case <-time.After(checkInterval):
 			elapsed += checkInterval
 		}
 	}
 }
 
+// reportRefresh passes the outcome of a refresh done outside the main
+// loop (a schedule pushed to the persistent session, or a run of failed
+// connects) to the main loop. Does not block: with the queue full, the
+// outcome is dropped.
+func reportRefresh(ok bool) {
+	select {
+	case refreshResults <- ok:
+	default:
+	}
+}
+
+// applyRefreshResult updates the functional watchdog state for a refresh
+// reported by reportRefresh, like the end of refreshScheduleViaMQTT
+func applyRefreshResult(ok bool, logger *slog.Logger) {
+	if ok {
+		consecutiveFailures = 0
+		lastSuccessfulRefresh = time.Now()
+		return
+	}
+	consecutiveFailures++
+	logger.Warn("watchdog:failure-count",
+		slog.Int("consecutive", consecutiveFailures),
+		slog.Int("max", maxConsecutiveFailures),
+	)
+	checkSystemHealth(logger)
+}
+
+// applyRefreshResults applies the refresh outcomes reported since the main
+// loop last slept
+func applyRefreshResults(logger *slog.Logger) {
+	for {
+		select {
+		case ok := <-refreshResults:
+			applyRefreshResult(ok, logger)
+		default:
+			return
+		}
+	}
+}
+
 // feedWatchdogIfHealthy only feeds the watchdog if the system is healthy.
 // When unhealthy, the watchdog will timeout and reset the device.
 func feedWatchdogIfHealthy() {
@@ -473,6 +755,7 @@ func checkSystemHealth(logger *slog.Logger) {
 		logger.Error("watchdog:unhealthy",
 			slog.String("reason", "max consecutive failures"),
 			slog.Int("failures", consecutiveFailures),
+			slog.String("ssid", wifiStats.ssid),
 		)
 		systemHealthy = false
 		return
@@ -490,20 +773,130 @@ func checkSystemHealth(logger *slog.Logger) {
 	}
 }
 
-// logLEDState logs the current LED states
+// logLEDState logs the current LED states of each premises and records a
+// gauge per LED
 func logLEDState(logger *slog.Logger) {
-	logger.Info("leds:state",
-		slog.Bool("green", ledState.green),
-		slog.Bool("black", ledState.black),
-		slog.Bool("brown", ledState.brown),
-	)
+	for p := 0; p < numPremises(); p++ {
+		var attrs [maxBins + 1]slog.Attr
+		attrs[0] = slog.String("premises", premisesName(p))
+		n := 1
+		for bt := BinType(1); int(bt) <= numBins(); bt++ {
+			attrs[n] = slog.Bool(bt.String(), ledState[p][bt])
+			n++
+			var v int64
+			if ledState[p][bt] {
+				v = 1
+			}
+			telemetry.RecordGauge(premisesMetric(p, bt), v)
+		}
+		logger.LogAttrs(context.Background(), slog.LevelInfo, "leds:state", attrs[:n]...)
+	}
+}
+
+// connectWiFi joins the first configured network that accepts the device
+// and sets up its address (see joinWiFi). The networks are tried in order, in up to
+// wifiConnectAttempts rounds, before giving up.
+func connectWiFi(logger *slog.Logger) error {
+	networks, err := config.WiFiNetworks()
+	if err != nil {
+		return err
+	}
+	if len(networks) == 0 {
+		return errNoSSID
+	}
+
+	for attempt := 1; attempt <= wifiConnectAttempts; attempt++ {
+		if attempt > 1 {
+			sleepWithWatchdog(wifiConnectRetryWait)
+		}
+		for i := range networks {
+			if err = joinWiFi(networks[i], logger); err != nil {
+				logger.Error("wifi:setup-failed",
+					slog.String("ssid", networks[i].SSID),
+					slog.String("err", err.Error()),
+					slog.Int("attempt", attempt),
+				)
+				continue
+			}
+			wifiStats.ssid = networks[i].SSID
+			wifiStats.network = i
+			wifiStats.networks = len(networks)
+			telemetry.SetWiFiNetwork(networks[i].SSID)
+			logger.Info("wifi:connected", slog.String("ssid", networks[i].SSID), slog.Int("priority", i+1))
+			return nil
+		}
+	}
+	return err
+}
+
+// joinWiFi joins one network and sets up the address: the static one
+// from netConfig, or one from DHCP
+func joinWiFi(network config.WiFiNetwork, logger *slog.Logger) error {
+	if err := wifi.join(network); err != nil {
+		return err
+	}
+	return setupAddress(&wifi.s, logger)
+}
+
+// setupAddress installs the static address from netConfig, or one leased
+// over DHCP, and resolves the gateway's MAC address (the stack sends all
+// off-subnet traffic there)
+func setupAddress(stack *xnet.StackAsync, logger *slog.Logger) error {
+	rstack := stack.StackRetrying(pollTime)
+	results := &xnet.DHCPResults{
+		AssignedAddr: netConfig.Addr,
+		Subnet:       netConfig.Subnet,
+		Router:       netConfig.Gateway,
+		DNSServers:   netConfig.DNS,
+	}
+	if !netConfig.Static {
+		var err error
+		results, err = rstack.DoDHCPv4(netConfig.DHCPRequest().As4(), 3*time.Second, 3)
+		if err != nil {
+			logger.Error("dhcp:failed", slog.String("err", err.Error()))
+			return err
+		}
+	}
+	// Install the configured setup as if it had been leased
+	if err := stack.AssimilateDHCPResults(results); err != nil {
+		logger.Error("net:setup-failed", slog.String("err", err.Error()))
+		return err
+	}
+	if netConfig.Static {
+		logger.Info("net:static", slog.String("addr", netConfig.Addr.String()))
+	} else {
+		logger.Info("dhcp:complete", slog.String("addr", results.AssignedAddr.String()))
+		if netConfig.Addr.IsValid() && results.AssignedAddr != netConfig.Addr {
+			logger.Warn("dhcp:request-declined", slog.String("requested", netConfig.Addr.String()))
+		}
+	}
+
+	gatewayHW, err := rstack.DoResolveHardwareAddress6(results.Router, 500*time.Millisecond, 4)
+	if err != nil {
+		logger.Error("net:gateway-unresolved",
+			slog.String("gateway", results.Router.String()),
+			slog.String("err", err.Error()),
+		)
+		return err
+	}
+	stack.SetGateway6(gatewayHW)
+
+	// Store the gateway and DNS servers for the console and NTP lookups
+	gatewayAddr = results.Router
+	dnsServers = results.DNSServers
+	return nil
 }
 
-// loopForeverStack processes network packets in the background
-func loopForeverStack(stack *cywnet.Stack) {
+// loopForeverStack processes network packets of the WiFi stack in the
+// background
+func loopForeverStack() {
 	var count int
 	for {
-		send, recv, _ := stack.RecvAndSend()
+		if !wifi.up {
+			time.Sleep(pollTime) // Between connection attempts
+			continue
+		}
+		send, recv, _ := wifi.RecvAndSend()
 		if send == 0 && recv == 0 {
 			time.Sleep(pollTime)
 		}
@@ -599,7 +992,7 @@ func syncNTP(stack *xnet.StackAsync, dnsServers []netip.Addr, logger *slog.Logge
 			logger.Info("ntp:synced",
 				slog.String("server", ntpHost),
 				slog.String("addr", addr.String()),
-				slog.String("time", time.Now().Format("2006-01-02 15:04:05")),
+				slog.String("time", localTime(time.Now()).Format("2006-01-02 15:04:05 MST")),
 				slog.Duration("offset", offset),
 			)
 			return offset, nil
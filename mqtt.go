@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"openenterprise/bindicator/config"
+	"openenterprise/bindicator/telemetry"
 
 	"github.com/soypat/lneto/tcp"
 	"github.com/soypat/lneto/x/xnet"
@@ -25,47 +26,209 @@ const (
 	responseWaitMs = 5000 // Max wait for response in ms
 )
 
-// MQTT topics
-var (
-	topicRequest  = []byte("bindicator/request")
-	topicResponse = []byte("bindicator/response")
-)
-
 // Pre-allocated buffers for memory efficiency
 var (
 	tcpRxBuf    [tcpBufSize]byte
 	tcpTxBuf    [tcpBufSize]byte
 	mqttUserBuf [mqttBufSize]byte
 	responseBuf [mqttBufSize]byte
+	requestBuf  [48 + maxPremisesLen + nonceLen + maxTopicLen]byte
 	responseLen int
 	gotResponse bool
 
-	// Subscribe request variable (reused)
-	varSub = mqtt.VariablesSubscribe{
-		TopicFilters: []mqtt.SubscribeRequest{
-			{TopicFilter: topicResponse, QoS: mqtt.QoS0},
-		},
-	}
+	// Payload length of the last message, and whether it was parsed while
+	// it was read (too large for responseBuf, see receiveSchedule)
+	responseTotal  int
+	responseParsed bool
+
+	// Premises of the request waiting for a response (-1: none)
+	requestPending = -1
+
+	// Responses dropped because they echoed another request's nonce
+	responsesIgnored int
+
+	// Source of the last message: the premises of its schedule topic (-1
+	// for the response topic) and whether the broker replayed it from the
+	// retained store
+	responsePremises int
+	responseRetained bool
+
+	// MQTT CONNECT credentials (empty: anonymous)
+	mqttUsername []byte
+	mqttPassword []byte
+
+	// Brokers in the order they are tried (config broker, see setBrokers)
+	mqttBrokers []config.Broker
+
+	// Broker of the last successful connection: index in broker.text and
+	// address. Hostnames keep their last resolved address in case DNS fails.
+	activeBroker     int
+	activeBrokerAddr netip.AddrPort
+	brokerResolved   [config.MaxBrokers]netip.AddrPort
+
+	// Subscribe request variable (reused) and the response topics
+	varSub          mqtt.VariablesSubscribe
+	responseFilters [maxPremises]mqtt.SubscribeRequest
 )
 
 // MQTT publish flags (QoS0, not retained, not dup)
 var pubFlags, _ = mqtt.NewPublishFlags(mqtt.QoS0, false, false)
 
-// fetchScheduleViaMQTT connects to the MQTT broker, publishes a request,
-// waits for the response, and parses it into jobs. Time is synced from response.
+// fetchScheduleViaMQTT connects to the first reachable broker and fetches
+// the schedule of every premises (see requestPremisesSchedule). Succeeds if
+// at least one premises was fetched; the others keep their cached jobs.
 func fetchScheduleViaMQTT(
 	stack *xnet.StackAsync,
-	brokerAddr netip.AddrPort,
+	brokers []config.Broker,
 	logger *slog.Logger,
 ) ([]BinJob, error) {
+	var conn tcp.Conn
+	client, brokerAddr, err := dialBrokers(&conn, stack, brokers, 0, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Subscribe to the response topic (one per premises if the template
+	// has {premises})
+	for i := 0; i < numResponseTopics(); i++ {
+		responseFilters[i] = mqtt.SubscribeRequest{TopicFilter: responseTopic(i), QoS: mqtt.QoS0}
+	}
+	varSub.TopicFilters = responseFilters[:numResponseTopics()]
+	varSub.PacketIdentifier = uint16(stack.Prand32())
+	if err = subscribeMQTT(client, &conn, varSub, logger); err != nil {
+		closeConn(&conn, stack, brokerAddr)
+		return nil, err
+	}
+
+	// Handle subscription acknowledgment
+	for i := 0; i < 20; i++ {
+		time.Sleep(100 * time.Millisecond)
+		client.HandleNext()
+	}
+
+	// Request each premises' schedule in turn. There is one response
+	// buffer, so a request is only sent once the previous one was answered.
+	fetched := 0
+	for p := 0; p < numPremises(); p++ {
+		if err = requestPremisesSchedule(client, &conn, stack, p, logger); err != nil {
+			logger.Error("mqtt:premises-failed",
+				slog.String("premises", premisesName(p)),
+				slog.String("err", err.Error()),
+			)
+			continue
+		}
+		fetched++
+	}
+
+	// Drop collections that are over or beyond the horizon and fill in
+	// generated ones after the fetched schedule (needs the clock), then
+	// report the resulting LED states. The state is published even if no
+	// schedule came back, so dashboards see the failures.
+	if fetched > 0 {
+		maintainSchedule(logger, time.Now())
+		updateLEDsFromSchedule(getJobs(), time.Now())
+	}
+	publishDiscovery(client, &conn, stack, logger)
+	publishStatus(client, &conn, stack, logger)
+
+	// Disconnect cleanly (the availability stays "online": the device is
+	// fine, it just does not keep the connection). The Last Will does not
+	// fire, so Home Assistant expires the entities when the state stops
+	// coming (see haExpireAfter).
+	client.Disconnect(errors.New("session complete"))
+	closeConn(&conn, stack, brokerAddr)
+
+	if fetched == 0 {
+		return nil, err
+	}
+	return getJobs(), nil
+}
+
+// setBrokers replaces the broker list; it is used from the next connection.
+// Resolved addresses are dropped as the indexes may now be other brokers.
+func setBrokers(brokers []config.Broker) {
+	mqttBrokers = brokers
+	brokerResolved = [config.MaxBrokers]netip.AddrPort{}
+}
+
+// dialBrokers connects to the brokers in broker.text order and returns
+// the client of the first that accepts the connection, and its address
+// (for closeConn). Hostnames are resolved through the DHCP DNS servers.
+func dialBrokers(
+	conn *tcp.Conn,
+	stack *xnet.StackAsync,
+	brokers []config.Broker,
+	keepalive time.Duration,
+	logger *slog.Logger,
+) (*mqtt.Client, netip.AddrPort, error) {
+	var lastErr error
+	for i := range brokers {
+		if i > 0 {
+			logger.Warn("mqtt:broker-failover",
+				slog.String("broker", brokers[i].String()),
+				slog.Int("index", i),
+			)
+		}
+		feedWatchdogIfHealthy()
+		addr, err := resolveBroker(stack, i, brokers[i], logger)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		client, err := connectMQTT(conn, stack, addr, keepalive, logger)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		activeBroker, activeBrokerAddr = i, addr
+		return client, addr, nil
+	}
+	return nil, netip.AddrPort{}, lastErr
+}
+
+// resolveBroker returns the address of broker i. Hostnames are looked up
+// every time; if DNS fails the last resolved address is used.
+func resolveBroker(stack *xnet.StackAsync, i int, b config.Broker, logger *slog.Logger) (netip.AddrPort, error) {
+	if addr, ok := b.AddrPort(); ok {
+		return addr, nil
+	}
+	rstack := stack.StackRetrying(pollTime)
+	addrs, err := rstack.DoLookupIP(b.Host, 5*time.Second, 2)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses")
+	}
+	if err != nil {
+		logger.Warn("mqtt:dns-failed",
+			slog.String("broker", b.Host),
+			slog.String("err", err.Error()),
+		)
+		if brokerResolved[i].IsValid() {
+			return brokerResolved[i], nil
+		}
+		return netip.AddrPort{}, err
+	}
+	brokerResolved[i] = netip.AddrPortFrom(addrs[0], b.Port)
+	logger.Info("mqtt:dns-resolved",
+		slog.String("broker", b.Host),
+		slog.String("addr", brokerResolved[i].String()),
+	)
+	return brokerResolved[i], nil
+}
+
+// connectMQTT dials the broker and waits for the MQTT connection. A
+// keepalive of 0 keeps the library default. The connection is closed on
+// failure.
+func connectMQTT(
+	conn *tcp.Conn,
+	stack *xnet.StackAsync,
+	brokerAddr netip.AddrPort,
+	keepalive time.Duration,
+	logger *slog.Logger,
+) (*mqtt.Client, error) {
 	// Create retrying stack for dial with retries
 	rstack := stack.StackRetrying(5 * time.Millisecond)
-	// Reset response state
-	gotResponse = false
-	responseLen = 0
 
 	// Configure TCP connection with pre-allocated buffers
-	var conn tcp.Conn
 	err := conn.Configure(tcp.ConnConfig{
 		RxBuf:             tcpRxBuf[:],
 		TxBuf:             tcpTxBuf[:],
@@ -88,6 +251,14 @@ func fetchScheduleViaMQTT(
 	clientID = append(clientID, '-')
 	clientID = appendHex(clientID, uint16(stack.Prand32()))
 	varconn.SetDefaultMQTT(clientID)
+	if keepalive > 0 {
+		varconn.KeepAlive = uint16(keepalive / time.Second)
+	}
+	if len(mqttUsername) > 0 {
+		varconn.Username = mqttUsername
+		varconn.Password = mqttPassword
+	}
+	setWill(&varconn)
 	client := mqtt.NewClient(cfg)
 
 	// Random local port
@@ -99,20 +270,20 @@ func fetchScheduleViaMQTT(
 	)
 
 	// Dial TCP with retries
-	err = rstack.DoDialTCP(&conn, lport, brokerAddr, mqttTimeout, mqttRetries)
+	err = rstack.DoDialTCP(conn, lport, brokerAddr, mqttTimeout, mqttRetries)
 	if err != nil {
 		logger.Error("mqtt:dial-failed", slog.String("err", err.Error()))
-		closeConn(&conn, stack, brokerAddr)
+		closeConn(conn, stack, brokerAddr)
 		return nil, err
 	}
 
 	// Start MQTT connection
 	logger.Info("mqtt:connecting")
 	conn.SetDeadline(time.Now().Add(mqttTimeout))
-	err = client.StartConnect(&conn, &varconn)
+	err = client.StartConnect(conn, &varconn)
 	if err != nil {
 		logger.Error("mqtt:start-connect-failed", slog.String("err", err.Error()))
-		closeConn(&conn, stack, brokerAddr)
+		closeConn(conn, stack, brokerAddr)
 		return nil, err
 	}
 
@@ -128,93 +299,272 @@ func fetchScheduleViaMQTT(
 	}
 	if !client.IsConnected() {
 		logger.Error("mqtt:connect-timeout")
-		closeConn(&conn, stack, brokerAddr)
+		closeConn(conn, stack, brokerAddr)
 		return nil, errors.New("mqtt connect timeout")
 	}
 	logger.Info("mqtt:connected")
+	return client, nil
+}
 
-	// Subscribe to response topic using StartSubscribe (non-blocking, no context needed)
+// subscribeMQTT subscribes to the topics in sub using StartSubscribe
+// (non-blocking, no context needed). The acknowledgment is left to the
+// caller's HandleNext loop.
+func subscribeMQTT(client *mqtt.Client, conn *tcp.Conn, sub mqtt.VariablesSubscribe, logger *slog.Logger) error {
 	conn.SetDeadline(time.Now().Add(mqttTimeout))
-	varSub.PacketIdentifier = uint16(stack.Prand32())
-	err = client.StartSubscribe(varSub)
+	err := client.StartSubscribe(sub)
 	if err != nil {
 		logger.Error("mqtt:subscribe-failed", slog.String("err", err.Error()))
-		closeConn(&conn, stack, brokerAddr)
-		return nil, err
+		return err
 	}
-	logger.Info("mqtt:subscribed", slog.String("topic", string(topicResponse)))
+	for i := range sub.TopicFilters {
+		logger.Info("mqtt:subscribed", slog.String("topic", string(sub.TopicFilters[i].TopicFilter)))
+	}
+	return nil
+}
 
-	// Handle subscription acknowledgment
-	for i := 0; i < 20; i++ {
-		time.Sleep(100 * time.Millisecond)
-		client.HandleNext()
+// requestPremisesSchedule publishes a schedule request for premises p,
+// waits for the response and parses it into that premises' jobs. Time is
+// synced from the response. Responses that echo another request's nonce
+// are ignored while waiting.
+func requestPremisesSchedule(
+	client *mqtt.Client,
+	conn *tcp.Conn,
+	stack *xnet.StackAsync,
+	p int,
+	logger *slog.Logger,
+) error {
+	// Reset response state
+	gotResponse = false
+	responseLen = 0
+	ignored := responsesIgnored
+
+	if err := publishScheduleRequest(client, conn, stack, p, logger); err != nil {
+		return err
+	}
+	requestPending = p
+	defer func() { requestPending = -1 }()
+
+	// Wait for the response, and for the rest of a chunked schedule (the
+	// wait starts again after each chunk)
+	for {
+		waitTime := 0
+		for !gotResponse && waitTime < responseWaitMs {
+			time.Sleep(100 * time.Millisecond)
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			client.HandleNext()
+			waitTime += 100
+		}
+
+		if responsesIgnored != ignored {
+			logger.Warn("mqtt:response-ignored",
+				slog.String("reason", "nonce mismatch"),
+				slog.Int("count", responsesIgnored-ignored),
+			)
+			ignored = responsesIgnored
+		}
+		if !gotResponse {
+			if scheduleChunks.next != 0 {
+				abortIncompleteSchedule(logger)
+				return errors.New("chunked schedule incomplete")
+			}
+			logger.Error("mqtt:no-response")
+			return errors.New("no response from broker")
+		}
+
+		logger.Info("mqtt:response-received", slog.Int("bytes", responseTotal))
+		done, err := handleScheduleResponse(p, logger)
+		if err != nil {
+			abortIncompleteSchedule(logger)
+			return err
+		}
+		if done {
+			return nil
+		}
+		gotResponse = false
 	}
+}
 
+// abortIncompleteSchedule gives up on a chunked schedule whose remaining
+// chunks did not arrive (or did not parse), restoring the jobs and
+// exceptions it was replacing rather than keeping part of it
+func abortIncompleteSchedule(logger *slog.Logger) {
+	if scheduleChunks.next == 0 {
+		return
+	}
+	received, total := scheduleChunks.next-1, scheduleChunks.total
+	restored := abortChunks()
+	logger.Error("schedule:chunks-incomplete",
+		slog.Int("received", received),
+		slog.Int("chunks", total),
+		slog.Bool("restored", restored),
+	)
+}
+
+// publishScheduleRequest asks the bridge for the schedule of premises p
+// with a new nonce (see encodeScheduleRequest)
+func publishScheduleRequest(
+	client *mqtt.Client,
+	conn *tcp.Conn,
+	stack *xnet.StackAsync,
+	p int,
+	logger *slog.Logger,
+) error {
 	// Publish request
+	setRequestNonce(stack.Prand32())
+	n := encodeScheduleRequest(requestBuf[:], p)
 	conn.SetDeadline(time.Now().Add(mqttTimeout))
 	pubVar := mqtt.VariablesPublish{
-		TopicName:        topicRequest,
+		TopicName:        requestTopic(p),
 		PacketIdentifier: uint16(stack.Prand32()),
 	}
-	err = client.PublishPayload(pubFlags, pubVar, []byte("ping"))
+	err := client.PublishPayload(pubFlags, pubVar, requestBuf[:n])
 	if err != nil {
 		logger.Error("mqtt:publish-failed", slog.String("err", err.Error()))
-		closeConn(&conn, stack, brokerAddr)
-		return nil, err
+		return err
 	}
-	logger.Info("mqtt:published", slog.String("topic", string(topicRequest)))
+	logger.Info("mqtt:published",
+		slog.String("topic", string(requestTopic(p))),
+		slog.String("premises", premisesName(p)),
+		slog.String("nonce", string(requestNonce[:])),
+	)
+	return nil
+}
 
-	// Wait for response
-	waitTime := 0
-	for !gotResponse && waitTime < responseWaitMs {
-		time.Sleep(100 * time.Millisecond)
-		conn.SetDeadline(time.Now().Add(2 * time.Second))
-		client.HandleNext()
-		waitTime += 100
+// handleScheduleResponse parses the message in responseBuf into the jobs of
+// premises p (unless it was parsed while it was read) and syncs time from
+// its timestamp. A retained message may be hours old, so its timestamp is
+// not used for the clock. done is false while more chunks of the schedule
+// are to come.
+func handleScheduleResponse(p int, logger *slog.Logger) (done bool, err error) {
+	res := parseReceived(responseBuf[:responseLen], responseTotal, responseParsed, p)
+	if res.Err == errPayloadTooLarge {
+		logger.Error("mqtt:response-oversize",
+			slog.Int("bytes", responseTotal),
+			slog.Int("limit", len(responseBuf)),
+		)
 	}
-
-	// Disconnect cleanly
-	client.Disconnect(errors.New("session complete"))
-	closeConn(&conn, stack, brokerAddr)
-
-	if !gotResponse {
-		logger.Error("mqtt:no-response")
-		return nil, errors.New("no response from broker")
+	if res.Err != nil {
+		logger.Error("schedule:invalid",
+			slog.Int("version", int(res.Version)),
+			slog.String("err", res.Err.Error()),
+		)
+		return false, res.Err
+	}
+	if !res.Complete() {
+		logger.Info("schedule:chunk",
+			slog.String("premises", premisesName(p)),
+			slog.Int("chunk", int(res.Chunk)),
+			slog.Int("chunks", int(res.Chunks)),
+		)
+	} else {
+		logParseResult(logger, &res)
 	}
-
-	logger.Info("mqtt:response-received", slog.Int("bytes", responseLen))
-
-	// Parse the response
-	count := parseScheduleResponse(responseBuf[:responseLen])
-	logger.Info("mqtt:parsed", slog.Int("jobs", count))
 
 	// Sync time from Node-RED timestamp
-	if parsedTimestamp > 0 {
-		serverTime := time.Unix(parsedTimestamp, 0)
+	if res.Timestamp > 0 && !responseRetained {
+		serverTime := time.Unix(res.Timestamp, 0)
 		offset := serverTime.Sub(time.Now())
 		runtime.AdjustTimeOffset(int64(offset))
 		logger.Info("mqtt:time-synced",
-			slog.String("time", time.Now().Format("2006-01-02 15:04:05")),
+			slog.String("time", localTime(time.Now()).Format("2006-01-02 15:04:05 MST")),
 		)
 	}
+	return res.Complete(), nil
+}
 
-	return getJobs(), nil
+// Cumulative schedule parse diagnostics (for telemetry counters)
+var (
+	scheduleRejectedTotal int
+	scheduleDroppedTotal  int
+)
+
+// logParseResult logs a schedule parse result and records telemetry metrics.
+// Rejected entries are logged individually so a broken transform is obvious.
+func logParseResult(logger *slog.Logger, res *ParseResult) {
+	logger.Info("mqtt:parsed",
+		slog.String("premises", premisesName(res.Request)),
+		slog.Int("version", int(res.Version)),
+		slog.Int("jobs", res.Accepted()),
+		slog.Int("rejected", res.RejectedCount),
+		slog.Int("dropped", res.Dropped),
+		slog.Int64("ts", res.Timestamp),
+	)
+
+	rejected := res.RejectedEntries()
+	for i := range rejected {
+		logger.Warn("schedule:rejected",
+			slog.String("entry", rejected[i].String()),
+			slog.String("reason", rejected[i].Reason.String()),
+		)
+	}
+	if res.RejectedCount > len(rejected) {
+		logger.Warn("schedule:rejected-more", slog.Int("total", res.RejectedCount))
+	}
+	if res.Duplicates > 0 {
+		logger.Info("schedule:duplicates", slog.Int("count", res.Duplicates))
+	}
+	if res.Truncated {
+		logger.Warn("schedule:truncated",
+			slog.Int("dropped", res.Dropped),
+			slog.Int("max_jobs", maxJobs),
+		)
+	}
+	if res.Timestamp == 0 {
+		logger.Warn("schedule:no-timestamp")
+	}
+	if res.Version == payloadJSONv2 {
+		logger.Info("schedule:v2",
+			slog.Uint64("rev", uint64(res.Revision)),
+			slog.String("premises", res.PremisesID()),
+			slog.Int("exceptions", res.Exceptions),
+		)
+	}
+
+	scheduleRejectedTotal += res.RejectedCount
+	scheduleDroppedTotal += res.Dropped
+	telemetry.RecordGauge("schedule.jobs", int64(res.Accepted()))
+	telemetry.RecordCounter("schedule.rejected.count", int64(scheduleRejectedTotal))
+	telemetry.RecordCounter("schedule.dropped.count", int64(scheduleDroppedTotal))
 }
 
-// onMQTTMessage handles incoming MQTT messages
+// onMQTTMessage handles incoming MQTT messages on the response topic and,
+// in a persistent session, the schedule and command topics
 func onMQTTMessage(pubHead mqtt.Header, varPub mqtt.VariablesPublish, r io.Reader) error {
-	// Check if this is the response topic
-	if !bytesEqual(varPub.TopicName, topicResponse) {
+	if onCommandMessage(pubHead, varPub.TopicName, r) || onHomeAssistantMessage(varPub.TopicName, r) {
 		return nil
 	}
 
-	// Read the payload into response buffer
-	n, err := r.Read(responseBuf[:])
-	if err != nil && err != io.EOF {
+	p := -1 // Response to the pending request
+	if !isResponseTopic(varPub.TopicName) {
+		if p = scheduleTopicPremises(varPub.TopicName); p < 0 {
+			return nil
+		}
+	}
+
+	// Read the payload into response buffer. A CSV schedule too large for
+	// it is parsed as it is read, into the premises it answers, unless it
+	// would be ignored below for lacking a nonce.
+	target := p
+	if target < 0 {
+		target = requestPending
+	}
+	n, total, parsed, err := receiveSchedule(r, responseBuf[:], streamPremises(p, target))
+	if err != nil {
 		return err
 	}
 
+	// Another device's response on a shared topic (or one that arrived
+	// when no request was pending)
+	if p < 0 && (target < 0 || !responseNonceMatches(responseBuf[:n])) {
+		responsesIgnored++
+		return nil
+	}
+
 	responseLen = n
+	responseTotal = total
+	responseParsed = parsed
+	responsePremises = p
+	responseRetained = pubHead.Flags().Retain()
 	gotResponse = true
 	return nil
 }
@@ -0,0 +1,125 @@
+//go:build tinygo
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"time"
+
+	"openenterprise/bindicator/ota"
+
+	"github.com/soypat/lneto/tcp"
+	"github.com/soypat/lneto/x/xnet"
+	mqtt "github.com/soypat/natiu-mqtt"
+)
+
+// Remote command state (see command.go). A message on the command topic is
+// stored by onCommandMessage and executed by the session loop, outside the
+// MQTT callback, so commands can publish and block like console commands.
+var (
+	commandBuf      [commandBufSize]byte
+	commandLen      int
+	commandTotal    int // Payload length (over commandBufSize: discarded)
+	commandPending  bool
+	commandRetained bool
+	commandParsed   remoteCommand
+	commandOutput   [commandOutputSize]byte
+	commandReply    [commandReplySize]byte
+)
+
+// onCommandMessage takes a message on the command topic. A command that
+// arrives while another is pending is dropped. Reports whether the message
+// was for the command topic.
+func onCommandMessage(pubHead mqtt.Header, topic []byte, r io.Reader) bool {
+	if !commands.enabled() || !bytesEqual(topic, commandTopic()) {
+		return false
+	}
+	if commandPending {
+		return true
+	}
+	commandLen, commandTotal, _ = readMessage(r, commandBuf[:])
+	commandRetained = pubHead.Flags().Retain()
+	commandPending = true
+	return true
+}
+
+// handleRemoteCommand authenticates and runs the pending command, then
+// publishes the reply. Envelopes without an ID get no reply. Only publish
+// errors are returned.
+func handleRemoteCommand(client *mqtt.Client, conn *tcp.Conn, stack *xnet.StackAsync, logger *slog.Logger) error {
+	c := &commandParsed
+	*c = remoteCommand{}
+	err := errCommandTooLarge
+	if commandTotal <= commandLen {
+		err = parseRemoteCommand(commandBuf[:commandLen], c)
+	}
+	if err == nil && commandRetained {
+		// A retained command would run again after every reconnect
+		err = errCommandRetained
+	}
+	if err == nil {
+		err = commands.verify(c, time.Now())
+	}
+	if err != nil {
+		logger.Warn("mqtt:command-rejected",
+			slog.String("id", string(c.id())),
+			slog.Int("bytes", commandTotal),
+			slog.String("err", err.Error()),
+		)
+		if c.IDLen == 0 {
+			return nil
+		}
+		return publishCommandReply(client, conn, stack, c.id(), false, []byte(err.Error()), logger)
+	}
+
+	logger.Info("mqtt:command",
+		slog.String("id", string(c.id())),
+		slog.String("cmd", string(c.cmd())),
+	)
+	out := stateWriter{buf: commandOutput[:]}
+	ok, reboot := runRemoteCommand(c.cmd(), &out, stack, logger)
+	err = publishCommandReply(client, conn, stack, c.id(), ok, commandOutput[:out.n], logger)
+	if reboot {
+		conn.Flush()
+		time.Sleep(100 * time.Millisecond)
+		ota.Reboot()
+	}
+	return err
+}
+
+// publishCommandReply publishes the reply to a command (not retained)
+func publishCommandReply(client *mqtt.Client, conn *tcp.Conn, stack *xnet.StackAsync, id []byte, ok bool, output []byte, logger *slog.Logger) error {
+	n, err := encodeCommandReply(commandReply[:], id, ok, output)
+	if err != nil {
+		logger.Error("mqtt:command-reply-failed", slog.String("err", err.Error()))
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	pubVar := mqtt.VariablesPublish{
+		TopicName:        commandReplyTopic(),
+		PacketIdentifier: uint16(stack.Prand32()),
+	}
+	if err = client.PublishPayload(pubFlags, pubVar, commandReply[:n]); err != nil {
+		logger.Error("mqtt:publish-failed", slog.String("err", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// runRemoteCommand runs one of the operational console commands (see
+// runDeviceCommand), writing its output to out. reboot is set when the
+// device should restart once the reply is out.
+func runRemoteCommand(cmd []byte, out *stateWriter, stack *xnet.StackAsync, logger *slog.Logger) (ok, reboot bool) {
+	handled, ok, reboot := runDeviceCommand(out, stack, cmd, logger, refreshChan)
+	if !handled {
+		out.raw("Unknown command: ")
+		out.bytes(cmd)
+		return false, false
+	}
+	// The reply carries the console's lines without the last line break
+	for out.n > 0 && (out.buf[out.n-1] == '\n' || out.buf[out.n-1] == '\r') {
+		out.n--
+	}
+	return ok, reboot
+}
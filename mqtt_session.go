@@ -0,0 +1,246 @@
+//go:build tinygo
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"openenterprise/bindicator/config"
+	"openenterprise/bindicator/telemetry"
+
+	"github.com/soypat/lneto/tcp"
+	"github.com/soypat/lneto/x/xnet"
+	mqtt "github.com/soypat/natiu-mqtt"
+)
+
+// sessionPoll is how often the persistent session checks for packets
+const sessionPoll = 100 * time.Millisecond
+
+// Persistent session state (see session.go)
+var (
+	mqttKeepAlive time.Duration // 0 = request/response fetches (default)
+
+	sessionConnected  bool
+	sessionReconnects int
+	sessionSince      time.Time // When the current connection came up
+	sessionRefresh    bool      // Set by the main loop to re-request schedules
+	statePending      bool      // Publish the state at the next poll
+	lastChunkAt       time.Time // When the last chunk of a pushed schedule arrived
+
+	sessionFilters [maxPremises + 3]mqtt.SubscribeRequest // Schedules, Home Assistant, commands
+	sessionSub     mqtt.VariablesSubscribe
+	sessionAlive   sessionKeepAlive
+)
+
+// Session errors (the link is then re-established after a backoff)
+var (
+	errSessionKeepAlive = errors.New("keepalive timeout")
+	errSessionClosed    = errors.New("connection lost")
+)
+
+// runMQTTSession keeps a persistent MQTT session up and never returns. It
+// replaces the periodic fetch in the main loop: schedules pushed by the
+// bridge are applied as they arrive, and requestSessionRefresh asks the
+// bridge to publish them again.
+func runMQTTSession(stack *xnet.StackAsync, logger *slog.Logger) {
+	var backoff time.Duration
+	failed := 0 // Attempts since the last session that got going
+	for {
+		wifiStats.lastMQTTAttempt = time.Now()
+		err := mqttSession(stack, mqttBrokers, logger)
+
+		// A session that got going restarts the backoff. Attempts that
+		// could not set one up are reported as a failed refresh, like an
+		// exhausted fetch (see sessionFailedAttempts).
+		if sessionConnected {
+			backoff = 0
+			failed = 0
+			sessionReconnects++
+		} else {
+			wifiStats.mqttFailCount++
+			if failed++; failed%sessionFailedAttempts == 0 {
+				reportRefresh(false)
+			}
+		}
+		sessionConnected = false
+		backoff = nextSessionBackoff(backoff)
+		logger.Warn("mqtt:session-lost",
+			slog.String("err", err.Error()),
+			slog.Duration("retry_in", backoff),
+			slog.Int("failed", failed),
+		)
+		telemetry.RecordCounter("mqtt.fail.count", int64(wifiStats.mqttFailCount))
+		time.Sleep(backoff)
+	}
+}
+
+// requestStatePublish asks the session to publish the device state (after
+// the LEDs were updated)
+func requestStatePublish() {
+	statePending = true
+}
+
+// requestSessionRefresh asks the session to request every premises'
+// schedule from the bridge (sent as soon as the session is connected)
+func requestSessionRefresh() {
+	sessionRefresh = true
+}
+
+// mqttSession connects to the first reachable broker, subscribes to the
+// schedule topics and handles packets until the link drops
+func mqttSession(stack *xnet.StackAsync, brokers []config.Broker, logger *slog.Logger) error {
+	var conn tcp.Conn
+	client, brokerAddr, err := dialBrokers(&conn, stack, brokers, mqttKeepAlive, logger)
+	if err != nil {
+		return err
+	}
+	defer closeConn(&conn, stack, brokerAddr)
+	defer abortIncompleteSchedule(logger)
+
+	// Subscribe to the retained schedule topic of every premises (the
+	// broker replays the last schedule straight away), Home Assistant's
+	// birth message and LED switches if enabled, and the command topic if
+	// a command secret is set
+	for p := 0; p < numPremises(); p++ {
+		sessionFilters[p] = mqtt.SubscribeRequest{TopicFilter: scheduleTopic(p), QoS: mqtt.QoS0}
+	}
+	n := numPremises()
+	if haConfig.Enabled {
+		sessionFilters[n] = mqtt.SubscribeRequest{TopicFilter: haStatusTopic, QoS: mqtt.QoS0}
+		n++
+	}
+	if haConfig.Switches {
+		sessionFilters[n] = mqtt.SubscribeRequest{TopicFilter: ledCommandFilter(), QoS: mqtt.QoS0}
+		n++
+	}
+	if commands.enabled() {
+		sessionFilters[n] = mqtt.SubscribeRequest{TopicFilter: commandTopic(), QoS: mqtt.QoS0}
+		n++
+	}
+	sessionSub.TopicFilters = sessionFilters[:n]
+	sessionSub.PacketIdentifier = uint16(stack.Prand32())
+	if err = subscribeMQTT(client, &conn, sessionSub, logger); err != nil {
+		return err
+	}
+
+	statePending = true
+	sessionConnected = true
+	sessionSince = time.Now()
+	sessionAlive.reset(mqttKeepAlive, sessionSince)
+	gotResponse = false
+	commandPending = false
+	logger.Info("mqtt:session-up",
+		slog.Duration("keepalive", mqttKeepAlive),
+		slog.Int("reconnects", sessionReconnects),
+	)
+
+	for {
+		time.Sleep(sessionPoll)
+		now := time.Now()
+		conn.SetDeadline(now.Add(sessionPoll))
+		if client.HandleNext() == nil {
+			sessionAlive.received(now)
+		}
+		if !client.IsConnected() || conn.State().IsClosed() {
+			return errSessionClosed
+		}
+
+		if gotResponse {
+			gotResponse = false
+			sessionScheduleReceived(logger)
+		} else if scheduleChunks.next != 0 && now.Sub(lastChunkAt) > responseWaitMs*time.Millisecond {
+			abortIncompleteSchedule(logger)
+		}
+
+		if commandPending {
+			err = handleRemoteCommand(client, &conn, stack, logger)
+			commandPending = false
+			if err != nil {
+				return err
+			}
+			sessionAlive.sent(now)
+		}
+
+		if sessionRefresh {
+			sessionRefresh = false
+			for p := 0; p < numPremises(); p++ {
+				if err = publishScheduleRequest(client, &conn, stack, p, logger); err != nil {
+					return err
+				}
+			}
+			sessionAlive.sent(now)
+		}
+
+		if haConfig.Enabled && !discoveryPublished {
+			if err = publishDiscovery(client, &conn, stack, logger); err != nil {
+				return err
+			}
+			sessionAlive.sent(now)
+		}
+
+		if statePending {
+			statePending = false
+			if err = publishStatus(client, &conn, stack, logger); err != nil {
+				return err
+			}
+			sessionAlive.sent(now)
+		}
+
+		if sessionAlive.expired(now) {
+			client.Disconnect(errSessionKeepAlive)
+			return errSessionKeepAlive
+		}
+		if sessionAlive.pingDue(now) {
+			conn.SetDeadline(now.Add(mqttTimeout))
+			if err = client.StartPing(); err != nil {
+				return err
+			}
+			sessionAlive.sent(now)
+		}
+	}
+}
+
+// sessionScheduleReceived applies a schedule pushed on a schedule topic:
+// the same bookkeeping as a successful fetch, then the LEDs are updated
+// without waiting for the next wake cycle
+func sessionScheduleReceived(logger *slog.Logger) {
+	p := responsePremises
+	if p < 0 {
+		return // Stray response-topic message; not subscribed in a session
+	}
+	logger.Info("mqtt:schedule-pushed",
+		slog.String("premises", premisesName(p)),
+		slog.Int("bytes", responseTotal),
+		slog.Bool("retained", responseRetained),
+	)
+	done, err := handleScheduleResponse(p, logger)
+	if err != nil {
+		abortIncompleteSchedule(logger)
+		wifiStats.mqttFailCount++
+		return
+	}
+	if !done {
+		lastChunkAt = time.Now()
+		return // Applied once the last chunk is in
+	}
+
+	now := time.Now()
+	wifiStats.lastMQTTSuccess = now
+	wifiStats.mqttSuccessCount++
+	telemetry.RecordCounter("mqtt.success.count", int64(wifiStats.mqttSuccessCount))
+	reportRefresh(true)
+	lastScheduleFetch = now
+	scheduleFetched = now
+
+	maintainSchedule(logger, now)
+	if wrote, err := saveSchedule(lastScheduleFetch); err != nil {
+		logger.Error("store:save-failed", slog.String("err", err.Error()))
+	} else if wrote {
+		logger.Info("store:saved", slog.Int("jobs", len(getJobs())))
+	}
+	updateLEDsFromSchedule(getJobs(), now)
+	logLEDState(logger)
+	statePending = true
+}
@@ -0,0 +1,189 @@
+//go:build tinygo
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"time"
+
+	"openenterprise/bindicator/ota"
+	"openenterprise/bindicator/version"
+
+	"github.com/soypat/lneto/tcp"
+	"github.com/soypat/lneto/x/xnet"
+	mqtt "github.com/soypat/natiu-mqtt"
+)
+
+// MQTT publish flags for the status topics (QoS0, retained, not dup)
+var retainFlags, _ = mqtt.NewPublishFlags(mqtt.QoS0, true, false)
+
+// Availability payloads
+var (
+	onlinePayload  = []byte(availabilityOnline)
+	offlinePayload = []byte(availabilityOffline)
+)
+
+// discoveryPublished is set once the discovery configs are on the broker
+// (they are retained); Home Assistant's birth message clears it
+var discoveryPublished bool
+
+// discoveryPause lets the TCP buffer drain between discovery configs
+const discoveryPause = 50 * time.Millisecond
+
+// setWill makes the broker publish "offline" (retained) on the
+// availability topic if the device goes away without disconnecting
+func setWill(varconn *mqtt.VariablesConnect) {
+	varconn.WillTopic = availabilityTopic()
+	varconn.WillMessage = offlinePayload
+	varconn.WillQoS = mqtt.QoS0
+	varconn.WillRetain = true
+}
+
+// currentState collects the device-wide state for encodeState
+func currentState() deviceState {
+	now := time.Now()
+	s := deviceState{
+		Time:                now,
+		Version:             version.Version,
+		GitSHA:              version.GitSHA,
+		Partition:           "A",
+		Healthy:             systemHealthy,
+		ConsecutiveFailures: consecutiveFailures,
+		MQTTSuccess:         wifiStats.mqttSuccessCount,
+		MQTTFail:            wifiStats.mqttFailCount,
+		LastMQTTSuccess:     wifiStats.lastMQTTSuccess,
+	}
+	if len(s.GitSHA) > 7 {
+		s.GitSHA = s.GitSHA[:7]
+	}
+	if ota.GetCurrentPartition() == ota.PartitionB {
+		s.Partition = "B"
+	}
+	if !startTime.IsZero() {
+		s.Uptime = now.Sub(startTime)
+	}
+	if !wifiStats.connectTime.IsZero() {
+		s.WiFiConnected = now.Sub(wifiStats.connectTime)
+	}
+	return s
+}
+
+// publishStatus publishes "online" on the availability topic and the
+// current state, both retained. Only publish errors are returned.
+func publishStatus(client *mqtt.Client, conn *tcp.Conn, stack *xnet.StackAsync, logger *slog.Logger) error {
+	s := currentState()
+	n, err := encodeState(stateBuf[:], &s, applyExceptions(getJobs()))
+	if err != nil {
+		// Not a link problem; availability is still published
+		logger.Error("mqtt:state-failed", slog.String("err", err.Error()))
+		n = 0
+	}
+
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	pubVar := mqtt.VariablesPublish{
+		TopicName:        availabilityTopic(),
+		PacketIdentifier: uint16(stack.Prand32()),
+	}
+	if err = client.PublishPayload(retainFlags, pubVar, onlinePayload); err != nil {
+		logger.Error("mqtt:publish-failed", slog.String("err", err.Error()))
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	pubVar.TopicName = stateTopic()
+	pubVar.PacketIdentifier = uint16(stack.Prand32())
+	if err = client.PublishPayload(retainFlags, pubVar, stateBuf[:n]); err != nil {
+		logger.Error("mqtt:publish-failed", slog.String("err", err.Error()))
+		return err
+	}
+	logger.Debug("mqtt:state-published",
+		slog.String("topic", string(stateTopic())),
+		slog.Int("bytes", n),
+	)
+	return nil
+}
+
+// updateDiscoveryExpiry sets haExpireAfter without a persistent session:
+// the entities become unavailable once two fetches in a row were missed.
+// Changed intervals republish the discovery configs at the next fetch.
+func updateDiscoveryExpiry() {
+	if !haConfig.Enabled || mqttKeepAlive > 0 {
+		return
+	}
+	if expire := 2*scheduleRefreshInterval + wakeInterval; expire != haExpireAfter {
+		haExpireAfter = expire
+		discoveryPublished = false
+	}
+}
+
+// publishDiscovery publishes the retained Home Assistant discovery config
+// of every entity (see encodeDiscovery), unless already done
+func publishDiscovery(client *mqtt.Client, conn *tcp.Conn, stack *xnet.StackAsync, logger *slog.Logger) error {
+	if !haConfig.Enabled || discoveryPublished {
+		return nil
+	}
+	pubVar := mqtt.VariablesPublish{}
+	i := 0
+	for ; ; i++ {
+		e, ok := haEntityAt(i)
+		if !ok {
+			break
+		}
+		topic, n, err := encodeDiscovery(e, version.Version, stateBuf[:])
+		if err != nil {
+			logger.Error("mqtt:discovery-failed", slog.String("err", err.Error()))
+			continue
+		}
+		feedWatchdogIfHealthy()
+		conn.SetDeadline(time.Now().Add(mqttTimeout))
+		pubVar.TopicName = topic
+		pubVar.PacketIdentifier = uint16(stack.Prand32())
+		if err = client.PublishPayload(retainFlags, pubVar, stateBuf[:n]); err != nil {
+			logger.Error("mqtt:publish-failed", slog.String("err", err.Error()))
+			return err
+		}
+		time.Sleep(discoveryPause)
+	}
+	discoveryPublished = true
+	logger.Info("mqtt:discovery-published",
+		slog.String("prefix", haConfig.Prefix),
+		slog.Int("entities", i),
+	)
+	return nil
+}
+
+// ledCommandBuf holds a switch command payload (ON or OFF)
+var ledCommandBuf [8]byte
+
+// onHomeAssistantMessage handles Home Assistant's birth message (the
+// discovery configs are published again) and LED switch commands, which
+// override an LED like the console led-<bin> command until the next LED
+// update. Reports whether the message was one of these.
+func onHomeAssistantMessage(topic []byte, r io.Reader) bool {
+	if !haConfig.Enabled {
+		return false
+	}
+	isStatus := bytesEqual(topic, haStatusTopic)
+	isCommand := haConfig.Switches && hasPrefix(topic, ledFilterBuf[:ledCommandBase])
+	if !isStatus && !isCommand {
+		return false
+	}
+	n, total, _ := readMessage(r, ledCommandBuf[:])
+	if total > n {
+		return true // Not a command or birth message
+	}
+	payload := ledCommandBuf[:n]
+	if isStatus {
+		if bytesEqual(payload, onlinePayload) {
+			discoveryPublished = false
+		}
+		return true
+	}
+	if p, bt, on, ok := parseLEDCommand(topic, payload); ok {
+		setLED(p, bt, on)
+		statePending = true
+	}
+	return true
+}
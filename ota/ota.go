@@ -283,6 +283,7 @@ import "C"
 
 import (
 	"errors"
+	"unsafe"
 )
 
 // Partition constants
@@ -293,6 +294,13 @@ const (
 	// Flash constants
 	SectorSize = 4096 // 4KB erase block
 	PageSize   = 256  // 256B write block
+
+	// Reserved region after partition B (0x3E2000-0x400000), never written
+	// by OTA updates. Used for application data such as the cached schedule.
+	ReservedOffset = 0x3E2000
+	ReservedSize   = 0x1E000 // 120KB
+
+	xipBase = 0x10000000 // Flash is memory-mapped here for reads
 )
 
 // Errors
@@ -400,6 +408,16 @@ func WriteChunk(offset uint32, data []byte) error {
 	return nil
 }
 
+// ReadFlash copies len(buf) bytes from the given raw flash offset into buf.
+// Reads go through the XIP window, so no ROM calls are needed.
+func ReadFlash(offset uint32, buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	src := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(xipBase+offset))), len(buf))
+	copy(buf, src)
+}
+
 // EraseSector erases a 4KB sector at the given offset.
 // offset is the raw byte offset from flash start.
 // Uses direct ROM flash functions to bypass TinyGo's machine.Flash.
@@ -1,77 +1,229 @@
 package main
 
-// parsedTimestamp holds the Unix timestamp from the last parsed response
-var parsedTimestamp int64
+// maxRejected is the number of rejected entries kept for diagnostics
+const maxRejected = 8
 
-// parseScheduleResponse parses the CSV format from Node-RED.
-// Format: "TIMESTAMP,YYYY-MM-DD:TYPE,YYYY-MM-DD:TYPE,..."
+// RejectReason explains why a schedule entry was not accepted
+type RejectReason uint8
+
+const (
+	RejectMalformed  RejectReason = iota + 1 // Not in YYYY-MM-DD:TYPE form
+	RejectBadDate                            // Date does not exist (e.g. Feb 31)
+	RejectUnknownBin                         // Bin matches no registered name or alias
+	RejectTooMany                            // Exception beyond maxExceptions
+	RejectPremises                           // Job for a premises other than the one requested
+)
+
+// String returns a short description of the reject reason
+func (r RejectReason) String() string {
+	switch r {
+	case RejectMalformed:
+		return "malformed"
+	case RejectBadDate:
+		return "bad-date"
+	case RejectUnknownBin:
+		return "unknown-bin"
+	case RejectTooMany:
+		return "too-many"
+	case RejectPremises:
+		return "other-premises"
+	default:
+		return "unknown"
+	}
+}
+
+// RejectedEntry is a schedule entry that was not accepted
+type RejectedEntry struct {
+	Entry  [24]byte // Raw entry text (truncated)
+	Len    uint8
+	Reason RejectReason
+}
+
+// String returns the (possibly truncated) raw entry text
+func (e *RejectedEntry) String() string {
+	return string(e.Entry[:e.Len])
+}
+
+// Schedule payload versions
+const (
+	payloadCSV    = 1 // TIMESTAMP,YYYY-MM-DD:TYPE,...
+	payloadJSONv2 = 2 // {"v":2,...}, see parse_json.go
+)
+
+// ParseResult summarises a parsed schedule response
+type ParseResult struct {
+	Version       uint8                      // Payload version (payloadCSV or payloadJSONv2)
+	Timestamp     int64                      // Unix timestamp from the payload (0 if absent)
+	Revision      uint32                     // Schedule revision (v2 only, 0 if absent)
+	Chunk         uint8                      // Chunk number of a chunked v2 schedule (0 if not chunked)
+	Chunks        uint8                      // Number of chunks in the schedule (0 if not chunked)
+	Request       int                        // Premises the schedule was requested for (index)
+	Premises      [maxPremisesLen]byte       // Default premises ID for jobs (payload or request)
+	PremisesLen   uint8                      //
+	Jobs          int                        // Jobs stored for the requested premises (0 if the payload had none)
+	Exceptions    int                        // Exceptions accepted (v2 only)
+	Rejected      [maxRejected]RejectedEntry // First rejected entries
+	RejectedCount int                        // Total rejected (may exceed len(Rejected))
+	Duplicates    int                        // Entries ignored as duplicates of an accepted job
+	Truncated     bool                       // Valid entries were dropped because the store was full
+	Dropped       int                        // Number of valid entries dropped (the latest are dropped first)
+	Err           error                      // Payload could not be parsed; cached jobs were kept
+}
+
+// Accepted returns the number of accepted jobs (those of the requested
+// premises; other premises' stored jobs are not counted)
+func (r *ParseResult) Accepted() int {
+	return r.Jobs
+}
+
+// Complete reports whether the schedule is complete: it was not chunked
+// or this was its last chunk
+func (r *ParseResult) Complete() bool {
+	return r.Chunk == r.Chunks
+}
+
+// RejectedEntries returns the recorded rejected entries
+func (r *ParseResult) RejectedEntries() []RejectedEntry {
+	n := r.RejectedCount
+	if n > len(r.Rejected) {
+		n = len(r.Rejected)
+	}
+	return r.Rejected[:n]
+}
+
+// reject records a rejected entry
+func (r *ParseResult) reject(entry []byte, reason RejectReason) {
+	if r.RejectedCount < len(r.Rejected) {
+		e := &r.Rejected[r.RejectedCount]
+		e.Len = uint8(copy(e.Entry[:], entry))
+		e.Reason = reason
+	}
+	r.RejectedCount++
+}
+
+// lastParseResult holds the result of the most recent parse (for diagnostics)
+var lastParseResult ParseResult
+
+// parseScheduleResponse parses a schedule response from Node-RED.
+// Payloads starting with a version marker ({"v":2) are parsed as JSON by
+// parseScheduleJSON; anything else is the legacy CSV format:
+// "TIMESTAMP,YYYY-MM-DD:TYPE,YYYY-MM-DD:TYPE,..."
 // Example: "1737207000,2026-01-17:BLACK,2026-01-31:GREEN"
-// Returns the number of jobs parsed into jobStorage.
-// The Unix timestamp is stored in parsedTimestamp.
-func parseScheduleResponse(data []byte) int {
-	clearJobs()
-	parsedTimestamp = 0
+// Accepted jobs are stored in jobStorage in date order, with duplicates
+// removed. Entries that are malformed, have an impossible date or an unknown
+// bin are recorded as rejected, and the latest valid entries beyond maxJobs
+// are counted as dropped. The result is also kept in lastParseResult.
+func parseScheduleResponse(data []byte) ParseResult {
+	return parsePremisesResponse(data, 0)
+}
 
-	if len(data) == 0 {
-		return 0
+// parsePremisesResponse parses the schedule response to a request for
+// premises p (see parseScheduleResponse). Only that premises' jobs are
+// replaced. With several premises configured, jobs without a premises ID
+// are tagged with p's, and a payload for another premises is rejected.
+func parsePremisesResponse(data []byte, p int) ParseResult {
+	if hasVersionMarker(data) {
+		res := parseScheduleJSON(data, p)
+		lastParseResult = res
+		return res
 	}
 
-	// Parse Unix timestamp from the beginning
-	pos := 0
-	for pos < len(data) && data[pos] >= '0' && data[pos] <= '9' {
-		parsedTimestamp = parsedTimestamp*10 + int64(data[pos]-'0')
-		pos++
+	// Same parser as a CSV payload streamed from MQTT (see receiveSchedule)
+	var c csvParser
+	c.begin(p)
+	c.write(data)
+	return c.finish()
+}
+
+// parseScheduleEntry parses a single "YYYY-MM-DD:TYPE" entry into jobStorage
+func parseScheduleEntry(res *ParseResult, entry []byte) {
+	// Minimum: "YYYY-MM-DD:X", date is exactly 10 chars
+	if len(entry) < 12 || entry[10] != ':' {
+		res.reject(entry, RejectMalformed)
+		return
 	}
 
-	// Skip the comma after timestamp
-	if pos < len(data) && data[pos] == ',' {
-		pos++
+	// Parse date: YYYY-MM-DD
+	year, month, day, reason := parseDate(entry[0:10])
+	if reason != 0 {
+		res.reject(entry, reason)
+		return
 	}
 
-	for pos < len(data) && jobCount < maxJobs {
-		// Find end of current entry (comma or end of data)
-		entryEnd := pos
-		for entryEnd < len(data) && data[entryEnd] != ',' {
-			entryEnd++
-		}
+	// Parse bin type
+	bt := parseBinType(entry[11:])
+	if bt == BinUnknown {
+		res.reject(entry, RejectUnknownBin)
+		return
+	}
+
+	job := BinJob{Year: uint16(year), Month: uint8(month), Day: uint8(day), Bin: bt}
+	job.Premises, job.PremisesLen = res.Premises, res.PremisesLen
+	addJob(res, &job)
+}
+
+// parseDate parses a "YYYY-MM-DD" date, returning a reject reason if the
+// text is malformed or the date does not exist
+func parseDate(s []byte) (year, month, day int, reason RejectReason) {
+	if len(s) != 10 || s[4] != '-' || s[7] != '-' ||
+		!allDigits(s[0:4]) || !allDigits(s[5:7]) || !allDigits(s[8:10]) {
+		return 0, 0, 0, RejectMalformed
+	}
+	year = atoi4(s[0:4])
+	month = atoi2(s[5:7])
+	day = atoi2(s[8:10])
+	if !validDate(year, month, day) {
+		return 0, 0, 0, RejectBadDate
+	}
+	return year, month, day, 0
+}
+
+// addJob inserts a job into jobStorage in date order, counting duplicates
+// and jobs dropped because the store is full. Returns the stored job or nil
+// if it was dropped.
+func addJob(res *ParseResult, job *BinJob) *BinJob {
+	stored, duplicate, dropped := insertJob(job)
+	if duplicate {
+		res.Duplicates++
+	}
+	if dropped {
+		res.Truncated = true
+		res.Dropped++
+	}
+	return stored
+}
 
-		// Parse entry: YYYY-MM-DD:TYPE
-		entry := data[pos:entryEnd]
-		if len(entry) >= 11 { // Minimum: "YYYY-MM-DD:X"
-			// Find colon separator
-			colonIdx := -1
-			for i := 0; i < len(entry); i++ {
-				if entry[i] == ':' {
-					colonIdx = i
-					break
-				}
-			}
-
-			if colonIdx == 10 { // Date is exactly 10 chars
-				// Parse date: YYYY-MM-DD
-				year := atoi4(entry[0:4])
-				month := atoi2(entry[5:7])
-				day := atoi2(entry[8:10])
-
-				// Parse bin type
-				binType := entry[colonIdx+1:]
-				bt := parseBinType(binType)
-
-				if bt != BinUnknown && year > 0 && month > 0 && month <= 12 && day > 0 && day <= 31 {
-					jobStorage[jobCount].Year = uint16(year)
-					jobStorage[jobCount].Month = uint8(month)
-					jobStorage[jobCount].Day = uint8(day)
-					jobStorage[jobCount].Bin = bt
-					jobCount++
-				}
-			}
+// allDigits reports whether s is non-empty and only contains ASCII digits
+func allDigits(s []byte) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
 		}
+	}
+	return len(s) > 0
+}
 
-		// Move to next entry
-		pos = entryEnd + 1
+// validDate reports whether year-month-day is a real calendar date
+func validDate(year, month, day int) bool {
+	if year <= 0 || month < 1 || month > 12 || day < 1 {
+		return false
 	}
+	return day <= daysInMonth(year, month)
+}
 
-	return jobCount
+// daysInMonth returns the number of days in the given month
+func daysInMonth(year, month int) int {
+	switch month {
+	case 2:
+		if year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+			return 29
+		}
+		return 28
+	case 4, 6, 9, 11:
+		return 30
+	default:
+		return 31
+	}
 }
 
 // atoi2 converts 2-digit ASCII string to int without allocation
@@ -102,28 +254,7 @@ func atoi4(s []byte) int {
 	return d1*1000 + d2*100 + d3*10 + d4
 }
 
-// parseBinType converts bin type string to BinType enum
+// parseBinType converts a bin type string to a registered BinType
 func parseBinType(s []byte) BinType {
-	if len(s) == 0 {
-		return BinUnknown
-	}
-
-	// Match based on first character and length
-	switch s[0] {
-	case 'G', 'g': // GREEN
-		if len(s) >= 5 {
-			return BinGreen
-		}
-	case 'B', 'b':
-		if len(s) >= 5 {
-			// Distinguish BLACK from BROWN by second char
-			if len(s) >= 2 && (s[1] == 'L' || s[1] == 'l') {
-				return BinBlack
-			}
-			if len(s) >= 2 && (s[1] == 'R' || s[1] == 'r') {
-				return BinBrown
-			}
-		}
-	}
-	return BinUnknown
+	return lookupBin(s)
 }
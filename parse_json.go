@@ -0,0 +1,625 @@
+package main
+
+import "errors"
+
+// Limits for v2 payload fields
+const (
+	maxNoteLen     = 32 // Bytes of a job note kept (longer notes are truncated)
+	maxPremisesLen = 12 // Bytes of a premises ID
+	maxJSONDepth   = 8  // Nesting allowed when skipping unknown values
+)
+
+// versionMarker starts every versioned (non-CSV) payload
+const versionMarker = `{"v":`
+
+// v2 payload errors
+var (
+	errJSONSyntax         = errors.New("schedule: invalid JSON")
+	errUnsupportedVersion = errors.New("schedule: unsupported payload version")
+	errOtherPremises      = errors.New("schedule: payload is for another premises")
+)
+
+// NoteString returns the job's note (empty if none)
+func (j *BinJob) NoteString() string {
+	return string(j.Note[:j.NoteLen])
+}
+
+// PremisesID returns the job's premises ID (empty if none)
+func (j *BinJob) PremisesID() string {
+	return string(j.Premises[:j.PremisesLen])
+}
+
+// PremisesID returns the payload's default premises ID (empty if none)
+func (r *ParseResult) PremisesID() string {
+	return string(r.Premises[:r.PremisesLen])
+}
+
+// hasVersionMarker reports whether data starts with the versioned payload
+// marker, ignoring leading whitespace
+func hasVersionMarker(data []byte) bool {
+	r := jsonReader{data: data}
+	r.skipSpace()
+	rest := data[r.pos:]
+	if len(rest) < len(versionMarker) {
+		return false
+	}
+	return jsonKeyIs(rest[:len(versionMarker)], versionMarker)
+}
+
+// responseNonce returns the raw "nonce" member of a versioned payload, or
+// nil if there is none (CSV payloads never have one)
+func responseNonce(data []byte) []byte {
+	if !hasVersionMarker(data) {
+		return nil
+	}
+	r := jsonReader{data: data}
+	r.expect('{')
+	for i := 0; r.next('}', i); i++ {
+		key := r.readString()
+		r.expect(':')
+		if jsonKeyIs(key, "nonce") {
+			return r.stringValue()
+		}
+		r.skipValue(0)
+	}
+	return nil
+}
+
+// parseScheduleJSON parses a v2 schedule payload. The version marker must
+// come first; other members may appear in any order and unknown members
+// are skipped so newer bridges can add fields.
+//
+//	{"v":2,"ts":1737207000,"rev":42,"premises":"1",
+//	 "jobs":[{"date":"2026-01-17","bin":"BLACK","note":"Put out by 7am"}],
+//	 "exceptions":[{"action":"move","date":"2026-01-31","bin":"GREEN","to":"2026-02-01"}]}
+//
+// The payload answers a request for premises p and replaces only that
+// premises' jobs. Jobs take the top-level premises ID unless they carry
+// their own; with several premises configured the ID defaults to p's and
+// jobs for other premises are rejected. The exceptions (cancel, move, add)
+// replace those from the previous payload for p and are applied when the
+// LEDs are updated (see applyExceptions). A payload without a "jobs"
+// member only updates the exceptions and keeps the cached jobs. The
+// payload is validated before anything is touched,
+// so a corrupt or unsupported payload sets Err and changes nothing.
+//
+// A schedule too large for one message is sent as numbered chunks, each a
+// complete payload with "chunk":N,"chunks":M (1-based). Chunk 1 replaces
+// the premises' jobs and exceptions, later chunks add to them and must
+// follow in order; the result counts all chunks so far and is Complete
+// after the last one. Until then the replaced jobs and exceptions are
+// kept, and a chunk out of sequence (or abortChunks, when the rest does
+// not arrive) puts them back.
+// Parsing does not allocate: strings are slices of data until copied into
+// fixed-size job fields.
+func parseScheduleJSON(data []byte, p int) ParseResult {
+	res := ParseResult{Version: payloadJSONv2, Request: p}
+
+	// Validate the whole document first
+	r := jsonReader{data: data}
+	r.skipValue(0)
+	r.skipSpace()
+	if r.err != nil || r.pos != len(data) {
+		res.Err = errJSONSyntax
+		return res
+	}
+
+	// Version marker: {"v":N
+	r = jsonReader{data: data}
+	r.expect('{')
+	r.readString()
+	r.expect(':')
+	if v := r.intValue(); v != payloadJSONv2 {
+		res.Err = errUnsupportedVersion
+		return res
+	}
+
+	// The default premises is needed before the jobs (which are stored with
+	// their premises ID), but members may come in any order
+	body := r
+	hasJobs := false
+	var chunk, chunks int64
+	for i := 1; r.next('}', i); i++ {
+		key := r.readString()
+		r.expect(':')
+		switch {
+		case jsonKeyIs(key, "premises"):
+			res.PremisesLen = uint8(copyJSONString(res.Premises[:], r.stringValue()))
+		case jsonKeyIs(key, "jobs"):
+			hasJobs = true
+			r.skipValue(0)
+		case jsonKeyIs(key, "chunk"):
+			chunk = r.intValue()
+		case jsonKeyIs(key, "chunks"):
+			chunks = r.intValue()
+		default:
+			r.skipValue(0)
+		}
+	}
+	r = body
+
+	// With several premises the payload must be for the one requested
+	if numPremises() > 1 {
+		if res.PremisesLen == 0 {
+			res.PremisesLen = uint8(copy(res.Premises[:], premisesID(p)))
+		}
+		if premisesIndex(res.Premises[:res.PremisesLen]) != p {
+			res.Err = errOtherPremises
+			return res
+		}
+	}
+
+	if chunk != 0 || chunks != 0 {
+		if chunk < 1 || chunk > chunks || chunks > maxScheduleChunks ||
+			(chunk > 1 && !scheduleChunks.continues(p, int(chunk), int(chunks))) {
+			abortChunks()
+			res.Err = errChunkSequence
+			return res
+		}
+		res.Chunk, res.Chunks = uint8(chunk), uint8(chunks)
+		hasJobs = true // Chunk 1 replaces the jobs even if it carries none
+	}
+
+	if chunk > 1 {
+		res.continueFrom(&lastParseResult)
+	} else {
+		// What a chunked schedule replaces is kept until its last chunk;
+		// one abandoned for this payload is restored
+		if chunk < chunks {
+			replacedSchedule.save(p)
+		} else {
+			replacedSchedule.restore()
+		}
+		if hasJobs {
+			clearPremisesJobs(p)
+		}
+		clearPayloadExceptions(p)
+	}
+	scheduleChunks = chunkSequence{}
+	if chunk < chunks {
+		scheduleChunks = chunkSequence{premises: p, next: int(chunk) + 1, total: int(chunks)}
+	} else {
+		replacedSchedule.discard(p)
+	}
+	for i := 1; r.next('}', i); i++ {
+		key := r.readString()
+		r.expect(':')
+		switch {
+		case jsonKeyIs(key, "ts"):
+			res.Timestamp = r.intValue()
+		case jsonKeyIs(key, "rev"):
+			res.Revision = uint32(r.intValue())
+		case jsonKeyIs(key, "jobs"):
+			parseJSONJobs(&r, &res)
+		case jsonKeyIs(key, "exceptions"):
+			parseJSONExceptions(&r, &res)
+		default:
+			r.skipValue(0)
+		}
+	}
+
+	if hasJobs {
+		res.Jobs = countPremisesJobs(p)
+	}
+	return res
+}
+
+// chunkSequence tracks a chunked schedule between its messages
+type chunkSequence struct {
+	premises int
+	next     int // Chunk expected next (0: no schedule in progress)
+	total    int
+}
+
+// scheduleChunks is the chunked schedule being received
+var scheduleChunks chunkSequence
+
+// abortChunks gives up on the chunked schedule being received and restores
+// the jobs and exceptions it was replacing. Returns whether one was in
+// progress.
+func abortChunks() bool {
+	scheduleChunks = chunkSequence{}
+	return replacedSchedule.restore()
+}
+
+// continues reports whether chunk of total is the next chunk of premises p
+func (s *chunkSequence) continues(p, chunk, total int) bool {
+	return s.next != 0 && s.premises == p && s.next == chunk && s.total == total
+}
+
+// continueFrom carries the counts of the previous chunks into r
+func (r *ParseResult) continueFrom(prev *ParseResult) {
+	r.Rejected = prev.Rejected
+	r.RejectedCount = prev.RejectedCount
+	r.Duplicates = prev.Duplicates
+	r.Truncated = prev.Truncated
+	r.Dropped = prev.Dropped
+	r.Exceptions = prev.Exceptions
+}
+
+// parseJSONJobs parses the "jobs" array into jobStorage
+func parseJSONJobs(r *jsonReader, res *ParseResult) {
+	if r.peek() != '[' {
+		r.skipValue(0)
+		return
+	}
+	r.pos++
+	for i := 0; r.next(']', i); i++ {
+		if r.peek() != '{' {
+			r.skipValue(0)
+			res.reject(nil, RejectMalformed)
+			continue
+		}
+		r.pos++
+
+		var date, bin, note, premises []byte
+		for j := 0; r.next('}', j); j++ {
+			key := r.readString()
+			r.expect(':')
+			switch {
+			case jsonKeyIs(key, "date"):
+				date = r.stringValue()
+			case jsonKeyIs(key, "bin"):
+				bin = r.stringValue()
+			case jsonKeyIs(key, "note"):
+				note = r.stringValue()
+			case jsonKeyIs(key, "premises"):
+				premises = r.stringValue()
+			default:
+				r.skipValue(0)
+			}
+		}
+
+		year, month, day, reason := parseDate(date)
+		bt := BinUnknown
+		if reason == 0 {
+			if bt = parseBinType(bin); bt == BinUnknown {
+				reason = RejectUnknownBin
+			}
+		}
+		if reason != 0 {
+			rejectJSON(res, reason, nil, date, bin)
+			continue
+		}
+
+		// Jobs without their own premises ID belong to the payload's premises
+		job := BinJob{Year: uint16(year), Month: uint8(month), Day: uint8(day), Bin: bt}
+		job.NoteLen = uint8(copyJSONString(job.Note[:], note))
+		if premises != nil {
+			job.PremisesLen = uint8(copyJSONString(job.Premises[:], premises))
+		} else {
+			job.Premises, job.PremisesLen = res.Premises, res.PremisesLen
+		}
+		if jobPremises(&job) != res.Request {
+			rejectJSON(res, RejectPremises, nil, date, bin)
+			continue
+		}
+		addJob(res, &job)
+	}
+}
+
+// parseJSONExceptions parses the "exceptions" array into the exception list
+func parseJSONExceptions(r *jsonReader, res *ParseResult) {
+	if r.peek() != '[' {
+		r.skipValue(0)
+		return
+	}
+	r.pos++
+	for i := 0; r.next(']', i); i++ {
+		if r.peek() != '{' {
+			r.skipValue(0)
+			res.reject(nil, RejectMalformed)
+			continue
+		}
+		r.pos++
+
+		var action, date, bin, to []byte
+		for j := 0; r.next('}', j); j++ {
+			key := r.readString()
+			r.expect(':')
+			switch {
+			case jsonKeyIs(key, "action"):
+				action = r.stringValue()
+			case jsonKeyIs(key, "date"):
+				date = r.stringValue()
+			case jsonKeyIs(key, "bin"):
+				bin = r.stringValue()
+			case jsonKeyIs(key, "to"):
+				to = r.stringValue()
+			default:
+				r.skipValue(0)
+			}
+		}
+
+		ex := ScheduleException{Premises: res.Premises, PremisesLen: res.PremisesLen}
+		reason := RejectReason(0)
+		if ex.Action = parseExceptionAction(action); ex.Action == 0 {
+			reason = RejectMalformed
+		}
+
+		year, month, day, dateReason := parseDate(date)
+		if reason == 0 {
+			reason = dateReason
+		}
+		if ex.Action == ExceptionMove && reason == 0 {
+			toYear, toMonth, toDay, toReason := parseDate(to)
+			reason = toReason
+			ex.ToYear, ex.ToMonth, ex.ToDay = uint16(toYear), uint8(toMonth), uint8(toDay)
+		}
+		if reason == 0 {
+			if ex.Bin = parseBinType(bin); ex.Bin == BinUnknown {
+				reason = RejectUnknownBin
+			}
+		}
+		if reason == 0 {
+			ex.Year, ex.Month, ex.Day = uint16(year), uint8(month), uint8(day)
+			if addException(&ex) != nil {
+				reason = RejectTooMany
+			}
+		}
+		if reason != 0 {
+			rejectJSON(res, reason, action, date, bin)
+			continue
+		}
+		res.Exceptions++
+	}
+}
+
+// rejectJSON records a rejected v2 entry as "[action ]date:bin"
+func rejectJSON(res *ParseResult, reason RejectReason, action, date, bin []byte) {
+	var buf [len(RejectedEntry{}.Entry)]byte
+	n := 0
+	if len(action) > 0 {
+		n += copy(buf[n:], action)
+		if n < len(buf) {
+			buf[n] = ' '
+			n++
+		}
+	}
+	n += copy(buf[n:], date)
+	if n < len(buf) {
+		buf[n] = ':'
+		n++
+	}
+	n += copy(buf[n:], bin)
+	res.reject(buf[:n], reason)
+}
+
+// writeDate writes "YYYY-MM-DD" into a 10-byte buffer
+func writeDate(b []byte, year uint16, month, day uint8) {
+	b[0] = byte('0' + year/1000%10)
+	b[1] = byte('0' + year/100%10)
+	b[2] = byte('0' + year/10%10)
+	b[3] = byte('0' + year%10)
+	b[4] = '-'
+	b[5] = '0' + month/10
+	b[6] = '0' + month%10
+	b[7] = '-'
+	b[8] = '0' + day/10
+	b[9] = '0' + day%10
+}
+
+// jsonKeyIs compares a raw JSON string with s without allocation
+func jsonKeyIs(key []byte, s string) bool {
+	if len(key) != len(s) {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// copyJSONString unescapes a raw JSON string into dst, truncating to fit.
+// \uXXXX escapes are replaced with '?'. Returns the number of bytes written.
+func copyJSONString(dst, raw []byte) int {
+	n := 0
+	for i := 0; i < len(raw) && n < len(dst); i++ {
+		c := raw[i]
+		if c == '\\' && i+1 < len(raw) {
+			i++
+			switch raw[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'r':
+				c = '\r'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case 'u':
+				c = '?'
+				i += 4
+			default:
+				c = raw[i] // \" \\ \/
+			}
+		}
+		dst[n] = c
+		n++
+	}
+	return n
+}
+
+// jsonReader is a zero-allocation JSON reader over a byte slice.
+// Strings are returned as raw (still escaped) slices of data.
+// The first syntax error is kept in err and stops further reading.
+type jsonReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// fail records a syntax error and moves to the end of the input
+func (r *jsonReader) fail() {
+	if r.err == nil {
+		r.err = errJSONSyntax
+	}
+	r.pos = len(r.data)
+}
+
+// skipSpace skips JSON whitespace
+func (r *jsonReader) skipSpace() {
+	for r.pos < len(r.data) {
+		switch r.data[r.pos] {
+		case ' ', '\t', '\r', '\n':
+			r.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end of the input
+func (r *jsonReader) peek() byte {
+	r.skipSpace()
+	if r.pos >= len(r.data) {
+		return 0
+	}
+	return r.data[r.pos]
+}
+
+// expect consumes the byte c or fails
+func (r *jsonReader) expect(c byte) bool {
+	if r.peek() != c {
+		r.fail()
+		return false
+	}
+	r.pos++
+	return true
+}
+
+// next moves to element i of an object or array closed by end, consuming
+// the separating comma. Returns false at the end of the container.
+func (r *jsonReader) next(end byte, i int) bool {
+	if r.err != nil {
+		return false
+	}
+	if r.peek() == end {
+		r.pos++
+		return false
+	}
+	if i > 0 && !r.expect(',') {
+		return false
+	}
+	return r.err == nil
+}
+
+// readString reads a string and returns its raw contents without quotes
+func (r *jsonReader) readString() []byte {
+	if !r.expect('"') {
+		return nil
+	}
+	start := r.pos
+	for r.pos < len(r.data) {
+		c := r.data[r.pos]
+		switch {
+		case c == '"':
+			s := r.data[start:r.pos]
+			r.pos++
+			return s
+		case c == '\\':
+			r.pos += 2
+		case c < 0x20:
+			r.fail()
+			return nil
+		default:
+			r.pos++
+		}
+	}
+	r.fail()
+	return nil
+}
+
+// stringValue reads a string value, skipping (and returning nil for) any
+// other type so a wrongly typed field is treated as missing
+func (r *jsonReader) stringValue() []byte {
+	if r.peek() != '"' {
+		r.skipValue(0)
+		return nil
+	}
+	return r.readString()
+}
+
+// intValue reads an integer value. Fractions are truncated; other types
+// are skipped and read as 0.
+func (r *jsonReader) intValue() int64 {
+	c := r.peek()
+	if c != '-' && (c < '0' || c > '9') {
+		r.skipValue(0)
+		return 0
+	}
+	neg := c == '-'
+	if neg {
+		r.pos++
+	}
+	var v int64
+	for r.pos < len(r.data) && r.data[r.pos] >= '0' && r.data[r.pos] <= '9' {
+		v = v*10 + int64(r.data[r.pos]-'0')
+		r.pos++
+	}
+	r.skipNumber()
+	if neg {
+		return -v
+	}
+	return v
+}
+
+// skipNumber skips the remaining characters of a number
+func (r *jsonReader) skipNumber() {
+	for r.pos < len(r.data) {
+		switch c := r.data[r.pos]; {
+		case c >= '0' && c <= '9', c == '-', c == '+', c == '.', c == 'e', c == 'E':
+			r.pos++
+		default:
+			return
+		}
+	}
+}
+
+// skipLiteral consumes the literal s (true, false, null) or fails
+func (r *jsonReader) skipLiteral(s string) {
+	if len(r.data)-r.pos < len(s) || !jsonKeyIs(r.data[r.pos:r.pos+len(s)], s) {
+		r.fail()
+		return
+	}
+	r.pos += len(s)
+}
+
+// skipValue skips any JSON value, failing on syntax errors or nesting
+// deeper than maxJSONDepth
+func (r *jsonReader) skipValue(depth int) {
+	if depth >= maxJSONDepth {
+		r.fail()
+		return
+	}
+	switch c := r.peek(); {
+	case c == '"':
+		r.readString()
+	case c == '{':
+		r.pos++
+		for i := 0; r.next('}', i); i++ {
+			r.readString()
+			r.expect(':')
+			r.skipValue(depth + 1)
+		}
+	case c == '[':
+		r.pos++
+		for i := 0; r.next(']', i); i++ {
+			r.skipValue(depth + 1)
+		}
+	case c == '-' || (c >= '0' && c <= '9'):
+		r.skipNumber()
+	case c == 't':
+		r.skipLiteral("true")
+	case c == 'f':
+		r.skipLiteral("false")
+	case c == 'n':
+		r.skipLiteral("null")
+	default:
+		r.fail()
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHasVersionMarker(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`{"v":2,"ts":1}`, true},
+		{"  \r\n{\"v\":2}", true},
+		{`{"v":3}`, true},
+		{"1737207000,2026-01-17:BLACK", false},
+		{"", false},
+		{`{"ts":1,"v":2}`, false},
+		{`{ "v": 2}`, false},
+	}
+
+	for _, tc := range tests {
+		if got := hasVersionMarker([]byte(tc.input)); got != tc.expected {
+			t.Errorf("hasVersionMarker(%q) = %v, want %v", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestParseScheduleJSON(t *testing.T) {
+	input := `{"v":2,"ts":1737207000,"rev":42,"premises":"1","jobs":[` +
+		`{"date":"2026-01-17","bin":"BLACK","note":"Put out by 7am"},` +
+		`{"date":"2026-01-31","bin":"green","premises":"2"},` +
+		`{"date":"2026-02-14","bin":"BROWN","extra":{"nested":[1,2,{"a":null}]}}]}`
+
+	res := parseScheduleResponse([]byte(input))
+	if res.Err != nil {
+		t.Fatalf("Err = %v", res.Err)
+	}
+	if res.Version != payloadJSONv2 {
+		t.Errorf("Version = %d, want %d", res.Version, payloadJSONv2)
+	}
+	if res.Timestamp != 1737207000 {
+		t.Errorf("Timestamp = %d, want 1737207000", res.Timestamp)
+	}
+	if res.Revision != 42 {
+		t.Errorf("Revision = %d, want 42", res.Revision)
+	}
+	if got := res.PremisesID(); got != "1" {
+		t.Errorf("PremisesID() = %q, want %q", got, "1")
+	}
+
+	expected := []struct {
+		job      BinJob
+		note     string
+		premises string
+	}{
+		{BinJob{Year: 2026, Month: 1, Day: 17, Bin: BinBlack}, "Put out by 7am", "1"},
+		{BinJob{Year: 2026, Month: 1, Day: 31, Bin: BinGreen}, "", "2"},
+		{BinJob{Year: 2026, Month: 2, Day: 14, Bin: BinBrown}, "", "1"},
+	}
+	jobs := getJobs()
+	if len(jobs) != len(expected) {
+		t.Fatalf("len(jobs) = %d, want %d", len(jobs), len(expected))
+	}
+	for i, want := range expected {
+		got := &jobs[i]
+		if got.Year != want.job.Year || got.Month != want.job.Month ||
+			got.Day != want.job.Day || got.Bin != want.job.Bin {
+			t.Errorf("job[%d] = %+v, want %+v", i, got, want.job)
+		}
+		if got.NoteString() != want.note {
+			t.Errorf("job[%d] note = %q, want %q", i, got.NoteString(), want.note)
+		}
+		if got.PremisesID() != want.premises {
+			t.Errorf("job[%d] premises = %q, want %q", i, got.PremisesID(), want.premises)
+		}
+	}
+}
+
+func TestParseScheduleJSONRejected(t *testing.T) {
+	input := `{"v":2,"ts":1,"jobs":[` +
+		`{"date":"2026-02-31","bin":"BLACK"},` +
+		`{"date":"2026-01-15","bin":"BLUE"},` +
+		`{"date":20260115,"bin":"GREEN"},` +
+		`"2026-01-22:GREEN",` +
+		`{"date":"2026-01-22","bin":"GREEN"}]}`
+
+	res := parseScheduleResponse([]byte(input))
+	if res.Err != nil {
+		t.Fatalf("Err = %v", res.Err)
+	}
+	if res.Accepted() != 1 {
+		t.Errorf("Accepted() = %d, want 1", res.Accepted())
+	}
+
+	expected := []struct {
+		entry  string
+		reason RejectReason
+	}{
+		{"2026-02-31:BLACK", RejectBadDate},
+		{"2026-01-15:BLUE", RejectUnknownBin},
+		{":GREEN", RejectMalformed},
+		{"", RejectMalformed},
+	}
+	rejected := res.RejectedEntries()
+	if len(rejected) != len(expected) {
+		t.Fatalf("len(RejectedEntries()) = %d, want %d", len(rejected), len(expected))
+	}
+	for i, want := range expected {
+		if got := rejected[i].String(); got != want.entry {
+			t.Errorf("rejected[%d] = %q, want %q", i, got, want.entry)
+		}
+		if rejected[i].Reason != want.reason {
+			t.Errorf("rejected[%d].Reason = %s, want %s", i, rejected[i].Reason, want.reason)
+		}
+	}
+}
+
+func TestParseScheduleJSONExceptions(t *testing.T) {
+	clearExceptions()
+	input := `{"v":2,"ts":1,"exceptions":[` +
+		`{"action":"move","date":"2026-01-31","bin":"GREEN","to":"2026-02-01"},` +
+		`{"action":"cancel","date":"2026-01-17","bin":"BLACK"},` +
+		`{"action":"add","date":"2026-02-20","bin":"BROWN"},` +
+		`{"action":"cancel","date":"2026-03-01","bin":"BLACK"},` +
+		`{"action":"swap","date":"2026-03-01","bin":"BLACK"}],` +
+		`"jobs":[{"date":"2026-01-17","bin":"BLACK"},{"date":"2026-01-31","bin":"GREEN"}]}`
+
+	res := parseScheduleResponse([]byte(input))
+	if res.Err != nil {
+		t.Fatalf("Err = %v", res.Err)
+	}
+	if res.Exceptions != 4 {
+		t.Errorf("Exceptions = %d, want 4", res.Exceptions)
+	}
+	if len(getExceptions()) != 4 {
+		t.Errorf("len(getExceptions()) = %d, want 4", len(getExceptions()))
+	}
+
+	// Exceptions are kept apart from the jobs
+	if len(getJobs()) != 2 {
+		t.Errorf("len(getJobs()) = %d, want 2", len(getJobs()))
+	}
+
+	rejected := res.RejectedEntries()
+	if len(rejected) != 1 {
+		t.Fatalf("len(RejectedEntries()) = %d, want 1", len(rejected))
+	}
+	if got := rejected[0].String(); got != "swap 2026-03-01:BLACK" || rejected[0].Reason != RejectMalformed {
+		t.Errorf("rejected[0] = %q (%s), want swap malformed", got, rejected[0].Reason)
+	}
+}
+
+func TestParseScheduleJSONInvalidKeepsCache(t *testing.T) {
+	parseScheduleResponse([]byte("1,2026-01-17:BLACK,2026-01-31:GREEN"))
+
+	tests := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"truncated", `{"v":2,"ts":1,"jobs":[{"date":"2026-02-01","bin":"BLACK"}`, errJSONSyntax},
+		{"trailing garbage", `{"v":2,"jobs":[]}x`, errJSONSyntax},
+		{"bad literal", `{"v":2,"x":nul}`, errJSONSyntax},
+		{"too deep", `{"v":2,"x":[[[[[[[[[]]]]]]]]]}`, errJSONSyntax},
+		{"future version", `{"v":3,"jobs":[]}`, errUnsupportedVersion},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := parseScheduleResponse([]byte(tc.input))
+			if res.Err != tc.err {
+				t.Errorf("Err = %v, want %v", res.Err, tc.err)
+			}
+			if len(getJobs()) != 2 {
+				t.Errorf("len(getJobs()) = %d, want cached 2", len(getJobs()))
+			}
+			if lastParseResult.Err != tc.err {
+				t.Errorf("lastParseResult.Err = %v, want %v", lastParseResult.Err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCopyJSONString(t *testing.T) {
+	tests := []struct {
+		raw      string
+		size     int
+		expected string
+	}{
+		{`plain`, 32, "plain"},
+		{`say \"hi\"`, 32, `say "hi"`},
+		{`a\\b\/c`, 32, `a\b/c`},
+		{`line\nbreak`, 32, "line\nbreak"},
+		{`caf\u00e9!`, 32, "caf?!"},
+		{`truncated note`, 9, "truncated"},
+	}
+
+	for _, tc := range tests {
+		dst := make([]byte, tc.size)
+		n := copyJSONString(dst, []byte(tc.raw))
+		if got := string(dst[:n]); got != tc.expected {
+			t.Errorf("copyJSONString(%q) = %q, want %q", tc.raw, got, tc.expected)
+		}
+	}
+}
+
+func TestJSONReaderSkipValue(t *testing.T) {
+	valid := []string{
+		`{}`, `[]`, `"s"`, `-1.5e3`, `true`, `false`, `null`,
+		`{"a":[1,{"b":"c\"d"}],"e":null}`,
+		" [ 1 , 2 ] ",
+	}
+	for _, s := range valid {
+		r := jsonReader{data: []byte(s)}
+		r.skipValue(0)
+		if r.err != nil {
+			t.Errorf("skipValue(%q) err = %v", s, r.err)
+		}
+	}
+
+	invalid := []string{`{`, `[1,]`, `{"a"}`, `{"a":}`, `tru`, `"open`, `}`, "\"ctl\x01\""}
+	for _, s := range invalid {
+		r := jsonReader{data: []byte(s)}
+		r.skipValue(0)
+		if r.err == nil {
+			t.Errorf("skipValue(%q) expected error", s)
+		}
+	}
+}
+
+func TestChunkedScheduleRestored(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+	cached := `{"v":2,"ts":1,"jobs":[{"date":"2026-01-03","bin":"BROWN"}],` +
+		`"exceptions":[{"action":"move","date":"2026-01-03","bin":"BROWN","to":"2026-01-04"}]}`
+	isCached := func() bool {
+		jobs := getJobs()
+		return len(jobs) == 1 && jobs[0].Bin == BinBrown && len(getExceptions()) == 1
+	}
+
+	// The final chunk never arrives: the cached schedule comes back
+	parseScheduleResponse([]byte(cached))
+	parseScheduleResponse([]byte(`{"v":2,"ts":2,"chunk":1,"chunks":2,"jobs":[{"date":"2026-01-17","bin":"BLACK"}]}`))
+	if isCached() {
+		t.Fatal("chunk 1 did not replace the cached schedule")
+	}
+	if !abortChunks() || !isCached() {
+		t.Errorf("after abortChunks: jobs %+v, %d exceptions; want the cached schedule", getJobs(), len(getExceptions()))
+	}
+	if abortChunks() {
+		t.Error("abortChunks() twice restored again")
+	}
+
+	// A chunk out of sequence, or the start of another schedule that
+	// abandons this one, restores it too
+	for _, next := range []string{
+		`{"v":2,"ts":3,"chunk":3,"chunks":3,"jobs":[]}`,
+		`{"v":2,"ts":3,"exceptions":[]}`,
+	} {
+		parseScheduleResponse([]byte(`{"v":2,"ts":2,"chunk":1,"chunks":3,"jobs":[{"date":"2026-01-17","bin":"BLACK"}]}`))
+		parseScheduleResponse([]byte(next))
+		if jobs := getJobs(); len(jobs) != 1 || jobs[0].Bin != BinBrown {
+			t.Errorf("after %s: jobs %+v, want the cached brown job", next, jobs)
+		}
+	}
+
+	// A CSV schedule abandons it: the remaining chunks are not added to
+	// the CSV's jobs
+	parseScheduleResponse([]byte(`{"v":2,"ts":2,"chunk":1,"chunks":2,"jobs":[{"date":"2026-01-17","bin":"BLACK"}]}`))
+	parseScheduleResponse([]byte("3,2026-01-24:GREEN"))
+	res := parseScheduleResponse([]byte(`{"v":2,"ts":2,"chunk":2,"chunks":2,"jobs":[{"date":"2026-01-31","bin":"GREEN"}]}`))
+	if jobs := getJobs(); res.Err != errChunkSequence || len(jobs) != 1 || jobs[0].Day != 24 {
+		t.Errorf("chunk after CSV: Err = %v, jobs %+v; want %v and the CSV's job", res.Err, jobs, errChunkSequence)
+	}
+	parseScheduleResponse([]byte(cached))
+
+	// A complete schedule is kept
+	parseScheduleResponse([]byte(`{"v":2,"ts":2,"chunk":1,"chunks":2,"jobs":[{"date":"2026-01-17","bin":"BLACK"}]}`))
+	parseScheduleResponse([]byte(`{"v":2,"ts":2,"chunk":2,"chunks":2,"jobs":[{"date":"2026-01-31","bin":"GREEN"}]}`))
+	if abortChunks() || len(getJobs()) != 2 || len(getExceptions()) != 0 {
+		t.Errorf("complete schedule: jobs %+v, %d exceptions; want black and green", getJobs(), len(getExceptions()))
+	}
+}
+
+func TestParseScheduleJSONChunks(t *testing.T) {
+	clearExceptions()
+	parseScheduleResponse([]byte("1,2026-01-03:BROWN"))
+
+	chunks := []string{
+		`{"v":2,"ts":1,"chunk":1,"chunks":3,"jobs":[{"date":"2026-01-17","bin":"BLACK"},{"date":"2026-01-17","bin":"NOPE"}]}`,
+		`{"v":2,"ts":2,"chunk":2,"chunks":3,"jobs":[{"date":"2026-01-31","bin":"GREEN"}],` +
+			`"exceptions":[{"action":"cancel","date":"2026-01-17","bin":"BLACK"}]}`,
+		`{"v":2,"ts":3,"chunk":3,"chunks":3,"exceptions":[{"action":"add","date":"2026-02-20","bin":"BROWN"}]}`,
+	}
+	for i, chunk := range chunks {
+		res := parseScheduleResponse([]byte(chunk))
+		if res.Err != nil {
+			t.Fatalf("chunk %d: Err = %v", i+1, res.Err)
+		}
+		if res.Complete() != (i == len(chunks)-1) {
+			t.Errorf("chunk %d: Complete() = %v", i+1, res.Complete())
+		}
+	}
+
+	// Chunk 1 replaced the cached job, later chunks added to it
+	jobs := getJobs()
+	if len(jobs) != 2 || jobs[0].Bin != BinBlack || jobs[1].Bin != BinGreen {
+		t.Errorf("jobs = %+v, want black and green", jobs)
+	}
+	if len(getExceptions()) != 2 || lastParseResult.Exceptions != 2 {
+		t.Errorf("exceptions = %d (result %d), want 2", len(getExceptions()), lastParseResult.Exceptions)
+	}
+	if lastParseResult.RejectedCount != 1 || lastParseResult.Timestamp != 3 {
+		t.Errorf("result = %d rejected, ts %d; want 1 rejected from chunk 1, ts 3",
+			lastParseResult.RejectedCount, lastParseResult.Timestamp)
+	}
+
+	tests := []struct {
+		name  string
+		input []string
+	}{
+		{"missing chunk", []string{`{"v":2,"chunk":1,"chunks":3,"jobs":[]}`, `{"v":2,"chunk":3,"chunks":3,"jobs":[]}`}},
+		{"no first chunk", []string{`{"v":2,"chunk":2,"chunks":2,"jobs":[]}`}},
+		{"total changed", []string{`{"v":2,"chunk":1,"chunks":3,"jobs":[]}`, `{"v":2,"chunk":2,"chunks":4,"jobs":[]}`}},
+		{"after the last", []string{`{"v":2,"chunk":1,"chunks":1,"jobs":[]}`, `{"v":2,"chunk":2,"chunks":1,"jobs":[]}`}},
+		{"zero", []string{`{"v":2,"chunk":0,"chunks":2,"jobs":[]}`}},
+		{"too many", []string{`{"v":2,"chunk":1,"chunks":17,"jobs":[]}`}},
+	}
+	for _, tc := range tests {
+		var res ParseResult
+		for _, input := range tc.input {
+			res = parseScheduleResponse([]byte(input))
+		}
+		if res.Err != errChunkSequence {
+			t.Errorf("%s: Err = %v, want %v", tc.name, res.Err, errChunkSequence)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
 
 func TestAtoi2(t *testing.T) {
 	tests := []struct {
@@ -72,6 +77,11 @@ func TestParseBinType(t *testing.T) {
 		{"RED", BinUnknown},
 		{"G", BinUnknown},
 		{"GREE", BinUnknown},
+		// Near-misses must not be guessed
+		{"BLUE", BinUnknown},
+		{"BROWNISH", BinUnknown},
+		{"GARDEN", BinUnknown},
+		{"BLACKS", BinUnknown},
 	}
 
 	for _, tc := range tests {
@@ -84,11 +94,11 @@ func TestParseBinType(t *testing.T) {
 
 func TestParseScheduleResponse(t *testing.T) {
 	tests := []struct {
-		name           string
-		input          string
-		expectedCount  int
-		expectedTS     int64
-		expectedJobs   []BinJob
+		name          string
+		input         string
+		expectedCount int
+		expectedTS    int64
+		expectedJobs  []BinJob
 	}{
 		{
 			name:          "empty",
@@ -153,18 +163,27 @@ func TestParseScheduleResponse(t *testing.T) {
 				{Year: 2026, Month: 1, Day: 22, Bin: BinGreen},
 			},
 		},
+		{
+			name:          "impossible date skipped",
+			input:         "1234567890,2026-02-31:BLACK,2028-02-29:GREEN",
+			expectedCount: 1,
+			expectedTS:    1234567890,
+			expectedJobs: []BinJob{
+				{Year: 2028, Month: 2, Day: 29, Bin: BinGreen},
+			},
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			count := parseScheduleResponse([]byte(tc.input))
+			res := parseScheduleResponse([]byte(tc.input))
 
-			if count != tc.expectedCount {
-				t.Errorf("count = %d, want %d", count, tc.expectedCount)
+			if res.Accepted() != tc.expectedCount {
+				t.Errorf("Accepted() = %d, want %d", res.Accepted(), tc.expectedCount)
 			}
 
-			if parsedTimestamp != tc.expectedTS {
-				t.Errorf("parsedTimestamp = %d, want %d", parsedTimestamp, tc.expectedTS)
+			if res.Timestamp != tc.expectedTS {
+				t.Errorf("Timestamp = %d, want %d", res.Timestamp, tc.expectedTS)
 			}
 
 			jobs := getJobs()
@@ -185,15 +204,186 @@ func TestParseScheduleResponse(t *testing.T) {
 }
 
 func TestParseScheduleResponseMaxJobs(t *testing.T) {
-	// Build input with more than maxJobs entries
+	// Build input with more than maxJobs daily entries, latest first
+	const total = maxJobs + 5
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
 	input := "1234567890"
-	for i := 0; i < 20; i++ {
-		input += ",2026-01-15:BLACK"
+	for i := total - 1; i >= 0; i-- {
+		input += "," + start.AddDate(0, 0, i).Format("2006-01-02") + ":BLACK"
+	}
+
+	res := parseScheduleResponse([]byte(input))
+
+	if res.Accepted() != maxJobs {
+		t.Errorf("Accepted() = %d, want %d (maxJobs)", res.Accepted(), maxJobs)
+	}
+	if !res.Truncated {
+		t.Error("Truncated = false, want true")
+	}
+	if res.Dropped != total-maxJobs {
+		t.Errorf("Dropped = %d, want %d", res.Dropped, total-maxJobs)
+	}
+	if res.RejectedCount != 0 {
+		t.Errorf("RejectedCount = %d, want 0", res.RejectedCount)
+	}
+
+	// The earliest collections are kept, in date order
+	jobs := getJobs()
+	last := start.AddDate(0, 0, maxJobs-1)
+	if j := jobs[0]; j.Year != 2026 || j.Month != 1 || j.Day != 1 {
+		t.Errorf("first job = %d-%d-%d, want 2026-1-1", j.Year, j.Month, j.Day)
+	}
+	if j := jobs[maxJobs-1]; int(j.Year) != last.Year() || time.Month(j.Month) != last.Month() || int(j.Day) != last.Day() {
+		t.Errorf("last job = %d-%d-%d, want %s", j.Year, j.Month, j.Day, last.Format("2006-01-02"))
+	}
+}
+
+func TestParseScheduleResponseSortedUnique(t *testing.T) {
+	res := parseScheduleResponse([]byte("1,2026-01-31:GREEN,2026-01-17:BLACK,2026-01-31:BLACK,2026-01-17:black,2026-01-31:GREEN"))
+
+	if res.Duplicates != 2 {
+		t.Errorf("Duplicates = %d, want 2", res.Duplicates)
+	}
+	expected := []BinJob{
+		{Year: 2026, Month: 1, Day: 17, Bin: BinBlack},
+		{Year: 2026, Month: 1, Day: 31, Bin: BinGreen},
+		{Year: 2026, Month: 1, Day: 31, Bin: BinBlack},
+	}
+	jobs := getJobs()
+	if len(jobs) != len(expected) {
+		t.Fatalf("len(jobs) = %d, want %d", len(jobs), len(expected))
+	}
+	for i, want := range expected {
+		if jobs[i] != want {
+			t.Errorf("job[%d] = %+v, want %+v", i, jobs[i], want)
+		}
+	}
+}
+
+func TestParseBinTypeAliases(t *testing.T) {
+	bins, err := config.WithBinAliases(config.DefaultBins, []config.BinAlias{
+		{Alias: "RECYCLING", Bin: "green"},
+		{Alias: "GENERAL", Bin: "black"},
+		{Alias: "GARDEN WASTE", Bin: "brown"},
+	})
+	if err != nil {
+		t.Fatalf("WithBinAliases: %v", err)
+	}
+	if err := loadBinRegistry(bins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	tests := []struct {
+		input    string
+		expected BinType
+	}{
+		{"RECYCLING", BinGreen},
+		{"recycling", BinGreen},
+		{"General", BinBlack},
+		{"GARDEN WASTE", BinBrown},
+		{"GREEN", BinGreen},
+		// Aliases match exactly, never by prefix
+		{"GARDEN", BinUnknown},
+		{"RECYCLE", BinUnknown},
 	}
 
-	count := parseScheduleResponse([]byte(input))
+	for _, tc := range tests {
+		got := parseBinType([]byte(tc.input))
+		if got != tc.expected {
+			t.Errorf("parseBinType(%q) = %d, want %d", tc.input, got, tc.expected)
+		}
+	}
+}
+
+func TestParseScheduleResponseRejected(t *testing.T) {
+	input := "1234567890,2026-01-15:BLUE,2026-01-22:GREEN,2026-02-31:BLACK," +
+		"15/01/2026:BROWN,2026-13-01:GREEN,2026-0a-05:GREEN,2026-02-05:A," +
+		"2026-02-12:B,2026-02-19:C,2026-02-26:D"
+
+	res := parseScheduleResponse([]byte(input))
+	if res.Accepted() != 1 {
+		t.Errorf("Accepted() = %d, want 1", res.Accepted())
+	}
+	if res.RejectedCount != 9 {
+		t.Errorf("RejectedCount = %d, want 9", res.RejectedCount)
+	}
+	if res.Truncated {
+		t.Error("Truncated = true, want false")
+	}
 
-	if count != maxJobs {
-		t.Errorf("count = %d, want %d (maxJobs)", count, maxJobs)
+	expected := []struct {
+		entry  string
+		reason RejectReason
+	}{
+		{"2026-01-15:BLUE", RejectUnknownBin},
+		{"2026-02-31:BLACK", RejectBadDate},
+		{"15/01/2026:BROWN", RejectMalformed},
+		{"2026-13-01:GREEN", RejectBadDate},
+		{"2026-0a-05:GREEN", RejectMalformed},
+		{"2026-02-05:A", RejectUnknownBin},
+		{"2026-02-12:B", RejectUnknownBin},
+		{"2026-02-19:C", RejectUnknownBin},
+	}
+	rejected := res.RejectedEntries()
+	if len(rejected) != len(expected) {
+		t.Fatalf("len(RejectedEntries()) = %d, want %d", len(rejected), len(expected))
+	}
+	for i, want := range expected {
+		if got := rejected[i].String(); got != want.entry {
+			t.Errorf("rejected[%d] = %q, want %q", i, got, want.entry)
+		}
+		if rejected[i].Reason != want.reason {
+			t.Errorf("rejected[%d].Reason = %s, want %s", i, rejected[i].Reason, want.reason)
+		}
+	}
+
+	// The result is kept for the console
+	if lastParseResult.RejectedCount != 9 {
+		t.Errorf("lastParseResult.RejectedCount = %d, want 9", lastParseResult.RejectedCount)
+	}
+
+	// A clean response clears the report
+	res = parseScheduleResponse([]byte("1234567890,2026-01-22:GREEN"))
+	if res.RejectedCount != 0 || len(res.RejectedEntries()) != 0 {
+		t.Errorf("RejectedCount = %d after clean parse, want 0", res.RejectedCount)
+	}
+}
+
+func TestParseScheduleResponseLongEntry(t *testing.T) {
+	res := parseScheduleResponse([]byte("1234567890,2026-01-15:SOMETHINGVERYLONGINDEED"))
+	if res.RejectedCount != 1 {
+		t.Fatalf("RejectedCount = %d, want 1", res.RejectedCount)
+	}
+	if got := res.Rejected[0].String(); got != "2026-01-15:SOMETHINGVERY" {
+		t.Errorf("rejected entry = %q, want truncated to 24 bytes", got)
+	}
+}
+
+func TestValidDate(t *testing.T) {
+	tests := []struct {
+		year, month, day int
+		expected         bool
+	}{
+		{2026, 1, 31, true},
+		{2026, 2, 28, true},
+		{2026, 2, 29, false},
+		{2028, 2, 29, true},
+		{2100, 2, 29, false},
+		{2000, 2, 29, true},
+		{2026, 2, 31, false},
+		{2026, 4, 31, false},
+		{2026, 12, 31, true},
+		{2026, 0, 1, false},
+		{2026, 13, 1, false},
+		{2026, 1, 0, false},
+		{0, 1, 1, false},
+	}
+
+	for _, tc := range tests {
+		got := validDate(tc.year, tc.month, tc.day)
+		if got != tc.expected {
+			t.Errorf("validDate(%d, %d, %d) = %v, want %v", tc.year, tc.month, tc.day, got, tc.expected)
+		}
 	}
 }
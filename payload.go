@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// Schedule messages larger than the response buffer. The MQTT payload is
+// read in pieces: a CSV payload of any length is parsed while it is read,
+// a versioned one must fit the buffer and is otherwise discarded and
+// reported. Larger v2 schedules are split into chunks by the bridge (see
+// parseScheduleJSON).
+const (
+	payloadReadSize   = 64 // Bytes read at a time beyond the response buffer
+	maxCSVEntryLen    = 64 // Longest CSV entry kept when split across reads
+	maxScheduleChunks = 16 // Most chunks in one v2 schedule
+)
+
+// Large payload errors
+var (
+	errPayloadTooLarge = errors.New("schedule: payload too large")
+	errChunkSequence   = errors.New("schedule: chunk out of sequence")
+	errPayloadCut      = errors.New("schedule: payload cut off")
+)
+
+// scheduleBackup keeps the jobs and payload exceptions of one premises
+// while a schedule that replaces them arrives in pieces (the chunks of a
+// v2 schedule, or a CSV payload parsed as it is read), so a schedule that
+// stops short is undone instead of leaving a truncated one
+type scheduleBackup struct {
+	premises       int // -1: nothing kept
+	jobs           [maxJobs]BinJob
+	jobCount       int
+	exceptions     [maxExceptions]ScheduleException
+	exceptionCount int
+}
+
+// replacedSchedule is the schedule being replaced piece by piece
+var replacedSchedule = scheduleBackup{premises: -1}
+
+// save keeps the schedule of premises p before it is replaced. One that is
+// already kept for p stays (a chunked schedule that starts over still
+// restores the original); one kept for another premises was abandoned
+// and is restored first.
+func (b *scheduleBackup) save(p int) {
+	if b.premises == p {
+		return
+	}
+	b.restore()
+	b.premises = p
+	b.jobCount = 0
+	for i := 0; i < jobCount; i++ {
+		if jobPremises(&jobStorage[i]) == p {
+			b.jobs[b.jobCount] = jobStorage[i]
+			b.jobCount++
+		}
+	}
+	b.exceptionCount = 0
+	for i := 0; i < exceptionCount; i++ {
+		if ex := &scheduleExceptions[i]; !ex.Manual && ex.premises() == p {
+			b.exceptions[b.exceptionCount] = *ex
+			b.exceptionCount++
+		}
+	}
+}
+
+// discard drops the kept schedule of premises p once its replacement is
+// complete
+func (b *scheduleBackup) discard(p int) {
+	if b.premises == p {
+		b.premises = -1
+	}
+}
+
+// restore puts the kept schedule back in place of what arrived of its
+// replacement. Returns whether there was one.
+func (b *scheduleBackup) restore() bool {
+	p := b.premises
+	if p < 0 {
+		return false
+	}
+	b.premises = -1
+	clearPremisesJobs(p)
+	for i := 0; i < b.jobCount; i++ {
+		insertJob(&b.jobs[i])
+	}
+	clearPayloadExceptions(p)
+	for i := 0; i < b.exceptionCount; i++ {
+		addException(&b.exceptions[i])
+	}
+	return true
+}
+
+// payloadReader reads an MQTT payload to its end. A single Read may return
+// only part of a message, so reads are repeated until the payload is done.
+type payloadReader struct {
+	r     io.Reader
+	total int   // Bytes read so far
+	eof   bool  // The whole payload has been read
+	err   error // Read error other than io.EOF
+}
+
+// read fills buf with the next part of the payload and returns its length
+func (p *payloadReader) read(buf []byte) int {
+	n := 0
+	for n < len(buf) && !p.eof {
+		k, err := p.r.Read(buf[n:])
+		n += k
+		switch {
+		case err == io.EOF || (err == nil && k == 0):
+			p.eof = true
+		case err != nil:
+			p.err = err
+			p.eof = true
+		}
+	}
+	p.total += n
+	return n
+}
+
+// fits reads ahead one piece after buf was filled and reports whether the
+// payload ended there; otherwise the piece read is returned in more
+func (p *payloadReader) fits(scratch []byte) (more []byte, fits bool) {
+	if p.eof {
+		return nil, true
+	}
+	k := p.read(scratch)
+	return scratch[:k], k == 0
+}
+
+// drain reads and discards the rest of the payload
+func (p *payloadReader) drain(scratch []byte) {
+	for !p.eof {
+		p.read(scratch)
+	}
+}
+
+// readMessage reads a whole message from r into buf and returns the bytes
+// stored and the payload length. A message longer than buf is read to the
+// end and discarded (total > n), so it cannot be mistaken for a shorter one.
+func readMessage(r io.Reader, buf []byte) (n, total int, err error) {
+	var scratch [payloadReadSize]byte
+	pr := payloadReader{r: r}
+	n = pr.read(buf)
+	if _, fits := pr.fits(scratch[:]); !fits {
+		pr.drain(scratch[:])
+	}
+	return n, pr.total, pr.err
+}
+
+// responseCSV parses CSV payloads that do not fit the response buffer
+var responseCSV csvParser
+
+// receiveSchedule reads a schedule message for premises p from r into buf.
+// A message that fits is left in buf (n bytes) to be parsed outside the
+// MQTT callback. A larger CSV message is parsed while it is read (parsed
+// is set, the result is in lastParseResult); a larger versioned message,
+// or any large message with p < 0 (no request pending), is read to the end
+// and discarded. total is the payload length.
+func receiveSchedule(r io.Reader, buf []byte, p int) (n, total int, parsed bool, err error) {
+	var scratch [payloadReadSize]byte
+	pr := payloadReader{r: r}
+	n = pr.read(buf)
+	more, fits := pr.fits(scratch[:])
+	if fits || pr.err != nil {
+		return n, pr.total, false, pr.err
+	}
+
+	if p < 0 || hasVersionMarker(buf[:n]) {
+		pr.drain(scratch[:])
+		return n, pr.total, false, pr.err
+	}
+
+	responseCSV.begin(p)
+	responseCSV.write(buf[:n])
+	responseCSV.write(more)
+	for !pr.eof {
+		k := pr.read(scratch[:])
+		responseCSV.write(scratch[:k])
+	}
+	if pr.err != nil {
+		// Cut off part way: keep the schedule it was replacing
+		replacedSchedule.restore()
+		lastParseResult = ParseResult{Version: payloadCSV, Request: p, Err: errPayloadCut}
+		return n, pr.total, true, pr.err
+	}
+	responseCSV.finish()
+	return n, pr.total, true, nil
+}
+
+// parseReceived returns the parse result of a message taken by
+// receiveSchedule: data is what was kept of it in the buffer. A discarded
+// message is recorded as errPayloadTooLarge, leaving the cached jobs.
+func parseReceived(data []byte, total int, parsed bool, p int) ParseResult {
+	switch {
+	case parsed:
+		return lastParseResult
+	case total > len(data):
+		lastParseResult = ParseResult{Version: payloadJSONv2, Request: p, Err: errPayloadTooLarge}
+		if !hasVersionMarker(data) {
+			lastParseResult.Version = payloadCSV
+		}
+		return lastParseResult
+	}
+	return parsePremisesResponse(data, p)
+}
+
+// csvParser parses a legacy CSV payload incrementally: the payload may be
+// passed to write in pieces split anywhere, entries included, so its size
+// is not limited by a buffer. Entries longer than maxCSVEntryLen are cut
+// (and then rejected as malformed or with an unknown bin).
+type csvParser struct {
+	res       ParseResult
+	entry     [maxCSVEntryLen]byte
+	entryLen  int
+	timestamp bool // Still reading the leading timestamp digits
+}
+
+// begin starts a payload answering a request for premises p. Its jobs are
+// replaced as the payload is read; the old ones are kept until finish in
+// case the payload is cut off (see receiveSchedule). A chunked schedule in
+// progress is abandoned, so its later chunks cannot add to this one.
+func (c *csvParser) begin(p int) {
+	abortChunks()
+	replacedSchedule.save(p)
+	clearPremisesJobs(p)
+	c.res = ParseResult{Version: payloadCSV, Request: p}
+	if numPremises() > 1 {
+		c.res.PremisesLen = uint8(copy(c.res.Premises[:], premisesID(p)))
+	}
+	c.entryLen = 0
+	c.timestamp = true
+}
+
+// write parses the next piece of the payload
+func (c *csvParser) write(data []byte) {
+	for _, b := range data {
+		if c.timestamp {
+			// Unix timestamp from the beginning, then an optional comma
+			if b >= '0' && b <= '9' {
+				c.res.Timestamp = c.res.Timestamp*10 + int64(b-'0')
+				continue
+			}
+			c.timestamp = false
+			if b == ',' {
+				continue
+			}
+		}
+		if b == ',' {
+			c.endEntry()
+			continue
+		}
+		if c.entryLen < len(c.entry) {
+			c.entry[c.entryLen] = b
+			c.entryLen++
+		}
+	}
+}
+
+// endEntry parses the entry collected so far (empty entries are ignored)
+func (c *csvParser) endEntry() {
+	if c.entryLen > 0 {
+		parseScheduleEntry(&c.res, c.entry[:c.entryLen])
+	}
+	c.entryLen = 0
+}
+
+// finish parses the last entry and returns the result, which is also kept
+// in lastParseResult
+func (c *csvParser) finish() ParseResult {
+	c.endEntry()
+	replacedSchedule.discard(c.res.Request)
+	c.res.Jobs = countPremisesJobs(c.res.Request)
+	lastParseResult = c.res
+	return c.res
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"openenterprise/bindicator/config"
+)
+
+// testCSVSchedule returns a CSV payload with n weekly black bin entries
+func testCSVSchedule(n int) string {
+	var b strings.Builder
+	b.WriteString("1737207000")
+	for i := 0; i < n; i++ {
+		b.WriteString(",2026-")
+		b.WriteString([]string{"01", "02", "03", "04", "05", "06"}[i/4])
+		b.WriteString("-")
+		b.WriteString([]string{"03", "10", "17", "24"}[i%4])
+		b.WriteString(":BLACK")
+	}
+	return b.String()
+}
+
+func TestCSVParserSplit(t *testing.T) {
+	input := testCSVSchedule(12) + ",2026-09-31:GREEN,,garbage"
+	want := parseScheduleResponse([]byte(input))
+
+	// Any split of the payload gives the same result
+	for _, size := range []int{1, 2, 7, 16, 64} {
+		var c csvParser
+		c.begin(0)
+		for data := []byte(input); len(data) > 0; {
+			n := min(size, len(data))
+			c.write(data[:n])
+			data = data[n:]
+		}
+		got := c.finish()
+		if got.Timestamp != want.Timestamp || got.Accepted() != want.Accepted() || got.RejectedCount != want.RejectedCount {
+			t.Errorf("pieces of %d: ts %d, %d accepted, %d rejected; want %d, %d, %d", size,
+				got.Timestamp, got.Accepted(), got.RejectedCount,
+				want.Timestamp, want.Accepted(), want.RejectedCount)
+		}
+	}
+}
+
+func TestReceiveSchedule(t *testing.T) {
+	var buf [128]byte
+	small := "1737207000,2026-01-17:BLACK"
+	exact := `{"v":2,"ts":1,"jobs":[]` + strings.Repeat(" ", len(buf)-24) + `}`
+	large := testCSVSchedule(20)
+	largeJSON := `{"v":2,"ts":1,"jobs":[` + strings.Repeat(`{"date":"2026-01-17","bin":"BLACK"},`, 8) + `]}`
+
+	tests := []struct {
+		name       string
+		input      string
+		p          int
+		wantN      int
+		wantParsed bool
+		wantErr    error // Err of parseReceived
+		wantJobs   int
+	}{
+		{"fits", small, 0, len(small), false, nil, 0},
+		{"exact fit", exact, 0, len(buf), false, nil, 0},
+		{"large csv streamed", large, 0, len(buf), true, nil, 20},
+		{"large json", largeJSON, 0, len(buf), false, errPayloadTooLarge, 0},
+		{"large without request", large, -1, len(buf), false, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lastParseResult = ParseResult{}
+			r := iotest.HalfReader(strings.NewReader(tt.input))
+			n, total, parsed, err := receiveSchedule(r, buf[:], tt.p)
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if n != tt.wantN || total != len(tt.input) || parsed != tt.wantParsed {
+				t.Fatalf("n, total, parsed = %d, %d, %v; want %d, %d, %v",
+					n, total, parsed, tt.wantN, len(tt.input), tt.wantParsed)
+			}
+			if tt.p < 0 {
+				return
+			}
+			res := parseReceived(buf[:n], total, parsed, tt.p)
+			if res.Err != tt.wantErr || lastParseResult.Err != tt.wantErr {
+				t.Errorf("Err = %v (last %v), want %v", res.Err, lastParseResult.Err, tt.wantErr)
+			}
+			if parsed && res.Accepted() != tt.wantJobs {
+				t.Errorf("Accepted() = %d, want %d", res.Accepted(), tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestReceiveScheduleNonceless(t *testing.T) {
+	var buf [128]byte
+	perDevice := config.DefaultTopics()
+	perDevice.Response = "bindicator/{client}/response"
+	useTestTopics(t, perDevice, "kitchen")
+	parseScheduleResponse([]byte("1,2026-01-03:BROWN"))
+	want := lastParseResult
+
+	// An oversize CSV response is rejected for its missing nonce before
+	// any of it is parsed
+	large := testCSVSchedule(20)
+	n, total, parsed, err := receiveSchedule(strings.NewReader(large), buf[:], streamPremises(-1, 0))
+	if err != nil || parsed || total != len(large) {
+		t.Fatalf("n, total, parsed, err = %d, %d, %v, %v; want the message discarded", n, total, parsed, err)
+	}
+	if responseNonceMatches(buf[:n]) {
+		t.Error("nonceless response accepted")
+	}
+	if jobs := getJobs(); len(jobs) != 1 || jobs[0].Bin != BinBrown {
+		t.Errorf("jobs = %+v, want the cached brown job", jobs)
+	}
+	if lastParseResult.Accepted() != want.Accepted() || lastParseResult.Timestamp != want.Timestamp {
+		t.Errorf("lastParseResult = %+v, want %+v", lastParseResult, want)
+	}
+
+	// A pushed schedule needs no nonce, and on the shared topic nonceless
+	// responses are streamed into the pending request's premises
+	if streamPremises(0, -1) != 0 {
+		t.Errorf("streamPremises(0, -1) = %d, want 0", streamPremises(0, -1))
+	}
+	useTestTopics(t, config.DefaultTopics(), "kitchen")
+	if streamPremises(-1, 0) != 0 {
+		t.Errorf("shared topic: streamPremises(-1, 0) = %d, want 0", streamPremises(-1, 0))
+	}
+}
+
+func TestReceiveScheduleCutOff(t *testing.T) {
+	var buf [128]byte
+	parseScheduleResponse([]byte("1,2026-01-03:BROWN"))
+
+	// The connection drops part way through a streamed CSV schedule
+	large := testCSVSchedule(20)
+	r := io.MultiReader(strings.NewReader(large[:200]), iotest.ErrReader(errors.New("connection reset")))
+	n, total, parsed, err := receiveSchedule(r, buf[:], 0)
+	if err == nil || !parsed {
+		t.Fatalf("err, parsed = %v, %v; want an error after parsing", err, parsed)
+	}
+	if res := parseReceived(buf[:n], total, parsed, 0); res.Err != errPayloadCut {
+		t.Errorf("Err = %v, want %v", res.Err, errPayloadCut)
+	}
+	if jobs := getJobs(); len(jobs) != 1 || jobs[0].Bin != BinBrown {
+		t.Errorf("jobs = %+v, want the cached brown job", jobs)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+
+	"openenterprise/bindicator/config"
+)
+
+// maxPremises is the number of premises the registry can hold
+const maxPremises = config.MaxPremises
+
+// premisesDef holds the registry entry for one premises
+type premisesDef struct {
+	name    string              // lower-case name for logs and the console
+	id      string              // premises ID sent in the schedule request
+	pins    [maxBins + 1]uint8  // LED pin per bin, indexed by BinType
+	metrics [maxBins + 1]string // telemetry gauge name per bin LED
+}
+
+// Premises registry (index 0 is the first premises in premises.text)
+var (
+	premisesDefs  [maxPremises]premisesDef
+	premisesCount int
+)
+
+// loadPremises replaces the premises registry. Every premises needs one
+// LED pin per registered bin (a premises without pins uses the bin
+// channels), and no pin may drive two LEDs. Must be called after the bin
+// registry is loaded.
+func loadPremises(list []config.Premises) error {
+	if len(list) == 0 {
+		return errors.New("premises: empty registry")
+	}
+	if len(list) > maxPremises {
+		return errors.New("premises: too many premises")
+	}
+	var defs [maxPremises]premisesDef
+	var used [32]bool
+	for i := range list {
+		p := &list[i]
+		if len(p.ID) > maxPremisesLen {
+			return errors.New("premises: id " + p.ID + " too long")
+		}
+		if p.LEDs != nil && len(p.LEDs) != numBins() {
+			return errors.New("premises: " + p.Name + " needs one LED pin per bin")
+		}
+		d := &defs[i]
+		d.name, d.id = toLowerASCII(p.Name), p.ID
+		for bt := BinType(1); int(bt) <= numBins(); bt++ {
+			pin := bt.Channel()
+			if p.LEDs != nil {
+				pin = p.LEDs[bt-1]
+			}
+			if int(pin) >= len(used) || used[pin] {
+				return errors.New("premises: " + p.Name + " LED pin for " + bt.String() + " already in use")
+			}
+			used[pin] = true
+			d.pins[bt] = pin
+
+			// The first premises keeps the single-premises gauge names
+			d.metrics[bt] = bt.def().metric
+			if i > 0 {
+				d.metrics[bt] = "led." + d.name + "." + bt.String()
+			}
+		}
+	}
+	premisesDefs = defs
+	premisesCount = len(list)
+	return nil
+}
+
+// numPremises returns the number of configured premises.
+// Valid premises indexes are 0..numPremises()-1.
+func numPremises() int {
+	return premisesCount
+}
+
+// premisesName returns the name of premises p
+func premisesName(p int) string {
+	return premisesDefs[p].name
+}
+
+// premisesID returns the premises ID of premises p
+func premisesID(p int) string {
+	return premisesDefs[p].id
+}
+
+// premisesPin returns the LED pin for a bin at premises p
+func premisesPin(p int, bt BinType) uint8 {
+	return premisesDefs[p].pins[bt]
+}
+
+// premisesMetric returns the telemetry gauge name for a bin LED at
+// premises p
+func premisesMetric(p int, bt BinType) string {
+	return premisesDefs[p].metrics[bt]
+}
+
+// premisesPins formats the LED pins of premises p as "2,3,4"
+func premisesPins(p int) string {
+	var buf [maxBins * 3]byte
+	n := 0
+	for bt := BinType(1); int(bt) <= numBins(); bt++ {
+		if bt > 1 {
+			buf[n] = ','
+			n++
+		}
+		pin := premisesDefs[p].pins[bt] // At most 29
+		if pin >= 10 {
+			buf[n] = '0' + pin/10
+			n++
+		}
+		buf[n] = '0' + pin%10
+		n++
+	}
+	return string(buf[:n])
+}
+
+// premisesIndex returns the premises a premises ID belongs to, or -1 if it
+// is not configured. With a single premises everything belongs to it (so
+// payloads naming any premises keep working), and jobs without an ID belong
+// to the first.
+func premisesIndex(id []byte) int {
+	if premisesCount == 1 || len(id) == 0 {
+		return 0
+	}
+	for i := 0; i < premisesCount; i++ {
+		if string(id) == premisesDefs[i].id {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookupPremises finds a premises by name (case-insensitive) or ID,
+// returning -1 if there is none
+func lookupPremises(s []byte) int {
+	for i := 0; i < premisesCount; i++ {
+		if equalFoldASCII(s, premisesDefs[i].name) || string(s) == premisesDefs[i].id {
+			return i
+		}
+	}
+	return -1
+}
+
+// jobPremises returns the premises a job belongs to (-1 if none)
+func jobPremises(job *BinJob) int {
+	return premisesIndex(job.Premises[:job.PremisesLen])
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// testPremises has the device's own premises on the bin channels and a
+// second premises with its own LEDs
+var testPremises = []config.Premises{
+	{Name: "office", ID: "1"},
+	{Name: "parents", ID: "37", LEDs: []uint8{6, 7, 8}},
+}
+
+// useTestPremises loads testPremises until the test ends
+func useTestPremises(t *testing.T) {
+	t.Helper()
+	if err := loadPremises(testPremises); err != nil {
+		t.Fatalf("loadPremises: %v", err)
+	}
+	t.Cleanup(func() {
+		loadPremises(config.DefaultPremises)
+		clearJobs()
+		clearExceptions()
+	})
+	clearJobs()
+	clearExceptions()
+}
+
+func TestLoadPremises(t *testing.T) {
+	defer loadPremises(config.DefaultPremises)
+
+	if err := loadPremises(testPremises); err != nil {
+		t.Fatalf("loadPremises: %v", err)
+	}
+	if numPremises() != 2 || premisesPins(0) != "2,3,4" || premisesPins(1) != "6,7,8" {
+		t.Errorf("premises = %d, pins %q and %q", numPremises(), premisesPins(0), premisesPins(1))
+	}
+	if premisesMetric(0, BinGreen) != "led.green" || premisesMetric(1, BinBrown) != "led.parents.brown" {
+		t.Errorf("metrics = %q, %q", premisesMetric(0, BinGreen), premisesMetric(1, BinBrown))
+	}
+	if lookupPremises([]byte("Parents")) != 1 || lookupPremises([]byte("37")) != 1 || lookupPremises([]byte("x")) != -1 {
+		t.Error("lookupPremises by name or ID failed")
+	}
+	if premisesIndex([]byte("1")) != 0 || premisesIndex(nil) != 0 || premisesIndex([]byte("99")) != -1 {
+		t.Error("premisesIndex failed")
+	}
+
+	errorTests := []struct {
+		name string
+		list []config.Premises
+	}{
+		{"pin count", []config.Premises{{Name: "a", ID: "1", LEDs: []uint8{6, 7}}}},
+		{"pin shared with bin channel", []config.Premises{{Name: "a", ID: "1"}, {Name: "b", ID: "2", LEDs: []uint8{4, 5, 6}}}},
+		{"pin shared", []config.Premises{{Name: "a", ID: "1", LEDs: []uint8{5, 6, 7}}, {Name: "b", ID: "2", LEDs: []uint8{7, 8, 9}}}},
+		{"long id", []config.Premises{{Name: "a", ID: "1234567890123"}}},
+	}
+	for _, tc := range errorTests {
+		if err := loadPremises(tc.list); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+	if numPremises() != 2 {
+		t.Errorf("failed load changed the registry: %d premises", numPremises())
+	}
+}
+
+func TestSinglePremisesOwnsAllJobs(t *testing.T) {
+	// With one premises, payloads naming any premises still drive its LEDs
+	parseScheduleResponse([]byte(`{"v":2,"premises":"12A","jobs":[{"date":"2026-01-20","bin":"BLACK"}]}`))
+	updateLEDsFromSchedule(getJobs(), time.Date(2026, 1, 19, 20, 0, 0, 0, time.UTC))
+	if !ledState[0][BinBlack] {
+		t.Error("black LED off, want on")
+	}
+}
+
+func TestParsePremisesResponse(t *testing.T) {
+	useTestPremises(t)
+
+	parsePremisesResponse([]byte("1,2026-01-20:BLACK,2026-01-27:GREEN"), 0)
+	res := parsePremisesResponse([]byte("1,2026-01-20:BROWN"), 1)
+	if res.PremisesID() != "37" || res.Accepted() != 1 {
+		t.Errorf("premises = %q, accepted %d; want 37 and 1", res.PremisesID(), res.Accepted())
+	}
+
+	// A new schedule for the office replaces only its own jobs, and only
+	// they are counted as accepted
+	res = parsePremisesResponse([]byte(`{"v":2,"jobs":[{"date":"2026-01-21","bin":"GREEN"}]}`), 0)
+	if res.Accepted() != 1 {
+		t.Errorf("accepted %d, want 1", res.Accepted())
+	}
+	expected := []string{"2026-01-20 brown 37", "2026-01-21 green 1"}
+	jobs := getJobs()
+	if len(jobs) != len(expected) {
+		t.Fatalf("len(jobs) = %d, want %d", len(jobs), len(expected))
+	}
+	for i, want := range expected {
+		if got := jobString(&jobs[i]) + " " + jobs[i].PremisesID(); got != want {
+			t.Errorf("job[%d] = %q, want %q", i, got, want)
+		}
+	}
+
+	// Payloads and jobs for another premises are rejected
+	res = parsePremisesResponse([]byte(`{"v":2,"premises":"37","jobs":[]}`), 0)
+	if res.Err != errOtherPremises {
+		t.Errorf("Err = %v, want %v", res.Err, errOtherPremises)
+	}
+	res = parsePremisesResponse([]byte(`{"v":2,"premises":"37","jobs":[`+
+		`{"date":"2026-01-22","bin":"BLACK"},{"date":"2026-01-23","bin":"BLACK","premises":"1"}]}`), 1)
+	if res.RejectedCount != 1 || res.Rejected[0].Reason != RejectPremises || len(getJobs()) != 2 {
+		t.Errorf("rejected %d (%v) with %d jobs, want 1 other-premises and 2 jobs",
+			res.RejectedCount, res.Rejected[0].Reason, len(getJobs()))
+	}
+}
+
+func TestPremisesLEDGroups(t *testing.T) {
+	useTestPremises(t)
+
+	parsePremisesResponse([]byte("1,2026-01-20:BLACK"), 0)
+	parsePremisesResponse([]byte(`{"v":2,"jobs":[{"date":"2026-01-20","bin":"GREEN"}],`+
+		`"exceptions":[{"action":"add","date":"2026-01-20","bin":"BROWN"}]}`), 1)
+
+	// Console exceptions apply to every premises
+	manual, _ := parseExceptionCommand([]byte("add 2026-01-20 green"))
+	addException(&manual)
+
+	updateLEDsFromSchedule(getJobs(), time.Date(2026, 1, 19, 20, 0, 0, 0, time.UTC))
+	expected := [2][4]bool{
+		{false, true, true, false}, // office: green (added), black
+		{false, true, false, true}, // parents: green, brown (added)
+	}
+	for p := range expected {
+		for bt := BinGreen; bt <= BinBrown; bt++ {
+			if ledState[p][bt] != expected[p][bt] {
+				t.Errorf("%s %s LED = %v, want %v", premisesName(p), bt, ledState[p][bt], expected[p][bt])
+			}
+		}
+	}
+
+	// A payload for the office keeps the parents' exceptions
+	parsePremisesResponse([]byte(`{"v":2,"exceptions":[]}`), 0)
+	if len(getExceptions()) != 2 {
+		t.Errorf("len(getExceptions()) = %d, want 2", len(getExceptions()))
+	}
+}
+
+func TestGenerateJobsFirstPremises(t *testing.T) {
+	useTestPremises(t)
+	if err := loadBinRegistry(recurTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	// A fetched job at the parents' does not stop generation for the office
+	parsePremisesResponse([]byte("1,2026-03-31:GREEN"), 1)
+	generateJobs(time.Date(2026, 3, 5, 9, 0, 0, 0, time.UTC))
+
+	for _, job := range getJobs() {
+		if job.Generated() && jobPremises(&job) != 0 {
+			t.Errorf("generated job %s for premises %d", jobString(&job), jobPremises(&job))
+		}
+	}
+	if n := len(getJobs()); n != 5 {
+		t.Errorf("len(jobs) = %d, want 1 fetched and 4 generated", n)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// WiFi provisioning. When the device cannot join any of its networks
+// (missing or wrong credentials, a new router) it starts its own access
+// point and serves a setup page at http://192.168.4.1. The network,
+// passphrase and broker entered there are saved as config overrides
+// (wifi_ssid, wifi_password, broker), making it the first network tried,
+// and the device reboots into station mode. If
+// nobody submits the form it reboots after provisionTimeout and tries the
+// old network again, so a router that was only down does not strand it.
+const (
+	provisionSSID        = "bindicator-setup"
+	provisionHTTPPort    = uint16(80)
+	provisionTimeout     = 10 * time.Minute
+	provisionPageSize    = 1536
+	wifiConnectAttempts  = 3
+	wifiConnectRetryWait = 5 * time.Second
+)
+
+// provisionAddr is the device's address on its access point network
+var provisionAddr = netip.AddrFrom4([4]byte{192, 168, 4, 1})
+
+// Provisioning errors
+var (
+	errNoSSID          = errors.New("wifi: no network configured")
+	errBadRequest      = errors.New("setup: malformed HTTP request")
+	errRequestTooLarge = errors.New("setup: request too large")
+	errFormSSID        = errors.New("enter the WiFi network name")
+	errFormPassword    = errors.New("enter the WiFi password")
+	errFormValue       = errors.New("invalid form value")
+)
+
+// httpRequest is a request to the setup page
+type httpRequest struct {
+	method []byte
+	path   []byte // Without the query string
+	body   []byte
+}
+
+// parseHTTPRequest parses the HTTP/1.x request received so far into buf,
+// a slice of the receive buffer whose capacity is the request size limit.
+// complete is false until the headers and Content-Length bytes of body
+// have arrived; a request that cannot fit is an error.
+func parseHTTPRequest(buf []byte) (req httpRequest, complete bool, err error) {
+	end := indexBytes(buf, "\r\n\r\n")
+	if end < 0 {
+		if len(buf) == cap(buf) {
+			return req, false, errRequestTooLarge
+		}
+		return req, false, nil
+	}
+
+	line, rest := cutLine(buf[:end+2])
+	req.method, line = nextField(line)
+	req.path, line = nextField(line)
+	if len(req.method) == 0 || len(req.path) == 0 || req.path[0] != '/' {
+		return req, false, errBadRequest
+	}
+	if q := indexBytes(req.path, "?"); q >= 0 {
+		req.path = req.path[:q]
+	}
+
+	length := 0
+	for len(rest) > 0 {
+		var header []byte
+		header, rest = cutLine(rest)
+		if name, value, ok := cutHeader(header); ok && equalFoldASCII(name, "content-length") {
+			if length, ok = atoiBytes(value); !ok {
+				return req, false, errBadRequest
+			}
+		}
+	}
+	body := buf[end+4:]
+	if end+4+length > cap(buf) {
+		return req, false, errRequestTooLarge
+	}
+	if len(body) < length {
+		return req, false, nil
+	}
+	req.body = body[:length]
+	return req, true, nil
+}
+
+// formValue URL-decodes the value of key in an
+// application/x-www-form-urlencoded body into dst. ok is false if the key
+// is missing, its encoding is invalid or it does not fit.
+func formValue(form []byte, key string, dst []byte) (n int, ok bool) {
+	for len(form) > 0 {
+		pair := form
+		if i := indexBytes(form, "&"); i >= 0 {
+			pair, form = form[:i], form[i+1:]
+		} else {
+			form = nil
+		}
+		eq := indexBytes(pair, "=")
+		if eq < 0 || string(pair[:eq]) != key {
+			continue
+		}
+		return urlDecode(pair[eq+1:], dst)
+	}
+	return 0, false
+}
+
+// urlDecode decodes '+' and %XX escapes from s into dst
+func urlDecode(s, dst []byte) (n int, ok bool) {
+	for i := 0; i < len(s); i++ {
+		if n == len(dst) {
+			return n, false
+		}
+		c := s[i]
+		switch c {
+		case '+':
+			c = ' '
+		case '%':
+			if i+2 >= len(s) {
+				return n, false
+			}
+			hi, ok1 := hexDigit(s[i+1])
+			lo, ok2 := hexDigit(s[i+2])
+			if !ok1 || !ok2 {
+				return n, false
+			}
+			c = hi<<4 | lo
+			i += 2
+		}
+		dst[n] = c
+		n++
+	}
+	return n, true
+}
+
+// provisionSubmit validates the setup form and sets the overrides it
+// carries. Nothing is changed if a value is invalid. An empty broker keeps
+// the current one.
+func provisionSubmit(form []byte) error {
+	var ssid, pass, broker [config.MaxSettingValueLen]byte
+	ns, ok := formValue(form, "ssid", ssid[:])
+	if !ok || ns == 0 {
+		return errFormSSID
+	}
+	np, ok := formValue(form, "password", pass[:])
+	if !ok || np == 0 {
+		return errFormPassword
+	}
+	nb, ok := formValue(form, "broker", broker[:])
+	if !ok {
+		return errFormValue
+	}
+
+	list := [3]config.Override{
+		{Key: "wifi_ssid", Value: string(ssid[:ns])},
+		{Key: "wifi_password", Value: string(pass[:np])},
+		{Key: "broker", Value: string(broker[:nb])},
+	}
+	n := len(list)
+	if nb == 0 {
+		n-- // Keep the broker
+	}
+	return config.SetAll(list[:n])
+}
+
+// provisionResponse answers a setup page request, rendering the page body
+// into page. saved is true once new settings have been accepted; the
+// caller then stores them and reboots.
+func provisionResponse(req httpRequest, page []byte) (status string, n int, saved bool) {
+	if string(req.path) != "/" {
+		w := stateWriter{buf: page}
+		w.raw("Not found\n")
+		return "404 Not Found", w.n, false
+	}
+
+	var message string
+	switch string(req.method) {
+	case "GET", "HEAD":
+	case "POST":
+		if err := provisionSubmit(req.body); err != nil {
+			message = err.Error()
+			break
+		}
+		return "200 OK", writeSavedPage(page), true
+	default:
+		w := stateWriter{buf: page}
+		w.raw("Method not allowed\n")
+		return "405 Method Not Allowed", w.n, false
+	}
+	return "200 OK", writeSetupPage(page, message), false
+}
+
+// writeSetupPage renders the setup form, showing message (an error from
+// the last submission) if not empty. The current network and broker are
+// filled in; the password never is.
+func writeSetupPage(buf []byte, message string) int {
+	broker, _, _ := config.Get("broker")
+	w := stateWriter{buf: buf}
+	w.raw(`<!DOCTYPE html><html><head><meta name="viewport" content="width=device-width">`)
+	w.raw(`<title>Bindicator setup</title></head><body><h1>Bindicator setup</h1>`)
+	if message != "" {
+		w.raw(`<p><b>`)
+		w.html(message)
+		w.raw(`</b></p>`)
+	}
+	w.raw(`<form method="post" action="/">`)
+	w.raw(`<p><label>WiFi network<br><input name="ssid" maxlength="32" required value="`)
+	w.html(firstSSID())
+	w.raw(`"></label></p>`)
+	w.raw(`<p><label>WiFi password<br><input name="password" type="password" minlength="8" maxlength="63" required></label></p>`)
+	w.raw(`<p><label>MQTT brokers (comma-separated, blank to keep)<br><input name="broker" value="`)
+	w.html(broker)
+	w.raw(`"></label></p>`)
+	w.raw(`<p><button>Save and reboot</button></p></form></body></html>`)
+	if w.overflow {
+		return 0
+	}
+	return w.n
+}
+
+// writeSavedPage renders the confirmation shown before the reboot
+func writeSavedPage(buf []byte) int {
+	w := stateWriter{buf: buf}
+	w.raw(`<!DOCTYPE html><html><head><meta name="viewport" content="width=device-width">`)
+	w.raw(`<title>Bindicator setup</title></head><body><h1>Saved</h1><p>Rebooting to join `)
+	w.html(firstSSID())
+	w.raw(`. If it cannot join, this setup network comes back within a few minutes.</p></body></html>`)
+	if w.overflow {
+		return 0
+	}
+	return w.n
+}
+
+// firstSSID returns the network tried first, or "" if none is configured
+func firstSSID() string {
+	if networks, err := config.WiFiNetworks(); err == nil && len(networks) > 0 {
+		return networks[0].SSID
+	}
+	return ""
+}
+
+// writeHTTPHeader writes the response status line and headers for a body
+// of n bytes
+func writeHTTPHeader(buf []byte, status string, n int) int {
+	w := stateWriter{buf: buf}
+	w.raw("HTTP/1.1 ")
+	w.raw(status)
+	w.raw("\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: ")
+	w.int(int64(n))
+	w.raw("\r\nConnection: close\r\n\r\n")
+	return w.n
+}
+
+// html writes s with the HTML special characters escaped
+func (w *stateWriter) html(s string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '<':
+			w.raw("&lt;")
+		case '>':
+			w.raw("&gt;")
+		case '&':
+			w.raw("&amp;")
+		case '"':
+			w.raw("&quot;")
+		case '\'':
+			w.raw("&#39;")
+		default:
+			w.byte(s[i])
+		}
+	}
+}
+
+// cutLine splits off the first CRLF-terminated line of s
+func cutLine(s []byte) (line, rest []byte) {
+	if i := indexBytes(s, "\r\n"); i >= 0 {
+		return s[:i], s[i+2:]
+	}
+	return s, nil
+}
+
+// cutHeader splits a "Name: value" header line
+func cutHeader(line []byte) (name, value []byte, ok bool) {
+	i := indexBytes(line, ":")
+	if i < 0 {
+		return nil, nil, false
+	}
+	value = line[i+1:]
+	for len(value) > 0 && (value[0] == ' ' || value[0] == '\t') {
+		value = value[1:]
+	}
+	for len(value) > 0 && (value[len(value)-1] == ' ' || value[len(value)-1] == '\t') {
+		value = value[:len(value)-1]
+	}
+	return line[:i], value, true
+}
+
+// indexBytes returns the index of the first sep in s, or -1
+func indexBytes(s []byte, sep string) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if string(s[i:i+len(sep)]) == sep {
+			return i
+		}
+	}
+	return -1
+}
+
+// atoiBytes parses a non-negative decimal number
+func atoiBytes(s []byte) (int, bool) {
+	if len(s) == 0 || len(s) > 9 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
@@ -0,0 +1,183 @@
+//go:build tinygo
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"openenterprise/bindicator/config"
+	"openenterprise/bindicator/credentials"
+	"openenterprise/bindicator/ota"
+
+	"github.com/soypat/lneto/tcp"
+	"github.com/soypat/lneto/x/xnet"
+)
+
+const (
+	provisionChannel     = 6
+	provisionReadTimeout = 10 * time.Second
+)
+
+// Provisioning buffers. The console never runs in this mode, so the setup
+// page reuses its TCP buffers and command buffer for the request. DHCP
+// replies are built in the WiFi send buffer.
+var (
+	provisionPage   [provisionPageSize]byte
+	provisionLeases dhcpLeases
+)
+
+// runProvisioning starts the setup access point and serves the setup page
+// until new settings are saved or provisionTimeout passes, then reboots.
+// The access point uses the console password as its WPA2 passphrase, or
+// is open if that is not 8-63 characters long.
+func runProvisioning(logger *slog.Logger, cause error) {
+	logger.Warn("provision:start", slog.String("err", cause.Error()), slog.String("ssid", provisionSSID))
+	wifi.up = false // Stop polling the station stack
+
+	pass := trimSecret(credentials.ConsolePassword())
+	if len(pass) < config.MinWiFiPasswordLen || len(pass) > config.MaxWiFiPasswordLen {
+		logger.Warn("provision:open-network")
+		pass = ""
+	}
+	err := wifi.dev.StartAP(provisionSSID, pass, provisionChannel)
+	if err == nil {
+		wifi.intercept = provisionIntercept
+		err = wifi.reset(provisionAddr, 1) // Setup page
+	}
+	if err != nil {
+		logger.Error("provision:ap-failed", slog.String("err", err.Error()))
+		fatalError("Setup access point failed - waiting for reset...")
+	}
+	stack := &wifi.s
+
+	go blinkProvisioning()
+	logger.Info("provision:ready", slog.String("url", "http://"+provisionAddr.String()+"/"))
+
+	var conn tcp.Conn
+	err = conn.Configure(tcp.ConnConfig{
+		RxBuf:             consoleRxBuf[:],
+		TxBuf:             consoleTxBuf[:],
+		TxPacketQueueSize: 3,
+	})
+	if err != nil {
+		logger.Error("provision:configure-failed", slog.String("err", err.Error()))
+		fatalError("Setup page failed - waiting for reset...")
+	}
+
+	deadline := time.Now().Add(provisionTimeout)
+	for time.Now().Before(deadline) {
+		if serveSetupPage(&conn, stack, logger, deadline) {
+			logger.Info("provision:saved", slog.String("ssid", firstSSID()))
+			time.Sleep(2 * time.Second) // Let the confirmation page reach the browser
+			ota.Reboot()
+		}
+	}
+	logger.Info("provision:timeout")
+	ota.Reboot()
+}
+
+// serveSetupPage answers one request to the setup page. Returns true once
+// new settings have been saved to flash.
+func serveSetupPage(conn *tcp.Conn, stack *xnet.StackAsync, logger *slog.Logger, deadline time.Time) (saved bool) {
+	conn.Abort()
+	if err := stack.ListenTCP(conn, provisionHTTPPort); err != nil {
+		logger.Error("provision:listen-failed", slog.String("err", err.Error()))
+		time.Sleep(time.Second)
+		return false
+	}
+	for conn.State().IsPreestablished() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !conn.State().IsSynchronized() {
+		return false
+	}
+	defer func() {
+		conn.Close()
+		for i := 0; i < 30 && !conn.State().IsClosed(); i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	// Read until the request is complete
+	var req httpRequest
+	n := 0
+	start := time.Now()
+	for {
+		k, readErr := conn.Read(consoleBuf[n:])
+		n += k
+		var complete bool
+		var err error
+		req, complete, err = parseHTTPRequest(consoleBuf[:n])
+		if err != nil {
+			logger.Warn("provision:bad-request", slog.String("err", err.Error()))
+			return false
+		}
+		if complete {
+			break
+		}
+		if errors.Is(readErr, net.ErrClosed) || errors.Is(readErr, io.EOF) || time.Since(start) > provisionReadTimeout {
+			return false
+		}
+		if k == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	status, size, saved := provisionResponse(req, provisionPage[:])
+	if saved {
+		if err := saveConfig(); err != nil {
+			logger.Error("provision:save-failed", slog.String("err", err.Error()))
+			status, size, saved = "500 Internal Server Error", writeSetupPage(provisionPage[:], "Could not save: "+err.Error()), false
+		}
+	}
+
+	var header [128]byte
+	writeProvision(conn, header[:writeHTTPHeader(header[:], status, size)])
+	if string(req.method) != "HEAD" {
+		writeProvision(conn, provisionPage[:size])
+	}
+	return saved
+}
+
+// writeProvision sends data in pieces that fit the TCP transmit buffer
+func writeProvision(conn *tcp.Conn, data []byte) {
+	const chunk = len(consoleTxBuf) / 2
+	for len(data) > 0 {
+		k := min(len(data), chunk)
+		if _, err := conn.Write(data[:k]); err != nil {
+			return
+		}
+		conn.Flush()
+		data = data[k:]
+		time.Sleep(50 * time.Millisecond) // Let the stack send before the next piece
+	}
+}
+
+// provisionIntercept answers DHCP on the setup network (see dhcpLeases)
+// and points the stack's replies at the client last heard from: the stack
+// sends every frame to its gateway, which the access point does not have.
+func provisionIntercept(frame, out []byte) (reply int, consumed bool) {
+	if req := dhcpServerPayload(frame); req != nil {
+		if n := provisionLeases.reply(req, out[dhcpFrameOff:], provisionAddr); n > 0 {
+			reply = dhcpFrame(out, wifi.s.HardwareAddress(), provisionAddr, n)
+		}
+		return reply, true
+	}
+	if mac, ok := ipv4Sender(frame, provisionAddr); ok {
+		wifi.s.SetGateway6(mac)
+	}
+	return 0, false
+}
+
+// blinkProvisioning blinks the first bin LED while the setup access point
+// is up
+func blinkProvisioning() {
+	for on := true; ; on = !on {
+		setLED(0, BinType(1), on)
+		time.Sleep(time.Second)
+	}
+}
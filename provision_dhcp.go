@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// Minimal DHCP server for the provisioning access point (RFC 2131): it
+// hands out provisionAddr+1 onwards to the few phones or laptops used to
+// fill in the setup page. Leases are kept in RAM by client MAC; when the
+// pool is full the oldest lease is reused.
+const (
+	dhcpServerPort = uint16(67)
+	dhcpClientPort = uint16(68)
+	dhcpPoolSize   = 8
+	dhcpLeaseTime  = uint32(provisionTimeout / time.Second)
+
+	dhcpHeaderSize = 240 // Fixed fields and magic cookie
+	dhcpReplySize  = dhcpHeaderSize + 64
+	dhcpMagic      = 0x63825363
+)
+
+// The setup network's frames are handled below the network stack, which
+// has no UDP server ports: DHCP messages are taken from the Ethernet
+// frames and replies built in place (IPv4 without options, no UDP
+// checksum).
+const (
+	ethHeaderSize  = 14
+	ipv4HeaderSize = 20
+	udpHeaderSize  = 8
+	dhcpFrameOff   = ethHeaderSize + ipv4HeaderSize + udpHeaderSize // DHCP message in a frame
+	etherTypeIPv4  = 0x0800
+	ipProtoUDP     = 17
+)
+
+// DHCP message types (option 53)
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpNak      = 6
+)
+
+// DHCP options used
+const (
+	dhcpOptPad         = 0
+	dhcpOptSubnetMask  = 1
+	dhcpOptRouter      = 3
+	dhcpOptRequestedIP = 50
+	dhcpOptLeaseTime   = 51
+	dhcpOptMessageType = 53
+	dhcpOptServerID    = 54
+	dhcpOptEnd         = 255
+)
+
+// dhcpLeases is the address pool of the provisioning network
+type dhcpLeases struct {
+	mac  [dhcpPoolSize][6]byte
+	used [dhcpPoolSize]bool
+	next int // Lease to reuse when the pool is full
+}
+
+// lease returns the pool index for a client, assigning one if needed
+func (l *dhcpLeases) lease(mac []byte) int {
+	for i := range l.mac {
+		if l.used[i] && string(l.mac[i][:]) == string(mac) {
+			return i
+		}
+	}
+	i := l.next
+	for j := range l.used {
+		if !l.used[j] {
+			i = j
+			break
+		}
+	}
+	if i == l.next {
+		l.next = (l.next + 1) % dhcpPoolSize
+	}
+	copy(l.mac[i][:], mac)
+	l.used[i] = true
+	return i
+}
+
+// addr returns the address of pool index i
+func (l *dhcpLeases) addr(server netip.Addr, i int) netip.Addr {
+	a := server.As4()
+	a[3] += byte(1 + i)
+	return netip.AddrFrom4(a)
+}
+
+// reply answers a client message in req on the network served from server,
+// writing the reply (to be broadcast to the client port) into out, which
+// must hold dhcpReplySize bytes. Returns 0 for messages that get no
+// answer: malformed ones, replies, releases and requests for another
+// server.
+func (l *dhcpLeases) reply(req, out []byte, server netip.Addr) int {
+	be := binary.BigEndian
+	if len(req) < dhcpHeaderSize || req[0] != 1 || req[1] != 1 || req[2] != 6 ||
+		be.Uint32(req[236:]) != dhcpMagic {
+		return 0 // Not an Ethernet BOOTREQUEST
+	}
+	var msgType byte
+	var requested, serverID []byte
+	opts := req[dhcpHeaderSize:]
+	for len(opts) > 0 && opts[0] != dhcpOptEnd {
+		if opts[0] == dhcpOptPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return 0
+		}
+		code, data := opts[0], opts[2:2+int(opts[1])]
+		switch {
+		case code == dhcpOptMessageType && len(data) == 1:
+			msgType = data[0]
+		case code == dhcpOptRequestedIP && len(data) == 4:
+			requested = data
+		case code == dhcpOptServerID && len(data) == 4:
+			serverID = data
+		}
+		opts = opts[2+len(data):]
+	}
+
+	self := server.As4()
+	mac := req[28:34]
+	var replyType byte
+	var yiaddr netip.Addr
+	switch msgType {
+	case dhcpDiscover:
+		replyType, yiaddr = dhcpOffer, l.addr(server, l.lease(mac))
+	case dhcpRequest:
+		if serverID != nil && string(serverID) != string(self[:]) {
+			return 0 // The client chose another server
+		}
+		yiaddr = l.addr(server, l.lease(mac))
+		want := requested
+		if want == nil {
+			want = req[12:16] // ciaddr when renewing
+		}
+		replyType = dhcpAck
+		if a := yiaddr.As4(); string(want) != string(a[:]) {
+			replyType, yiaddr = dhcpNak, netip.AddrFrom4([4]byte{})
+		}
+	default:
+		return 0
+	}
+
+	clear(out[:dhcpReplySize])
+	out[0], out[1], out[2] = 2, 1, 6 // BOOTREPLY, Ethernet
+	copy(out[4:8], req[4:8])         // xid
+	copy(out[10:12], req[10:12])     // flags
+	ip := yiaddr.As4()
+	copy(out[16:20], ip[:])
+	copy(out[20:24], self[:])
+	copy(out[24:28], req[24:28]) // giaddr
+	copy(out[28:44], req[28:44]) // chaddr
+	be.PutUint32(out[236:], dhcpMagic)
+
+	n := dhcpHeaderSize
+	n += copy(out[n:], []byte{dhcpOptMessageType, 1, replyType})
+	n += copy(out[n:], []byte{dhcpOptServerID, 4})
+	n += copy(out[n:], self[:])
+	if replyType != dhcpNak {
+		n += copy(out[n:], []byte{dhcpOptLeaseTime, 4})
+		be.PutUint32(out[n:], dhcpLeaseTime)
+		n += 4
+		n += copy(out[n:], []byte{dhcpOptSubnetMask, 4, 255, 255, 255, 0})
+		n += copy(out[n:], []byte{dhcpOptRouter, 4})
+		n += copy(out[n:], self[:])
+	}
+	out[n] = dhcpOptEnd
+	return n + 1
+}
+
+// dhcpServerPayload returns the DHCP message of an Ethernet frame sent to
+// the server port, or nil for any other frame
+func dhcpServerPayload(frame []byte) []byte {
+	be := binary.BigEndian
+	if len(frame) < dhcpFrameOff || be.Uint16(frame[12:]) != etherTypeIPv4 {
+		return nil
+	}
+	ip := frame[ethHeaderSize:]
+	ihl := int(ip[0]&0x0f) * 4
+	total := int(be.Uint16(ip[2:]))
+	if ip[0]>>4 != 4 || ihl < ipv4HeaderSize || ip[9] != ipProtoUDP ||
+		be.Uint16(ip[6:])&0x3fff != 0 || total < ihl+udpHeaderSize || total > len(ip) {
+		return nil // Not UDP, a fragment or truncated
+	}
+	udp := ip[ihl:total]
+	size := int(be.Uint16(udp[4:]))
+	if be.Uint16(udp[2:]) != dhcpServerPort || size < udpHeaderSize || size > len(udp) {
+		return nil
+	}
+	return udp[udpHeaderSize:size]
+}
+
+// dhcpFrame wraps the n-byte DHCP reply at out[dhcpFrameOff:] in a
+// broadcast from the server (the clients have no address yet) and returns
+// the frame length
+func dhcpFrame(out []byte, serverMAC [6]byte, server netip.Addr, n int) int {
+	be := binary.BigEndian
+	for i := 0; i < 6; i++ {
+		out[i] = 0xff
+	}
+	copy(out[6:12], serverMAC[:])
+	be.PutUint16(out[12:], etherTypeIPv4)
+
+	ip := out[ethHeaderSize:dhcpFrameOff]
+	clear(ip)
+	ip[0] = 0x45 // IPv4, no options
+	be.PutUint16(ip[2:], uint16(ipv4HeaderSize+udpHeaderSize+n))
+	ip[8], ip[9] = 64, ipProtoUDP // TTL, protocol
+	self := server.As4()
+	copy(ip[12:16], self[:])
+	copy(ip[16:20], []byte{255, 255, 255, 255})
+	be.PutUint16(ip[10:], ipv4Checksum(ip[:ipv4HeaderSize]))
+
+	udp := ip[ipv4HeaderSize:]
+	be.PutUint16(udp[0:], dhcpServerPort)
+	be.PutUint16(udp[2:], dhcpClientPort)
+	be.PutUint16(udp[4:], uint16(udpHeaderSize+n)) // Checksum 0: none
+	return dhcpFrameOff + n
+}
+
+// ipv4Checksum returns the header checksum of an IPv4 header whose
+// checksum field is zero
+func ipv4Checksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// ipv4Sender returns the source MAC address of an IPv4 frame sent to addr
+func ipv4Sender(frame []byte, addr netip.Addr) (mac [6]byte, ok bool) {
+	if len(frame) < ethHeaderSize+ipv4HeaderSize || binary.BigEndian.Uint16(frame[12:]) != etherTypeIPv4 {
+		return mac, false
+	}
+	dst := addr.As4()
+	if frame[ethHeaderSize]>>4 != 4 || string(frame[ethHeaderSize+16:ethHeaderSize+20]) != string(dst[:]) {
+		return mac, false
+	}
+	copy(mac[:], frame[6:12])
+	return mac, true
+}
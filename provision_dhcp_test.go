@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// dhcpMessage builds a client message with the given options
+func dhcpMessage(msgType byte, mac byte, opts ...byte) []byte {
+	msg := make([]byte, dhcpHeaderSize, dhcpHeaderSize+16)
+	msg[0], msg[1], msg[2] = 1, 1, 6
+	binary.BigEndian.PutUint32(msg[4:], 0x1234abcd)
+	msg[28], msg[33] = 0x02, mac
+	binary.BigEndian.PutUint32(msg[236:], dhcpMagic)
+	msg = append(msg, dhcpOptMessageType, 1, msgType)
+	msg = append(msg, opts...)
+	return append(msg, dhcpOptEnd)
+}
+
+// dhcpOption returns the value of an option in a server reply
+func dhcpOption(reply []byte, code byte) []byte {
+	opts := reply[dhcpHeaderSize:]
+	for len(opts) > 1 && opts[0] != dhcpOptEnd {
+		if opts[0] == code {
+			return opts[2 : 2+int(opts[1])]
+		}
+		opts = opts[2+int(opts[1]):]
+	}
+	return nil
+}
+
+func TestDHCPServer(t *testing.T) {
+	var leases dhcpLeases
+	var out [dhcpReplySize]byte
+	server := provisionAddr
+
+	n := leases.reply(dhcpMessage(dhcpDiscover, 1), out[:], server)
+	if n == 0 || out[0] != 2 || dhcpOption(out[:n], dhcpOptMessageType)[0] != dhcpOffer {
+		t.Fatalf("DISCOVER: no offer (%d bytes)", n)
+	}
+	if binary.BigEndian.Uint32(out[4:]) != 0x1234abcd {
+		t.Error("offer xid does not match")
+	}
+	offered := netip.AddrFrom4([4]byte(out[16:20]))
+	if offered != netip.MustParseAddr("192.168.4.2") {
+		t.Errorf("offered %v", offered)
+	}
+	if got := dhcpOption(out[:n], dhcpOptServerID); string(got) != string(server.AsSlice()) {
+		t.Errorf("server ID = %v", got)
+	}
+
+	// The same client gets the same address; another one the next
+	o := offered.As4()
+	n = leases.reply(dhcpMessage(dhcpRequest, 1, dhcpOptRequestedIP, 4, o[0], o[1], o[2], o[3],
+		dhcpOptServerID, 4, 192, 168, 4, 1), out[:], server)
+	if n == 0 || dhcpOption(out[:n], dhcpOptMessageType)[0] != dhcpAck || [4]byte(out[16:20]) != o {
+		t.Errorf("REQUEST: %v", out[:n])
+	}
+	n = leases.reply(dhcpMessage(dhcpDiscover, 2), out[:], server)
+	if netip.AddrFrom4([4]byte(out[16:20])) != netip.MustParseAddr("192.168.4.3") {
+		t.Errorf("second client offered %v", out[16:20])
+	}
+
+	// A request for an address that is not the client's is refused, and
+	// one for another server is ignored
+	n = leases.reply(dhcpMessage(dhcpRequest, 1, dhcpOptRequestedIP, 4, 10, 0, 0, 5), out[:], server)
+	if n == 0 || dhcpOption(out[:n], dhcpOptMessageType)[0] != dhcpNak {
+		t.Error("REQUEST for a foreign address not refused")
+	}
+	if n := leases.reply(dhcpMessage(dhcpRequest, 1, dhcpOptServerID, 4, 10, 0, 0, 1), out[:], server); n != 0 {
+		t.Error("REQUEST for another server answered")
+	}
+
+	// Malformed messages get no answer
+	short := dhcpMessage(dhcpDiscover, 3)[:100]
+	if n := leases.reply(short, out[:], server); n != 0 {
+		t.Error("short message answered")
+	}
+	bad := dhcpMessage(dhcpDiscover, 3, 50, 200)
+	if n := leases.reply(bad, out[:], server); n != 0 {
+		t.Error("message with an overlong option answered")
+	}
+
+	// A full pool reuses leases
+	for mac := byte(10); mac < 10+dhcpPoolSize; mac++ {
+		leases.reply(dhcpMessage(dhcpDiscover, mac), out[:], server)
+	}
+	if a := netip.AddrFrom4([4]byte(out[16:20])); !a.IsValid() || a == server {
+		t.Errorf("full pool offered %v", a)
+	}
+}
+
+// dhcpClientFrame wraps a client message in the broadcast a client without
+// an address sends
+func dhcpClientFrame(msg []byte) []byte {
+	be := binary.BigEndian
+	frame := make([]byte, dhcpFrameOff, dhcpFrameOff+len(msg))
+	copy(frame, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0, 0, 0, 0, 1, 0x08, 0x00})
+	ip := frame[ethHeaderSize:]
+	ip[0], ip[8], ip[9] = 0x45, 64, ipProtoUDP
+	be.PutUint16(ip[2:], uint16(ipv4HeaderSize+udpHeaderSize+len(msg)))
+	copy(ip[16:20], []byte{255, 255, 255, 255})
+	be.PutUint16(ip[20:], dhcpClientPort)
+	be.PutUint16(ip[22:], dhcpServerPort)
+	be.PutUint16(ip[24:], uint16(udpHeaderSize+len(msg)))
+	return append(frame, msg...)
+}
+
+func TestDHCPFrames(t *testing.T) {
+	be := binary.BigEndian
+	msg := dhcpMessage(dhcpDiscover, 1)
+	frame := dhcpClientFrame(msg)
+	if got := dhcpServerPayload(frame); string(got) != string(msg) {
+		t.Fatalf("payload = %d bytes, want the %d-byte message", len(got), len(msg))
+	}
+
+	// Other traffic is left to the stack
+	for name, change := range map[string]func(f []byte){
+		"ARP":       func(f []byte) { f[13] = 0x06 },
+		"TCP":       func(f []byte) { f[ethHeaderSize+9] = 6 },
+		"port 68":   func(f []byte) { f[ethHeaderSize+23] = 68 },
+		"fragment":  func(f []byte) { f[ethHeaderSize+6] = 0x20 },
+		"truncated": func(f []byte) { be.PutUint16(f[ethHeaderSize+2:], 1500) },
+	} {
+		f := dhcpClientFrame(msg)
+		change(f)
+		if dhcpServerPayload(f) != nil {
+			t.Errorf("%s frame taken as DHCP", name)
+		}
+	}
+	if dhcpServerPayload(frame[:30]) != nil {
+		t.Error("short frame taken as DHCP")
+	}
+
+	// The offer is broadcast from the server to the client port
+	var leases dhcpLeases
+	var out [dhcpFrameOff + dhcpReplySize]byte
+	n := leases.reply(dhcpServerPayload(frame), out[dhcpFrameOff:], provisionAddr)
+	mac := [6]byte{0x28, 0xcd, 0xc1, 0, 0, 1}
+	size := dhcpFrame(out[:], mac, provisionAddr, n)
+	if size != dhcpFrameOff+n {
+		t.Fatalf("frame size = %d, want %d", size, dhcpFrameOff+n)
+	}
+	ip := out[ethHeaderSize:]
+	if string(out[0:6]) != "\xff\xff\xff\xff\xff\xff" || [6]byte(out[6:12]) != mac || be.Uint16(out[12:]) != etherTypeIPv4 {
+		t.Errorf("Ethernet header % x", out[:ethHeaderSize])
+	}
+	if int(be.Uint16(ip[2:])) != size-ethHeaderSize || ipv4Checksum(ip[:ipv4HeaderSize]) != 0 {
+		t.Errorf("IPv4 header % x: bad length or checksum", ip[:ipv4HeaderSize])
+	}
+	if netip.AddrFrom4([4]byte(ip[12:16])) != provisionAddr || string(ip[16:20]) != "\xff\xff\xff\xff" {
+		t.Errorf("addresses % x", ip[12:20])
+	}
+	if be.Uint16(ip[20:]) != dhcpServerPort || be.Uint16(ip[22:]) != dhcpClientPort || int(be.Uint16(ip[24:])) != udpHeaderSize+n {
+		t.Errorf("UDP header % x", ip[20:28])
+	}
+	if dhcpOption(out[dhcpFrameOff:size], dhcpOptMessageType)[0] != dhcpOffer {
+		t.Error("frame does not carry the offer")
+	}
+
+	// Replies of the stack go to the client last heard from
+	tcp := dhcpClientFrame(msg)
+	copy(tcp[ethHeaderSize+16:], provisionAddr.AsSlice())
+	if got, ok := ipv4Sender(tcp, provisionAddr); !ok || got != [6]byte{0x02, 0, 0, 0, 0, 1} {
+		t.Errorf("ipv4Sender = % x, %v", got, ok)
+	}
+	if _, ok := ipv4Sender(frame, provisionAddr); ok {
+		t.Error("broadcast taken as sent to the server")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"openenterprise/bindicator/config"
+)
+
+func TestParseHTTPRequest(t *testing.T) {
+	post := "POST /?x=1 HTTP/1.1\r\nHost: 192.168.4.1\r\ncontent-length: 11\r\n\r\nssid=a&b=cd"
+
+	var buf [256]byte
+	for _, split := range []int{10, len(post) - 11, len(post) - 1} {
+		n := copy(buf[:], post[:split])
+		if _, complete, err := parseHTTPRequest(buf[:n]); complete || err != nil {
+			t.Errorf("partial request (%d bytes): complete=%v err=%v", split, complete, err)
+		}
+	}
+	n := copy(buf[:], post)
+	req, complete, err := parseHTTPRequest(buf[:n])
+	if !complete || err != nil {
+		t.Fatalf("parseHTTPRequest() complete=%v err=%v", complete, err)
+	}
+	if string(req.method) != "POST" || string(req.path) != "/" || string(req.body) != "ssid=a&b=cd" {
+		t.Errorf("request = %q %q %q", req.method, req.path, req.body)
+	}
+
+	n = copy(buf[:], "GET / HTTP/1.1\r\n\r\n")
+	if req, complete, _ := parseHTTPRequest(buf[:n]); !complete || string(req.method) != "GET" {
+		t.Errorf("GET: %q complete=%v", req.method, complete)
+	}
+
+	errs := []struct {
+		req string
+		err error
+	}{
+		{"GET\r\n\r\n", errBadRequest},
+		{"GET index.html HTTP/1.1\r\n\r\n", errBadRequest},
+		{"POST / HTTP/1.1\r\nContent-Length: x\r\n\r\n", errBadRequest},
+		{"POST / HTTP/1.1\r\nContent-Length: 5000\r\n\r\n", errRequestTooLarge},
+		{"GET / HTTP/1.1\r\nCookie: " + strings.Repeat("a", len(buf)), errRequestTooLarge},
+	}
+	for _, tc := range errs {
+		n := copy(buf[:], tc.req)
+		if _, _, err := parseHTTPRequest(buf[:n]); err != tc.err {
+			t.Errorf("parseHTTPRequest(%.30q) err = %v, want %v", tc.req, err, tc.err)
+		}
+	}
+}
+
+func TestFormValue(t *testing.T) {
+	form := []byte("ssid=My+Home%20Wi-Fi&password=p%26ss%3Dword&empty=&bad=%zz")
+	var dst [32]byte
+	tests := []struct {
+		key, want string
+		ok        bool
+	}{
+		{"ssid", "My Home Wi-Fi", true},
+		{"password", "p&ss=word", true},
+		{"empty", "", true},
+		{"bad", "", false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		n, ok := formValue(form, tt.key, dst[:])
+		if ok != tt.ok || (ok && string(dst[:n]) != tt.want) {
+			t.Errorf("formValue(%q) = %q, %v; want %q, %v", tt.key, dst[:n], ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := formValue([]byte("ssid=0123456789"), "ssid", dst[:4]); ok {
+		t.Error("formValue() accepted a value longer than dst")
+	}
+}
+
+func TestProvisionResponse(t *testing.T) {
+	t.Cleanup(func() {
+		for _, s := range config.Settings() {
+			config.Reset(s.Key)
+		}
+	})
+	var page [provisionPageSize]byte
+
+	status, n, saved := provisionResponse(httpRequest{method: []byte("GET"), path: []byte("/")}, page[:])
+	if status != "200 OK" || saved || !strings.Contains(string(page[:n]), `<form method="post"`) {
+		t.Errorf("GET / = %s, saved=%v:\n%s", status, saved, page[:n])
+	}
+	if status, _, _ := provisionResponse(httpRequest{method: []byte("GET"), path: []byte("/favicon.ico")}, page[:]); status != "404 Not Found" {
+		t.Errorf("GET /favicon.ico = %s", status)
+	}
+
+	// An invalid submission is reported on the page and changes nothing
+	before, _, _ := config.Get("wifi_ssid")
+	post := httpRequest{method: []byte("POST"), path: []byte("/"), body: []byte("ssid=Attic&password=short&broker=")}
+	status, n, saved = provisionResponse(post, page[:])
+	if saved || !strings.Contains(string(page[:n]), "passphrase") {
+		t.Errorf("invalid POST: saved=%v:\n%s", saved, page[:n])
+	}
+	if v, _, _ := config.Get("wifi_ssid"); v != before {
+		t.Errorf("wifi_ssid = %q after an invalid submission", v)
+	}
+
+	post.body = []byte("ssid=%3Cscript%3E&password=correct+horse&broker=mqtt.example.net%2C+10.0.0.2")
+	status, n, saved = provisionResponse(post, page[:])
+	if !saved || status != "200 OK" {
+		t.Fatalf("POST = %s, saved=%v:\n%s", status, saved, page[:n])
+	}
+	if strings.Contains(string(page[:n]), "<script>") || !strings.Contains(string(page[:n]), "&lt;script&gt;") {
+		t.Errorf("SSID not escaped:\n%s", page[:n])
+	}
+	if networks, _ := config.WiFiNetworks(); len(networks) == 0 || networks[0] != (config.WiFiNetwork{SSID: "<script>", Password: "correct horse"}) {
+		t.Errorf("WiFiNetworks() = %v, want the submitted network first", networks)
+	}
+	if v, src, _ := config.Get("broker"); v != "mqtt.example.net, 10.0.0.2" || src != config.SourceOverride {
+		t.Errorf("broker = %q (%v)", v, src)
+	}
+
+	// A blank broker keeps the current one
+	post.body = []byte("ssid=Attic&password=correct+horse&broker=")
+	if _, _, saved := provisionResponse(post, page[:]); !saved {
+		t.Fatal("POST without broker not saved")
+	}
+	if v, _, _ := config.Get("broker"); v != "mqtt.example.net, 10.0.0.2" {
+		t.Errorf("broker = %q, want it kept", v)
+	}
+	if n := writeSetupPage(page[:], ""); strings.Contains(string(page[:n]), "correct horse") {
+		t.Error("setup page shows the password")
+	}
+}
+
+func TestWriteHTTPHeader(t *testing.T) {
+	var buf [128]byte
+	n := writeHTTPHeader(buf[:], "200 OK", 1234)
+	want := "HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: 1234\r\nConnection: close\r\n\r\n"
+	if string(buf[:n]) != want {
+		t.Errorf("writeHTTPHeader() = %q", buf[:n])
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import "time"
+
+// recurAhead is how far ahead collections are generated from recurrence
+// rules. Generation runs every wake cycle, so this only needs to cover the
+// longest notification window plus a margin; keeping it short leaves the
+// job store for fetched collections.
+const recurAhead = 28 * 24 * time.Hour
+
+// recurStaleAfter is how long after the last fetched schedule the
+// recurrence rules take over. A fetch is due every schedule refresh
+// interval (3h by default), so a day without one means the bridge is
+// unreachable rather than late.
+const recurStaleAfter = 24 * time.Hour
+
+// scheduleFetched is when the cached schedule was last fetched, restored
+// with it from flash (zero: never)
+var scheduleFetched time.Time
+
+// scheduleStale reports whether no fresh schedule has arrived for
+// recurStaleAfter
+func scheduleStale(now time.Time) bool {
+	return scheduleFetched.IsZero() || now.Sub(scheduleFetched) >= recurStaleAfter
+}
+
+// generateJobs adds collections from the bins' recurrence rules for the
+// period from today until recurAhead, marked jobGenerated, once no fresh
+// schedule has arrived (see scheduleStale). Fetched jobs override the
+// rules: a bin only gets generated jobs after its last fetched collection,
+// so they fill in once the cached schedule runs out. The rules describe
+// the first premises, so only it gets generated jobs. Existing generated
+// jobs are replaced, and removed while the schedule is fresh. Does nothing
+// until the clock is set. Returns the number of generated jobs in the
+// store.
+func generateJobs(now time.Time) int {
+	clearGeneratedJobs()
+	if !clockIsSet(now) || !scheduleStale(now) {
+		return 0
+	}
+
+	// Civil dates are computed in UTC so day arithmetic ignores DST
+	local := localTime(now)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	until := today.Add(recurAhead)
+
+	generated := 0
+	for bt := BinType(1); int(bt) <= numBins(); bt++ {
+		r := bt.Recurrence()
+		if r.Every == 0 {
+			continue
+		}
+
+		// Start a week back so windows that close days after the
+		// collection are still covered, or after the last fetched job
+		from := today.AddDate(0, 0, -7)
+		if last, ok := lastFetchedJob(bt); ok && !last.Before(from) {
+			from = last.AddDate(0, 0, 1)
+		}
+
+		// First collection on or after from
+		days := int(from.Sub(r.Anchor) / (24 * time.Hour))
+		skip := (-days%r.Every + r.Every) % r.Every
+		for d := from.AddDate(0, 0, skip); !d.After(until); d = d.AddDate(0, 0, r.Every) {
+			job := BinJob{
+				Year:  uint16(d.Year()),
+				Month: uint8(d.Month()),
+				Day:   uint8(d.Day()),
+				Bin:   bt,
+				Flags: jobGenerated,
+			}
+			if !now.Before(movedWindowOff(&job)) {
+				continue // Already over, even if moved
+			}
+			if jobCount == maxJobs {
+				return generated // Never evict fetched jobs
+			}
+			if _, duplicate, _ := insertJob(&job); !duplicate {
+				generated++
+			}
+		}
+	}
+	return generated
+}
+
+// lastFetchedJob returns the date (midnight UTC) of the bin's latest
+// fetched job at the first premises
+func lastFetchedJob(bt BinType) (time.Time, bool) {
+	for i := jobCount - 1; i >= 0; i-- {
+		job := &jobStorage[i]
+		if job.Bin == bt && !job.Generated() && jobPremises(job) == 0 {
+			return time.Date(int(job.Year), time.Month(job.Month), int(job.Day), 0, 0, 0, 0, time.UTC), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// clearGeneratedJobs removes all generated jobs from the store
+func clearGeneratedJobs() {
+	n := 0
+	for i := 0; i < jobCount; i++ {
+		if !jobStorage[i].Generated() {
+			if n != i {
+				jobStorage[n] = jobStorage[i]
+			}
+			n++
+		}
+	}
+	jobCount = n
+}
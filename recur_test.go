@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// recurTestBins has fortnightly green and black bins on alternate weeks
+// and a brown bin without a rule
+var recurTestBins = []config.Bin{
+	{Name: "green", Channel: 2, Recur: config.Recurrence{Every: 14, Anchor: time.Date(2026, 1, 6, 0, 0, 0, 0, time.UTC)}},
+	{Name: "black", Channel: 3, Recur: config.Recurrence{Every: 14, Anchor: time.Date(2026, 1, 13, 0, 0, 0, 0, time.UTC)}},
+	{Name: "brown", Channel: 4},
+}
+
+func TestGenerateJobs(t *testing.T) {
+	if err := loadBinRegistry(recurTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+	clearJobs()
+
+	// Thursday 2026-03-05 09:00 GMT: Tue 2026-03-03 (green) is over
+	now := time.Date(2026, 3, 5, 9, 0, 0, 0, time.UTC)
+	n := generateJobs(now)
+
+	expected := []string{
+		"2026-03-10 black",
+		"2026-03-17 green",
+		"2026-03-24 black",
+		"2026-03-31 green",
+	}
+	jobs := getJobs()
+	if n != len(expected) || len(jobs) != len(expected) {
+		t.Fatalf("generateJobs() = %d with %d jobs, want %d", n, len(jobs), len(expected))
+	}
+	for i, want := range expected {
+		if got := jobString(&jobs[i]); got != want {
+			t.Errorf("job[%d] = %q, want %q", i, got, want)
+		}
+		if !jobs[i].Generated() {
+			t.Errorf("job[%d] not marked generated", i)
+		}
+	}
+
+	// Regenerating replaces rather than duplicates
+	if n := generateJobs(now); n != len(expected) || len(getJobs()) != len(expected) {
+		t.Errorf("second generateJobs() = %d with %d jobs, want %d", n, len(getJobs()), len(expected))
+	}
+}
+
+func TestGenerateJobsFetchedOverride(t *testing.T) {
+	if err := loadBinRegistry(recurTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	// The fetched schedule moves black to a Wednesday for a bank holiday
+	// and covers green until the end of March
+	parseScheduleResponse([]byte("1,2026-03-11:BLACK,2026-03-17:GREEN,2026-03-31:GREEN"))
+	generateJobs(time.Date(2026, 3, 5, 9, 0, 0, 0, time.UTC))
+
+	expected := []string{
+		"2026-03-11 black fetched",
+		"2026-03-17 green fetched",
+		"2026-03-24 black generated",
+		"2026-03-31 green fetched",
+	}
+	jobs := getJobs()
+	if len(jobs) != len(expected) {
+		t.Fatalf("len(jobs) = %d, want %d", len(jobs), len(expected))
+	}
+	for i, want := range expected {
+		source := " fetched"
+		if jobs[i].Generated() {
+			source = " generated"
+		}
+		if got := jobString(&jobs[i]) + source; got != want {
+			t.Errorf("job[%d] = %q, want %q", i, got, want)
+		}
+	}
+
+	// Generated jobs are not persisted
+	var buf [storeMaxSize]byte
+	n := encodeSchedule(buf[:], getJobs(), getExceptions(), 1)
+	if _, _, err := decodeSchedule(buf[:n]); err != nil {
+		t.Fatalf("decodeSchedule() err = %v", err)
+	}
+	if len(getJobs()) != 3 {
+		t.Errorf("restored %d jobs, want 3 fetched", len(getJobs()))
+	}
+}
+
+func TestGenerateJobsFreshSchedule(t *testing.T) {
+	if err := loadBinRegistry(recurTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+	clearJobs()
+	defer func() { scheduleFetched = time.Time{} }()
+
+	// Cached schedule running out while the bridge is unreachable
+	now := time.Date(2026, 3, 5, 9, 0, 0, 0, time.UTC)
+	parseScheduleResponse([]byte("1,2026-03-10:BLACK"))
+	scheduleFetched = now.Add(-25 * time.Hour)
+	if n := generateJobs(now); n == 0 {
+		t.Fatal("generateJobs() generated nothing with a stale schedule")
+	}
+
+	// A fresh schedule is used alone, and removes the generated jobs
+	scheduleFetched = now.Add(-time.Hour)
+	if n := generateJobs(now); n != 0 || len(getJobs()) != 1 {
+		t.Errorf("generateJobs() = %d with %d jobs after a fresh fetch, want 0 and the fetched job", n, len(getJobs()))
+	}
+	if n := generateJobs(now.Add(24 * time.Hour)); n == 0 {
+		t.Error("generateJobs() generated nothing a day after the last fetch")
+	}
+}
+
+func TestGenerateJobsClockNotSet(t *testing.T) {
+	if err := loadBinRegistry(recurTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+	clearJobs()
+
+	if n := generateJobs(time.Unix(120, 0)); n != 0 || len(getJobs()) != 0 {
+		t.Errorf("generateJobs(unset clock) = %d with %d jobs, want none", n, len(getJobs()))
+	}
+}
+
+// jobString formats a job as "YYYY-MM-DD bin"
+func jobString(job *BinJob) string {
+	var date [10]byte
+	writeDate(date[:], job.Year, job.Month, job.Day)
+	return string(date[:]) + " " + job.Bin.String()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// collectionWindow returns when a job's LED turns on and off, using the
+// bin's configured window in local time
+func collectionWindow(job *BinJob) (on, off time.Time) {
+	w := job.Bin.Window()
+	year, month, day := int(job.Year), time.Month(job.Month), int(job.Day)
+	return windowEdgeTime(w.On, year, month, day), windowEdgeTime(w.Off, year, month, day)
+}
+
+// windowEdgeTime returns the instant of a window edge for a collection day
+func windowEdgeTime(e config.WindowEdge, year int, month time.Month, day int) time.Time {
+	if e.Clock {
+		return localDate(year, month, day+e.Days, int(e.At/time.Hour), int(e.At%time.Hour/time.Minute))
+	}
+	return localDate(year, month, day, 0, 0).Add(e.At)
+}
+
+// inCollectionWindow reports whether now is strictly inside the job's window
+func inCollectionWindow(job *BinJob, now time.Time) bool {
+	on, off := collectionWindow(job)
+	return now.After(on) && now.Before(off)
+}
+
+// binStates sets on[bt] for every bin with a job at premises p whose
+// window contains now. Bins without a job in their window are set to false.
+func binStates(jobs []BinJob, now time.Time, p int, on *[maxBins + 1]bool) {
+	for i := range on {
+		on[i] = false
+	}
+	for i := 0; i < len(jobs); i++ {
+		job := &jobs[i]
+		if job.Bin != BinUnknown && jobPremises(job) == p && inCollectionWindow(job, now) {
+			on[job.Bin] = true
+		}
+	}
+}
+
+// nextCollection returns the index of the first job whose window has not
+// closed yet, or -1 if there is none
+func nextCollection(jobs []BinJob, now time.Time) int {
+	for i := 0; i < len(jobs); i++ {
+		if _, off := collectionWindow(&jobs[i]); now.Before(off) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// windowTestBins has one bin per kind of notification window
+var windowTestBins = []config.Bin{
+	// Default: noon the day before until noon
+	{Name: "green", Channel: 2},
+	// Offsets: 12h either side of local midnight
+	{Name: "black", Channel: 3, Window: config.Window{
+		On:  config.WindowEdge{At: -12 * time.Hour},
+		Off: config.WindowEdge{At: 12 * time.Hour},
+	}},
+	// Garden waste: 18:00 the day before until 09:00
+	{Name: "brown", Channel: 4, Window: config.Window{
+		On:  config.WindowEdge{Clock: true, Days: -1, At: 18 * time.Hour},
+		Off: config.WindowEdge{Clock: true, At: 9 * time.Hour},
+	}},
+}
+
+func TestCollectionWindow(t *testing.T) {
+	if err := loadBinRegistry(windowTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	tests := []struct {
+		name string
+		job  BinJob
+		on   time.Time
+		off  time.Time
+	}{
+		{
+			name: "default window in GMT",
+			job:  BinJob{Year: 2026, Month: 1, Day: 20, Bin: BinGreen},
+			on:   time.Date(2026, 1, 19, 12, 0, 0, 0, time.UTC),
+			off:  time.Date(2026, 1, 20, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "default window in BST",
+			job:  BinJob{Year: 2026, Month: 7, Day: 2, Bin: BinGreen},
+			on:   time.Date(2026, 7, 1, 11, 0, 0, 0, time.UTC),
+			off:  time.Date(2026, 7, 2, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "garden window",
+			job:  BinJob{Year: 2026, Month: 7, Day: 2, Bin: BinBrown},
+			on:   time.Date(2026, 7, 1, 17, 0, 0, 0, time.UTC),
+			off:  time.Date(2026, 7, 2, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "garden window across month boundary",
+			job:  BinJob{Year: 2026, Month: 3, Day: 1, Bin: BinBrown},
+			on:   time.Date(2026, 2, 28, 18, 0, 0, 0, time.UTC),
+			off:  time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			// Clocks go forward at 01:00 UTC on 2026-03-29: offsets are
+			// elapsed time from local midnight, clock edges are wall time
+			name: "offset window when clocks go forward",
+			job:  BinJob{Year: 2026, Month: 3, Day: 29, Bin: BinBlack},
+			on:   time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC),
+			off:  time.Date(2026, 3, 29, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "clock window when clocks go forward",
+			job:  BinJob{Year: 2026, Month: 3, Day: 29, Bin: BinGreen},
+			on:   time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC),
+			off:  time.Date(2026, 3, 29, 11, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			on, off := collectionWindow(&tc.job)
+			if !on.Equal(tc.on) {
+				t.Errorf("on = %v, want %v", on.UTC(), tc.on)
+			}
+			if !off.Equal(tc.off) {
+				t.Errorf("off = %v, want %v", off.UTC(), tc.off)
+			}
+		})
+	}
+}
+
+func TestBinStatesPerBinWindows(t *testing.T) {
+	if err := loadBinRegistry(windowTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	// All three bins collected on the same (winter) day
+	jobs := []BinJob{
+		{Year: 2026, Month: 1, Day: 20, Bin: BinGreen},
+		{Year: 2026, Month: 1, Day: 20, Bin: BinBlack},
+		{Year: 2026, Month: 1, Day: 20, Bin: BinBrown},
+	}
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		green bool
+		black bool
+		brown bool
+	}{
+		{"day before morning", time.Date(2026, 1, 19, 8, 0, 0, 0, time.UTC), false, false, false},
+		{"day before afternoon", time.Date(2026, 1, 19, 15, 0, 0, 0, time.UTC), true, true, false},
+		{"day before evening", time.Date(2026, 1, 19, 18, 30, 0, 0, time.UTC), true, true, true},
+		{"collection day 08:59", time.Date(2026, 1, 20, 8, 59, 0, 0, time.UTC), true, true, true},
+		{"collection day 10:00", time.Date(2026, 1, 20, 10, 0, 0, 0, time.UTC), true, true, false},
+		{"collection day afternoon", time.Date(2026, 1, 20, 13, 0, 0, 0, time.UTC), false, false, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var on [maxBins + 1]bool
+			on[BinUnknown] = true // must be cleared
+			binStates(jobs, tc.now, 0, &on)
+			if on[BinGreen] != tc.green || on[BinBlack] != tc.black || on[BinBrown] != tc.brown {
+				t.Errorf("green/black/brown = %v/%v/%v, want %v/%v/%v",
+					on[BinGreen], on[BinBlack], on[BinBrown], tc.green, tc.black, tc.brown)
+			}
+			if on[BinUnknown] {
+				t.Error("BinUnknown state not cleared")
+			}
+		})
+	}
+}
+
+func TestNextCollection(t *testing.T) {
+	if err := loadBinRegistry(windowTestBins); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	jobs := []BinJob{
+		{Year: 2026, Month: 1, Day: 20, Bin: BinBrown},
+		{Year: 2026, Month: 1, Day: 20, Bin: BinGreen},
+		{Year: 2026, Month: 1, Day: 27, Bin: BinBlack},
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected int
+	}{
+		{"before all", time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC), 0},
+		{"garden window closed", time.Date(2026, 1, 20, 10, 0, 0, 0, time.UTC), 1},
+		{"both closed", time.Date(2026, 1, 20, 13, 0, 0, 0, time.UTC), 2},
+		{"all passed", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), -1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := nextCollection(jobs, tc.now); got != tc.expected {
+				t.Errorf("nextCollection() = %d, want %d", got, tc.expected)
+			}
+		})
+	}
+}
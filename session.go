@@ -0,0 +1,66 @@
+package main
+
+import "time"
+
+// Persistent MQTT session (enabled by config/mqtt_keepalive.text). The
+// device stays connected and subscribes to a retained schedule topic per
+// premises (see scheduleTopic), so the bridge can push a new schedule at
+// any time.
+
+// Reconnect backoff after the session drops: 2s doubling to 5m
+const (
+	sessionMinBackoff = 2 * time.Second
+	sessionMaxBackoff = 5 * time.Minute
+)
+
+// sessionFailedAttempts failed connects in a row count as one failed
+// refresh for the functional watchdog (a fetch also tries 4 times)
+const sessionFailedAttempts = 4
+
+// nextSessionBackoff doubles a reconnect backoff, capped at
+// sessionMaxBackoff (0 starts at sessionMinBackoff)
+func nextSessionBackoff(d time.Duration) time.Duration {
+	if d < sessionMinBackoff {
+		return sessionMinBackoff
+	}
+	d *= 2
+	if d > sessionMaxBackoff {
+		d = sessionMaxBackoff
+	}
+	return d
+}
+
+// sessionKeepAlive tracks traffic on the persistent session. The client
+// pings when it has sent nothing for half the keepalive, and gives the link
+// up when nothing (not even a ping response) arrived for one and a half
+// keepalives, the same grace the broker allows the client.
+type sessionKeepAlive struct {
+	interval time.Duration
+	lastTx   time.Time
+	lastRx   time.Time
+}
+
+// reset starts tracking a new connection
+func (k *sessionKeepAlive) reset(interval time.Duration, now time.Time) {
+	k.interval, k.lastTx, k.lastRx = interval, now, now
+}
+
+// sent records a packet sent to the broker
+func (k *sessionKeepAlive) sent(now time.Time) {
+	k.lastTx = now
+}
+
+// received records a packet from the broker
+func (k *sessionKeepAlive) received(now time.Time) {
+	k.lastRx = now
+}
+
+// pingDue reports whether a ping should be sent
+func (k *sessionKeepAlive) pingDue(now time.Time) bool {
+	return now.Sub(k.lastTx) >= k.interval/2
+}
+
+// expired reports whether the broker has gone quiet for too long
+func (k *sessionKeepAlive) expired(now time.Time) bool {
+	return now.Sub(k.lastRx) >= k.interval*3/2
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+func TestScheduleTopic(t *testing.T) {
+	useTestPremises(t)
+	useTestTopics(t, config.DefaultTopics(), "kitchen")
+
+	if got := string(scheduleTopic(1)); got != "bindicator/schedule/37" {
+		t.Errorf("scheduleTopic(1) = %q", got)
+	}
+	tests := []struct {
+		topic string
+		want  int
+	}{
+		{"bindicator/schedule/1", 0},
+		{"bindicator/schedule/37", 1},
+		{"bindicator/schedule/99", -1},
+		{"bindicator/schedule/", -1},
+		{"bindicator/response", -1},
+	}
+	for _, tc := range tests {
+		if got := scheduleTopicPremises([]byte(tc.topic)); got != tc.want {
+			t.Errorf("scheduleTopicPremises(%q) = %d, want %d", tc.topic, got, tc.want)
+		}
+	}
+}
+
+func TestNextSessionBackoff(t *testing.T) {
+	var d time.Duration
+	var got []time.Duration
+	for i := 0; i < 10; i++ {
+		d = nextSessionBackoff(d)
+		got = append(got, d)
+	}
+	if got[0] != sessionMinBackoff || got[1] != 4*time.Second || got[9] != sessionMaxBackoff {
+		t.Errorf("backoff = %v", got)
+	}
+}
+
+func TestSessionKeepAlive(t *testing.T) {
+	start := time.Date(2026, 1, 19, 20, 0, 0, 0, time.UTC)
+	var k sessionKeepAlive
+	k.reset(time.Minute, start)
+
+	if k.pingDue(start.Add(29*time.Second)) || !k.pingDue(start.Add(30*time.Second)) {
+		t.Error("ping due at half the keepalive")
+	}
+	k.sent(start.Add(30 * time.Second))
+	if k.pingDue(start.Add(45 * time.Second)) {
+		t.Error("ping due right after sending")
+	}
+
+	// A ping response keeps the link alive
+	k.received(start.Add(31 * time.Second))
+	if k.expired(start.Add(90 * time.Second)) {
+		t.Error("expired one keepalive after the last packet")
+	}
+	if !k.expired(start.Add(121 * time.Second)) {
+		t.Error("not expired after one and a half keepalives of silence")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Device status topics: bindicator/<clientid>/state carries the retained
+// state JSON, bindicator/<clientid>/availability is "online" while the
+// device is connected and "offline" (the MQTT Last Will) once the broker
+// loses it. They are expanded from topicDeviceTemplate like the schedule
+// topics (see expandTopic).
+const (
+	topicDeviceTemplate     = "bindicator/{client}"
+	topicStateSuffix        = "/state"
+	topicAvailabilitySuffix = "/availability"
+	maxStatusClientIDLen    = 32
+	availabilityOnline      = "online"
+	availabilityOffline     = "offline"
+	stateBufSize            = 1536
+	maxStatusTopicLen       = len(topicDeviceTemplate) + maxStatusClientIDLen + len(topicAvailabilitySuffix)
+)
+
+// Status topics (built by setStatusTopics)
+var (
+	stateTopicBuf        [maxStatusTopicLen]byte
+	stateTopicLen        int
+	availabilityTopicBuf [maxStatusTopicLen]byte
+	availabilityTopicLen int
+)
+
+// setStatusTopics builds the status topics for a client ID (without the
+// random suffix, so the topics stay put across reconnects), and the command
+// topics next to them. Long IDs are cut to maxStatusClientIDLen.
+func setStatusTopics(clientID string) {
+	clientID = topicClientID(clientID)
+	stateTopicLen = expandTopic(stateTopicBuf[:], topicDeviceTemplate+topicStateSuffix, clientID, "")
+	availabilityTopicLen = expandTopic(availabilityTopicBuf[:], topicDeviceTemplate+topicAvailabilitySuffix, clientID, "")
+	setCommandTopics(clientID)
+}
+
+// statusTopicBase returns bindicator/<clientid>
+func statusTopicBase() []byte {
+	return stateTopicBuf[:stateTopicLen-len(topicStateSuffix)]
+}
+
+// stateTopic returns bindicator/<clientid>/state
+func stateTopic() []byte {
+	return stateTopicBuf[:stateTopicLen]
+}
+
+// availabilityTopic returns bindicator/<clientid>/availability
+func availabilityTopic() []byte {
+	return availabilityTopicBuf[:availabilityTopicLen]
+}
+
+// deviceState is the device-wide part of the state JSON; the LED states
+// and next collections are added per premises by encodeState
+type deviceState struct {
+	Time                time.Time
+	Version             string
+	GitSHA              string
+	Partition           string
+	Uptime              time.Duration
+	Healthy             bool
+	ConsecutiveFailures int
+	WiFiConnected       time.Duration // Time since WiFi connected
+	MQTTSuccess         int
+	MQTTFail            int
+	LastMQTTSuccess     time.Time // Zero if never
+}
+
+var errStateTooLarge = errors.New("state: JSON does not fit the buffer")
+
+// stateBuf holds the encoded state JSON
+var stateBuf [stateBufSize]byte
+
+// encodeState writes the state JSON into buf:
+//
+//	{"ts":1768852800,"version":"v1.2.0","sha":"abc1234","partition":"A",
+//	 "uptime_s":3600,"healthy":true,"consecutive_failures":0,
+//	 "wifi":{"connected_s":3590,"mqtt_success":4,"mqtt_fail":0,"last_success":1768852790},
+//	 "premises":[{"name":"home","id":"1","leds":{"green":false,"black":true,"brown":false},
+//	   "next":{"date":"2026-01-20","bin":"black"}}]}
+//
+// The LEDs are the states last set by updateLEDsFromSchedule; next is the
+// first collection in jobs (with exceptions applied) whose window is still
+// open, or null. Returns the length written.
+func encodeState(buf []byte, s *deviceState, jobs []BinJob) (int, error) {
+	w := stateWriter{buf: buf}
+	w.raw(`{"ts":`)
+	w.int(s.Time.Unix())
+	w.raw(`,"version":`)
+	w.str(s.Version)
+	w.raw(`,"sha":`)
+	w.str(s.GitSHA)
+	w.raw(`,"partition":`)
+	w.str(s.Partition)
+	w.raw(`,"uptime_s":`)
+	w.int(int64(s.Uptime / time.Second))
+	w.raw(`,"healthy":`)
+	w.bool(s.Healthy)
+	w.raw(`,"consecutive_failures":`)
+	w.int(int64(s.ConsecutiveFailures))
+
+	w.raw(`,"wifi":{"connected_s":`)
+	w.int(int64(s.WiFiConnected / time.Second))
+	w.raw(`,"mqtt_success":`)
+	w.int(int64(s.MQTTSuccess))
+	w.raw(`,"mqtt_fail":`)
+	w.int(int64(s.MQTTFail))
+	w.raw(`,"last_success":`)
+	if s.LastMQTTSuccess.IsZero() {
+		w.int(0)
+	} else {
+		w.int(s.LastMQTTSuccess.Unix())
+	}
+
+	w.raw(`},"premises":[`)
+	for p := 0; p < numPremises(); p++ {
+		if p > 0 {
+			w.raw(",")
+		}
+		w.raw(`{"name":`)
+		w.str(premisesName(p))
+		w.raw(`,"id":`)
+		w.str(premisesID(p))
+		w.raw(`,"leds":{`)
+		for bt := BinType(1); int(bt) <= numBins(); bt++ {
+			if bt > 1 {
+				w.raw(",")
+			}
+			w.str(bt.String())
+			w.raw(":")
+			w.bool(ledState[p][bt])
+		}
+		w.raw(`},"next":`)
+		if job := nextPremisesCollection(jobs, s.Time, p); job != nil {
+			var date [10]byte
+			writeDate(date[:], job.Year, job.Month, job.Day)
+			w.raw(`{"date":"`)
+			w.bytes(date[:])
+			w.raw(`","bin":`)
+			w.str(job.Bin.String())
+			w.raw("}")
+		} else {
+			w.raw("null")
+		}
+		w.raw("}")
+	}
+	w.raw("]}")
+
+	if w.overflow {
+		return 0, errStateTooLarge
+	}
+	return w.n, nil
+}
+
+// nextPremisesCollection returns the first job at premises p whose window
+// has not closed, or nil
+func nextPremisesCollection(jobs []BinJob, now time.Time, p int) *BinJob {
+	for i := 0; i < len(jobs); i++ {
+		if jobPremises(&jobs[i]) != p {
+			continue
+		}
+		if _, off := collectionWindow(&jobs[i]); now.Before(off) {
+			return &jobs[i]
+		}
+	}
+	return nil
+}
+
+// stateWriter appends JSON to a fixed buffer, recording overflow instead
+// of growing it
+type stateWriter struct {
+	buf      []byte
+	n        int
+	overflow bool
+}
+
+func (w *stateWriter) raw(s string) {
+	if w.n+len(s) > len(w.buf) {
+		w.overflow = true
+		return
+	}
+	w.n += copy(w.buf[w.n:], s)
+}
+
+func (w *stateWriter) bytes(b []byte) {
+	if w.n+len(b) > len(w.buf) {
+		w.overflow = true
+		return
+	}
+	w.n += copy(w.buf[w.n:], b)
+}
+
+// str writes a JSON string (see esc)
+func (w *stateWriter) str(s string) {
+	w.raw(`"`)
+	w.esc(s)
+	w.raw(`"`)
+}
+
+// esc writes the body of a JSON string. Config names and IDs are plain
+// ASCII, so only quotes and backslashes are escaped and control characters
+// dropped.
+func (w *stateWriter) esc(s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			w.raw(`\`)
+			w.raw(s[i : i+1])
+		case c >= ' ':
+			w.raw(s[i : i+1])
+		}
+	}
+}
+
+func (w *stateWriter) int(v int64) {
+	var digits [20]byte
+	i := len(digits)
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	for {
+		i--
+		digits[i] = byte('0' + v%10)
+		v /= 10
+		if v == 0 {
+			break
+		}
+	}
+	if neg {
+		i--
+		digits[i] = '-'
+	}
+	w.bytes(digits[i:])
+}
+
+func (w *stateWriter) bool(v bool) {
+	if v {
+		w.raw("true")
+	} else {
+		w.raw("false")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStatusTopics(t *testing.T) {
+	setStatusTopics("kitchen")
+	if string(stateTopic()) != "bindicator/kitchen/state" || string(availabilityTopic()) != "bindicator/kitchen/availability" {
+		t.Errorf("topics = %q, %q", stateTopic(), availabilityTopic())
+	}
+	setStatusTopics("a-very-long-client-id-that-does-not-fit")
+	if string(availabilityTopic()) != "bindicator/a-very-long-client-id-that-does-/availability" {
+		t.Errorf("availability topic = %q", availabilityTopic())
+	}
+}
+
+func TestEncodeState(t *testing.T) {
+	useTestPremises(t)
+	now := time.Date(2026, 1, 19, 20, 0, 0, 0, time.UTC)
+	parsePremisesResponse([]byte("1,2026-01-20:BLACK,2026-01-27:GREEN"), 0)
+	updateLEDsFromSchedule(getJobs(), now)
+
+	s := deviceState{
+		Time:            now,
+		Version:         "v1.2.0",
+		Partition:       "B",
+		Healthy:         true,
+		WiFiConnected:   90 * time.Minute,
+		MQTTSuccess:     4,
+		MQTTFail:        1,
+		LastMQTTSuccess: now.Add(-time.Minute),
+	}
+	n, err := encodeState(stateBuf[:], &s, getJobs())
+	if err != nil {
+		t.Fatalf("encodeState: %v", err)
+	}
+
+	var got struct {
+		Ts        int64
+		Partition string
+		Healthy   bool
+		WiFi      struct {
+			ConnectedS  int64 `json:"connected_s"`
+			MQTTFail    int   `json:"mqtt_fail"`
+			LastSuccess int64 `json:"last_success"`
+		}
+		Premises []struct {
+			Name string
+			LEDs map[string]bool
+			Next *struct{ Date, Bin string }
+		}
+	}
+	if err := json.Unmarshal(stateBuf[:n], &got); err != nil {
+		t.Fatalf("invalid JSON %s: %v", stateBuf[:n], err)
+	}
+	if got.Ts != now.Unix() || got.Partition != "B" || !got.Healthy ||
+		got.WiFi.ConnectedS != 5400 || got.WiFi.MQTTFail != 1 || got.WiFi.LastSuccess != now.Unix()-60 {
+		t.Errorf("state = %s", stateBuf[:n])
+	}
+	if len(got.Premises) != 2 || got.Premises[1].Name != "parents" {
+		t.Fatalf("premises = %+v", got.Premises)
+	}
+	office := got.Premises[0]
+	if !office.LEDs["black"] || office.LEDs["green"] || office.Next == nil ||
+		office.Next.Date != "2026-01-20" || office.Next.Bin != "black" {
+		t.Errorf("office = %+v", office)
+	}
+	if got.Premises[1].Next != nil {
+		t.Errorf("parents next = %+v, want null", got.Premises[1].Next)
+	}
+
+	if _, err := encodeState(stateBuf[:100], &s, getJobs()); err != errStateTooLarge {
+		t.Errorf("encodeState(short buffer) = %v, want %v", err, errStateTooLarge)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Schedule record persisted to flash (little-endian):
+//
+//	magic    uint32 "BSCH"
+//	version  uint16
+//	count    uint16
+//	fetched  int64  Unix time of the fetch that produced the schedule
+//	jobs     count * storeJobSize
+//	excount  uint16 (version 2 on)
+//	reserved uint16
+//	excepts  excount * storeExceptionSize
+//	crc      uint32 CRC-32 (IEEE) of everything before it
+//
+// Bins are stored by name rather than BinType so a record stays valid if
+// the registry order changes between firmware builds. Generated jobs are
+// not stored; they are recreated from the recurrence rules. Version 1
+// records (no exceptions section) and version 2 records (exceptions
+// without a premises ID) are still read.
+const (
+	storeMagic           = 0x48435342 // "BSCH"
+	storeVersion         = 3
+	storeHeaderSize      = 16
+	storeBinNameLen      = 16
+	storeJobSize         = 8 + storeBinNameLen + maxNoteLen + maxPremisesLen
+	storeExceptionSizeV2 = 12 + storeBinNameLen
+	storeExceptionSize   = storeExceptionSizeV2 + maxPremisesLen
+	storeMaxSize         = storeHeaderSize + maxJobs*storeJobSize + 4 + maxExceptions*storeExceptionSize + 4
+)
+
+// Schedule store errors
+var (
+	errStoreEmpty   = errors.New("store: no schedule saved")
+	errStoreVersion = errors.New("store: unsupported record version")
+	errStoreCorrupt = errors.New("store: corrupt record")
+)
+
+// encodeSchedule writes a schedule record into buf (at least storeMaxSize
+// bytes) and returns its length
+func encodeSchedule(buf []byte, jobs []BinJob, exceptions []ScheduleException, fetched int64) int {
+	le := binary.LittleEndian
+	if len(jobs) > maxJobs {
+		jobs = jobs[:maxJobs]
+	}
+	le.PutUint32(buf[0:], storeMagic)
+	le.PutUint16(buf[4:], storeVersion)
+	le.PutUint64(buf[8:], uint64(fetched))
+
+	pos := storeHeaderSize
+	count := 0
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Generated() {
+			continue
+		}
+		count++
+		rec := buf[pos : pos+storeJobSize]
+		for j := range rec {
+			rec[j] = 0
+		}
+		le.PutUint16(rec[0:], job.Year)
+		rec[2] = job.Month
+		rec[3] = job.Day
+		rec[4] = uint8(copy(rec[8:8+storeBinNameLen], job.Bin.String()))
+		rec[5] = job.NoteLen
+		rec[6] = job.PremisesLen
+		rec[7] = job.Flags
+		copy(rec[8+storeBinNameLen:], job.Note[:])
+		copy(rec[8+storeBinNameLen+maxNoteLen:], job.Premises[:])
+		pos += storeJobSize
+	}
+	le.PutUint16(buf[6:], uint16(count))
+
+	if len(exceptions) > maxExceptions {
+		exceptions = exceptions[:maxExceptions]
+	}
+	le.PutUint16(buf[pos:], uint16(len(exceptions)))
+	le.PutUint16(buf[pos+2:], 0)
+	pos += 4
+	for i := range exceptions {
+		ex := &exceptions[i]
+		rec := buf[pos : pos+storeExceptionSize]
+		for j := range rec {
+			rec[j] = 0
+		}
+		rec[0] = uint8(ex.Action)
+		rec[1] = uint8(copy(rec[12:12+storeBinNameLen], ex.Bin.String()))
+		if ex.Manual {
+			rec[2] = 1
+		}
+		rec[3] = ex.PremisesLen
+		copy(rec[12+storeBinNameLen:], ex.Premises[:ex.PremisesLen])
+		le.PutUint16(rec[4:], ex.Year)
+		rec[6] = ex.Month
+		rec[7] = ex.Day
+		le.PutUint16(rec[8:], ex.ToYear)
+		rec[10] = ex.ToMonth
+		rec[11] = ex.ToDay
+		pos += storeExceptionSize
+	}
+
+	le.PutUint32(buf[pos:], crc32IEEE(buf[:pos]))
+	return pos + 4
+}
+
+// decodeSchedule validates a schedule record and loads its jobs into
+// jobStorage and its exceptions into the exception list. Jobs and
+// exceptions whose bin is no longer registered are skipped (and counted in
+// skipped). Neither is touched unless the record is valid.
+func decodeSchedule(buf []byte) (fetched int64, skipped int, err error) {
+	le := binary.LittleEndian
+	if len(buf) < storeHeaderSize+4 {
+		return 0, 0, errStoreCorrupt
+	}
+	switch le.Uint32(buf[0:]) {
+	case storeMagic:
+	case 0xFFFFFFFF:
+		return 0, 0, errStoreEmpty // Erased flash
+	default:
+		return 0, 0, errStoreCorrupt
+	}
+	version := le.Uint16(buf[4:])
+	if version < 1 || version > storeVersion {
+		return 0, 0, errStoreVersion
+	}
+	count := int(le.Uint16(buf[6:]))
+	jobsEnd := storeHeaderSize + count*storeJobSize
+	if count > maxJobs || jobsEnd+4 > len(buf) {
+		return 0, 0, errStoreCorrupt
+	}
+	end, exCount, exSize := jobsEnd, 0, storeExceptionSize
+	if version == 2 {
+		exSize = storeExceptionSizeV2
+	}
+	if version >= 2 {
+		exCount = int(le.Uint16(buf[jobsEnd:]))
+		end = jobsEnd + 4 + exCount*exSize
+		if exCount > maxExceptions || end+4 > len(buf) {
+			return 0, 0, errStoreCorrupt
+		}
+	}
+	if le.Uint32(buf[end:]) != crc32IEEE(buf[:end]) {
+		return 0, 0, errStoreCorrupt
+	}
+	fetched = int64(le.Uint64(buf[8:]))
+
+	clearJobs()
+	for pos := storeHeaderSize; pos < jobsEnd; pos += storeJobSize {
+		rec := buf[pos : pos+storeJobSize]
+		nameLen, noteLen, premisesLen := int(rec[4]), rec[5], rec[6]
+		if nameLen > storeBinNameLen || noteLen > maxNoteLen || premisesLen > maxPremisesLen {
+			skipped++
+			continue
+		}
+		bt := lookupBin(rec[8 : 8+nameLen])
+		if bt == BinUnknown {
+			skipped++
+			continue
+		}
+		job := BinJob{
+			Year:        le.Uint16(rec[0:]),
+			Month:       rec[2],
+			Day:         rec[3],
+			Bin:         bt,
+			NoteLen:     noteLen,
+			PremisesLen: premisesLen,
+			Flags:       rec[7] &^ jobGenerated,
+		}
+		copy(job.Note[:], rec[8+storeBinNameLen:])
+		copy(job.Premises[:], rec[8+storeBinNameLen+maxNoteLen:])
+		insertJob(&job)
+	}
+
+	clearExceptions()
+	for pos := jobsEnd + 4; pos < end; pos += exSize {
+		rec := buf[pos : pos+exSize]
+		nameLen, premisesLen := int(rec[1]), rec[3] // Zero in version 2
+		if nameLen > storeBinNameLen || premisesLen > maxPremisesLen {
+			skipped++
+			continue
+		}
+		bt := lookupBin(rec[12 : 12+nameLen])
+		if bt == BinUnknown {
+			skipped++
+			continue
+		}
+		ex := ScheduleException{
+			Action:  ExceptionAction(rec[0]),
+			Bin:     bt,
+			Manual:  rec[2] != 0,
+			Year:    le.Uint16(rec[4:]),
+			Month:   rec[6],
+			Day:     rec[7],
+			ToYear:  le.Uint16(rec[8:]),
+			ToMonth: rec[10],
+			ToDay:   rec[11],
+		}
+		ex.PremisesLen = uint8(copy(ex.Premises[:], rec[12+storeBinNameLen:12+storeBinNameLen+int(premisesLen)]))
+		addException(&ex)
+	}
+	return fetched, skipped, nil
+}
+
+// crc32IEEE computes the IEEE CRC-32 of data without a lookup table
+// (hash/crc32 allocates its table on first use)
+func crc32IEEE(data []byte) uint32 {
+	crc := ^uint32(0)
+	for _, b := range data {
+		crc ^= uint32(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xEDB88320
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
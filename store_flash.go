@@ -0,0 +1,141 @@
+//go:build tinygo
+
+package main
+
+import (
+	"device/rp"
+	"errors"
+	"runtime"
+	"time"
+
+	"openenterprise/bindicator/ota"
+)
+
+// Flash location of the schedule record (start of the reserved region)
+const (
+	storeOffset  = ota.ReservedOffset
+	storeBufSize = (storeMaxSize + ota.PageSize - 1) / ota.PageSize * ota.PageSize
+	storeSectors = (storeBufSize + ota.SectorSize - 1) / ota.SectorSize
+
+	// storeRewriteAge forces a rewrite of an unchanged schedule so the
+	// stored fetch time does not go stale
+	storeRewriteAge = 24 * time.Hour
+)
+
+var errStoreTooLarge = errors.New("store: record exceeds reserved region")
+
+// Pre-allocated buffers for the encoded record and for comparing it with
+// flash a page at a time
+var (
+	storeBuf  [storeBufSize]byte
+	storePage [ota.PageSize]byte
+)
+
+// Saved schedule info (for console status)
+var (
+	storedFetchTime time.Time // Fetch time of the record in flash
+	storeWrites     int       // Flash writes since boot
+	storeLastErr    error     // Last save/restore error
+)
+
+// saveSchedule persists the current jobs, exceptions and fetch time to
+// flash. The record is only rewritten when the jobs or exceptions changed
+// or the stored copy is older than storeRewriteAge, to limit flash wear.
+// Returns true if flash was written.
+func saveSchedule(fetched time.Time) (bool, error) {
+	if storeOffset+storeSectors*ota.SectorSize > configStoreOffset {
+		return false, errStoreTooLarge
+	}
+	n := encodeSchedule(storeBuf[:], getJobs(), getExceptions(), fetched.Unix())
+	size := (n + ota.PageSize - 1) / ota.PageSize * ota.PageSize
+	for i := n; i < size; i++ {
+		storeBuf[i] = 0xFF // Erased flash value
+	}
+
+	// Skip the write if only the fetch time (and so the CRC) changed
+	if flashMatches(storeOffset, storeBuf[:8]) &&
+		flashMatches(storeOffset+storeHeaderSize, storeBuf[storeHeaderSize:n-4]) &&
+		fetched.Sub(storedFetchTime) < storeRewriteAge {
+		return false, nil
+	}
+
+	for s := 0; s < storeSectors; s++ {
+		if err := ota.EraseSector(storeOffset + uint32(s*ota.SectorSize)); err != nil {
+			storeLastErr = err
+			return false, err
+		}
+	}
+	if err := ota.WriteChunk(storeOffset, storeBuf[:size]); err != nil {
+		storeLastErr = err
+		return false, err
+	}
+
+	// Read back to confirm the write took
+	if !flashMatches(storeOffset, storeBuf[:n]) {
+		storeLastErr = ota.ErrFlashWriteFailed
+		return false, ota.ErrFlashWriteFailed
+	}
+	storedFetchTime = time.Unix(fetched.Unix(), 0)
+	storeWrites++
+	storeLastErr = nil
+	return true, nil
+}
+
+// flashMatches reports whether flash at offset holds data
+func flashMatches(offset uint32, data []byte) bool {
+	for len(data) > 0 {
+		chunk := storePage[:min(len(data), len(storePage))]
+		ota.ReadFlash(offset, chunk)
+		if string(chunk) != string(data[:len(chunk)]) {
+			return false
+		}
+		offset += uint32(len(chunk))
+		data = data[len(chunk):]
+	}
+	return true
+}
+
+// restoreSchedule loads the saved schedule from flash into jobStorage.
+// Returns the fetch time of the record and the number of jobs skipped
+// because their bin is no longer configured.
+func restoreSchedule() (time.Time, int, error) {
+	ota.ReadFlash(storeOffset, storeBuf[:])
+	fetched, skipped, err := decodeSchedule(storeBuf[:])
+	storeLastErr = err
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	storedFetchTime = time.Unix(fetched, 0)
+	return storedFetchTime, skipped, nil
+}
+
+// Clock hint kept in watchdog scratch registers, which survive watchdog
+// and soft resets (but not power loss). SCRATCH2-7 are used by the
+// bootrom and its reboot API, so only SCRATCH0/1 are used: the Unix time and its complement
+// XORed with a magic value as a validity check.
+const clockHintMagic = 0xB1D1CA70
+
+// saveClockHint records the current time for the next boot. Does nothing
+// until the clock has been set.
+func saveClockHint(now time.Time) {
+	if !clockIsSet(now) {
+		return
+	}
+	t := uint32(now.Unix())
+	rp.WATCHDOG.SCRATCH0.Set(t)
+	rp.WATCHDOG.SCRATCH1.Set(^t ^ clockHintMagic)
+}
+
+// restoreClockHint sets the clock from the hint left by the previous boot.
+// Returns false if there is no valid hint. The hint is at most one wake
+// interval (plus the reboot time) behind, which is close enough to pick
+// the right LEDs until NTP or MQTT sets the clock properly.
+func restoreClockHint() (time.Time, bool) {
+	t := rp.WATCHDOG.SCRATCH0.Get()
+	if t == 0 || rp.WATCHDOG.SCRATCH1.Get() != ^t^clockHintMagic {
+		return time.Time{}, false
+	}
+	hint := time.Unix(int64(t), 0)
+	runtime.AdjustTimeOffset(int64(hint.Sub(time.Now())))
+	return hint, true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"openenterprise/bindicator/config"
+)
+
+func TestScheduleRoundTrip(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+	parseScheduleResponse([]byte(`{"v":2,"ts":1,"premises":"12A","jobs":[` +
+		`{"date":"2026-01-17","bin":"BLACK","note":"Put out by 7am"},` +
+		`{"date":"2026-01-31","bin":"GREEN"}],` +
+		`"exceptions":[{"action":"move","date":"2026-01-31","bin":"GREEN","to":"2026-02-01"}]}`))
+	manual, _ := parseExceptionCommand([]byte("add 2026-02-07 brown"))
+	addException(&manual)
+	want := append([]BinJob(nil), getJobs()...)
+	wantExceptions := append([]ScheduleException(nil), getExceptions()...)
+
+	var buf [storeMaxSize]byte
+	n := encodeSchedule(buf[:], getJobs(), getExceptions(), 1737207000)
+	clearJobs()
+	clearExceptions()
+
+	fetched, skipped, err := decodeSchedule(buf[:n])
+	if err != nil {
+		t.Fatalf("decodeSchedule() err = %v", err)
+	}
+	if fetched != 1737207000 {
+		t.Errorf("fetched = %d, want 1737207000", fetched)
+	}
+	if skipped != 0 {
+		t.Errorf("skipped = %d, want 0", skipped)
+	}
+	got := getJobs()
+	if len(got) != len(want) {
+		t.Fatalf("len(jobs) = %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("job[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got[0].NoteString() != "Put out by 7am" || got[1].PremisesID() != "12A" {
+		t.Errorf("note/premises = %q/%q", got[0].NoteString(), got[1].PremisesID())
+	}
+	gotExceptions := getExceptions()
+	if len(gotExceptions) != len(wantExceptions) {
+		t.Fatalf("len(exceptions) = %d, want %d", len(gotExceptions), len(wantExceptions))
+	}
+	for i := range wantExceptions {
+		if gotExceptions[i] != wantExceptions[i] {
+			t.Errorf("exception[%d] = %+v, want %+v", i, gotExceptions[i], wantExceptions[i])
+		}
+	}
+}
+
+func TestDecodeScheduleVersion1(t *testing.T) {
+	clearExceptions()
+	parseScheduleResponse([]byte("1,2026-01-17:BLACK,2026-01-31:GREEN"))
+	var buf [storeMaxSize]byte
+	n := encodeSchedule(buf[:], getJobs(), nil, 1)
+
+	// A version 1 record has no exceptions section
+	n -= 8
+	buf[4] = 1
+	binary.LittleEndian.PutUint32(buf[n:], crc32IEEE(buf[:n]))
+	n += 4
+
+	manual, _ := parseExceptionCommand([]byte("cancel 2026-01-17 black"))
+	addException(&manual)
+	clearJobs()
+	if _, _, err := decodeSchedule(buf[:n]); err != nil {
+		t.Fatalf("decodeSchedule() err = %v", err)
+	}
+	if len(getJobs()) != 2 || len(getExceptions()) != 0 {
+		t.Errorf("restored %d jobs and %d exceptions, want 2 and 0", len(getJobs()), len(getExceptions()))
+	}
+}
+
+func TestDecodeScheduleErrors(t *testing.T) {
+	parseScheduleResponse([]byte("1,2026-01-17:BLACK,2026-01-31:GREEN"))
+
+	var valid [storeMaxSize]byte
+	n := encodeSchedule(valid[:], getJobs(), getExceptions(), 1)
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+		err    error
+	}{
+		{"erased flash", func(b []byte) []byte {
+			for i := range b {
+				b[i] = 0xFF
+			}
+			return b
+		}, errStoreEmpty},
+		{"bad magic", func(b []byte) []byte { b[0] = 'X'; return b }, errStoreCorrupt},
+		{"future version", func(b []byte) []byte { b[4] = 9; return b }, errStoreVersion},
+		{"flipped bit", func(b []byte) []byte { b[storeHeaderSize] ^= 1; return b }, errStoreCorrupt},
+		{"bad crc", func(b []byte) []byte { b[n-1] ^= 0x80; return b }, errStoreCorrupt},
+		{"count too large", func(b []byte) []byte { b[6] = 0xFF; return b }, errStoreCorrupt},
+		{"truncated", func(b []byte) []byte { return b[:n-2] }, errStoreCorrupt},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf [storeMaxSize]byte
+			copy(buf[:], valid[:n])
+			if _, _, err := decodeSchedule(tc.modify(buf[:n])); err != tc.err {
+				t.Errorf("err = %v, want %v", err, tc.err)
+			}
+			if len(getJobs()) != 2 {
+				t.Errorf("len(getJobs()) = %d, want cached 2", len(getJobs()))
+			}
+		})
+	}
+}
+
+func TestDecodeScheduleUnknownBin(t *testing.T) {
+	parseScheduleResponse([]byte("1,2026-01-17:BLACK,2026-01-24:BROWN,2026-01-31:GREEN"))
+	var buf [storeMaxSize]byte
+	n := encodeSchedule(buf[:], getJobs(), getExceptions(), 1)
+
+	// Reboot with a registry that no longer has a brown bin
+	if err := loadBinRegistry([]config.Bin{
+		{Name: "green", Channel: 2},
+		{Name: "black", Channel: 3},
+	}); err != nil {
+		t.Fatalf("loadBinRegistry: %v", err)
+	}
+	defer loadBinRegistry(config.DefaultBins)
+
+	_, skipped, err := decodeSchedule(buf[:n])
+	if err != nil {
+		t.Fatalf("decodeSchedule() err = %v", err)
+	}
+	if skipped != 1 {
+		t.Errorf("skipped = %d, want 1", skipped)
+	}
+	jobs := getJobs()
+	if len(jobs) != 2 || jobs[0].Bin != lookupBinName("black") || jobs[1].Bin != lookupBinName("green") {
+		t.Errorf("jobs = %+v, want black then green", jobs)
+	}
+}
+
+func TestCRC32IEEE(t *testing.T) {
+	// Standard check value for CRC-32/ISO-HDLC
+	if got := crc32IEEE([]byte("123456789")); got != 0xCBF43926 {
+		t.Errorf("crc32IEEE() = %#x, want 0xcbf43926", got)
+	}
+	if got := crc32IEEE(nil); got != 0 {
+		t.Errorf("crc32IEEE(nil) = %#x, want 0", got)
+	}
+}
+
+func TestDecodeScheduleVersion2(t *testing.T) {
+	clearExceptions()
+	defer clearExceptions()
+	parseScheduleResponse([]byte("1,2026-01-17:BLACK"))
+	manual, _ := parseExceptionCommand([]byte("move 2026-01-17 black 2026-01-18"))
+	addException(&manual)
+	var buf [storeMaxSize]byte
+	n := encodeSchedule(buf[:], getJobs(), getExceptions(), 1)
+
+	// A version 2 exception record has no premises ID
+	n -= storeExceptionSize - storeExceptionSizeV2
+	buf[4] = 2
+	binary.LittleEndian.PutUint32(buf[n-4:], crc32IEEE(buf[:n-4]))
+
+	clearExceptions()
+	if _, _, err := decodeSchedule(buf[:n]); err != nil {
+		t.Fatalf("decodeSchedule() err = %v", err)
+	}
+	if len(getExceptions()) != 1 || getExceptions()[0] != manual {
+		t.Errorf("exceptions = %+v, want %+v", getExceptions(), manual)
+	}
+}
@@ -163,6 +163,11 @@ func (w *jsonWriter) writeResourceAttributes() {
 	w.writeString(shortSHA())
 	w.writeRaw(`}},`)
 	w.writeRaw(`{"key":"host.name","value":{"stringValue":"bindicator-pico"}}`)
+	if wifiSSID != "" {
+		w.writeRaw(`,{"key":"wifi.ssid","value":{"stringValue":`)
+		w.writeString(wifiSSID)
+		w.writeRaw(`}}`)
+	}
 	w.writeRaw(`]}`)
 }
 
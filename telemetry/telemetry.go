@@ -117,6 +117,7 @@ var (
 	stack     *xnet.StackAsync
 	logger    *slog.Logger
 	collector netip.AddrPort
+	wifiSSID  string // Network in use (resource attribute)
 
 	// Current trace context (set by GenerateTraceID)
 	CurrentTraceID [16]byte
@@ -130,6 +131,14 @@ var (
 	SendErrors  int
 )
 
+// SetWiFiNetwork records the WiFi network the device joined. It is sent
+// as the wifi.ssid resource attribute.
+func SetWiFiNetwork(ssid string) {
+	mu.Lock()
+	wifiSSID = ssid
+	mu.Unlock()
+}
+
 // Init initializes the telemetry module with the given network stack and collector address.
 func Init(s *xnet.StackAsync, log *slog.Logger, collectorAddr netip.AddrPort) error {
 	mu.Lock()
@@ -0,0 +1,180 @@
+package main
+
+import "openenterprise/bindicator/config"
+
+// Schedule request, response and retained schedule topics, expanded from
+// the templates in config/topics.text. With {premises} in a template every
+// premises gets its own topic; otherwise all premises share one. The
+// status and command topics are expanded from fixed templates by the same
+// code (see setStatusTopics).
+const maxTopicLen = config.MaxTopicTemplateLen + maxStatusClientIDLen + maxPremisesLen
+
+// Expanded topics per premises (set by setTopicTemplates)
+var (
+	requestTopicBuf     [maxPremises][maxTopicLen]byte
+	requestTopicLen     [maxPremises]int
+	responseTopicBuf    [maxPremises][maxTopicLen]byte
+	responseTopicLen    [maxPremises]int
+	responsePerPremises bool // Response template contains {premises}
+	scheduleTopicBuf    [maxPremises][maxTopicLen]byte
+	scheduleTopicLen    [maxPremises]int
+)
+
+// nonceLen is the length of the hex nonce sent with each request
+const nonceLen = 8
+
+// requestNonce is the nonce of the last request. A versioned response
+// that carries a different nonce answers another device's request.
+var requestNonce [nonceLen]byte
+
+// acceptNonceless lets responses without a nonce through (CSV and older
+// bridges; see config.Topics.AcceptNonceless)
+var acceptNonceless bool
+
+// setTopicTemplates expands the request, response and schedule templates
+// for a client ID and every configured premises
+func setTopicTemplates(t config.Topics, clientID string) {
+	clientID = topicClientID(clientID)
+	acceptNonceless = t.AcceptNonceless()
+	responsePerPremises = false
+	for p := 0; p < numPremises(); p++ {
+		requestTopicLen[p] = expandTopic(requestTopicBuf[p][:], t.Request, clientID, premisesID(p))
+		responseTopicLen[p] = expandTopic(responseTopicBuf[p][:], t.Response, clientID, premisesID(p))
+		scheduleTopicLen[p] = expandTopic(scheduleTopicBuf[p][:], t.Schedule, clientID, premisesID(p))
+		if p > 0 && string(responseTopic(p)) != string(responseTopic(0)) {
+			responsePerPremises = true
+		}
+	}
+}
+
+// topicClientID returns the client ID as it appears in topics: cut to
+// maxStatusClientIDLen
+func topicClientID(clientID string) string {
+	if len(clientID) > maxStatusClientIDLen {
+		clientID = clientID[:maxStatusClientIDLen]
+	}
+	return clientID
+}
+
+// expandTopic writes template with its placeholders replaced into buf and
+// returns the length
+func expandTopic(buf []byte, template, clientID, premises string) int {
+	n := 0
+	for i := 0; i < len(template); i++ {
+		rest := template[i:]
+		switch {
+		case len(rest) >= len("{client}") && rest[:len("{client}")] == "{client}":
+			n += copy(buf[n:], clientID)
+			i += len("{client}") - 1
+		case len(rest) >= len("{premises}") && rest[:len("{premises}")] == "{premises}":
+			n += copy(buf[n:], premises)
+			i += len("{premises}") - 1
+		case n < len(buf):
+			buf[n] = template[i]
+			n++
+		}
+	}
+	return n
+}
+
+// requestTopic returns the topic the schedule request of premises p is
+// published on
+func requestTopic(p int) []byte {
+	return requestTopicBuf[p][:requestTopicLen[p]]
+}
+
+// responseTopic returns the topic the bridge answers premises p's request on
+func responseTopic(p int) []byte {
+	return responseTopicBuf[p][:responseTopicLen[p]]
+}
+
+// scheduleTopic returns the retained topic the bridge publishes premises
+// p's schedule on (persistent session)
+func scheduleTopic(p int) []byte {
+	return scheduleTopicBuf[p][:scheduleTopicLen[p]]
+}
+
+// scheduleTopicPremises returns the premises a schedule topic belongs to,
+// or -1 if topic is not a schedule topic of a configured premises
+func scheduleTopicPremises(topic []byte) int {
+	for p := 0; p < numPremises(); p++ {
+		if string(topic) == string(scheduleTopic(p)) {
+			return p
+		}
+	}
+	return -1
+}
+
+// numResponseTopics returns how many distinct response topics there are:
+// one per premises with {premises} in the template, otherwise one
+func numResponseTopics() int {
+	if responsePerPremises {
+		return numPremises()
+	}
+	return 1
+}
+
+// isResponseTopic reports whether topic is one of the response topics
+func isResponseTopic(topic []byte) bool {
+	for p := 0; p < numResponseTopics(); p++ {
+		if string(topic) == string(responseTopic(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// setRequestNonce makes a new request nonce from a random number
+func setRequestNonce(r uint32) {
+	const hexDigits = "0123456789abcdef"
+	for i := nonceLen - 1; i >= 0; i-- {
+		requestNonce[i] = hexDigits[r&0xf]
+		r >>= 4
+	}
+}
+
+// responseNonceMatches reports whether a response answers the last
+// request, which always carries a nonce: the response must echo
+// requestNonce. Responses without one (CSV, older bridges) could answer
+// any device's request, so they depend on acceptNonceless.
+func responseNonceMatches(data []byte) bool {
+	nonce := responseNonce(data)
+	if nonce == nil {
+		return acceptNonceless
+	}
+	return string(nonce) == string(requestNonce[:])
+}
+
+// streamPremises returns the premises a message too large for the
+// response buffer may be parsed into as it is read (see receiveSchedule):
+// p for a schedule topic's message, or the pending request's premises for
+// a response. Such a message can only be CSV, which carries no nonce, so
+// a response is only streamed when nonceless responses are accepted;
+// otherwise -1, and the message is discarded before it replaces any jobs.
+func streamPremises(p, pending int) int {
+	if p >= 0 {
+		return p
+	}
+	if !acceptNonceless {
+		return -1
+	}
+	return pending
+}
+
+// encodeScheduleRequest writes the schedule request for premises p into
+// buf: {"premises":"<id>","nonce":"<nonce>","reply_to":"<response topic>"}.
+// Returns the length, or 0 if buf is too small.
+func encodeScheduleRequest(buf []byte, p int) int {
+	w := stateWriter{buf: buf}
+	w.raw(`{"premises":`)
+	w.str(premisesID(p))
+	w.raw(`,"nonce":"`)
+	w.bytes(requestNonce[:])
+	w.raw(`","reply_to":"`)
+	w.escBytes(responseTopic(p))
+	w.raw(`"}`)
+	if w.overflow {
+		return 0
+	}
+	return w.n
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"openenterprise/bindicator/config"
+)
+
+// useTestTopics expands templates until the test ends
+func useTestTopics(t *testing.T, topics config.Topics, clientID string) {
+	t.Helper()
+	setTopicTemplates(topics, clientID)
+	t.Cleanup(func() {
+		setTopicTemplates(config.DefaultTopics(), "")
+	})
+}
+
+func TestTopicTemplates(t *testing.T) {
+	useTestPremises(t)
+
+	useTestTopics(t, config.DefaultTopics(), "kitchen")
+	if string(requestTopic(1)) != "bindicator/request" || string(responseTopic(1)) != "bindicator/response" {
+		t.Errorf("default topics = %q, %q", requestTopic(1), responseTopic(1))
+	}
+	if numResponseTopics() != 1 || !isResponseTopic([]byte("bindicator/response")) {
+		t.Errorf("default: %d response topics", numResponseTopics())
+	}
+
+	useTestTopics(t, config.Topics{Request: "bins/{client}/request", Response: "bins/{client}/{premises}/response", Schedule: "bins/{client}/{premises}/schedule"}, "kitchen")
+	if string(requestTopic(0)) != "bins/kitchen/request" || string(requestTopic(1)) != "bins/kitchen/request" {
+		t.Errorf("request topics = %q, %q", requestTopic(0), requestTopic(1))
+	}
+	if string(responseTopic(0)) != "bins/kitchen/1/response" || string(responseTopic(1)) != "bins/kitchen/37/response" {
+		t.Errorf("response topics = %q, %q", responseTopic(0), responseTopic(1))
+	}
+	if string(scheduleTopic(1)) != "bins/kitchen/37/schedule" || scheduleTopicPremises([]byte("bins/kitchen/37/schedule")) != 1 {
+		t.Errorf("schedule topic = %q", scheduleTopic(1))
+	}
+	if numResponseTopics() != 2 || !isResponseTopic([]byte("bins/kitchen/37/response")) {
+		t.Errorf("per premises: %d response topics", numResponseTopics())
+	}
+	for _, topic := range []string{"bindicator/response", "bins/other/1/response", "bins/kitchen/2/response"} {
+		if isResponseTopic([]byte(topic)) {
+			t.Errorf("isResponseTopic(%q) = true", topic)
+		}
+	}
+}
+
+func TestEncodeScheduleRequest(t *testing.T) {
+	useTestPremises(t)
+	useTestTopics(t, config.Topics{Request: config.DefaultRequestTopic, Response: "bindicator/{client}/response", Schedule: config.DefaultScheduleTopic}, "kitchen")
+	setRequestNonce(0x9f3a1c2e)
+	if string(requestNonce[:]) != "9f3a1c2e" {
+		t.Fatalf("nonce = %q", requestNonce[:])
+	}
+
+	var buf [192]byte
+	n := encodeScheduleRequest(buf[:], 1)
+	var got struct {
+		Premises string `json:"premises"`
+		Nonce    string `json:"nonce"`
+		ReplyTo  string `json:"reply_to"`
+	}
+	if err := json.Unmarshal(buf[:n], &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf[:n], err)
+	}
+	if got.Premises != "37" || got.Nonce != "9f3a1c2e" || got.ReplyTo != "bindicator/kitchen/response" {
+		t.Errorf("request = %+v", got)
+	}
+	if n := encodeScheduleRequest(buf[:20], 1); n != 0 {
+		t.Errorf("small buffer: n = %d, want 0", n)
+	}
+}
+
+func TestResponseNonceMatches(t *testing.T) {
+	setRequestNonce(0x9f3a1c2e)
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"echoed", `{"v":2,"ts":1,"nonce":"9f3a1c2e","jobs":[]}`, true},
+		{"other device", `{"v":2,"ts":1,"nonce":"0badf00d","jobs":[]}`, false},
+		{"after jobs", `{"v":2,"jobs":[{"date":"2026-01-20","bin":"black","nonce":"x"}],"nonce":"0badf00d"}`, false},
+		{"no nonce", `{"v":2,"ts":1,"jobs":[]}`, false},
+		{"csv", `1737207000,2026-01-17:BLACK`, false},
+	}
+
+	// By default a per-device response topic only takes responses that
+	// echo the nonce
+	perDevice := config.DefaultTopics()
+	perDevice.Response = "bindicator/{client}/response"
+	useTestTopics(t, perDevice, "kitchen")
+	for _, tt := range tests {
+		if got := responseNonceMatches([]byte(tt.data)); got != tt.want {
+			t.Errorf("%s: responseNonceMatches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// The shared default topic, where older bridges answer, and "nonceless
+	// accept" also take responses without a nonce; a wrong nonce is still
+	// rejected
+	accept := perDevice
+	accept.Nonceless = config.NoncelessAccept
+	for _, topics := range []config.Topics{config.DefaultTopics(), accept} {
+		useTestTopics(t, topics, "kitchen")
+		for _, tt := range tests[3:] {
+			if !responseNonceMatches([]byte(tt.data)) {
+				t.Errorf("%s on %s: rejected", tt.name, topics.Response)
+			}
+		}
+		if responseNonceMatches([]byte(tests[1].data)) {
+			t.Errorf("other device on %s: accepted", topics.Response)
+		}
+	}
+}
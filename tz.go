@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+// tzTransition describes when a DST change happens each year: the nth
+// weekday of a month (week 5 = last) at a time of day.
+type tzTransition struct {
+	month   time.Month
+	week    int // 1-4, or 5 for the last weekday of the month
+	weekday time.Weekday
+	at      time.Duration // Time of day of the change
+	utc     bool          // at is UTC rather than the local time before the change
+}
+
+// tzRule describes a timezone: a standard offset and an optional DST rule
+type tzRule struct {
+	name      string
+	stdName   string
+	stdOffset int // Seconds east of UTC
+	dstName   string
+	dstOffset int
+	dstStart  tzTransition
+	dstEnd    tzTransition
+}
+
+// DST transition rules (TinyGo has no tzdata, so the rules are embedded)
+var (
+	// EU: last Sunday in March and October at 01:00 UTC
+	tzEUStart = tzTransition{time.March, 5, time.Sunday, 1 * time.Hour, true}
+	tzEUEnd   = tzTransition{time.October, 5, time.Sunday, 1 * time.Hour, true}
+
+	// US: second Sunday in March to first Sunday in November at 02:00 local
+	tzUSStart = tzTransition{time.March, 2, time.Sunday, 2 * time.Hour, false}
+	tzUSEnd   = tzTransition{time.November, 1, time.Sunday, 2 * time.Hour, false}
+
+	// Australia (south-east): first Sunday in October to first Sunday in April
+	tzAUStart = tzTransition{time.October, 1, time.Sunday, 2 * time.Hour, false}
+	tzAUEnd   = tzTransition{time.April, 1, time.Sunday, 3 * time.Hour, false}
+)
+
+// tzRules lists the supported timezones
+var tzRules = [...]tzRule{
+	{name: "UTC", stdName: "UTC"},
+	{"Europe/London", "GMT", 0, "BST", 3600, tzEUStart, tzEUEnd},
+	{"Europe/Dublin", "GMT", 0, "IST", 3600, tzEUStart, tzEUEnd},
+	{"Europe/Lisbon", "WET", 0, "WEST", 3600, tzEUStart, tzEUEnd},
+	{"Europe/Paris", "CET", 3600, "CEST", 7200, tzEUStart, tzEUEnd},
+	{"Europe/Berlin", "CET", 3600, "CEST", 7200, tzEUStart, tzEUEnd},
+	{"Europe/Amsterdam", "CET", 3600, "CEST", 7200, tzEUStart, tzEUEnd},
+	{"Europe/Brussels", "CET", 3600, "CEST", 7200, tzEUStart, tzEUEnd},
+	{"Europe/Madrid", "CET", 3600, "CEST", 7200, tzEUStart, tzEUEnd},
+	{"Europe/Rome", "CET", 3600, "CEST", 7200, tzEUStart, tzEUEnd},
+	{"Europe/Helsinki", "EET", 7200, "EEST", 10800, tzEUStart, tzEUEnd},
+	{"Europe/Athens", "EET", 7200, "EEST", 10800, tzEUStart, tzEUEnd},
+	{"America/New_York", "EST", -5 * 3600, "EDT", -4 * 3600, tzUSStart, tzUSEnd},
+	{"America/Chicago", "CST", -6 * 3600, "CDT", -5 * 3600, tzUSStart, tzUSEnd},
+	{"America/Denver", "MST", -7 * 3600, "MDT", -6 * 3600, tzUSStart, tzUSEnd},
+	{"America/Los_Angeles", "PST", -8 * 3600, "PDT", -7 * 3600, tzUSStart, tzUSEnd},
+	{"Australia/Sydney", "AEST", 10 * 3600, "AEDT", 11 * 3600, tzAUStart, tzAUEnd},
+	{"Australia/Melbourne", "AEST", 10 * 3600, "AEDT", 11 * 3600, tzAUStart, tzAUEnd},
+}
+
+// Current timezone. The fixed-offset locations are created once when the
+// timezone is set, so converting times does not allocate.
+var (
+	tzCurrent *tzRule
+	tzStdLoc  *time.Location
+	tzDstLoc  *time.Location
+)
+
+func init() {
+	setTimezone(config.DefaultTimezone)
+}
+
+// setTimezone selects a timezone by IANA name (case-insensitive).
+// On error the current timezone is kept.
+func setTimezone(name string) error {
+	for i := range tzRules {
+		r := &tzRules[i]
+		if !equalFoldASCII([]byte(name), r.name) {
+			continue
+		}
+		tzCurrent = r
+		tzStdLoc = time.FixedZone(r.stdName, r.stdOffset)
+		tzDstLoc = tzStdLoc
+		if r.dstName != "" {
+			tzDstLoc = time.FixedZone(r.dstName, r.dstOffset)
+		}
+		return nil
+	}
+	return errors.New("timezone: unsupported zone " + name)
+}
+
+// timezoneName returns the IANA name of the current timezone
+func timezoneName() string {
+	return tzCurrent.name
+}
+
+// localTime converts t to local wall-clock time in the current timezone
+func localTime(t time.Time) time.Time {
+	if tzCurrent.isDST(t) {
+		return t.In(tzDstLoc)
+	}
+	return t.In(tzStdLoc)
+}
+
+// localDate returns the instant of a local wall-clock time. The day may be
+// out of range (e.g. day 0 is the last day of the previous month). Times
+// repeated when DST ends resolve to the first (DST) occurrence, and times
+// skipped when DST starts resolve to an hour later.
+func localDate(year int, month time.Month, day, hour, min int) time.Time {
+	if t := time.Date(year, month, day, hour, min, 0, 0, tzDstLoc); tzCurrent.isDST(t) {
+		return t.In(tzDstLoc)
+	}
+	return localTime(time.Date(year, month, day, hour, min, 0, 0, tzStdLoc))
+}
+
+// isDST reports whether DST is in effect at t
+func (r *tzRule) isDST(t time.Time) bool {
+	if r.dstName == "" {
+		return false
+	}
+	year := t.UTC().Year()
+	start := r.dstStart.instant(year, r.stdOffset)
+	end := r.dstEnd.instant(year, r.dstOffset)
+	if start.Before(end) {
+		// Northern hemisphere: DST in the middle of the year
+		return !t.Before(start) && t.Before(end)
+	}
+	// Southern hemisphere: DST spans the new year
+	return !t.Before(start) || t.Before(end)
+}
+
+// instant returns when the transition happens in the given year. offset is
+// the UTC offset (seconds) in effect before the change, used for local times.
+func (tr tzTransition) instant(year, offset int) time.Time {
+	first := time.Date(year, tr.month, 1, 0, 0, 0, 0, time.UTC)
+	day := 1 + (int(tr.weekday)-int(first.Weekday())+7)%7 + 7*(tr.week-1)
+	for day > daysInMonth(year, int(tr.month)) {
+		day -= 7
+	}
+	t := time.Date(year, tr.month, day, 0, 0, 0, 0, time.UTC).Add(tr.at)
+	if !tr.utc {
+		t = t.Add(-time.Duration(offset) * time.Second)
+	}
+	return t
+}
+
+// localLogTime is a slog ReplaceAttr function that writes record
+// timestamps in local wall-clock time
+func localLogTime(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey && len(groups) == 0 {
+		a.Value = slog.TimeValue(localTime(a.Value.Time()))
+	}
+	return a
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"openenterprise/bindicator/config"
+)
+
+func TestSetTimezone(t *testing.T) {
+	defer setTimezone(config.DefaultTimezone)
+
+	if err := setTimezone("america/new_york"); err != nil {
+		t.Fatalf("setTimezone: %v", err)
+	}
+	if got := timezoneName(); got != "America/New_York" {
+		t.Errorf("timezoneName() = %q, want America/New_York", got)
+	}
+
+	if err := setTimezone("Mars/Olympus_Mons"); err == nil {
+		t.Error("expected error for unsupported timezone")
+	}
+	if got := timezoneName(); got != "America/New_York" {
+		t.Errorf("timezone changed after error: %q", got)
+	}
+}
+
+func TestLocalTime(t *testing.T) {
+	defer setTimezone(config.DefaultTimezone)
+
+	tests := []struct {
+		zone     string
+		utc      time.Time
+		expected string
+	}{
+		// Europe/London: BST from last Sunday in March to last Sunday in October at 01:00 UTC
+		{"Europe/London", time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC), "2026-01-15 12:00 GMT"},
+		{"Europe/London", time.Date(2026, 3, 29, 0, 59, 0, 0, time.UTC), "2026-03-29 00:59 GMT"},
+		{"Europe/London", time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC), "2026-03-29 02:00 BST"},
+		{"Europe/London", time.Date(2026, 7, 1, 23, 30, 0, 0, time.UTC), "2026-07-02 00:30 BST"},
+		{"Europe/London", time.Date(2026, 10, 25, 0, 59, 0, 0, time.UTC), "2026-10-25 01:59 BST"},
+		{"Europe/London", time.Date(2026, 10, 25, 1, 0, 0, 0, time.UTC), "2026-10-25 01:00 GMT"},
+		{"Europe/London", time.Date(2027, 3, 28, 1, 0, 0, 0, time.UTC), "2027-03-28 02:00 BST"},
+		// Central Europe switches at the same instant
+		{"Europe/Paris", time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC), "2026-03-29 03:00 CEST"},
+		// US: second Sunday in March to first Sunday in November at 02:00 local
+		{"America/New_York", time.Date(2026, 3, 8, 6, 59, 0, 0, time.UTC), "2026-03-08 01:59 EST"},
+		{"America/New_York", time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC), "2026-03-08 03:00 EDT"},
+		{"America/New_York", time.Date(2026, 11, 1, 5, 59, 0, 0, time.UTC), "2026-11-01 01:59 EDT"},
+		{"America/New_York", time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC), "2026-11-01 01:00 EST"},
+		// Southern hemisphere: DST spans the new year
+		{"Australia/Sydney", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), "2026-01-01 11:00 AEDT"},
+		{"Australia/Sydney", time.Date(2026, 4, 4, 16, 0, 0, 0, time.UTC), "2026-04-05 02:00 AEST"},
+		{"Australia/Sydney", time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), "2026-07-01 10:00 AEST"},
+		{"Australia/Sydney", time.Date(2026, 10, 3, 16, 0, 0, 0, time.UTC), "2026-10-04 03:00 AEDT"},
+		{"UTC", time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC), "2026-07-01 12:00 UTC"},
+	}
+
+	for _, tc := range tests {
+		if err := setTimezone(tc.zone); err != nil {
+			t.Fatalf("setTimezone(%q): %v", tc.zone, err)
+		}
+		got := localTime(tc.utc).Format("2006-01-02 15:04 MST")
+		if got != tc.expected {
+			t.Errorf("%s: localTime(%v) = %s, want %s", tc.zone, tc.utc, got, tc.expected)
+		}
+	}
+}
+
+func TestLocalDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		year     int
+		month    time.Month
+		day      int
+		hour     int
+		expected time.Time
+	}{
+		{"winter midnight", 2026, 1, 20, 0, time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"summer midnight", 2026, 7, 1, 0, time.Date(2026, 6, 30, 23, 0, 0, 0, time.UTC)},
+		{"summer noon", 2026, 7, 1, 12, time.Date(2026, 7, 1, 11, 0, 0, 0, time.UTC)},
+		{"day 0 is end of previous month", 2026, 8, 0, 12, time.Date(2026, 7, 31, 11, 0, 0, 0, time.UTC)},
+		{"skipped hour moves forward", 2026, 3, 29, 1, time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC)},
+		{"repeated hour is first occurrence", 2026, 10, 25, 1, time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := localDate(tc.year, tc.month, tc.day, tc.hour, 0)
+			if !got.Equal(tc.expected) {
+				t.Errorf("localDate() = %v, want %v", got.UTC(), tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+//go:build tinygo
+
+package main
+
+import (
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"openenterprise/bindicator/config"
+
+	"github.com/soypat/cyw43439"
+	"github.com/soypat/lneto/x/xnet"
+)
+
+// Link loss: the radio reassociates by itself after short drops, so the
+// network list is only rejoined once the link has been down for
+// wifiLinkGrace. The link is checked every wifiLinkCheck.
+const (
+	wifiLinkCheck = 5 * time.Second
+	wifiLinkGrace = 30 * time.Second
+)
+
+// wifiStack is the radio and the network stack on top of it. The device
+// is created and initialised once: NewPicoWDevice claims a PIO state
+// machine, so it cannot be recreated for every join attempt. Joining a
+// network associates the radio and resets the stack.
+type wifiStack struct {
+	dev     *cyw43439.Device
+	s       xnet.StackAsync
+	up      bool // Stack configured; polled by loopForeverStack
+	recvd   int  // Length of the frame received by the last poll
+	reply   int  // Length of a frame from intercept waiting in sendbuf
+	sendbuf [cyw43439.MTU]byte
+
+	// intercept, if set, sees every received frame before the stack (the
+	// setup access point's DHCP server). It may write a reply frame into
+	// out and return its length; consumed frames do not reach the stack.
+	intercept func(frame, out []byte) (reply int, consumed bool)
+}
+
+// wifi is the device's only radio
+var wifi wifiStack
+
+// init creates the radio device and loads its firmware
+func (w *wifiStack) init(devcfg cyw43439.Config) error {
+	w.dev = cyw43439.NewPicoWDevice()
+	if err := w.dev.Init(devcfg); err != nil {
+		return err
+	}
+	w.dev.RecvEthHandle(w.recv)
+	return nil
+}
+
+// join associates with one network (open if it has no password) and
+// resets the stack, which then has no address until setupAddress
+func (w *wifiStack) join(network config.WiFiNetwork) error {
+	w.up = false // Stop polling while the stack is reset
+	if err := w.dev.JoinWPA2(network.SSID, network.Password); err != nil {
+		return err
+	}
+	return w.reset(netip.Addr{}, 3) // MQTT + debug console + OTA
+}
+
+// reset configures the stack for the radio's MAC address with addr (an
+// invalid one is set later) and starts polling it. Connections of the
+// previous setup are dropped; their owners reconnect.
+func (w *wifiStack) reset(addr netip.Addr, maxTCPConns int) error {
+	mac, err := w.dev.HardwareAddr6()
+	if err != nil {
+		return err
+	}
+	err = w.s.Reset(xnet.StackConfig{
+		StaticAddress:   addr,
+		Hostname:        "bindicator",
+		MaxTCPConns:     maxTCPConns,
+		RandSeed:        time.Now().UnixNano() | 1, // Must not be zero
+		HardwareAddress: mac,
+		MTU:             cyw43439.MTU,
+	})
+	if err != nil {
+		return err
+	}
+	w.up = true
+	return nil
+}
+
+// recv hands a received frame to the stack
+func (w *wifiStack) recv(pkt []byte) error {
+	w.recvd = len(pkt)
+	if !w.up {
+		return nil // Joining; the stack is not set up yet
+	}
+	if w.intercept != nil {
+		// Called with the radio locked, so the reply is sent by RecvAndSend
+		n, consumed := w.intercept(pkt, w.sendbuf[:])
+		w.reply = n
+		if consumed {
+			return nil
+		}
+	}
+	return w.s.Demux(pkt, 0)
+}
+
+// RecvAndSend polls the radio for one frame and sends one the stack has
+// ready. Returns the lengths of both (0 = none).
+func (w *wifiStack) RecvAndSend() (send, recv int, err error) {
+	w.recvd = 0
+	_, err = w.dev.PollOne()
+	recv = w.recvd
+	if w.reply > 0 {
+		if sendErr := w.dev.SendEth(w.sendbuf[:w.reply]); sendErr != nil {
+			err = sendErr
+		}
+		w.reply = 0
+	}
+	send, encErr := w.s.Encapsulate(w.sendbuf[:], -1, 0)
+	if encErr != nil {
+		return 0, recv, encErr
+	}
+	if send > 0 {
+		if sendErr := w.dev.SendEth(w.sendbuf[:send]); sendErr != nil {
+			err = sendErr
+		}
+	}
+	return send, recv, err
+}
+
+// rejoinChan carries watchLink's rejoin requests to the main loop
+var rejoinChan = make(chan struct{}, 1)
+
+// watchLink asks the main loop to rejoin WiFi (see rejoinWiFi) when the
+// link has been down for wifiLinkGrace, and again after every further
+// wifiLinkGrace while it stays down. Rejoining resets the stack, so it is
+// not done from this goroutine.
+func watchLink(logger *slog.Logger) {
+	var downSince time.Time
+	for {
+		time.Sleep(wifiLinkCheck)
+		if wifi.up && wifi.dev.IsLinkUp() {
+			downSince = time.Time{}
+			continue
+		}
+		now := time.Now()
+		if downSince.IsZero() {
+			downSince = now
+			logger.Warn("wifi:link-down", slog.String("ssid", wifiStats.ssid))
+			continue
+		}
+		if now.Sub(downSince) < wifiLinkGrace {
+			continue
+		}
+		select {
+		case rejoinChan <- struct{}{}:
+			logger.Warn("wifi:rejoin", slog.Duration("down", now.Sub(downSince)))
+			downSince = now
+		default: // The last request is still pending
+		}
+	}
+}
+
+// rejoinWiFi rejoins WiFi through the network list (see connectWiFi). Runs
+// on the main loop, between its fetches. A rejoin that fails on every
+// network counts as a failed refresh, so the functional watchdog resets
+// the device if the networks stay unreachable.
+func rejoinWiFi(logger *slog.Logger) {
+	if err := connectWiFi(logger); err != nil {
+		consecutiveFailures++
+		logger.Error("wifi:rejoin-failed",
+			slog.String("err", err.Error()),
+			slog.Int("consecutive", consecutiveFailures),
+			slog.Int("max", maxConsecutiveFailures),
+		)
+		checkSystemHealth(logger)
+		return
+	}
+	wifiStats.reconnectCount++
+	wifiStats.connectTime = time.Now()
+}